@@ -0,0 +1,544 @@
+// Package admin serves an HTTP/JSON API to inspect and edit a DefaultDataModel
+// while the server is running.
+//
+//	GET  /api/status                 uptime, transports and mode
+//	GET  /api/config                 the Config of the server
+//	GET  /api/counters               the Stats snapshot, /metrics serves the Prometheus format
+//	GET  /api/tables/{table}         ?address=&count= values of a range
+//	PUT  /api/tables/{table}         {"address": 0, "values": [1, 2]}
+//	PUT  /api/tables/{table}/{addr}  {"value": 1}
+//	GET  /api/map                    all named registers with their values
+//	GET  /api/map/{name}             one named register
+//	PUT  /api/map/{name}             {"value": 21.5}
+//	GET  /api/events                 server-sent events with every change
+//	GET  /api/audit/{table}/{addr}   ?unit=&limit= recent writes, newest first
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/schnack/mbslave"
+	"github.com/schnack/mbslave/audit"
+)
+
+// Register - a named value in the data model, see Admin.Map
+type Register struct {
+	Table     mbslave.Table    `json:"table"`
+	Address   uint16           `json:"address"`
+	Type      mbslave.DataType `json:"type"`
+	SwapWords bool             `json:"swap_words,omitempty"`
+}
+
+// Admin - http.Handler of the API, it can also run its own listener as a mbslave.Service
+type Admin struct {
+	DataModel *mbslave.DefaultDataModel
+	// Config is shown by /api/config, optional
+	Config *mbslave.Config
+	// Server lists its transports in /api/status, optional
+	Server *mbslave.Server
+	// Stats is shown by /api/counters and /metrics, optional
+	Stats *mbslave.Stats
+	// Audit - the history of writes under /api/audit, optional
+	Audit *audit.Audit
+	// Map - registers available by name under /api/map
+	Map map[string]Register
+	// Token is required as "Authorization: Bearer <token>" when set.
+	// Event streams may pass it as ?token= because EventSource cannot set headers.
+	Token string
+	// ReadOnly rejects all changes
+	ReadOnly bool
+	// Address of the listener started by Start, e.g. ":8080"
+	Address string
+
+	started time.Time
+	mux     *http.ServeMux
+
+	mu       sync.Mutex
+	server   *http.Server
+	listener net.Listener
+}
+
+func NewAdmin(dataModel *mbslave.DefaultDataModel) *Admin {
+	a := &Admin{
+		DataModel: dataModel,
+		Map:       make(map[string]Register),
+		started:   time.Now(),
+		mux:       http.NewServeMux(),
+	}
+	a.mux.HandleFunc("GET /api/status", a.status)
+	a.mux.HandleFunc("GET /api/config", a.config)
+	a.mux.HandleFunc("GET /api/counters", a.counters)
+	a.mux.HandleFunc("GET /metrics", a.metrics)
+	a.mux.HandleFunc("GET /api/tables/{table}", a.getRange)
+	a.mux.HandleFunc("PUT /api/tables/{table}", a.putRange)
+	a.mux.HandleFunc("PUT /api/tables/{table}/{address}", a.putValue)
+	a.mux.HandleFunc("GET /api/map", a.getMap)
+	a.mux.HandleFunc("GET /api/map/{name}", a.getRegister)
+	a.mux.HandleFunc("PUT /api/map/{name}", a.putRegister)
+	a.mux.HandleFunc("GET /api/events", a.events)
+	a.mux.HandleFunc("GET /api/audit/{table}/{address}", a.history)
+	return a
+}
+
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+		return
+	}
+	if a.ReadOnly && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusForbidden, errors.New("read-only mode"))
+		return
+	}
+	a.mux.ServeHTTP(w, r)
+}
+
+// Start - listens on Address, implements mbslave.Service
+func (a *Admin) Start() error {
+	listener, err := net.Listen("tcp", a.Address)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: a, ReadHeaderTimeout: 10 * time.Second}
+	a.mu.Lock()
+	a.server, a.listener = server, listener
+	a.mu.Unlock()
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	return nil
+}
+
+// Stop - closes the listener and all connections, event streams included
+func (a *Admin) Stop() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.server == nil {
+		return nil
+	}
+	err := a.server.Close()
+	a.server, a.listener = nil, nil
+	return err
+}
+
+// Addr - address of the started listener, nil before Start
+func (a *Admin) Addr() net.Addr {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.listener == nil {
+		return nil
+	}
+	return a.listener.Addr()
+}
+
+func (a *Admin) authorized(r *http.Request) bool {
+	if a.Token == "" {
+		return true
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) == 1
+}
+
+func (a *Admin) status(w http.ResponseWriter, _ *http.Request) {
+	status := struct {
+		Started    time.Time `json:"started"`
+		Uptime     float64   `json:"uptime"`
+		ReadOnly   bool      `json:"read_only"`
+		Transports []string  `json:"transports"`
+	}{
+		Started:    a.started,
+		Uptime:     time.Since(a.started).Seconds(),
+		ReadOnly:   a.ReadOnly,
+		Transports: []string{},
+	}
+	if a.Server != nil {
+		for _, transport := range a.Server.Transports() {
+			status.Transports = append(status.Transports, fmt.Sprintf("%T", transport))
+		}
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (a *Admin) config(w http.ResponseWriter, _ *http.Request) {
+	if a.Config == nil {
+		writeError(w, http.StatusNotFound, errors.New("no config"))
+		return
+	}
+	writeJSON(w, http.StatusOK, a.Config)
+}
+
+func (a *Admin) counters(w http.ResponseWriter, _ *http.Request) {
+	if a.Stats == nil {
+		writeError(w, http.StatusNotFound, errors.New("no counters"))
+		return
+	}
+	type count struct {
+		Unit     *uint8 `json:"unit,omitempty"`
+		Function *uint8 `json:"function,omitempty"`
+		Code     *uint8 `json:"code,omitempty"`
+		Count    uint64 `json:"count"`
+	}
+	snapshot := a.Stats.Snapshot()
+	counters := struct {
+		FramesReceived uint64                       `json:"frames_received"`
+		FramesSent     uint64                       `json:"frames_sent"`
+		CrcErrors      uint64                       `json:"crc_errors"`
+		ParseErrors    uint64                       `json:"parse_errors"`
+		Broadcasts     uint64                       `json:"broadcasts"`
+		Disconnects    uint64                       `json:"disconnects"`
+		Connections    map[mbslave.ConnEvent]uint64 `json:"connections"`
+		Requests       []count                      `json:"requests"`
+		Exceptions     []count                      `json:"exceptions"`
+		Unanswered     []count                      `json:"unanswered"`
+	}{
+		FramesReceived: snapshot.FramesReceived,
+		FramesSent:     snapshot.FramesSent,
+		CrcErrors:      snapshot.CrcErrors,
+		ParseErrors:    snapshot.ParseErrors,
+		Broadcasts:     snapshot.Broadcasts,
+		Disconnects:    snapshot.Disconnects,
+		Connections:    snapshot.Connections,
+		Requests:       []count{},
+		Exceptions:     []count{},
+		Unanswered:     []count{},
+	}
+	for k, v := range snapshot.Requests {
+		unit, function := k[0], k[1]
+		counters.Requests = append(counters.Requests, count{Unit: &unit, Function: &function, Count: v})
+	}
+	for k, v := range snapshot.Exceptions {
+		function, code := k[0], k[1]
+		counters.Exceptions = append(counters.Exceptions, count{Function: &function, Code: &code, Count: v})
+	}
+	for unit, v := range snapshot.Unanswered {
+		unit := unit
+		counters.Unanswered = append(counters.Unanswered, count{Unit: &unit, Count: v})
+	}
+	for _, list := range [][]count{counters.Requests, counters.Exceptions, counters.Unanswered} {
+		sort.Slice(list, func(i, j int) bool {
+			a, b := list[i], list[j]
+			for _, pair := range [][2]*uint8{{a.Unit, b.Unit}, {a.Function, b.Function}, {a.Code, b.Code}} {
+				if pair[0] != nil && *pair[0] != *pair[1] {
+					return *pair[0] < *pair[1]
+				}
+			}
+			return false
+		})
+	}
+	writeJSON(w, http.StatusOK, counters)
+}
+
+func (a *Admin) metrics(w http.ResponseWriter, r *http.Request) {
+	if a.Stats == nil {
+		writeError(w, http.StatusNotFound, errors.New("no counters"))
+		return
+	}
+	a.Stats.ServeHTTP(w, r)
+}
+
+func (a *Admin) getRange(w http.ResponseWriter, r *http.Request) {
+	table, err := mbslave.ParseTable(r.PathValue("table"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	address, count := 0, 1
+	for name, target := range map[string]*int{"address": &address, "count": &count} {
+		if v := r.URL.Query().Get(name); v != "" {
+			if *target, err = strconv.Atoi(v); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s: %w", name, err))
+				return
+			}
+		}
+	}
+	if err := a.checkRange(table, address, count); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// no Modbus read, the read callbacks are not called
+	words := make([]uint16, count)
+	if err := a.DataModel.Peek(table, uint16(address), words); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	values := make([]interface{}, count)
+	for i, value := range words {
+		if table.IsBit() {
+			values[i] = value != 0
+		} else {
+			values[i] = value
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"table": table, "address": address, "values": values})
+}
+
+func (a *Admin) putRange(w http.ResponseWriter, r *http.Request) {
+	table, err := mbslave.ParseTable(r.PathValue("table"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	var body struct {
+		Address int           `json:"address"`
+		Values  []interface{} `json:"values"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	a.write(w, table, body.Address, body.Values)
+}
+
+func (a *Admin) putValue(w http.ResponseWriter, r *http.Request) {
+	table, err := mbslave.ParseTable(r.PathValue("table"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	address, err := strconv.Atoi(r.PathValue("address"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid address: %w", err))
+		return
+	}
+	var body struct {
+		Value interface{} `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	a.write(w, table, address, []interface{}{body.Value})
+}
+
+// write - checks all values before the first one is written
+func (a *Admin) write(w http.ResponseWriter, table mbslave.Table, address int, values []interface{}) {
+	if err := a.checkRange(table, address, len(values)); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	words := make([]uint16, len(values))
+	for i, v := range values {
+		word, err := toWord(v, table.IsBit())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("value #%d: %w", i, err))
+			return
+		}
+		words[i] = word
+	}
+	if err := a.DataModel.SetRange(table, uint16(address), words); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Admin) getMap(w http.ResponseWriter, _ *http.Request) {
+	names := make([]string, 0, len(a.Map))
+	for name := range a.Map {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	registers := make([]namedValue, 0, len(names))
+	for _, name := range names {
+		registers = append(registers, a.namedValue(name, a.Map[name]))
+	}
+	writeJSON(w, http.StatusOK, registers)
+}
+
+func (a *Admin) getRegister(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	register, ok := a.Map[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown register %q", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, a.namedValue(name, register))
+}
+
+func (a *Admin) putRegister(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	register, ok := a.Map[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown register %q", name))
+		return
+	}
+	var body struct {
+		Value *float64 `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Value == nil {
+		writeError(w, http.StatusBadRequest, errors.New("a numeric value is required"))
+		return
+	}
+	if err := a.checkRange(register.Table, int(register.Address), register.words()); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	words := register.Type.Encode(*body.Value)
+	if register.Table.IsBit() {
+		words = mbslave.TypeBool.Encode(*body.Value)
+	}
+	if register.SwapWords {
+		mbslave.SwapWords(words)
+	}
+	// all words at once, the watchers never see half of a value
+	if err := a.DataModel.SetRange(register.Table, register.Address, words); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type namedValue struct {
+	Name string `json:"name"`
+	Register
+	Value float64 `json:"value"`
+}
+
+func (a *Admin) namedValue(name string, register Register) namedValue {
+	words := make([]uint16, register.words())
+	if a.checkRange(register.Table, int(register.Address), len(words)) == nil {
+		_ = a.DataModel.Peek(register.Table, register.Address, words)
+	}
+	if register.SwapWords {
+		mbslave.SwapWords(words)
+	}
+	dataType := register.Type
+	if register.Table.IsBit() {
+		dataType = mbslave.TypeBool
+	}
+	return namedValue{Name: name, Register: register, Value: dataType.Decode(words)}
+}
+
+func (r Register) words() int {
+	if r.Table.IsBit() {
+		return 1
+	}
+	return r.Type.Words()
+}
+
+// events - streams the changes of the data model as server-sent events.
+// Changes are dropped for a client that does not keep up.
+func (a *Admin) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	changes := make(chan mbslave.Change, 256)
+	cancel := a.DataModel.Watch(func(change mbslave.Change) {
+		select {
+		case changes <- change:
+		default:
+		}
+	})
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case change := <-changes:
+			data, _ := json.Marshal(struct {
+				Table   mbslave.Table `json:"table"`
+				Address uint16        `json:"address"`
+				Value   uint16        `json:"value"`
+			}{change.Table, change.Address, change.Value})
+			if _, err := fmt.Fprintf(w, "event: change\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// history - the unit defaults to the one of the data model, the limit to 100
+func (a *Admin) history(w http.ResponseWriter, r *http.Request) {
+	if a.Audit == nil {
+		writeError(w, http.StatusNotFound, errors.New("the audit log is off"))
+		return
+	}
+	table, err := mbslave.ParseTable(r.PathValue("table"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	address, err := strconv.ParseUint(r.PathValue("address"), 10, 16)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid address: %w", err))
+		return
+	}
+	unit, limit := int(a.DataModel.SlaveId), 100
+	for name, target := range map[string]*int{"unit": &unit, "limit": &limit} {
+		if v := r.URL.Query().Get(name); v != "" {
+			if *target, err = strconv.Atoi(v); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s: %w", name, err))
+				return
+			}
+		}
+	}
+	records := a.Audit.History(uint8(unit), table, uint16(address), limit)
+	if records == nil {
+		records = []audit.Record{}
+	}
+	writeJSON(w, http.StatusOK, records)
+}
+
+func (a *Admin) checkRange(table mbslave.Table, address, count int) error {
+	if address < 0 || count < 1 || address+count > a.DataModel.Length(table) {
+		return fmt.Errorf("range %d+%d is outside of %s (%d)", address, count, table, a.DataModel.Length(table))
+	}
+	return nil
+}
+
+// toWord - bits accept booleans and 0/1, registers accept integers 0..65535
+func toWord(v interface{}, bit bool) (uint16, error) {
+	switch value := v.(type) {
+	case bool:
+		if bit {
+			if value {
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case float64:
+		max := float64(0xffff)
+		if bit {
+			max = 1
+		}
+		if value == float64(int64(value)) && value >= 0 && value <= max {
+			return uint16(value), nil
+		}
+	}
+	if bit {
+		return 0, fmt.Errorf("expected a boolean or 0/1, got %v", v)
+	}
+	return 0, fmt.Errorf("expected an integer 0..65535, got %v", v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
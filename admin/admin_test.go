@@ -0,0 +1,213 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/schnack/gotest"
+	"github.com/schnack/mbslave"
+	"github.com/schnack/mbslave/audit"
+)
+
+func newAdmin() (*Admin, *mbslave.DefaultDataModel) {
+	dm := mbslave.NewDefaultDataModel(&mbslave.Config{
+		SizeDiscreteInputs:   8,
+		SizeCoils:            8,
+		SizeInputRegisters:   8,
+		SizeHoldingRegisters: 8,
+	})
+	a := NewAdmin(dm)
+	a.Map["temperature"] = Register{Table: mbslave.TableInputRegisters, Address: 2, Type: mbslave.TypeFloat32}
+	return a, dm
+}
+
+func do(a *Admin, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, r)
+	return w
+}
+
+func TestAdmin_Tables(t *testing.T) {
+	a, dm := newAdmin()
+
+	w := do(a, http.MethodPut, "/api/tables/ir", `{"address": 1, "values": [10, 11]}`)
+	if err := gotest.Expect(w.Code).Eq(http.StatusNoContent); err != nil {
+		t.Error(err, w.Body.String())
+	}
+	if err := gotest.Expect(dm.GetInputRegisters(2)).Eq(uint16(11)); err != nil {
+		t.Error(err)
+	}
+
+	w = do(a, http.MethodPut, "/api/tables/coils/3", `{"value": true}`)
+	if err := gotest.Expect(w.Code).Eq(http.StatusNoContent); err != nil {
+		t.Error(err, w.Body.String())
+	}
+	if err := gotest.Expect(dm.GetCoils(3)).True(); err != nil {
+		t.Error(err)
+	}
+
+	w = do(a, http.MethodGet, "/api/tables/coils?address=2&count=2", "")
+	if err := gotest.Expect(strings.TrimSpace(w.Body.String())).Eq(`{"address":2,"table":"coils","values":[false,true]}`); err != nil {
+		t.Error(err)
+	}
+
+	for _, tc := range []struct{ method, target, body string }{
+		{http.MethodPut, "/api/tables/hr", `{"address": 7, "values": [1, 2]}`},
+		{http.MethodPut, "/api/tables/hr/1", `{"value": 70000}`},
+		{http.MethodPut, "/api/tables/di/1", `{"value": 2}`},
+		{http.MethodGet, "/api/tables/hr?count=100", ""},
+	} {
+		w := do(a, tc.method, tc.target, tc.body)
+		if err := gotest.Expect(w.Code).Eq(http.StatusBadRequest); err != nil {
+			t.Errorf("%s %s: %s", tc.method, tc.target, err)
+		}
+	}
+	if err := gotest.Expect(dm.GetHoldingRegisters(7)).Eq(uint16(0)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAdmin_Map(t *testing.T) {
+	a, dm := newAdmin()
+
+	w := do(a, http.MethodPut, "/api/map/temperature", `{"value": 21.5}`)
+	if err := gotest.Expect(w.Code).Eq(http.StatusNoContent); err != nil {
+		t.Error(err, w.Body.String())
+	}
+	if err := gotest.Expect(dm.GetInputRegisters(2)).Eq(uint16(0x41ac)); err != nil {
+		t.Error(err)
+	}
+
+	w = do(a, http.MethodGet, "/api/map/temperature", "")
+	var register namedValue
+	if err := json.Unmarshal(w.Body.Bytes(), &register); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(register).Eq(namedValue{Name: "temperature", Register: a.Map["temperature"], Value: 21.5}); err != nil {
+		t.Error(err)
+	}
+
+	w = do(a, http.MethodGet, "/api/map/pressure", "")
+	if err := gotest.Expect(w.Code).Eq(http.StatusNotFound); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAdmin_Auth(t *testing.T) {
+	a, _ := newAdmin()
+	a.Token = "secret"
+	a.ReadOnly = true
+
+	r := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, r)
+	if err := gotest.Expect(w.Code).Eq(http.StatusUnauthorized); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(do(a, http.MethodGet, "/api/status", "").Code).Eq(http.StatusOK); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(do(a, http.MethodPut, "/api/tables/hr/1", `{"value": 1}`).Code).Eq(http.StatusForbidden); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAdmin_Events(t *testing.T) {
+	a, dm := newAdmin()
+	a.Address = "127.0.0.1:0"
+	a.Token = "secret"
+	if err := a.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+
+	resp, err := http.Get("http://" + a.Addr().String() + "/api/events?token=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := gotest.Expect(resp.Header.Get("Content-Type")).Eq("text/event-stream"); err != nil {
+		t.Error(err)
+	}
+
+	_ = dm.SetHoldingRegisters(5, 42)
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 2 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	if err := gotest.Expect(lines).Eq([]string{"event: change", `data: {"table":"hr","address":5,"value":42}`}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAdmin_Audit(t *testing.T) {
+	a, dm := newAdmin()
+	if err := gotest.Expect(do(a, http.MethodGet, "/api/audit/hr/1", "").Code).Eq(http.StatusNotFound); err != nil {
+		t.Error(err)
+	}
+	a.Audit = audit.NewAudit(nil)
+	defer a.Audit.Watch(dm)()
+	_ = dm.SetHoldingRegisters(1, 10)
+	_ = dm.SetHoldingRegisters(1, 11)
+
+	w := do(a, http.MethodGet, "/api/audit/hr/1?limit=1", "")
+	var records []audit.Record
+	if err := json.Unmarshal(w.Body.Bytes(), &records); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+	if err := gotest.Expect(len(records)).Eq(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect([]uint16{records[0].Old, records[0].New}).Eq([]uint16{10, 11}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(do(a, http.MethodGet, "/api/audit/hr/2", "").Body.String()).Eq("[]\n"); err != nil {
+		t.Error(err)
+	}
+}
+
+// rangeStorage - a SliceStorage recording the lengths of the writes
+type rangeStorage struct {
+	*mbslave.SliceStorage
+	sets []int
+}
+
+func (s *rangeStorage) Set(table mbslave.Table, address uint16, values []uint16) ([]uint16, error) {
+	s.sets = append(s.sets, len(values))
+	return s.SliceStorage.Set(table, address, values)
+}
+
+func TestAdmin_Ranges(t *testing.T) {
+	config := &mbslave.Config{SizeInputRegisters: 8}
+	s := &rangeStorage{SliceStorage: mbslave.NewSliceStorage(config)}
+	a, _ := newAdmin()
+	a.DataModel = mbslave.NewStorageDataModel(config, s)
+	a.DataModel.SetCallbackInputRegisters(2, func(event mbslave.Event, address uint16, value uint16) {
+		if event == mbslave.EventRead {
+			t.Errorf("the read callback of %d was called", address)
+		}
+	})
+
+	// every write at once, the reads are no Modbus reads
+	do(a, http.MethodPut, "/api/map/temperature", `{"value": 21.5}`)
+	do(a, http.MethodPut, "/api/tables/ir", `{"address": 4, "values": [1, 2, 3]}`)
+	if err := gotest.Expect(s.sets).Eq([]int{2, 3}); err != nil {
+		t.Error(err)
+	}
+	w := do(a, http.MethodGet, "/api/map/temperature", "")
+	if err := gotest.Expect(strings.Contains(w.Body.String(), `"value":21.5`)).True(); err != nil {
+		t.Error(err, w.Body.String())
+	}
+	do(a, http.MethodGet, "/api/tables/ir?address=2&count=2", "")
+}
@@ -0,0 +1,247 @@
+// Package audit records every write to a DefaultDataModel with the request
+// that caused it: writes of the function codes 5, 6, 15 and 16, the ones the
+// data model rejected with an exception and the local calls of the Set methods.
+//
+//	a := audit.NewAudit(sink)
+//	defer a.Watch(dm)()
+//	dm.Use(a.Middleware())
+package audit
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/schnack/mbslave"
+)
+
+const (
+	SourceModbus = "modbus"
+	SourceLocal  = "local"
+)
+
+// Record - one write, Rejected ones carry the exception and the value that was not written
+type Record struct {
+	Time time.Time `json:"time"`
+	// Source - SourceModbus or SourceLocal
+	Source    string        `json:"source"`
+	Transport string        `json:"transport,omitempty"`
+	Remote    string        `json:"remote,omitempty"`
+	Unit      uint8         `json:"unit"`
+	Function  uint8         `json:"function,omitempty"`
+	Table     mbslave.Table `json:"table"`
+	Address   uint16        `json:"address"`
+	Old       uint16        `json:"old"`
+	New       uint16        `json:"new"`
+	Rejected  bool          `json:"rejected,omitempty"`
+	Exception uint8         `json:"exception,omitempty"`
+}
+
+// Sink - receives every record, e.g. a FileSink
+type Sink interface {
+	Write(record Record) error
+}
+
+// SinkFunc - adapts a function to Sink
+type SinkFunc func(record Record) error
+
+func (f SinkFunc) Write(record Record) error {
+	return f(record)
+}
+
+// Audit - keeps the recent records in memory for History and passes all of them to the Sink
+type Audit struct {
+	// Sink receives every record, optional
+	Sink Sink
+	// Capacity - records kept for History, 1000 when zero
+	Capacity int
+	// OnError is called when the sink fails, optional
+	OnError func(err error)
+	// Now - the clock of the records, time.Now when nil
+	Now func() time.Time
+
+	mu      sync.Mutex
+	records []Record
+	next    int
+	muSink  sync.Mutex
+	muDm    sync.RWMutex
+	models  map[uint8]*mbslave.DefaultDataModel
+}
+
+func NewAudit(sink Sink) *Audit {
+	return &Audit{Sink: sink, models: make(map[uint8]*mbslave.DefaultDataModel)}
+}
+
+// Watch - records the changes of the data model until cancel is called.
+// The records are written while the data model holds the lock of the table,
+// a slow sink slows down the writes.
+func (a *Audit) Watch(dm *mbslave.DefaultDataModel) (cancel func()) {
+	a.muDm.Lock()
+	if a.models == nil {
+		a.models = make(map[uint8]*mbslave.DefaultDataModel)
+	}
+	a.models[dm.SlaveId] = dm
+	a.muDm.Unlock()
+
+	stop := dm.Watch(func(change mbslave.Change) {
+		record := Record{
+			Source:  SourceLocal,
+			Unit:    dm.SlaveId,
+			Table:   change.Table,
+			Address: change.Address,
+			Old:     change.Old,
+			New:     change.Value,
+		}
+		if info := change.Source; info != nil {
+			record.Source = SourceModbus
+			record.Transport = info.Transport
+			record.Remote = info.Remote
+			record.Function = info.Function
+		}
+		a.Record(record)
+	})
+	return func() {
+		stop()
+		a.muDm.Lock()
+		if a.models[dm.SlaveId] == dm {
+			delete(a.models, dm.SlaveId)
+		}
+		a.muDm.Unlock()
+	}
+}
+
+// Middleware - records the writes answered with an exception, the accepted
+// ones are recorded by Watch. It may wrap the data model or the whole server.
+func (a *Audit) Middleware() mbslave.Middleware {
+	return func(next mbslave.Handler) mbslave.Handler {
+		return func(ctx context.Context, req mbslave.Request, resp mbslave.Response) {
+			next(ctx, req, resp)
+			if resp.GetError() == 0 {
+				return
+			}
+			table, values := attempted(req)
+			if values == nil {
+				return
+			}
+			info, _ := mbslave.RequestInfoFrom(ctx)
+			for i, value := range values {
+				address := req.GetAddress() + uint16(i)
+				a.Record(Record{
+					Source:    SourceModbus,
+					Transport: info.Transport,
+					Remote:    info.Remote,
+					Unit:      req.GetSlaveId(),
+					Function:  req.GetFunction(),
+					Table:     table,
+					Address:   address,
+					Old:       a.current(req.GetSlaveId(), table, address),
+					New:       value,
+					Rejected:  true,
+					Exception: resp.GetError(),
+				})
+			}
+		}
+	}
+}
+
+// Record - adds a record, the time is set when it is zero
+func (a *Audit) Record(record Record) {
+	if record.Time.IsZero() {
+		if a.Now != nil {
+			record.Time = a.Now()
+		} else {
+			record.Time = time.Now()
+		}
+	}
+
+	a.mu.Lock()
+	capacity := a.Capacity
+	if capacity <= 0 {
+		capacity = 1000
+	}
+	if len(a.records) < capacity {
+		a.records = append(a.records, record)
+	} else {
+		a.records[a.next%len(a.records)] = record
+	}
+	a.next++
+	a.mu.Unlock()
+
+	if a.Sink == nil {
+		return
+	}
+	a.muSink.Lock()
+	err := a.Sink.Write(record)
+	a.muSink.Unlock()
+	if err != nil && a.OnError != nil {
+		a.OnError(err)
+	}
+}
+
+// History - the recent records of one register or bit, newest first.
+// A limit of zero or less returns all kept records.
+func (a *Audit) History(unit uint8, table mbslave.Table, address uint16, limit int) []Record {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var history []Record
+	for i := 1; i <= len(a.records); i++ {
+		record := a.records[(a.next-i)%len(a.records)]
+		if record.Unit != unit || record.Table != table || record.Address != address {
+			continue
+		}
+		history = append(history, record)
+		if limit > 0 && len(history) == limit {
+			break
+		}
+	}
+	return history
+}
+
+func (a *Audit) current(unit uint8, table mbslave.Table, address uint16) uint16 {
+	a.muDm.RLock()
+	dm := a.models[unit]
+	a.muDm.RUnlock()
+	if dm == nil || int(address) >= dm.Length(table) {
+		return 0
+	}
+	// no Modbus read, the read callbacks are not called
+	values := make([]uint16, 1)
+	if err := dm.Peek(table, address, values); err != nil {
+		return 0
+	}
+	return values[0]
+}
+
+// attempted - the values of a write request, nil for other functions
+func attempted(req mbslave.Request) (mbslave.Table, []uint16) {
+	data := req.GetData()
+	switch req.GetFunction() {
+	case mbslave.FuncWriteSingleCoil:
+		if len(data) < 2 {
+			return mbslave.TableCoils, nil
+		}
+		if binary.BigEndian.Uint16(data) != 0 {
+			return mbslave.TableCoils, []uint16{1}
+		}
+		return mbslave.TableCoils, []uint16{0}
+	case mbslave.FuncWriteSingleRegister:
+		if len(data) < 2 {
+			return mbslave.TableHoldingRegisters, nil
+		}
+		return mbslave.TableHoldingRegisters, []uint16{binary.BigEndian.Uint16(data)}
+	case mbslave.FuncWriteMultipleCoils:
+		values := make([]uint16, 0, req.GetQuantity())
+		for i := 0; i < int(req.GetQuantity()) && i/8 < len(data); i++ {
+			values = append(values, uint16(data[i/8]>>(i%8)&0x01))
+		}
+		return mbslave.TableCoils, values
+	case mbslave.FuncWriteMultipleRegisters:
+		values := make([]uint16, 0, req.GetQuantity())
+		for i := 0; i < int(req.GetQuantity()) && (i+1)*2 <= len(data); i++ {
+			values = append(values, binary.BigEndian.Uint16(data[i*2:]))
+		}
+		return mbslave.TableHoldingRegisters, values
+	}
+	return 0, nil
+}
@@ -0,0 +1,200 @@
+package audit
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/schnack/gotest"
+	"github.com/schnack/mbslave"
+)
+
+func handle(dm *mbslave.DefaultDataModel, adu []byte) mbslave.Response {
+	ctx := mbslave.WithRequestInfo(context.Background(), mbslave.RequestInfo{Transport: "tcp", Remote: "10.0.0.7:50210", Unit: adu[0], Function: adu[1]})
+	request := mbslave.NewRtuRequest(mbslave.AppendCrc(adu))
+	response := mbslave.NewRtuResponse(request)
+	dm.HandleContext(ctx, request, response)
+	return response
+}
+
+func TestAudit(t *testing.T) {
+	dm := mbslave.NewDefaultDataModel(&mbslave.Config{SlaveId: 1, SizeCoils: 8, SizeHoldingRegisters: 8})
+	var written []Record
+	a := NewAudit(SinkFunc(func(record Record) error {
+		written = append(written, record)
+		return nil
+	}))
+	a.Now = func() time.Time { return time.Unix(1700000000, 0) }
+	defer a.Watch(dm)()
+	dm.Use(a.Middleware())
+
+	_ = dm.SetHoldingRegisters(1, 7)
+	handle(dm, []byte{0x01, 0x06, 0x00, 0x01, 0x00, 0x2a})
+	handle(dm, []byte{0x01, 0x10, 0x00, 0x07, 0x00, 0x02, 0x04, 0x00, 0x01, 0x00, 0x02})
+	handle(dm, []byte{0x01, 0x05, 0x00, 0x02, 0xff, 0x00})
+
+	if err := gotest.Expect(written[0]).Eq(Record{Time: time.Unix(1700000000, 0), Source: SourceLocal, Unit: 1, Table: mbslave.TableHoldingRegisters, Address: 1, Old: 0, New: 7}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(written[1]).Eq(Record{Time: time.Unix(1700000000, 0), Source: SourceModbus, Transport: "tcp", Remote: "10.0.0.7:50210", Unit: 1, Function: 6, Table: mbslave.TableHoldingRegisters, Address: 1, Old: 7, New: 42}); err != nil {
+		t.Error(err)
+	}
+	// the range 7..8 is outside the table
+	rejected := written[len(written)-3 : len(written)-1]
+	if err := gotest.Expect(rejected[1]).Eq(Record{Time: time.Unix(1700000000, 0), Source: SourceModbus, Transport: "tcp", Remote: "10.0.0.7:50210", Unit: 1, Function: 16, Table: mbslave.TableHoldingRegisters, Address: 8, Old: 0, New: 2, Rejected: true, Exception: mbslave.ErrorAddress}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(written[len(written)-1].Table).Eq(mbslave.TableCoils); err != nil {
+		t.Error(err)
+	}
+
+	history := a.History(1, mbslave.TableHoldingRegisters, 1, 0)
+	if err := gotest.Expect(len(history)).Eq(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(history[0].New).Eq(uint16(42)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(len(a.History(1, mbslave.TableHoldingRegisters, 1, 1))).Eq(1); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(len(a.History(2, mbslave.TableHoldingRegisters, 1, 0))).Eq(0); err != nil {
+		t.Error(err)
+	}
+}
+
+// request - the PDU of the response of the server at addr to a request for unit 1
+func request(t *testing.T, addr net.Addr, pdu []byte) []byte {
+	conn, err := net.DialTimeout("tcp", addr.String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write(mbslave.MbapFrame(1, 0x01, pdu)); err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, mbslave.MbapHeaderSize)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatal(err)
+	}
+	mbap, _ := mbslave.ParseMbapHeader(header)
+	resp := make([]byte, int(mbap.Length)-1)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestAudit_Server(t *testing.T) {
+	config := &mbslave.Config{Address: "127.0.0.1:0", SlaveId: 1, SizeHoldingRegisters: 8}
+	dm := mbslave.NewDefaultDataModel(config)
+	limited := mbslave.NewTcpTransport(config)
+	limited.Log = mbslave.NopLogger{}
+	limited.RateLimit = 0.1
+	limited.RateBurst = 2
+	readOnly := mbslave.NewTcpTransport(config)
+	readOnly.Log = mbslave.NopLogger{}
+
+	var mu sync.Mutex
+	var written []Record
+	a := NewAudit(SinkFunc(func(record Record) error {
+		mu.Lock()
+		defer mu.Unlock()
+		written = append(written, record)
+		return nil
+	}))
+	defer a.Watch(dm)()
+	server := mbslave.NewServer(limited, dm)
+	server.AddTransport(readOnly, mbslave.TransportOptions{ReadOnly: true})
+	// outermost, the writes denied by the other middlewares are recorded too
+	server.Use(a.Middleware())
+	server.Use(mbslave.AuthorizeMiddleware(func(info mbslave.RequestInfo, req mbslave.Request) bool {
+		return req.GetFunction() != mbslave.FuncWriteMultipleRegisters
+	}, mbslave.NopLogger{}))
+	done := make(chan error)
+	go func() { done <- server.Listen() }()
+	defer func() {
+		_ = server.Close()
+		<-done
+	}()
+
+	request(t, limited.Addr(), []byte{0x10, 0x00, 0x01, 0x00, 0x01, 0x02, 0x00, 0x01})
+	request(t, limited.Addr(), []byte{0x06, 0x00, 0x02, 0x00, 0x02})
+	request(t, limited.Addr(), []byte{0x06, 0x00, 0x03, 0x00, 0x03})
+	request(t, readOnly.Addr(), []byte{0x06, 0x00, 0x04, 0x00, 0x04})
+
+	type write struct {
+		Address   uint16
+		Rejected  bool
+		Exception uint8
+	}
+	var writes []write
+	mu.Lock()
+	defer mu.Unlock()
+	for _, record := range written {
+		writes = append(writes, write{record.Address, record.Rejected, record.Exception})
+	}
+	if err := gotest.Expect(writes).Eq([]write{
+		{Address: 1, Rejected: true, Exception: mbslave.ErrorFunction},
+		{Address: 2},
+		{Address: 3, Rejected: true, Exception: mbslave.ErrorWait},
+		{Address: 4, Rejected: true, Exception: mbslave.ErrorFunction},
+	}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAudit_Current(t *testing.T) {
+	dm := mbslave.NewDefaultDataModel(&mbslave.Config{SlaveId: 1, SizeHoldingRegisters: 8})
+	var written []Record
+	a := NewAudit(SinkFunc(func(record Record) error {
+		written = append(written, record)
+		return nil
+	}))
+	_ = dm.SetHoldingRegisters(7, 5)
+	defer a.Watch(dm)()
+	dm.Use(a.Middleware())
+	// the callbacks run in their own goroutines
+	read := make(chan uint16, 1)
+	dm.SetCallbackHoldingRegisters(7, func(event mbslave.Event, address uint16, value uint16) {
+		if event == mbslave.EventRead {
+			read <- address
+		}
+	})
+
+	// the range 7..8 is outside the table, the old values are read without the callbacks
+	handle(dm, []byte{0x01, 0x10, 0x00, 0x07, 0x00, 0x02, 0x04, 0x00, 0x01, 0x00, 0x02})
+	if err := gotest.Expect(len(written)).Eq(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(written[0].Old).Eq(uint16(5)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(written[0].Rejected).True(); err != nil {
+		t.Error(err)
+	}
+	select {
+	case address := <-read:
+		t.Errorf("the read callback of %d was called", address)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestAudit_Capacity(t *testing.T) {
+	a := &Audit{Capacity: 3}
+	for i := 0; i < 5; i++ {
+		a.Record(Record{Table: mbslave.TableHoldingRegisters, New: uint16(i)})
+	}
+	history := a.History(0, mbslave.TableHoldingRegisters, 0, 0)
+	var values []uint16
+	for _, record := range history {
+		values = append(values, record.New)
+	}
+	if err := gotest.Expect(values).Eq([]uint16{4, 3, 2}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,113 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileSink - writes the records as JSON lines. When the file would grow over
+// MaxSize it is renamed to path.1, path.1 to path.2 and so on, the oldest of
+// MaxFiles rotated files is removed.
+type FileSink struct {
+	Path string
+	// MaxSize in bytes, zero never rotates
+	MaxSize int64
+	// MaxFiles - rotated files to keep, zero keeps none
+	MaxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileSink - opens or creates the file, new records are appended
+func NewFileSink(path string, maxSize int64, maxFiles int) (*FileSink, error) {
+	s := &FileSink{Path: path, MaxSize: maxSize, MaxFiles: maxFiles}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) Write(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	if s.MaxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.MaxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+// rotate - the file is opened again even when a rename failed
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+	err := s.shift()
+	if openErr := s.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+func (s *FileSink) shift() error {
+	if s.MaxFiles <= 0 {
+		if err := os.Remove(s.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	_ = os.Remove(rotated(s.Path, s.MaxFiles))
+	for i := s.MaxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(rotated(s.Path, i), rotated(s.Path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(s.Path, rotated(s.Path, 1))
+}
+
+func rotated(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/schnack/gotest"
+	"github.com/schnack/mbslave"
+)
+
+func lines(t *testing.T, path string) []Record {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	record := Record{Source: SourceLocal, Table: mbslave.TableHoldingRegisters, Address: 1, New: 1}
+	line, _ := json.Marshal(record)
+
+	// two records fit into a file
+	sink, err := NewFileSink(path, int64(2*(len(line)+1)), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint16(1); i <= 7; i++ {
+		record.New = i
+		if err := sink.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = sink.Close()
+
+	if err := gotest.Expect(len(lines(t, path))).Eq(1); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(lines(t, path)[0].New).Eq(uint16(7)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(lines(t, path+".1")[0].New).Eq(uint16(5)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(lines(t, path+".2")[0].New).Eq(uint16(3)); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("more rotated files than MaxFiles")
+	}
+	if err := gotest.Expect(lines(t, path+".2")[0].Table).Eq(mbslave.TableHoldingRegisters); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,117 @@
+package mbslave
+
+import (
+	"context"
+	"encoding/binary"
+)
+
+// Permission - function codes allowed on an address range
+type Permission struct {
+	// Functions - allowed function codes, empty allows all
+	Functions []uint8 `json:"functions"`
+	// Start, Count - the addresses a request reads or writes must lie in
+	// Start..Start+Count-1, both ranges of FC23. Count zero allows all addresses
+	Start uint16 `json:"start"`
+	Count int    `json:"count"`
+}
+
+func (p Permission) allows(req Request) bool {
+	if len(p.Functions) > 0 {
+		found := false
+		for _, function := range p.Functions {
+			if function == req.GetFunction() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if p.Count <= 0 {
+		return true
+	}
+	ranges, ok := addressRanges(req)
+	if !ok {
+		return false
+	}
+	for _, r := range ranges {
+		if r[0] < int(p.Start) || r[0]+r[1] > int(p.Start)+p.Count {
+			return false
+		}
+	}
+	return true
+}
+
+// addressRanges - the start and quantity of the addresses the request reads or writes,
+// ok is false when the PDU is too short to hold them
+func addressRanges(req Request) (ranges [][2]int, ok bool) {
+	data := req.GetData()
+	switch req.GetFunction() {
+	case FuncReadWriteRegisters:
+		// read address, read quantity, write address, write quantity
+		if len(data) < 8 {
+			return nil, false
+		}
+		return [][2]int{
+			{int(binary.BigEndian.Uint16(data[0:2])), int(binary.BigEndian.Uint16(data[2:4]))},
+			{int(binary.BigEndian.Uint16(data[4:6])), int(binary.BigEndian.Uint16(data[6:8]))},
+		}, true
+	case FuncMaskWriteRegister:
+		if len(data) < 2 {
+			return nil, false
+		}
+		return [][2]int{{int(binary.BigEndian.Uint16(data[0:2])), 1}}, true
+	}
+	quantity := int(req.GetQuantity())
+	if quantity == 0 {
+		quantity = 1
+	}
+	return [][2]int{{int(req.GetAddress()), quantity}}, true
+}
+
+// Roles - the permissions of every role, a request is allowed when one
+// permission of the role in its RequestInfo allows it. The role is empty for
+// clients without one, e.g. on plain Modbus/TCP.
+type Roles map[string][]Permission
+
+// Authorize - see AuthorizeMiddleware
+func (r Roles) Authorize(info RequestInfo, req Request) bool {
+	for _, permission := range r[info.Role] {
+		if permission.allows(req) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizeMiddleware - requests denied by authorize are answered with
+// ErrorFunction (0x01) as the Modbus/TCP Security profile requires and
+// logged at warn level
+func AuthorizeMiddleware(authorize func(info RequestInfo, req Request) bool, log Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, req Request, resp Response) {
+			// the data model parses it again, a broken frame is left to it
+			if err := req.Parse(); err != nil {
+				next(ctx, req, resp)
+				return
+			}
+			info, _ := RequestInfoFrom(ctx)
+			if authorize(info, req) {
+				next(ctx, req, resp)
+				return
+			}
+			resp.SetError(ErrorFunction)
+			if log.Enabled(LevelWarn) {
+				log.Log(LevelWarn, "request denied",
+					Field{Key: "remote", Value: info.Remote},
+					Field{Key: "role", Value: info.Role},
+					FieldUnit(req.GetSlaveId()),
+					FieldFunction(req.GetFunction()),
+					FieldAddress(req.GetAddress()),
+					FieldQuantity(req.GetQuantity()),
+				)
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package mbslave
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/schnack/gotest"
+)
+
+func TestRoles_Authorize(t *testing.T) {
+	roles := Roles{
+		"operator": {
+			{Functions: []uint8{FuncReadHoldingRegisters, FuncWriteSingleRegister, FuncMaskWriteRegister, FuncReadWriteRegisters}, Start: 10, Count: 10},
+			{Functions: []uint8{FuncReadCoils}},
+		},
+		"engineer": {{}},
+		"zone":     {{Start: 10, Count: 10}},
+	}
+	tests := []struct {
+		role string
+		adu  []byte
+		want bool
+	}{
+		{"operator", []byte{0x01, 0x03, 0x00, 0x0a, 0x00, 0x0a}, true},
+		{"operator", []byte{0x01, 0x03, 0x00, 0x0a, 0x00, 0x0b}, false},
+		{"operator", []byte{0x01, 0x06, 0x00, 0x13, 0x00, 0x01}, true},
+		{"operator", []byte{0x01, 0x06, 0x00, 0x09, 0x00, 0x01}, false},
+		{"operator", []byte{0x01, 0x01, 0x01, 0x00, 0x00, 0x08}, true},
+		{"operator", []byte{0x01, 0x10, 0x00, 0x0a, 0x00, 0x01, 0x02, 0x00, 0x01}, false},
+		{"engineer", []byte{0x01, 0x10, 0x00, 0x0a, 0x00, 0x01, 0x02, 0x00, 0x01}, true},
+		// the write range of FC23 is checked as well as the read range
+		{"operator", []byte{0x01, 0x17, 0x00, 0x0a, 0x00, 0x02, 0x00, 0x0c, 0x00, 0x01, 0x02, 0x00, 0x01}, true},
+		{"operator", []byte{0x01, 0x17, 0x00, 0x0a, 0x00, 0x02, 0x00, 0x00, 0x00, 0x01, 0x02, 0x00, 0x01}, false},
+		{"operator", []byte{0x01, 0x17, 0x00, 0x0a, 0x00, 0x02, 0x00, 0x13, 0x00, 0x02, 0x04, 0x00, 0x01, 0x00, 0x02}, false},
+		{"zone", []byte{0x01, 0x17, 0x00, 0x0a, 0x00, 0x02, 0x00, 0x00, 0x00, 0x01, 0x02, 0x00, 0x01}, false},
+		{"operator", []byte{0x01, 0x16, 0x00, 0x0b, 0xff, 0x00, 0x00, 0x01}, true},
+		{"operator", []byte{0x01, 0x16, 0x00, 0x01, 0xff, 0x00, 0x00, 0x01}, false},
+		{"", []byte{0x01, 0x03, 0x00, 0x0a, 0x00, 0x01}, false},
+	}
+	for _, test := range tests {
+		request := NewRtuRequest(AppendCrc(test.adu))
+		_ = request.Parse()
+		if err := gotest.Expect(roles.Authorize(RequestInfo{Role: test.role}, request)).Eq(test.want); err != nil {
+			t.Errorf("%s % x: %s", test.role, test.adu, err)
+		}
+	}
+}
+
+func TestAuthorizeMiddleware(t *testing.T) {
+	out := new(bytes.Buffer)
+	dm := NewDefaultDataModel(&Config{SlaveId: 1, SizeHoldingRegisters: 8})
+	dm.Use(AuthorizeMiddleware(Roles{"viewer": {{Functions: []uint8{FuncReadHoldingRegisters}}}}.Authorize, NewStdLogger(log.New(out, "", 0), LevelWarn)))
+	ctx := WithRequestInfo(context.Background(), RequestInfo{Remote: "10.0.0.7:50210", Role: "viewer"})
+
+	response := handle(func(req Request, resp Response) { dm.HandleContext(ctx, req, resp) }, AppendCrc([]byte{0x01, 0x06, 0x00, 0x01, 0x00, 0x2a}))
+	if err := gotest.Expect(response.GetError()).Eq(ErrorFunction); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(dm.GetHoldingRegisters(1)).Eq(uint16(0)); err != nil {
+		t.Error(err)
+	}
+	if !strings.HasPrefix(out.String(), "WARN request denied remote=10.0.0.7:50210 role=viewer unit=1 function=6 address=1 quantity=1") {
+		t.Error(out.String())
+	}
+
+	response = handle(func(req Request, resp Response) { dm.HandleContext(ctx, req, resp) }, AppendCrc([]byte{0x01, 0x03, 0x00, 0x01, 0x00, 0x01}))
+	if err := gotest.Expect(response.GetError()).Eq(uint8(0)); err != nil {
+		t.Error(err)
+	}
+}
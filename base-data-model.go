@@ -1,38 +1,132 @@
 package mbslave
 
+import (
+	"context"
+	"time"
+)
+
 type BaseDataModel struct {
-	SlaveId  uint8
-	function [256]func(Request, Response)
+	SlaveId     uint8
+	Metrics     Metrics
+	Tracer      Tracer
+	function    [256]Handler
+	middlewares []Middleware
 }
 
 func (bdm *BaseDataModel) SetSlaveId(id uint8) {
 	bdm.SlaveId = id
 }
 
+func (bdm *BaseDataModel) SetMetrics(m Metrics) {
+	bdm.Metrics = m
+}
+
+func (bdm *BaseDataModel) SetTracer(t Tracer) {
+	bdm.Tracer = t
+}
+
 func (bdm *BaseDataModel) SetFunction(code uint8, f func(Request, Response)) {
-	bdm.function[code] = f
+	bdm.function[code] = HandlerFunc(f)
+}
+
+// SetContextFunction - registers a handler receiving the context of the transport
+func (bdm *BaseDataModel) SetContextFunction(code uint8, h Handler) {
+	bdm.function[code] = h
+}
+
+// Use - wraps the handlers of all functions, also the ones registered later and the
+// answer to unsupported functions. The first middleware is the outermost. Call it before Listen.
+func (bdm *BaseDataModel) Use(middlewares ...Middleware) {
+	bdm.middlewares = append(bdm.middlewares, middlewares...)
+}
+
+// GetFunction - returns the handler registered for the function code or nil,
+// a context-aware handler is called with context.Background
+func (bdm *BaseDataModel) GetFunction(code uint8) func(Request, Response) {
+	h := bdm.function[code]
+	if h == nil {
+		return nil
+	}
+	return func(req Request, resp Response) {
+		h(context.Background(), req, resp)
+	}
+}
+
+// GetContextFunction - returns the handler registered for the function code or nil
+func (bdm *BaseDataModel) GetContextFunction(code uint8) Handler {
+	return bdm.function[code]
 }
 
 func (bdm *BaseDataModel) Handler(req Request, resp Response) {
+	bdm.HandleContext(context.Background(), req, resp)
+}
 
-	if req.GetSlaveId() != bdm.SlaveId && req.GetSlaveId() != 255 {
+// HandleContext - Handler with the context of the transport passed to the functions
+func (bdm *BaseDataModel) HandleContext(ctx context.Context, req Request, resp Response) {
+	metrics := bdm.metrics()
+
+	unit := req.GetSlaveId()
+	broadcast := IsBroadcast(ctx, req)
+	// Modbus/TCP addresses the server itself with 0 and 255
+	if unit != bdm.SlaveId && !broadcast && unit != 0 && unit != 255 {
 		resp.Unanswered(true)
 		return
 	}
 
-	if err := req.Parse(); err != nil {
+	tracer := bdm.tracer()
+	_, span := tracer.Start(ctx, "modbus.parse", time.Time{})
+	err := req.Parse()
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+	if err != nil {
+		// the transport counts the damaged frames
 		resp.Unanswered(true)
 		return
 	}
+	metrics.Request(req.GetSlaveId(), req.GetFunction())
 
-	if bdm.function[req.GetFunction()] != nil {
-		bdm.function[req.GetFunction()](req, resp)
+	ctx, span = tracer.Start(ctx, "modbus.function", time.Time{},
+		FieldUnit(req.GetSlaveId()),
+		FieldFunction(req.GetFunction()),
+		FieldAddress(req.GetAddress()),
+		FieldQuantity(req.GetQuantity()),
+	)
+	if f := bdm.function[req.GetFunction()]; f != nil {
+		start := time.Now()
+		chain(f, bdm.middlewares)(ctx, req, resp)
+		metrics.HandlerDuration(req.GetFunction(), time.Since(start))
 	} else {
-		resp.SetError(ErrorFunction)
+		chain(unsupportedFunction, bdm.middlewares)(ctx, req, resp)
+	}
+	span.SetAttributes(FieldException(resp.GetError()))
+	span.End()
+	if resp.GetError() != 0 {
+		metrics.Exception(req.GetFunction(), resp.GetError())
 	}
 
-	if req.GetSlaveId() == 255 {
+	if broadcast {
+		metrics.Broadcast()
 		resp.Unanswered(true)
 	}
 	return
 }
+
+func unsupportedFunction(_ context.Context, req Request, resp Response) {
+	resp.SetError(ErrorFunction)
+}
+
+func (bdm *BaseDataModel) tracer() Tracer {
+	if bdm.Tracer == nil {
+		return NopTracer{}
+	}
+	return bdm.Tracer
+}
+
+func (bdm *BaseDataModel) metrics() Metrics {
+	if bdm.Metrics == nil {
+		return NopMetrics{}
+	}
+	return bdm.Metrics
+}
@@ -39,3 +39,14 @@ func TestBaseDataModel_Handler(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestBaseDataModel_GetFunction(t *testing.T) {
+	bdm := BaseDataModel{}
+	if err := gotest.Expect(bdm.GetFunction(0x03)).Nil(); err != nil {
+		t.Error(err)
+	}
+	bdm.SetFunction(0x03, func(req Request, resp Response) {})
+	if err := gotest.Expect(bdm.GetFunction(0x03)).NotNil(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,267 @@
+package mbslave
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Bus - an in-memory multi-drop line shared by the ports of Attach. Every character
+// occupies the line for its transmission time at the baud rate of the config,
+// characters sent by several nodes at once collide.
+type Bus struct {
+	// Echo - the nodes also receive their own characters, like a transceiver
+	// with the receiver always enabled
+	Echo bool
+
+	config     Config
+	clock      Clock
+	mu         sync.Mutex
+	cond       *sync.Cond
+	nodes      []*BusPort
+	noise      []byte
+	collisions int
+	closed     bool
+	done       chan struct{}
+}
+
+// Clock - the time of a Bus and of an RtuTransport, tests pass one that advances on Sleep without waiting
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	// After - receives the time once d has passed on the clock
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock - the wall clock
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time        { return time.Now() }
+func (SystemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+func (SystemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// NewBus - the baud rate and the character format of the config set the timing,
+// a zero baud rate delivers the characters without delay
+func NewBus(config *Config) *Bus {
+	return NewBusWithClock(config, SystemClock{})
+}
+
+// NewBusWithClock - a bus whose characters take their transmission time on the clock
+func NewBusWithClock(config *Config, clock Clock) *Bus {
+	b := &Bus{config: *config, clock: clock, done: make(chan struct{})}
+	b.cond = sync.NewCond(&b.mu)
+	go b.run()
+	return b
+}
+
+// Attach - a new node of the bus
+func (b *Bus) Attach() *BusPort {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	port := &BusPort{bus: b, closed: b.closed}
+	b.nodes = append(b.nodes, port)
+	return port
+}
+
+// Noise - sends the characters from no node, they collide with the transmissions of the nodes
+func (b *Bus) Noise(data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.noise = append(b.noise, data...)
+	b.cond.Broadcast()
+}
+
+// Collisions - the number of characters sent by more than one source
+func (b *Bus) Collisions() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.collisions
+}
+
+// Close - stops the line and closes all ports
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		for _, node := range b.nodes {
+			node.closed = true
+			node.tx = nil
+		}
+		b.cond.Broadcast()
+	}
+	b.mu.Unlock()
+	<-b.done
+	return nil
+}
+
+// run - moves one character slot at a time, a slot follows the previous one
+// without a gap while somebody transmits
+func (b *Bus) run() {
+	defer close(b.done)
+	char := b.config.TransmissionTime(1)
+	var slot time.Time
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		for !b.closed && !b.transmitting() {
+			b.cond.Wait()
+		}
+		if b.closed {
+			return
+		}
+
+		if now := b.clock.Now(); now.Sub(slot) >= char {
+			slot = now
+		}
+		var chars []byte
+		var senders []*BusPort
+		for _, node := range b.nodes {
+			if len(node.tx) > 0 {
+				chars = append(chars, node.tx[0])
+				senders = append(senders, node)
+			}
+		}
+		if len(b.noise) > 0 {
+			chars = append(chars, b.noise[0])
+			b.noise = b.noise[1:]
+		}
+		slot = slot.Add(char)
+
+		b.mu.Unlock()
+		b.clock.Sleep(slot.Sub(b.clock.Now()))
+		b.mu.Lock()
+
+		c := chars[0]
+		if len(chars) > 1 {
+			// the drivers fight, the receivers see a corrupted character
+			b.collisions++
+			for _, other := range chars[1:] {
+				c &= other
+			}
+		}
+		for _, node := range senders {
+			if len(node.tx) > 0 {
+				node.tx = node.tx[1:]
+			}
+		}
+		for _, node := range b.nodes {
+			if !node.closed && (b.Echo || !containsPort(senders, node)) {
+				node.rx = append(node.rx, c)
+			}
+		}
+		b.cond.Broadcast()
+	}
+}
+
+func (b *Bus) transmitting() bool {
+	if len(b.noise) > 0 {
+		return true
+	}
+	for _, node := range b.nodes {
+		if len(node.tx) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPort(ports []*BusPort, port *BusPort) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// BusPort - a node of a Bus, it implements serial.Port
+type BusPort struct {
+	bus    *Bus
+	tx     []byte
+	rx     []byte
+	closed bool
+}
+
+// Read - blocks until a character was received
+func (p *BusPort) Read(b []byte) (int, error) {
+	bus := p.bus
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	for !p.closed && len(p.rx) == 0 {
+		bus.cond.Wait()
+	}
+	if len(p.rx) == 0 {
+		return 0, io.ErrClosedPipe
+	}
+	n := copy(b, p.rx)
+	p.rx = p.rx[n:]
+	return n, nil
+}
+
+// Write - blocks until the last character has left the line
+func (p *BusPort) Write(b []byte) (int, error) {
+	bus := p.bus
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if p.closed {
+		return 0, io.ErrClosedPipe
+	}
+	p.tx = append(p.tx, b...)
+	bus.cond.Broadcast()
+	for !p.closed && len(p.tx) > 0 {
+		bus.cond.Wait()
+	}
+	if p.closed {
+		return 0, io.ErrClosedPipe
+	}
+	return len(b), nil
+}
+
+// Close - detaches the node, the characters not sent yet are dropped
+func (p *BusPort) Close() error {
+	bus := p.bus
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	p.closed = true
+	p.tx = nil
+	for i, node := range bus.nodes {
+		if node == p {
+			bus.nodes = append(bus.nodes[:i], bus.nodes[i+1:]...)
+			break
+		}
+	}
+	bus.cond.Broadcast()
+	return nil
+}
+
+// SetMode - the timing is set by the config of the bus
+func (p *BusPort) SetMode(mode *serial.Mode) error {
+	return nil
+}
+
+func (p *BusPort) ResetInputBuffer() error {
+	p.bus.mu.Lock()
+	defer p.bus.mu.Unlock()
+	p.rx = nil
+	return nil
+}
+
+func (p *BusPort) ResetOutputBuffer() error {
+	return nil
+}
+
+func (p *BusPort) SetDTR(dtr bool) error {
+	return nil
+}
+
+func (p *BusPort) SetRTS(rts bool) error {
+	return nil
+}
+
+func (p *BusPort) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{}, nil
+}
@@ -0,0 +1,241 @@
+package mbslave
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/schnack/gotest"
+	"go.bug.st/serial"
+)
+
+// virtualClock - advances on Sleep without waiting, the bus delivers the characters at once
+// and the transmission times are exact
+type virtualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []clockWaiter
+}
+
+// clockWaiter - a channel of After that receives once the clock reaches at
+type clockWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func (c *virtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *virtualClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d > 0 {
+		c.now = c.now.Add(d)
+	}
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+		} else {
+			w.c <- c.now
+		}
+	}
+	c.waiters = waiters
+}
+
+func (c *virtualClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := clockWaiter{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- c.now
+	} else {
+		c.waiters = append(c.waiters, w)
+	}
+	return w.c
+}
+
+// newBusSlaves - a transport with its own tables for every unit, all attached to the bus,
+// the slaves keep the silent interval and the character timeout of the bus config on the clock of the bus
+func newBusSlaves(t *testing.T, bus *Bus, units ...uint8) []*DefaultDataModel {
+	ports := make(map[string]serial.Port)
+	open := OpenSerialPort
+	OpenSerialPort = func(config *Config) (serial.Port, error) {
+		return ports[config.Port], nil
+	}
+	t.Cleanup(func() { OpenSerialPort = open })
+
+	models := make([]*DefaultDataModel, len(units))
+	for i, unit := range units {
+		config := &Config{Port: string(rune('a' + i)), SlaveId: unit, BaudRate: bus.config.BaudRate, SilentInterval: bus.config.SilentInterval, CharTimeout: bus.config.CharTimeout, SizeCoils: 8, SizeHoldingRegisters: 8}
+		ports[config.Port] = bus.Attach()
+		models[i] = NewDefaultDataModel(config)
+		models[i].Metrics = NewStats(nil)
+		rt := NewRtuTransport(config)
+		rt.Log = NopLogger{}
+		rt.Clock = bus.clock
+		rt.Metrics = models[i].Metrics
+		rt.SetHandler(models[i].Handler)
+		connected := make(chan struct{})
+		rt.OnState = func(state PortState, err error) {
+			if state == PortConnected {
+				close(connected)
+			}
+		}
+		done := make(chan error)
+		go func() { done <- rt.Listen() }()
+		<-connected
+		t.Cleanup(func() {
+			_ = rt.Close()
+			<-done
+		})
+	}
+	return models
+}
+
+// readN - fails the test when the bytes do not arrive within a second
+func readN(t *testing.T, port *BusPort, n int) []byte {
+	deadline := time.Now().Add(time.Second)
+	for received(port) < n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if received(port) < n {
+		t.Fatalf("received %d of %d bytes", received(port), n)
+	}
+	b := make([]byte, n)
+	for read := 0; read < n; {
+		m, err := port.Read(b[read:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		read += m
+	}
+	return b
+}
+
+// drained - every port has read the bytes delivered to it
+func drained(bus *Bus) bool {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	for _, port := range bus.nodes {
+		if len(port.rx) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func received(port *BusPort) int {
+	port.bus.mu.Lock()
+	defer port.bus.mu.Unlock()
+	return len(port.rx)
+}
+
+func TestBus_Units(t *testing.T) {
+	clock := &virtualClock{now: time.Unix(0, 0)}
+	bus := NewBusWithClock(&Config{BaudRate: 9600}, clock)
+	defer bus.Close()
+	models := newBusSlaves(t, bus, 1, 2)
+	_ = models[0].SetHoldingRegisters(0, 0x11)
+	_ = models[1].SetHoldingRegisters(0, 0x2a)
+	master := bus.Attach()
+
+	char := bus.config.TransmissionTime(1)
+	start := clock.Now()
+	if _, err := master.Write(AppendCrc([]byte{0x02, 0x03, 0x00, 0x00, 0x00, 0x01})); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(readN(t, master, 7)).Eq(AppendCrc([]byte{0x02, 0x03, 0x02, 0x00, 0x2a})); err != nil {
+		t.Error(err)
+	}
+	// 8 characters of the request and 7 characters of the response
+	if err := gotest.Expect(clock.Now().Sub(start)).Eq(15 * char); err != nil {
+		t.Error(err)
+	}
+
+	// the master keeps the silent interval of 3.5 characters after the response like on
+	// a real line. The transports time it on the bus clock: unit 1 drops the response
+	// it heard once the silent interval has passed on the clock.
+	deadline := time.Now().Add(time.Second)
+	for !drained(bus) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	silent := 7 * char / 2
+	clock.Sleep(silent)
+
+	// a broadcast is applied by all units and answered by none
+	if _, err := master.Write(AppendCrc([]byte{0x00, 0x05, 0x00, 0x01, 0xff, 0x00})); err != nil {
+		t.Fatal(err)
+	}
+	deadline = time.Now().Add(time.Second)
+	for !(models[0].GetCoils(1) && models[1].GetCoils(1)) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := gotest.Expect(models[0].GetCoils(1) && models[1].GetCoils(1)).True(); err != nil {
+		t.Error(err)
+	}
+	// unit 1 dropped the response it heard before the broadcast
+	if err := gotest.Expect(models[0].Metrics.(*Stats).Snapshot().CrcErrors).Eq(uint64(1)); err != nil {
+		t.Error(err)
+	}
+	// the broadcast starts after the silent interval instead of the last character
+	if err := gotest.Expect(clock.Now().Sub(start)).Eq(23*char + silent); err != nil {
+		t.Error(err)
+	}
+	time.Sleep(25 * time.Millisecond)
+	if err := gotest.Expect(received(master)).Eq(0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBus_Collision(t *testing.T) {
+	// the noise collides on the wall clock only, the scheduler of a loaded test run
+	// may pause longer than 3.5 characters and the frames are split by a longer silent interval
+	// without the character timeout
+	bus := NewBus(&Config{BaudRate: 9600, SilentInterval: 20 * time.Millisecond, CharTimeout: -1})
+	defer bus.Close()
+	newBusSlaves(t, bus, 1)
+	master := bus.Attach()
+	request := AppendCrc([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01})
+
+	bus.Noise([]byte{0x55, 0xaa, 0x55, 0xaa, 0x55, 0xaa, 0x55, 0xaa})
+	if _, err := master.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := gotest.Expect(bus.Collisions() > 0).True(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(received(master)).Eq(0); err != nil {
+		t.Error("the corrupted request was answered")
+	}
+
+	// the next request on a quiet line is answered
+	if _, err := master.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(readN(t, master, 7)).Eq(AppendCrc([]byte{0x01, 0x03, 0x02, 0x00, 0x00})); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBus_Echo(t *testing.T) {
+	bus := NewBus(&Config{})
+	bus.Echo = true
+	a, b := bus.Attach(), bus.Attach()
+	_, _ = a.Write([]byte{0x01, 0x02})
+	if err := gotest.Expect(readN(t, a, 2)).Eq([]byte{0x01, 0x02}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(readN(t, b, 2)).Eq([]byte{0x01, 0x02}); err != nil {
+		t.Error(err)
+	}
+
+	_ = bus.Close()
+	if _, err := a.Read(make([]byte, 1)); err == nil {
+		t.Error("expected an error after Close")
+	}
+}
@@ -0,0 +1,133 @@
+package mbslave
+
+import (
+	"encoding/binary"
+)
+
+const (
+	// ModbusTcpPort - the port of the synthesized TCP headers, Wireshark dissects it as Modbus/TCP
+	ModbusTcpPort = uint16(502)
+	// tcpClientPort - the port of the synthesized client
+	tcpClientPort = uint16(49152)
+
+	ethernetHeaderSize = 14
+	ipv4HeaderSize     = 20
+	tcpHeaderSize      = 20
+	etherTypeIpv4      = uint16(0x0800)
+	ipProtocolTcp      = uint8(6)
+	tcpFlagsPshAck     = uint8(0x18)
+)
+
+var (
+	// the synthesized client and server, locally administered MACs and TEST-NET-1 addresses
+	tcpClientMac = [6]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	tcpServerMac = [6]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+	tcpClientIp  = [4]byte{192, 0, 2, 1}
+	tcpServerIp  = [4]byte{192, 0, 2, 2}
+)
+
+// tcpStream - the sequence numbers of the synthesized connection
+type tcpStream struct {
+	seq [2]uint32
+}
+
+// frame - the ADU in an Ethernet/IPv4/TCP frame, received ADUs go from the client to port 502
+func (ts *tcpStream) frame(direction Direction, adu []byte) []byte {
+	srcMac, dstMac := tcpClientMac, tcpServerMac
+	srcIp, dstIp := tcpClientIp, tcpServerIp
+	srcPort, dstPort := tcpClientPort, ModbusTcpPort
+	from, to := 0, 1
+	if direction == DirectionOut {
+		srcMac, dstMac = dstMac, srcMac
+		srcIp, dstIp = dstIp, srcIp
+		srcPort, dstPort = dstPort, srcPort
+		from, to = 1, 0
+	}
+
+	frame := make([]byte, ethernetHeaderSize+ipv4HeaderSize+tcpHeaderSize+len(adu))
+	copy(frame[0:6], dstMac[:])
+	copy(frame[6:12], srcMac[:])
+	binary.BigEndian.PutUint16(frame[12:14], etherTypeIpv4)
+
+	ip := frame[ethernetHeaderSize : ethernetHeaderSize+ipv4HeaderSize]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(ipv4HeaderSize+tcpHeaderSize+len(adu)))
+	ip[6] = 0x40 // don't fragment
+	ip[8] = 64
+	ip[9] = ipProtocolTcp
+	copy(ip[12:16], srcIp[:])
+	copy(ip[16:20], dstIp[:])
+	binary.BigEndian.PutUint16(ip[10:12], checksum(0, ip))
+
+	tcp := frame[ethernetHeaderSize+ipv4HeaderSize:]
+	binary.BigEndian.PutUint16(tcp[0:2], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:4], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:8], ts.seq[from])
+	binary.BigEndian.PutUint32(tcp[8:12], ts.seq[to])
+	tcp[12] = tcpHeaderSize / 4 << 4
+	tcp[13] = tcpFlagsPshAck
+	binary.BigEndian.PutUint16(tcp[14:16], 0xffff)
+	copy(tcp[tcpHeaderSize:], adu)
+
+	pseudo := make([]byte, 12)
+	copy(pseudo[0:4], srcIp[:])
+	copy(pseudo[4:8], dstIp[:])
+	pseudo[9] = ipProtocolTcp
+	binary.BigEndian.PutUint16(pseudo[10:12], uint16(len(tcp)))
+	binary.BigEndian.PutUint16(tcp[16:18], checksum(sum(0, pseudo), tcp))
+
+	ts.seq[from] += uint32(len(adu))
+	return frame
+}
+
+// tcpPayload - the TCP payload of an Ethernet frame and the direction by the port 502,
+// ok is false for other frames
+func tcpPayload(frame []byte) (payload []byte, direction Direction, ok bool) {
+	if len(frame) < ethernetHeaderSize || binary.BigEndian.Uint16(frame[12:14]) != etherTypeIpv4 {
+		return nil, 0, false
+	}
+	ip := frame[ethernetHeaderSize:]
+	if len(ip) < ipv4HeaderSize || ip[0]>>4 != 4 || ip[9] != ipProtocolTcp {
+		return nil, 0, false
+	}
+	ihl := int(ip[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(ip[2:4]))
+	if ihl < ipv4HeaderSize || total < ihl || total > len(ip) {
+		return nil, 0, false
+	}
+	tcp := ip[ihl:total]
+	if len(tcp) < tcpHeaderSize {
+		return nil, 0, false
+	}
+	offset := int(tcp[12]>>4) * 4
+	if offset < tcpHeaderSize || offset > len(tcp) {
+		return nil, 0, false
+	}
+	switch ModbusTcpPort {
+	case binary.BigEndian.Uint16(tcp[2:4]):
+		direction = DirectionIn
+	case binary.BigEndian.Uint16(tcp[0:2]):
+		direction = DirectionOut
+	}
+	return tcp[offset:], direction, true
+}
+
+// checksum - the internet checksum of data, initial is the sum of a pseudo header
+func checksum(initial uint32, data []byte) uint16 {
+	s := sum(initial, data)
+	for s>>16 != 0 {
+		s = s&0xffff + s>>16
+	}
+	return ^uint16(s)
+}
+
+func sum(initial uint32, data []byte) uint32 {
+	s := initial
+	for i := 0; i+1 < len(data); i += 2 {
+		s += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		s += uint32(data[len(data)-1]) << 8
+	}
+	return s
+}
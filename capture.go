@@ -0,0 +1,284 @@
+package mbslave
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Direction - whether a frame was received or transmitted
+type Direction int
+
+const (
+	DirectionIn = Direction(iota + 1)
+	DirectionOut
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionIn:
+		return "in"
+	case DirectionOut:
+		return "out"
+	}
+	return "unknown"
+}
+
+const (
+	// LinkTypeRtu - DLT_USER0, set "mbrtu" for it in the Wireshark DLT_USER preferences
+	LinkTypeRtu = uint16(147)
+	// LinkTypeTcp - DLT_EN10MB, the ADUs are in Ethernet/IPv4/TCP frames of port 502
+	// that Wireshark dissects as Modbus/TCP. The addresses are synthesized,
+	// all connections of the transport share one.
+	LinkTypeTcp = uint16(1)
+)
+
+const (
+	pcapngSectionHeader  = uint32(0x0a0d0d0a)
+	pcapngInterface      = uint32(0x00000001)
+	pcapngEnhancedPacket = uint32(0x00000006)
+	pcapngByteOrderMagic = uint32(0x1a2b3c4d)
+	pcapngOptionFlags    = uint16(2)
+	pcapngSnapLen        = uint32(65535)
+)
+
+// Capturer - receives every ADU passing through a transport
+type Capturer interface {
+	Capture(direction Direction, t time.Time, adu []byte) error
+}
+
+// PcapWriter - writes frames to a pcapng stream with the direction stored in epb_flags
+type PcapWriter struct {
+	mu       sync.Mutex
+	w        *bufio.Writer
+	closer   io.Closer
+	linkType uint16
+	stream   tcpStream
+}
+
+// NewPcapWriter - writes the section and interface headers for the link type
+func NewPcapWriter(w io.Writer, linkType uint16) (*PcapWriter, error) {
+	pw := &PcapWriter{w: bufio.NewWriter(w), linkType: linkType}
+	if c, ok := w.(io.Closer); ok {
+		pw.closer = c
+	}
+
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:4], pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:6], 1)
+	binary.LittleEndian.PutUint16(shb[6:8], 0)
+	binary.LittleEndian.PutUint64(shb[8:16], 0xffffffffffffffff)
+	if err := pw.block(pcapngSectionHeader, shb); err != nil {
+		return nil, err
+	}
+
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:2], linkType)
+	binary.LittleEndian.PutUint32(idb[4:8], pcapngSnapLen)
+	if err := pw.block(pcapngInterface, idb); err != nil {
+		return nil, err
+	}
+	return pw, pw.w.Flush()
+}
+
+// CreatePcapFile - creates or truncates the capture file
+func CreatePcapFile(path string, linkType uint16) (*PcapWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	pw, err := NewPcapWriter(f, linkType)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return pw, nil
+}
+
+func (pw *PcapWriter) Capture(direction Direction, t time.Time, adu []byte) error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	if pw.linkType == LinkTypeTcp {
+		adu = pw.stream.frame(direction, adu)
+	}
+	padded := (len(adu) + 3) &^ 3
+	body := make([]byte, 20+padded+12)
+	ts := uint64(t.UnixNano() / int64(time.Microsecond))
+	binary.LittleEndian.PutUint32(body[0:4], 0)
+	binary.LittleEndian.PutUint32(body[4:8], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(body[8:12], uint32(ts))
+	binary.LittleEndian.PutUint32(body[12:16], uint32(len(adu)))
+	binary.LittleEndian.PutUint32(body[16:20], uint32(len(adu)))
+	copy(body[20:], adu)
+
+	options := body[20+padded:]
+	binary.LittleEndian.PutUint16(options[0:2], pcapngOptionFlags)
+	binary.LittleEndian.PutUint16(options[2:4], 4)
+	binary.LittleEndian.PutUint32(options[4:8], uint32(direction)&0x03)
+	// options[8:12] - opt_endofopt
+
+	if err := pw.block(pcapngEnhancedPacket, body); err != nil {
+		return err
+	}
+	return pw.w.Flush()
+}
+
+func (pw *PcapWriter) Close() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	err := pw.w.Flush()
+	if pw.closer != nil {
+		if errClose := pw.closer.Close(); err == nil {
+			err = errClose
+		}
+	}
+	return err
+}
+
+func (pw *PcapWriter) block(blockType uint32, body []byte) error {
+	header := make([]byte, 8)
+	length := uint32(12 + len(body))
+	binary.LittleEndian.PutUint32(header[0:4], blockType)
+	binary.LittleEndian.PutUint32(header[4:8], length)
+	trailer := make([]byte, 4)
+	binary.LittleEndian.PutUint32(trailer, length)
+	for _, b := range [][]byte{header, body, trailer} {
+		if _, err := pw.w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Packet - a frame read from a capture
+type Packet struct {
+	Time      time.Time
+	Direction Direction
+	LinkType  uint16
+	// Data - the ADU, the TCP payload of a LinkTypeTcp frame
+	Data []byte
+}
+
+// PcapReader - reads packets written by PcapWriter or another pcapng producer
+type PcapReader struct {
+	r         *bufio.Reader
+	order     binary.ByteOrder
+	linkTypes []uint16
+}
+
+func NewPcapReader(r io.Reader) *PcapReader {
+	return &PcapReader{r: bufio.NewReader(r)}
+}
+
+// Next - returns the next packet or io.EOF. Ethernet frames without a TCP payload
+// are skipped, the direction of the others is taken from the port 502 without epb_flags.
+func (pr *PcapReader) Next() (*Packet, error) {
+	for {
+		blockType, body, err := pr.block()
+		if err != nil {
+			return nil, err
+		}
+		switch blockType {
+		case pcapngInterface:
+			if len(body) < 8 {
+				return nil, fmt.Errorf("pcapng: short interface block")
+			}
+			pr.linkTypes = append(pr.linkTypes, pr.order.Uint16(body[0:2]))
+		case pcapngEnhancedPacket:
+			packet, err := pr.packet(body)
+			if err != nil || packet.LinkType != LinkTypeTcp {
+				return packet, err
+			}
+			payload, direction, ok := tcpPayload(packet.Data)
+			if !ok || len(payload) == 0 {
+				continue
+			}
+			packet.Data = payload
+			if packet.Direction == 0 {
+				packet.Direction = direction
+			}
+			return packet, nil
+		}
+	}
+}
+
+func (pr *PcapReader) block() (uint32, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(pr.r, header); err != nil {
+		return 0, nil, err
+	}
+	if binary.LittleEndian.Uint32(header[0:4]) == pcapngSectionHeader {
+		magic, err := pr.r.Peek(4)
+		if err != nil {
+			return 0, nil, unexpectedEOF(err)
+		}
+		switch pcapngByteOrderMagic {
+		case binary.LittleEndian.Uint32(magic):
+			pr.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(magic):
+			pr.order = binary.BigEndian
+		default:
+			return 0, nil, fmt.Errorf("pcapng: bad byte order magic")
+		}
+		pr.linkTypes = nil
+	}
+	if pr.order == nil {
+		return 0, nil, fmt.Errorf("pcapng: missing section header")
+	}
+
+	length := pr.order.Uint32(header[4:8])
+	if length < 12 || length%4 != 0 {
+		return 0, nil, fmt.Errorf("pcapng: bad block length %d", length)
+	}
+	rest := make([]byte, length-8)
+	if _, err := io.ReadFull(pr.r, rest); err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+	return pr.order.Uint32(header[0:4]), rest[:len(rest)-4], nil
+}
+
+func (pr *PcapReader) packet(body []byte) (*Packet, error) {
+	if len(body) < 20 {
+		return nil, fmt.Errorf("pcapng: short packet block")
+	}
+	iface := pr.order.Uint32(body[0:4])
+	ts := uint64(pr.order.Uint32(body[4:8]))<<32 | uint64(pr.order.Uint32(body[8:12]))
+	captured := int(pr.order.Uint32(body[12:16]))
+	if 20+captured > len(body) {
+		return nil, fmt.Errorf("pcapng: packet exceeds block")
+	}
+	packet := &Packet{
+		Time: time.Unix(0, int64(ts)*int64(time.Microsecond)),
+		Data: append([]byte(nil), body[20:20+captured]...),
+	}
+	if int(iface) < len(pr.linkTypes) {
+		packet.LinkType = pr.linkTypes[iface]
+	}
+
+	options := body[20+((captured+3)&^3):]
+	for len(options) >= 4 {
+		code := pr.order.Uint16(options[0:2])
+		size := int(pr.order.Uint16(options[2:4]))
+		if code == 0 || 4+size > len(options) {
+			break
+		}
+		if code == pcapngOptionFlags && size == 4 {
+			packet.Direction = Direction(pr.order.Uint32(options[4:8]) & 0x03)
+		}
+		options = options[4+((size+3)&^3):]
+	}
+	return packet, nil
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,146 @@
+package mbslave
+
+import (
+	"bytes"
+	"github.com/schnack/gotest"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestPcapWriter_Capture(t *testing.T) {
+	out := new(bytes.Buffer)
+	pw, err := NewPcapWriter(out, LinkTypeRtu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Unix(1600000000, 123456000)
+	_ = pw.Capture(DirectionIn, at, []byte{0x01, 0x05, 0x00, 0x01, 0xff})
+	_ = pw.Capture(DirectionOut, at.Add(time.Millisecond), []byte{0x01, 0x05, 0x00, 0x01})
+
+	reader := NewPcapReader(out)
+	packet, err := reader.Next()
+	if err := gotest.Expect(err).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(packet.Data).Eq([]byte{0x01, 0x05, 0x00, 0x01, 0xff}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(packet.Direction).Eq(DirectionIn); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(packet.LinkType).Eq(LinkTypeRtu); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(packet.Time.Equal(at)).True(); err != nil {
+		t.Error(err)
+	}
+
+	packet, _ = reader.Next()
+	if err := gotest.Expect(packet.Direction).Eq(DirectionOut); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(packet.Data).Eq([]byte{0x01, 0x05, 0x00, 0x01}); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestPcapWriter_CaptureTcp(t *testing.T) {
+	out := new(bytes.Buffer)
+	pw, _ := NewPcapWriter(out, LinkTypeTcp)
+	at := time.Unix(1600000000, 0)
+	request := MbapFrame(1, 0x11, []byte{0x03, 0x00, 0x01, 0x00, 0x01})
+	response := MbapFrame(1, 0x11, []byte{0x03, 0x02, 0x12, 0x34})
+	_ = pw.Capture(DirectionIn, at, request)
+	// a frame without payload is skipped
+	_ = pw.Capture(DirectionOut, at, nil)
+	_ = pw.Capture(DirectionOut, at, response)
+
+	reader := NewPcapReader(out)
+	for _, expected := range []Packet{
+		{Time: at, Direction: DirectionIn, LinkType: LinkTypeTcp, Data: request},
+		{Time: at, Direction: DirectionOut, LinkType: LinkTypeTcp, Data: response},
+	} {
+		packet, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := gotest.Expect(*packet).Eq(expected); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestTcpStream_frame(t *testing.T) {
+	var stream tcpStream
+	adu := MbapFrame(1, 0x11, []byte{0x03, 0x00, 0x01, 0x00, 0x01})
+	in := stream.frame(DirectionIn, adu)
+	out := stream.frame(DirectionOut, []byte{0x00})
+
+	ip := in[ethernetHeaderSize : ethernetHeaderSize+ipv4HeaderSize]
+	if err := gotest.Expect(checksum(0, ip)).Eq(uint16(0)); err != nil {
+		t.Error("ip checksum: ", err)
+	}
+	tcp := in[ethernetHeaderSize+ipv4HeaderSize:]
+	pseudo := append(append(append([]byte(nil), ip[12:20]...), 0, ipProtocolTcp), byte(len(tcp)>>8), byte(len(tcp)))
+	if err := gotest.Expect(checksum(sum(0, pseudo), tcp)).Eq(uint16(0)); err != nil {
+		t.Error("tcp checksum: ", err)
+	}
+
+	payload, direction, ok := tcpPayload(in)
+	if err := gotest.Expect(ok).True(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(payload).Eq(adu); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(direction).Eq(DirectionIn); err != nil {
+		t.Error(err)
+	}
+	// the response acknowledges the request
+	tcp = out[ethernetHeaderSize+ipv4HeaderSize:]
+	if err := gotest.Expect(tcp[0:4]).Eq([]byte{0x01, 0xf6, 0xc0, 0x00}); err != nil {
+		t.Error("ports: ", err)
+	}
+	if err := gotest.Expect(tcp[8:12]).Eq([]byte{0, 0, 0, byte(len(adu))}); err != nil {
+		t.Error("ack: ", err)
+	}
+}
+
+func TestRtuTransport_Capture(t *testing.T) {
+	setupRtuTransport()
+	defer teardownRtuTransport()
+	config := &Config{Port: "capture", BaudRate: 9600, SilentInterval: 2 * time.Hour}
+	InoutSerialPort.GetOut(config.Port).Write([]byte{0x01, 0x05, 0x00, 0x01, 0xff, 0x00, 0xdd, 0xfa})
+
+	out := new(bytes.Buffer)
+	pw, _ := NewPcapWriter(out, LinkTypeRtu)
+	rt := NewRtuTransport(config)
+	rt.Capture = pw
+	rt.SetHandler(func(request Request, resp Response) {
+		_ = request.Parse()
+		resp.SetSingleWrite(request.GetAddress(), request.GetData())
+	})
+	_ = rt.Listen()
+
+	reader := NewPcapReader(out)
+	for _, direction := range []Direction{DirectionIn, DirectionOut} {
+		packet, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := gotest.Expect(packet.Direction).Eq(direction); err != nil {
+			t.Error(err)
+		}
+		if err := gotest.Expect(packet.Data).Eq([]byte{0x01, 0x05, 0x00, 0x01, 0xff, 0x00, 0xdd, 0xfa}); err != nil {
+			t.Error(err)
+		}
+	}
+}
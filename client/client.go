@@ -0,0 +1,290 @@
+// Package client is a Modbus master sharing the frame codec with mbslave.
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/schnack/mbslave"
+)
+
+var (
+	// ErrTimeout - no valid response before the deadline
+	ErrTimeout = errors.New("modbus: response timeout")
+	// ErrInvalidResponse - the response does not match the request
+	ErrInvalidResponse = errors.New("modbus: invalid response")
+	// ErrBroadcastRead - a read of the broadcast unit, it is never answered
+	ErrBroadcastRead = errors.New("modbus: read from the broadcast unit")
+)
+
+// NoBroadcast - Client.Broadcast of transports without broadcast like Modbus/TCP
+const NoBroadcast = -1
+
+// ExceptionError - the slave answered with an exception
+type ExceptionError struct {
+	Function uint8
+	Code     uint8
+}
+
+func (e *ExceptionError) Error() string {
+	return fmt.Sprintf("modbus: function %d exception %d", e.Function, e.Code)
+}
+
+// Transport - sends a PDU to a unit and returns the response PDU.
+// With wait false the request is a broadcast and nothing is read.
+type Transport interface {
+	Send(ctx context.Context, unit uint8, pdu []byte, wait bool) ([]byte, error)
+	Close() error
+}
+
+// Client - Modbus master, safe for concurrent use, requests are serialized
+type Client struct {
+	Transport Transport
+	// Timeout applies to requests whose context has no deadline
+	Timeout time.Duration
+	// Retries after a timeout or a damaged response
+	Retries int
+	// TurnaroundDelay - pause after a broadcast before the next request
+	TurnaroundDelay time.Duration
+	// Broadcast - unit id that is not answered, 0 by the specification. An mbslave
+	// RTU server executes the broadcasts of unit 0 and 255 without answering them.
+	// Modbus/TCP has no broadcast, NoBroadcast sends every unit and waits for it.
+	Broadcast int
+
+	mu sync.Mutex
+}
+
+// NewClient - a TcpTransport gets NoBroadcast, the unit 0 is answered on it
+func NewClient(transport Transport) *Client {
+	c := &Client{
+		Transport:       transport,
+		Timeout:         time.Second,
+		TurnaroundDelay: 100 * time.Millisecond,
+	}
+	if _, ok := transport.(*TcpTransport); ok {
+		c.Broadcast = NoBroadcast
+	}
+	return c
+}
+
+func (c *Client) Close() error {
+	return c.Transport.Close()
+}
+
+// Send - sends a raw PDU and returns the response PDU, exceptions become *ExceptionError
+func (c *Client) Send(ctx context.Context, unit uint8, pdu []byte) ([]byte, error) {
+	if len(pdu) == 0 {
+		return nil, fmt.Errorf("modbus: empty pdu")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.isBroadcast(unit) {
+		if _, err := c.Transport.Send(ctx, unit, pdu, false); err != nil {
+			return nil, err
+		}
+		select {
+		case <-time.After(c.TurnaroundDelay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return nil, nil
+	}
+
+	var err error
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		var resp []byte
+		resp, err = c.send(ctx, unit, pdu)
+		if err == nil {
+			return resp, nil
+		}
+		if !errors.Is(err, ErrTimeout) && !errors.Is(err, ErrInvalidResponse) || ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+func (c *Client) send(ctx context.Context, unit uint8, pdu []byte) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok && c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	resp, err := c.Transport.Send(ctx, unit, pdu, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) == 0 {
+		return nil, ErrInvalidResponse
+	}
+	if resp[0] == mbslave.ExceptionFunction(pdu[0]) {
+		if len(resp) < 2 {
+			return nil, ErrInvalidResponse
+		}
+		return nil, &ExceptionError{Function: pdu[0], Code: resp[1]}
+	}
+	if resp[0] != pdu[0] {
+		return nil, ErrInvalidResponse
+	}
+	return resp, nil
+}
+
+func (c *Client) ReadCoils(ctx context.Context, unit uint8, address, quantity uint16) ([]bool, error) {
+	return c.readBits(ctx, unit, mbslave.FuncReadCoils, address, quantity)
+}
+
+func (c *Client) ReadDiscreteInputs(ctx context.Context, unit uint8, address, quantity uint16) ([]bool, error) {
+	return c.readBits(ctx, unit, mbslave.FuncReadDiscreteInputs, address, quantity)
+}
+
+func (c *Client) ReadHoldingRegisters(ctx context.Context, unit uint8, address, quantity uint16) ([]uint16, error) {
+	return c.readRegisters(ctx, unit, mbslave.FuncReadHoldingRegisters, address, quantity)
+}
+
+func (c *Client) ReadInputRegisters(ctx context.Context, unit uint8, address, quantity uint16) ([]uint16, error) {
+	return c.readRegisters(ctx, unit, mbslave.FuncReadInputRegisters, address, quantity)
+}
+
+func (c *Client) WriteSingleCoil(ctx context.Context, unit uint8, address uint16, value bool) error {
+	data := uint16(0x0000)
+	if value {
+		data = 0xff00
+	}
+	return c.write(ctx, unit, pdu(mbslave.FuncWriteSingleCoil, address, data))
+}
+
+func (c *Client) WriteSingleRegister(ctx context.Context, unit uint8, address, value uint16) error {
+	return c.write(ctx, unit, pdu(mbslave.FuncWriteSingleRegister, address, value))
+}
+
+func (c *Client) WriteMultipleCoils(ctx context.Context, unit uint8, address uint16, values []bool) error {
+	if len(values) == 0 || len(values) > 1968 {
+		return fmt.Errorf("modbus: invalid quantity %d", len(values))
+	}
+	data := make([]byte, (len(values)+7)/8)
+	for i, value := range values {
+		if value {
+			data[i/8] |= 1 << (i % 8)
+		}
+	}
+	req := append(pdu(mbslave.FuncWriteMultipleCoils, address, uint16(len(values))), byte(len(data)))
+	return c.write(ctx, unit, append(req, data...))
+}
+
+func (c *Client) WriteMultipleRegisters(ctx context.Context, unit uint8, address uint16, values []uint16) error {
+	if len(values) == 0 || len(values) > 123 {
+		return fmt.Errorf("modbus: invalid quantity %d", len(values))
+	}
+	req := append(pdu(mbslave.FuncWriteMultipleRegisters, address, uint16(len(values))), byte(len(values)*2))
+	return c.write(ctx, unit, append(req, registers(values)...))
+}
+
+// MaskWriteRegister - FC22, result = (current AND and) OR (or AND NOT and)
+func (c *Client) MaskWriteRegister(ctx context.Context, unit uint8, address, and, or uint16) error {
+	req := append(pdu(mbslave.FuncMaskWriteRegister, address, and), byte(or>>8), byte(or))
+	return c.write(ctx, unit, req)
+}
+
+// ReadWriteMultipleRegisters - FC23, the write is performed before the read
+func (c *Client) ReadWriteMultipleRegisters(ctx context.Context, unit uint8, readAddress, readQuantity, writeAddress uint16, values []uint16) ([]uint16, error) {
+	if readQuantity == 0 || readQuantity > 125 || len(values) == 0 || len(values) > 121 {
+		return nil, fmt.Errorf("modbus: invalid quantity")
+	}
+	if c.isBroadcast(unit) {
+		return nil, ErrBroadcastRead
+	}
+	req := pdu(mbslave.FuncReadWriteRegisters, readAddress, readQuantity)
+	req = append(req, byte(writeAddress>>8), byte(writeAddress), byte(len(values)>>8), byte(len(values)), byte(len(values)*2))
+	resp, err := c.Send(ctx, unit, append(req, registers(values)...))
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	return decodeRegisters(resp, readQuantity)
+}
+
+func (c *Client) readBits(ctx context.Context, unit, function uint8, address, quantity uint16) ([]bool, error) {
+	if quantity == 0 || quantity > 2000 {
+		return nil, fmt.Errorf("modbus: invalid quantity %d", quantity)
+	}
+	if c.isBroadcast(unit) {
+		return nil, ErrBroadcastRead
+	}
+	resp, err := c.Send(ctx, unit, pdu(function, address, quantity))
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	if len(resp) < 2 || int(resp[1]) != (int(quantity)+7)/8 || len(resp) != 2+int(resp[1]) {
+		return nil, ErrInvalidResponse
+	}
+	values := make([]bool, quantity)
+	for i := range values {
+		values[i] = resp[2+i/8]>>(i%8)&0x01 == 1
+	}
+	return values, nil
+}
+
+func (c *Client) readRegisters(ctx context.Context, unit, function uint8, address, quantity uint16) ([]uint16, error) {
+	if quantity == 0 || quantity > 125 {
+		return nil, fmt.Errorf("modbus: invalid quantity %d", quantity)
+	}
+	if c.isBroadcast(unit) {
+		return nil, ErrBroadcastRead
+	}
+	resp, err := c.Send(ctx, unit, pdu(function, address, quantity))
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	return decodeRegisters(resp, quantity)
+}
+
+func (c *Client) isBroadcast(unit uint8) bool {
+	return int(unit) == c.Broadcast
+}
+
+// write - the echo of a write request is its first five bytes
+func (c *Client) write(ctx context.Context, unit uint8, req []byte) error {
+	resp, err := c.Send(ctx, unit, req)
+	if err != nil || resp == nil {
+		return err
+	}
+	echo := 5
+	if req[0] == mbslave.FuncMaskWriteRegister {
+		echo = 7
+	}
+	if len(resp) != echo || string(resp) != string(req[:echo]) {
+		return ErrInvalidResponse
+	}
+	return nil
+}
+
+func pdu(function uint8, address, value uint16) []byte {
+	b := make([]byte, 5)
+	b[0] = function
+	binary.BigEndian.PutUint16(b[1:3], address)
+	binary.BigEndian.PutUint16(b[3:5], value)
+	return b
+}
+
+func registers(values []uint16) []byte {
+	b := make([]byte, len(values)*2)
+	for i, value := range values {
+		binary.BigEndian.PutUint16(b[i*2:], value)
+	}
+	return b
+}
+
+func decodeRegisters(resp []byte, quantity uint16) ([]uint16, error) {
+	if len(resp) < 2 || int(resp[1]) != int(quantity)*2 || len(resp) != 2+int(resp[1]) {
+		return nil, ErrInvalidResponse
+	}
+	values := make([]uint16, quantity)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint16(resp[2+i*2:])
+	}
+	return values, nil
+}
@@ -0,0 +1,166 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/schnack/gotest"
+	"github.com/schnack/mbslave"
+	"go.bug.st/serial"
+)
+
+func startServer(t *testing.T) (*Client, *mbslave.DefaultDataModel) {
+	master, slave := mbslave.NewPipeSerialPorts()
+	open := mbslave.OpenSerialPort
+	mbslave.OpenSerialPort = func(*mbslave.Config) (serial.Port, error) {
+		return slave, nil
+	}
+
+	config := &mbslave.Config{
+		Port:                 "pipe",
+		BaudRate:             115200,
+		SlaveId:              0x11,
+		SizeDiscreteInputs:   32,
+		SizeCoils:            32,
+		SizeInputRegisters:   32,
+		SizeHoldingRegisters: 32,
+	}
+	dm := mbslave.NewDefaultDataModel(config)
+	transport := mbslave.NewRtuTransport(config)
+	transport.Log = mbslave.NopLogger{}
+	server := mbslave.NewServer(transport, dm)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = server.Listen()
+	}()
+
+	c := NewClient(NewRtuTransport(master))
+	c.Timeout = 200 * time.Millisecond
+	t.Cleanup(func() {
+		_ = master.Close()
+		_ = server.Close()
+		<-done
+		mbslave.OpenSerialPort = open
+	})
+	return c, dm
+}
+
+func TestClient_Rtu(t *testing.T) {
+	c, dm := startServer(t)
+	ctx := context.Background()
+	_ = dm.SetInputRegisters(2, 0xbeef)
+	_ = dm.SetDiscreteInputs(9, true)
+
+	if err := gotest.Expect(c.WriteMultipleRegisters(ctx, 0x11, 4, []uint16{1, 2, 3})).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	values, err := c.ReadHoldingRegisters(ctx, 0x11, 4, 3)
+	if err := gotest.Expect(err).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(values).Eq([]uint16{1, 2, 3}); err != nil {
+		t.Error(err)
+	}
+
+	if err := gotest.Expect(c.WriteSingleCoil(ctx, 0x11, 3, true)).NotError(); err != nil {
+		t.Error(err)
+	}
+	coils, err := c.ReadCoils(ctx, 0x11, 0, 5)
+	if err := gotest.Expect(err).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(coils).Eq([]bool{false, false, false, true, false}); err != nil {
+		t.Error(err)
+	}
+
+	inputs, _ := c.ReadInputRegisters(ctx, 0x11, 2, 1)
+	if err := gotest.Expect(inputs).Eq([]uint16{0xbeef}); err != nil {
+		t.Error(err)
+	}
+	discrete, _ := c.ReadDiscreteInputs(ctx, 0x11, 8, 2)
+	if err := gotest.Expect(discrete).Eq([]bool{false, true}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClient_Exception(t *testing.T) {
+	c, _ := startServer(t)
+
+	_, err := c.ReadHoldingRegisters(context.Background(), 0x11, 100, 1)
+	var exception *ExceptionError
+	if !errors.As(err, &exception) {
+		t.Fatalf("expected exception, got %v", err)
+	}
+	if err := gotest.Expect(*exception).Eq(ExceptionError{Function: mbslave.FuncReadHoldingRegisters, Code: mbslave.ErrorAddress}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClient_Timeout(t *testing.T) {
+	c, _ := startServer(t)
+	c.Timeout = 20 * time.Millisecond
+	c.Retries = 2
+
+	start := time.Now()
+	_, err := c.ReadHoldingRegisters(context.Background(), 0x12, 0, 1)
+	if err := gotest.Expect(err).Eq(ErrTimeout); err != nil {
+		t.Error(err)
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("retries were not made, elapsed %s", elapsed)
+	}
+}
+
+func TestClient_Broadcast(t *testing.T) {
+	c, dm := startServer(t)
+	c.TurnaroundDelay = 20 * time.Millisecond
+
+	// the unit 0 of the specification and 255 of earlier mbslave versions
+	for _, unit := range []uint8{0, 255} {
+		c.Broadcast = int(unit)
+		if err := gotest.Expect(c.WriteSingleRegister(context.Background(), unit, 7, uint16(unit)+1)).NotError(); err != nil {
+			t.Fatal(err)
+		}
+		if err := gotest.Expect(dm.GetHoldingRegisters(7)).Eq(uint16(unit) + 1); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// a read is never answered
+	if _, err := c.ReadHoldingRegisters(context.Background(), 255, 7, 1); err != ErrBroadcastRead {
+		t.Errorf("read of the broadcast unit: %v", err)
+	}
+}
+
+func TestClient_Tcp(t *testing.T) {
+	master, slave := net.Pipe()
+	defer slave.Close()
+	go func() {
+		header := make([]byte, mbslave.MbapHeaderSize)
+		for {
+			if _, err := io.ReadFull(slave, header); err != nil {
+				return
+			}
+			mbap, _ := mbslave.ParseMbapHeader(header)
+			pdu := make([]byte, mbap.Length-1)
+			_, _ = io.ReadFull(slave, pdu)
+			_, _ = slave.Write(mbslave.MbapFrame(mbap.TransactionId, mbap.UnitId, []byte{pdu[0], 0x04, 0x00, 0x01, 0x00, 0x02}))
+		}
+	}()
+
+	c := NewClient(NewTcpTransport(master))
+	defer c.Close()
+	// Modbus/TCP has no broadcast, the unit 0 is answered
+	values, err := c.ReadInputRegisters(context.Background(), 0, 0, 2)
+	if err := gotest.Expect(err).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(values).Eq([]uint16{1, 2}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/schnack/mbslave"
+	"go.bug.st/serial"
+)
+
+// RtuTransport - RTU framing over a serial port
+type RtuTransport struct {
+	Port serial.Port
+	// FrameDelay - silence kept before every request, 3.5 characters by default
+	FrameDelay time.Duration
+
+	bytes   chan byte
+	errors  chan error
+	once    sync.Once
+	lastEnd time.Time
+}
+
+// NewRtuClient - opens the port with mbslave.OpenSerialPort
+func NewRtuClient(config *mbslave.Config) (*Client, error) {
+	port, err := mbslave.OpenSerialPort(config)
+	if err != nil {
+		return nil, err
+	}
+	transport := NewRtuTransport(port)
+	transport.FrameDelay = (&mbslave.RtuTransport{Config: config}).SilentInterval()
+	return NewClient(transport), nil
+}
+
+func NewRtuTransport(port serial.Port) *RtuTransport {
+	return &RtuTransport{
+		Port:       port,
+		FrameDelay: 1750 * time.Microsecond,
+	}
+}
+
+func (t *RtuTransport) Close() error {
+	return t.Port.Close()
+}
+
+// read - a single goroutine reads the port for the lifetime of the transport
+func (t *RtuTransport) read() {
+	t.bytes = make(chan byte, 512)
+	t.errors = make(chan error, 1)
+	go func() {
+		b := make([]byte, 64)
+		for {
+			n, err := t.Port.Read(b)
+			for i := 0; i < n; i++ {
+				t.bytes <- b[i]
+			}
+			if err == nil && n == 0 {
+				err = fmt.Errorf("unable to read data from serial port")
+			}
+			if err != nil {
+				t.errors <- err
+				return
+			}
+		}
+	}()
+}
+
+func (t *RtuTransport) Send(ctx context.Context, unit uint8, pdu []byte, wait bool) ([]byte, error) {
+	t.once.Do(t.read)
+
+	if pause := t.FrameDelay - time.Since(t.lastEnd); pause > 0 {
+		time.Sleep(pause)
+	}
+	// drop late or unsolicited bytes
+	for drained := false; !drained; {
+		select {
+		case <-t.bytes:
+		default:
+			drained = true
+		}
+	}
+
+	adu := mbslave.AppendCrc(append([]byte{unit}, pdu...))
+	_, err := t.Port.Write(adu)
+	t.lastEnd = time.Now()
+	if err != nil || !wait {
+		return nil, err
+	}
+
+	var resp []byte
+	for {
+		select {
+		case b := <-t.bytes:
+			resp = append(resp, b)
+		case err := <-t.errors:
+			t.errors <- err
+			return nil, err
+		case <-ctx.Done():
+			t.lastEnd = time.Now()
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, ErrTimeout
+			}
+			return nil, ctx.Err()
+		}
+
+		length := mbslave.RtuResponseLength(resp)
+		if length < 0 {
+			return nil, fmt.Errorf("modbus: function %d is not supported by rtu framing", resp[1])
+		}
+		if length == 0 || len(resp) < length {
+			continue
+		}
+		t.lastEnd = time.Now()
+		if resp[0] != unit || !mbslave.CheckCrc(resp[:length]) {
+			return nil, ErrInvalidResponse
+		}
+		return resp[1 : length-2], nil
+	}
+}
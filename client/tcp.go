@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+
+	"github.com/schnack/mbslave"
+)
+
+// TcpTransport - Modbus/TCP framing over a stream connection
+type TcpTransport struct {
+	Conn net.Conn
+
+	transactionId uint16
+}
+
+// NewTcpClient - dials the address, e.g. "127.0.0.1:502"
+func NewTcpClient(address string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(NewTcpTransport(conn)), nil
+}
+
+func NewTcpTransport(conn net.Conn) *TcpTransport {
+	return &TcpTransport{Conn: conn}
+}
+
+func (t *TcpTransport) Close() error {
+	return t.Conn.Close()
+}
+
+func (t *TcpTransport) Send(ctx context.Context, unit uint8, pdu []byte, wait bool) ([]byte, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Time{}
+	}
+	if err := t.Conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	t.transactionId++
+	if _, err := t.Conn.Write(mbslave.MbapFrame(t.transactionId, unit, pdu)); err != nil {
+		return nil, t.error(err)
+	}
+	if !wait {
+		return nil, nil
+	}
+
+	for {
+		header := make([]byte, mbslave.MbapHeaderSize)
+		if _, err := io.ReadFull(t.Conn, header); err != nil {
+			return nil, t.error(err)
+		}
+		mbap, err := mbslave.ParseMbapHeader(header)
+		if err != nil {
+			return nil, ErrInvalidResponse
+		}
+		resp := make([]byte, mbap.Length-1)
+		if _, err := io.ReadFull(t.Conn, resp); err != nil {
+			return nil, t.error(err)
+		}
+		// a late answer to a timed out request
+		if mbap.TransactionId != t.transactionId {
+			continue
+		}
+		if mbap.UnitId != unit {
+			return nil, ErrInvalidResponse
+		}
+		return resp, nil
+	}
+}
+
+func (t *TcpTransport) error(err error) error {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return ErrTimeout
+	}
+	return err
+}
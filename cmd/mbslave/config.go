@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/schnack/mbslave"
+	"github.com/schnack/mbslave/admin"
+	"go.bug.st/serial"
+)
+
+// Config - the config file, flags override its values
+type Config struct {
+	Serial []SerialConfig `json:"serial"`
+	// Tcp - addresses of Modbus/TCP listeners
+	Tcp []string `json:"tcp"`
+	// RtuOverTcp - addresses of listeners for RTU frames over TCP
+	RtuOverTcp []string `json:"rtu_over_tcp"`
+	// Tls - Modbus/TCP Security listeners with client certificates
+	Tls []TlsConfig `json:"tls"`
+	// Limits - apply to every TCP and TLS listener
+	Limits Limits `json:"limits"`
+	// Roles - what the roles of the client certificates may do on the TLS
+	// listeners, all requests are allowed without roles
+	Roles mbslave.Roles `json:"roles"`
+	// Units - unit ids served by the simulator, every unit has its own tables
+	Units []int `json:"units"`
+	Sizes Sizes `json:"sizes"`
+	// Registers - the register map, names are used by the admin API
+	Registers map[string]Register `json:"registers"`
+	// RegisterMap - file with more registers in the format of Registers
+	RegisterMap string `json:"register_map"`
+	// Values - initial values
+	Values []Value `json:"values"`
+	// Snapshot - file loaded at start and written at exit
+	Snapshot   string `json:"snapshot"`
+	Admin      string `json:"admin"`
+	AdminToken string `json:"admin_token"`
+	// Console - the interactive console on stdin/stdout for the first unit
+	Console bool `json:"console"`
+	// Audit - the log of all writes, off without a path
+	Audit AuditConfig `json:"audit"`
+	// Storage - "file:DIR" or "mmap:DIR" keeps the tables of every unit in
+	// DIR/unit-N.tables for other processes, "bolt:DIR" in the database
+	// DIR/unit-N.db, in memory without it
+	Storage string `json:"storage"`
+	// Mqtt - mirrors the registers to a broker, off without a broker
+	Mqtt MqttConfig `json:"mqtt"`
+	Log  LogConfig  `json:"log"`
+}
+
+type SerialConfig struct {
+	Port     string `json:"port"`
+	BaudRate int    `json:"baud_rate"`
+	DataBits int    `json:"data_bits"`
+	// Parity - none, odd, even, mark or space
+	Parity string `json:"parity"`
+	// StopBits - 1, 1.5 or 2
+	StopBits string `json:"stop_bits"`
+	// CharTimeout - a pause inside a frame that ends it, e.g. "2ms", 1.5 characters
+	// without it. A negative one disables the check for USB adapters.
+	CharTimeout string `json:"char_timeout"`
+}
+
+type TlsConfig struct {
+	// Address of the listener, e.g. ":802"
+	Address string `json:"address"`
+	// Cert, Key - PEM files of the server certificate
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+	// ClientCA - PEM file with the CAs of the client certificates
+	ClientCA string `json:"client_ca"`
+}
+
+// Limits - see the fields of mbslave.TcpTransport
+type Limits struct {
+	// Allow, Deny - client networks in CIDR notation
+	Allow    []string `json:"allow"`
+	Deny     []string `json:"deny"`
+	MaxConns int      `json:"max_conns"`
+	// Policy - "reject" the newest connection or "evict" the oldest idle one
+	Policy string `json:"policy"`
+	// IdleTimeout - e.g. "60s"
+	IdleTimeout string  `json:"idle_timeout"`
+	RateLimit   float64 `json:"rate_limit"`
+	RateBurst   int     `json:"rate_burst"`
+}
+
+// apply - sets the limits of the transport, the config is validated before
+func (l Limits) apply(tt *mbslave.TcpTransport) {
+	for _, s := range l.Allow {
+		tt.Allow = append(tt.Allow, netip.MustParsePrefix(s))
+	}
+	for _, s := range l.Deny {
+		tt.Deny = append(tt.Deny, netip.MustParsePrefix(s))
+	}
+	tt.MaxConns = l.MaxConns
+	if l.Policy == "evict" {
+		tt.ConnPolicy = mbslave.EvictOldestIdle
+	}
+	tt.IdleTimeout, _ = time.ParseDuration(l.IdleTimeout)
+	tt.RateLimit = l.RateLimit
+	tt.RateBurst = l.RateBurst
+}
+
+func (l Limits) validate() error {
+	for _, s := range append(append([]string(nil), l.Allow...), l.Deny...) {
+		if _, err := netip.ParsePrefix(s); err != nil {
+			return err
+		}
+	}
+	if l.Policy != "" && l.Policy != "reject" && l.Policy != "evict" {
+		return fmt.Errorf("unknown connection policy %q, expected reject or evict", l.Policy)
+	}
+	if l.IdleTimeout != "" {
+		if _, err := time.ParseDuration(l.IdleTimeout); err != nil {
+			return fmt.Errorf("idle timeout: %w", err)
+		}
+	}
+	return nil
+}
+
+type Sizes struct {
+	DiscreteInputs   uint16 `json:"di"`
+	Coils            uint16 `json:"coils"`
+	InputRegisters   uint16 `json:"ir"`
+	HoldingRegisters uint16 `json:"hr"`
+}
+
+// Register - a named register with an optional initial value
+type Register struct {
+	admin.Register
+	Value *float64 `json:"value,omitempty"`
+	// Unit, Scale - published by the MQTT bridge
+	Unit  string  `json:"unit,omitempty"`
+	Scale float64 `json:"scale,omitempty"`
+}
+
+// Value - initial value of a register or bit, Unit 0 sets it in all units
+type Value struct {
+	Unit    int           `json:"unit"`
+	Table   mbslave.Table `json:"table"`
+	Address uint16        `json:"address"`
+	Value   uint16        `json:"value"`
+}
+
+type AuditConfig struct {
+	// Path - JSON lines file, it is rotated to path.1 .. path.N
+	Path string `json:"path"`
+	// MaxSize - bytes of the file before it is rotated
+	MaxSize int64 `json:"max_size"`
+	// MaxFiles - rotated files to keep
+	MaxFiles int `json:"max_files"`
+}
+
+// MqttConfig - see mqtt.Bridge, the topics of unit N are Prefix/N/<register>
+type MqttConfig struct {
+	// Broker - e.g. "tcp://localhost:1883"
+	Broker   string `json:"broker"`
+	Prefix   string `json:"prefix"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	QoS      byte   `json:"qos"`
+	Retain   bool   `json:"retain"`
+}
+
+type LogConfig struct {
+	// Level - debug, info, warn or error
+	Level string `json:"level"`
+	// Format - text or json
+	Format string `json:"format"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Units: []int{1},
+		Sizes: Sizes{
+			DiscreteInputs:   1000,
+			Coils:            1000,
+			InputRegisters:   1000,
+			HoldingRegisters: 1000,
+		},
+		Registers: make(map[string]Register),
+		Audit:     AuditConfig{MaxSize: 10 << 20, MaxFiles: 5},
+		Mqtt:      MqttConfig{Prefix: "mbslave"},
+		Log:       LogConfig{Level: "info", Format: "text"},
+	}
+}
+
+// loadConfig - reads the JSON file into the config
+func loadConfig(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// loadRegisterMap - adds the registers of the file to the config
+func loadRegisterMap(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	registers := make(map[string]Register)
+	if err := json.Unmarshal(data, &registers); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if config.Registers == nil {
+		config.Registers = make(map[string]Register)
+	}
+	for name, register := range registers {
+		config.Registers[name] = register
+	}
+	return nil
+}
+
+// validate - checks the config before anything is opened
+func (c *Config) validate() error {
+	if len(c.Serial)+len(c.Tcp)+len(c.RtuOverTcp)+len(c.Tls) == 0 {
+		return fmt.Errorf("no transport, use -serial, -tcp, -rtu-tcp or -tls")
+	}
+	if err := c.Limits.validate(); err != nil {
+		return err
+	}
+	for _, t := range c.Tls {
+		if t.Cert == "" || t.Key == "" || t.ClientCA == "" {
+			return fmt.Errorf("tls %s: the certificate, key and client CA are required", t.Address)
+		}
+	}
+	if c.Mqtt.QoS > 2 {
+		return fmt.Errorf("mqtt qos %d is not 0, 1 or 2", c.Mqtt.QoS)
+	}
+	if _, _, err := parseStorage(c.Storage); err != nil {
+		return err
+	}
+	if len(c.Units) == 0 {
+		return fmt.Errorf("no unit id")
+	}
+	for _, unit := range c.Units {
+		if unit < 1 || unit > 247 {
+			return fmt.Errorf("unit id %d is outside of 1..247", unit)
+		}
+	}
+	for _, s := range c.Serial {
+		if _, err := parseParity(s.Parity); err != nil {
+			return err
+		}
+		if s.CharTimeout != "" {
+			if _, err := time.ParseDuration(s.CharTimeout); err != nil {
+				return fmt.Errorf("char timeout: %w", err)
+			}
+		}
+		if _, err := parseStopBits(s.StopBits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseValue - parses "table:address=value", e.g. hr:10=123 or 2/coils:3=1 for unit 2
+func parseValue(s string) (Value, error) {
+	var v Value
+	target, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return v, fmt.Errorf("invalid value %q, expected table:address=value", s)
+	}
+	if unit, rest, ok := strings.Cut(target, "/"); ok {
+		u, err := strconv.ParseUint(unit, 0, 8)
+		if err != nil {
+			return v, fmt.Errorf("invalid unit in %q: %w", s, err)
+		}
+		v.Unit, target = int(u), rest
+	}
+	table, address, ok := strings.Cut(target, ":")
+	if !ok {
+		return v, fmt.Errorf("invalid value %q, expected table:address=value", s)
+	}
+	var err error
+	if v.Table, err = mbslave.ParseTable(table); err != nil {
+		return v, err
+	}
+	a, err := strconv.ParseUint(address, 0, 16)
+	if err != nil {
+		return v, fmt.Errorf("invalid address in %q: %w", s, err)
+	}
+	n, err := strconv.ParseUint(value, 0, 16)
+	if err != nil {
+		return v, fmt.Errorf("invalid value in %q: %w", s, err)
+	}
+	v.Address, v.Value = uint16(a), uint16(n)
+	return v, nil
+}
+
+// parseStorage - splits "file:DIR", "mmap:DIR" and "bolt:DIR", empty is the memory
+func parseStorage(s string) (kind, dir string, err error) {
+	if s == "" {
+		return "", "", nil
+	}
+	kind, dir, ok := strings.Cut(s, ":")
+	if !ok || dir == "" || (kind != "file" && kind != "mmap" && kind != "bolt") {
+		return "", "", fmt.Errorf("invalid storage %q, expected file:DIR, mmap:DIR or bolt:DIR", s)
+	}
+	return kind, dir, nil
+}
+
+// parseUnits - parses a comma separated list of unit ids
+func parseUnits(s string) ([]int, error) {
+	var units []int
+	for _, field := range strings.Split(s, ",") {
+		unit, err := strconv.ParseUint(strings.TrimSpace(field), 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid unit id %q", field)
+		}
+		units = append(units, int(unit))
+	}
+	return units, nil
+}
+
+func parseParity(s string) (serial.Parity, error) {
+	switch strings.ToLower(s) {
+	case "", "none", "n":
+		return mbslave.NoParity, nil
+	case "odd", "o":
+		return mbslave.OddParity, nil
+	case "even", "e":
+		return mbslave.EvenParity, nil
+	case "mark", "m":
+		return mbslave.MarkParity, nil
+	case "space", "s":
+		return mbslave.SpaceParity, nil
+	}
+	return 0, fmt.Errorf("unknown parity %q", s)
+}
+
+func parseStopBits(s string) (serial.StopBits, error) {
+	switch s {
+	case "", "1":
+		return mbslave.OneStopBit, nil
+	case "1.5":
+		return mbslave.OnePointFiveStopBits, nil
+	case "2":
+		return mbslave.TwoStopBits, nil
+	}
+	return 0, fmt.Errorf("unknown stop bits %q", s)
+}
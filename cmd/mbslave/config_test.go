@@ -0,0 +1,189 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/schnack/gotest"
+	"github.com/schnack/mbslave"
+	"github.com/schnack/mbslave/admin"
+)
+
+func TestParseFlags(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "simulator.json")
+	_ = os.WriteFile(configPath, []byte(`{
+		"tcp": [":502"],
+		"units": [1, 2],
+		"sizes": {"hr": 100},
+		"registers": {"setpoint": {"table": "hr", "address": 4, "type": "float32", "value": 20.5}},
+		"log": {"level": "debug"}
+	}`), 0o644)
+	mapPath := filepath.Join(dir, "map.json")
+	_ = os.WriteFile(mapPath, []byte(`{"alarm": {"table": "coils", "address": 2}}`), 0o644)
+
+	config, err := parseFlags(flag.NewFlagSet("mbslave", flag.ContinueOnError), []string{
+		"-config", configPath,
+		"-serial", "/dev/ttyUSB0", "-baud", "19200", "-parity", "even", "-char-timeout", "-1ns",
+		"-units", "3",
+		"-ir", "50",
+		"-map", mapPath,
+		"-set", "hr:10=123", "-set", "3/coils:1=1",
+		"-log-format", "json",
+		"-audit", "audit.jsonl",
+		"-allow", "10.0.0.0/8", "-idle-timeout", "1m",
+		"-storage", "mmap:/dev/shm/mbslave",
+		"-mqtt", "tcp://localhost:1883",
+	})
+	if err := gotest.Expect(err).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(config.Serial).Eq([]SerialConfig{{Port: "/dev/ttyUSB0", BaudRate: 19200, DataBits: 8, Parity: "even", StopBits: "1", CharTimeout: "-1ns"}}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(config.Tcp).Eq([]string{":502"}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(config.Units).Eq([]int{3}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(config.Sizes).Eq(Sizes{DiscreteInputs: 1000, Coils: 1000, InputRegisters: 50, HoldingRegisters: 100}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(len(config.Registers)).Eq(2); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(config.Registers["setpoint"].Type).Eq(mbslave.TypeFloat32); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(config.Values).Eq([]Value{
+		{Table: mbslave.TableHoldingRegisters, Address: 10, Value: 123},
+		{Unit: 3, Table: mbslave.TableCoils, Address: 1, Value: 1},
+	}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(config.Log).Eq(LogConfig{Level: "debug", Format: "json"}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(config.Limits).Eq(Limits{Allow: []string{"10.0.0.0/8"}, IdleTimeout: "1m0s"}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(config.Audit).Eq(AuditConfig{Path: "audit.jsonl", MaxSize: 10 << 20, MaxFiles: 5}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(config.Storage).Eq("mmap:/dev/shm/mbslave"); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(config.Mqtt).Eq(MqttConfig{Broker: "tcp://localhost:1883", Prefix: "mbslave"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseFlags_Invalid(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"-tcp", ":502", "-units", "0"},
+		{"-tcp", ":502", "-set", "hr:10"},
+		{"-serial", "COM1", "-parity", "x"},
+		{"-tcp", ":502", "-hr", "70000"},
+		{"-tls", ":802", "-tls-cert", "server.pem"},
+		{"-tcp", ":502", "-deny", "10.0.0.1"},
+		{"-tcp", ":502", "-storage", "/dev/shm/mbslave"},
+	} {
+		fs := flag.NewFlagSet("mbslave", flag.ContinueOnError)
+		if _, err := parseFlags(fs, args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestNewSimulator(t *testing.T) {
+	value := 20.5
+	config := defaultConfig()
+	config.Tcp = []string{"127.0.0.1:0"}
+	config.Units = []int{1, 2}
+	config.Registers["setpoint"] = Register{Register: registerAt(mbslave.TableHoldingRegisters, 4, mbslave.TypeFloat32), Value: &value}
+	config.Values = []Value{{Unit: 2, Table: mbslave.TableCoils, Address: 1, Value: 1}}
+	config.Console = true
+	config.Limits = Limits{MaxConns: 4, Policy: "evict"}
+
+	s, err := newSimulator(config)
+	if err := gotest.Expect(err).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(s.models[1].GetHoldingRegisters(4)).Eq(uint16(0x41a4)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(s.models[1].GetCoils(1)).False(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(s.models[2].GetCoils(1)).True(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(len(s.server.Transports())).Eq(1); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(s.server.Transports()[0].(*mbslave.TcpTransport).Capture).NotNil(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(s.server.Transports()[0].(*mbslave.TcpTransport).ConnPolicy).Eq(mbslave.EvictOldestIdle); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewSimulator_Storage(t *testing.T) {
+	for _, kind := range []string{"file", "bolt"} {
+		config := defaultConfig()
+		config.Tcp = []string{"127.0.0.1:0"}
+		config.Storage = kind + ":" + filepath.Join(t.TempDir(), "tables")
+		config.Values = []Value{{Table: mbslave.TableHoldingRegisters, Address: 2, Value: 7}}
+
+		s, err := newSimulator(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = s.models[1].SetCoils(3, true)
+		s.close()
+
+		// the tables stay in the file
+		config.Values = nil
+		s, err = newSimulator(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := gotest.Expect(s.models[1].GetHoldingRegisters(2)).Eq(uint16(7)); err != nil {
+			t.Errorf("%s: %s", kind, err)
+		}
+		if err := gotest.Expect(s.models[1].GetCoils(3)).True(); err != nil {
+			t.Errorf("%s: %s", kind, err)
+		}
+		s.close()
+	}
+}
+
+func TestNewBridge(t *testing.T) {
+	config := defaultConfig()
+	config.Mqtt.Broker = "tcp://localhost:1883"
+	config.Mqtt.QoS = 1
+	config.Registers["setpoint"] = Register{Register: registerAt(mbslave.TableHoldingRegisters, 4, mbslave.TypeFloat32), Unit: "°C", Scale: 0.1}
+
+	b := newBridge(config, mbslave.NewDefaultDataModel(&mbslave.Config{SlaveId: 2}))
+	if err := gotest.Expect(b.Prefix).Eq("mbslave/2"); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(b.QoS).Eq(byte(1)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(b.Map["setpoint"].Unit).Eq("°C"); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(b.Map["setpoint"].Scale).Eq(0.1); err != nil {
+		t.Error(err)
+	}
+}
+
+func registerAt(table mbslave.Table, address uint16, dataType mbslave.DataType) admin.Register {
+	return admin.Register{Table: table, Address: address, Type: dataType}
+}
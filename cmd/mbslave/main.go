@@ -0,0 +1,293 @@
+// Command mbslave is a Modbus slave simulator serving one or more units over
+// serial ports, Modbus/TCP and RTU over TCP.
+//
+//	mbslave -serial /dev/ttyUSB0 -baud 19200 -units 1,2 -set hr:0=100
+//	mbslave -tcp :502 -map registers.json -snapshot state.json -admin :8080
+//	mbslave -config simulator.json
+//	mbslave -tcp :502 -map registers.json -console
+//	mbslave -tcp :502 -audit audit.jsonl -admin :8080
+//	mbslave -tls :802 -tls-cert server.pem -tls-key server.key -tls-ca clients.pem
+//	mbslave -tcp :502 -storage mmap:/dev/shm/mbslave
+//	mbslave -tcp :502 -map registers.json -mqtt tcp://localhost:1883
+//
+// Flags override the values of the config file. The snapshot is loaded at
+// start and written on SIGINT/SIGTERM.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func main() {
+	config, err := parseFlags(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := setupLog(config.Log); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, config); err != nil {
+		logrus.Error(err)
+		os.Exit(1)
+	}
+}
+
+// parseFlags - the config file first, then the flags that were set
+func parseFlags(fs *flag.FlagSet, args []string) (*Config, error) {
+	var (
+		configPath  = fs.String("config", "", "JSON config file")
+		serialPort  = fs.String("serial", "", "serial port, e.g. /dev/ttyUSB0, or pty:/tmp/ttyMB0 for a virtual port")
+		baudRate    = fs.Int("baud", 9600, "baud rate of -serial")
+		dataBits    = fs.Int("data-bits", 8, "data bits of -serial")
+		parity      = fs.String("parity", "none", "parity of -serial: none, odd, even, mark, space")
+		stopBits    = fs.String("stop-bits", "1", "stop bits of -serial: 1, 1.5, 2")
+		charTimeout = fs.Duration("char-timeout", 0, "pause inside a frame of -serial that ends it (default 1.5 characters), negative for USB adapters")
+		tcp         stringList
+		rtuOverTcp  stringList
+		values      stringList
+		allow       stringList
+		deny        stringList
+		maxConns    = fs.Int("max-conns", 0, "concurrent TCP connections, the newest is rejected above it")
+		idleTimeout = fs.Duration("idle-timeout", 0, "TCP connections without a request are closed after it")
+		rateLimit   = fs.Float64("rate-limit", 0, "requests per second of a TCP client, answered with exception 6 above it")
+		units       = fs.String("units", "", "comma separated unit ids (default 1)")
+		di          = fs.Uint("di", 0, "size of discrete inputs (default 1000)")
+		coils       = fs.Uint("coils", 0, "size of coils (default 1000)")
+		ir          = fs.Uint("ir", 0, "size of input registers (default 1000)")
+		hr          = fs.Uint("hr", 0, "size of holding registers (default 1000)")
+		registerMap = fs.String("map", "", "JSON register map file")
+		snapshot    = fs.String("snapshot", "", "file with the tables, loaded at start and written at exit")
+		adminAddr   = fs.String("admin", "", "address of the HTTP admin API, e.g. :8080")
+		adminToken  = fs.String("admin-token", "", "bearer token of the admin API")
+		console     = fs.Bool("console", false, "interactive console on stdin/stdout, quit stops the simulator")
+		tlsAddr     = fs.String("tls", "", "Modbus/TCP Security listen address, e.g. :802")
+		tlsCert     = fs.String("tls-cert", "", "PEM certificate of -tls")
+		tlsKey      = fs.String("tls-key", "", "PEM key of -tls")
+		tlsCA       = fs.String("tls-ca", "", "PEM CAs of the client certificates of -tls")
+		auditPath   = fs.String("audit", "", "JSON lines file with every write, rotated at 10 MB")
+		mqttBroker  = fs.String("mqtt", "", "MQTT broker the registers of the map are published to, e.g. tcp://localhost:1883")
+		storagePath = fs.String("storage", "", "tables shared with other processes, file:DIR, mmap:DIR or bolt:DIR, e.g. mmap:/dev/shm/mbslave")
+		logLevel    = fs.String("log-level", "", "debug, info, warn or error (default info)")
+		logFormat   = fs.String("log-format", "", "text or json (default text)")
+	)
+	fs.Var(&tcp, "tcp", "Modbus/TCP listen address, repeatable")
+	fs.Var(&rtuOverTcp, "rtu-tcp", "RTU over TCP listen address, repeatable")
+	fs.Var(&allow, "allow", "client network allowed on TCP, e.g. 10.0.0.0/8, repeatable")
+	fs.Var(&deny, "deny", "client network denied on TCP, repeatable")
+	fs.Var(&values, "set", "initial value [unit/]table:address=value, e.g. hr:10=123, repeatable")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	config := defaultConfig()
+	if *configPath != "" {
+		if err := loadConfig(*configPath, config); err != nil {
+			return nil, err
+		}
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	if *serialPort != "" {
+		sc := SerialConfig{
+			Port:     *serialPort,
+			BaudRate: *baudRate,
+			DataBits: *dataBits,
+			Parity:   *parity,
+			StopBits: *stopBits,
+		}
+		if *charTimeout != 0 {
+			sc.CharTimeout = charTimeout.String()
+		}
+		config.Serial = append(config.Serial, sc)
+	}
+	config.Tcp = append(config.Tcp, tcp...)
+	config.RtuOverTcp = append(config.RtuOverTcp, rtuOverTcp...)
+	config.Limits.Allow = append(config.Limits.Allow, allow...)
+	config.Limits.Deny = append(config.Limits.Deny, deny...)
+	if set["max-conns"] {
+		config.Limits.MaxConns = *maxConns
+	}
+	if set["idle-timeout"] {
+		config.Limits.IdleTimeout = idleTimeout.String()
+	}
+	if set["rate-limit"] {
+		config.Limits.RateLimit = *rateLimit
+	}
+	if *tlsAddr != "" {
+		config.Tls = append(config.Tls, TlsConfig{Address: *tlsAddr, Cert: *tlsCert, Key: *tlsKey, ClientCA: *tlsCA})
+	}
+	if set["units"] {
+		u, err := parseUnits(*units)
+		if err != nil {
+			return nil, err
+		}
+		config.Units = u
+	}
+	sizes := []struct {
+		name   string
+		value  uint
+		target *uint16
+	}{
+		{"di", *di, &config.Sizes.DiscreteInputs},
+		{"coils", *coils, &config.Sizes.Coils},
+		{"ir", *ir, &config.Sizes.InputRegisters},
+		{"hr", *hr, &config.Sizes.HoldingRegisters},
+	}
+	for _, size := range sizes {
+		if !set[size.name] {
+			continue
+		}
+		if size.value > 0xffff {
+			return nil, fmt.Errorf("-%s: size %d is larger than 65535", size.name, size.value)
+		}
+		*size.target = uint16(size.value)
+	}
+	if *registerMap != "" {
+		config.RegisterMap = *registerMap
+	}
+	if config.RegisterMap != "" {
+		if err := loadRegisterMap(config.RegisterMap, config); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range values {
+		v, err := parseValue(s)
+		if err != nil {
+			return nil, err
+		}
+		config.Values = append(config.Values, v)
+	}
+	if set["snapshot"] {
+		config.Snapshot = *snapshot
+	}
+	if set["admin"] {
+		config.Admin = *adminAddr
+	}
+	if set["admin-token"] {
+		config.AdminToken = *adminToken
+	}
+	if set["console"] {
+		config.Console = *console
+	}
+	if set["audit"] {
+		config.Audit.Path = *auditPath
+	}
+	if set["mqtt"] {
+		config.Mqtt.Broker = *mqttBroker
+	}
+	if set["storage"] {
+		config.Storage = *storagePath
+	}
+	if set["log-level"] {
+		config.Log.Level = *logLevel
+	}
+	if set["log-format"] {
+		config.Log.Format = *logFormat
+	}
+	return config, config.validate()
+}
+
+func setupLog(config LogConfig) error {
+	level, err := logrus.ParseLevel(config.Level)
+	if err != nil {
+		return err
+	}
+	logrus.SetLevel(level)
+	switch config.Format {
+	case "", "text":
+		logrus.SetFormatter(&logrus.TextFormatter{FullTimestamp: true, TimestampFormat: "Jan _2 15:04:05.000"})
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q", config.Format)
+	}
+	return nil
+}
+
+// run - serves until the context is done or a transport fails
+func run(ctx context.Context, config *Config) error {
+	s, err := newSimulator(config)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	if config.Snapshot != "" {
+		snapshot, err := loadSnapshot(config.Snapshot)
+		if err != nil {
+			return err
+		}
+		if err := snapshot.restore(s.models); err != nil {
+			return fmt.Errorf("%s: %w", config.Snapshot, err)
+		}
+	}
+	// the initial values are not audited
+	if config.Audit.Path != "" {
+		closeAudit, err := s.startAudit(config.Audit)
+		if err != nil {
+			return err
+		}
+		defer closeAudit()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.server.Listen()
+	}()
+	logrus.WithField("units", config.Units).Info("simulator started")
+
+	if s.console != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		// the log is printed above the prompt
+		logrus.SetOutput(s.console)
+		defer logrus.SetOutput(os.Stderr)
+		go func() {
+			if err := s.console.Run(os.Stdin, os.Stdout); err != nil {
+				logrus.Error(err)
+			}
+			cancel()
+		}()
+	}
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		logrus.Info("shutting down")
+		_ = s.server.Close()
+		err = <-done
+	}
+
+	if config.Snapshot != "" {
+		if serr := takeSnapshot(s.models).save(config.Snapshot); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return err
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/schnack/mbslave"
+	"github.com/schnack/mbslave/admin"
+	"github.com/schnack/mbslave/audit"
+	"github.com/schnack/mbslave/console"
+	"github.com/schnack/mbslave/gateway"
+	"github.com/schnack/mbslave/mqtt"
+	"github.com/schnack/mbslave/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// simulator - the server and the tables of every unit
+type simulator struct {
+	server  *mbslave.Server
+	models  map[uint8]*mbslave.DefaultDataModel
+	stats   *mbslave.Stats
+	console *console.Console
+	admin   *admin.Admin
+	audit   *audit.Audit
+	stores  []io.Closer
+}
+
+func newSimulator(config *Config) (*simulator, error) {
+	s := &simulator{
+		models: make(map[uint8]*mbslave.DefaultDataModel),
+		stats:  mbslave.NewStats(nil),
+	}
+	gw := gateway.NewGateway()
+	units := make([]uint8, 0, len(config.Units))
+	for _, unit := range config.Units {
+		dmConfig := &mbslave.Config{
+			SlaveId:              uint8(unit),
+			SizeDiscreteInputs:   config.Sizes.DiscreteInputs,
+			SizeCoils:            config.Sizes.Coils,
+			SizeInputRegisters:   config.Sizes.InputRegisters,
+			SizeHoldingRegisters: config.Sizes.HoldingRegisters,
+		}
+		dm, err := s.newDataModel(config.Storage, dmConfig)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		dm.SetMetrics(s.stats)
+		gw.AddLocal(uint8(unit), dm)
+		s.models[uint8(unit)] = dm
+		units = append(units, uint8(unit))
+	}
+	if err := s.setValues(config); err != nil {
+		s.close()
+		return nil, err
+	}
+
+	// other units are not answered, a bus may have more slaves
+	options := mbslave.TransportOptions{Units: units}
+	s.server = mbslave.NewServer(nil, gw)
+	if config.Audit.Path != "" {
+		// the outermost middleware records the writes denied by the others too,
+		// the file is opened by startAudit
+		s.audit = audit.NewAudit(nil)
+		s.server.Use(s.audit.Middleware())
+	}
+	s.server.Use(mbslave.RecoverMiddleware(mbslave.NewLogrusLogger(logrus.StandardLogger())))
+	if len(config.Roles) > 0 {
+		// the roles apply to the TLS listeners only
+		authorize := func(info mbslave.RequestInfo, req mbslave.Request) bool {
+			return info.Transport != "tls" || config.Roles.Authorize(info, req)
+		}
+		s.server.Use(mbslave.AuthorizeMiddleware(authorize, mbslave.NewLogrusLogger(logrus.StandardLogger())))
+	}
+	for _, sc := range config.Serial {
+		parity, _ := parseParity(sc.Parity)
+		stopBits, _ := parseStopBits(sc.StopBits)
+		charTimeout, _ := time.ParseDuration(sc.CharTimeout)
+		dataBits := sc.DataBits
+		if dataBits == 0 {
+			dataBits = 8
+		}
+		s.server.AddTransport(mbslave.NewRtuTransport(&mbslave.Config{
+			Port:        sc.Port,
+			BaudRate:    sc.BaudRate,
+			DataBits:    dataBits,
+			Parity:      parity,
+			StopBits:    stopBits,
+			CharTimeout: charTimeout,
+		}), options)
+	}
+	for _, address := range config.Tcp {
+		tt := mbslave.NewTcpTransport(&mbslave.Config{Address: address})
+		config.Limits.apply(tt)
+		s.server.AddTransport(tt, options)
+	}
+	for _, address := range config.RtuOverTcp {
+		tt := mbslave.NewRtuOverTcpTransport(&mbslave.Config{Address: address})
+		config.Limits.apply(tt)
+		s.server.AddTransport(tt, options)
+	}
+	for _, tc := range config.Tls {
+		tlsConfig, err := loadTls(tc)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		tt := mbslave.NewTlsTransport(&mbslave.Config{Address: tc.Address}, tlsConfig)
+		config.Limits.apply(tt)
+		s.server.AddTransport(tt, options)
+	}
+	s.server.SetMetrics(s.stats)
+
+	if config.Admin != "" {
+		a := admin.NewAdmin(s.models[units[0]])
+		a.Address = config.Admin
+		a.Token = config.AdminToken
+		a.Server = s.server
+		a.Stats = s.stats
+		for name, register := range config.Registers {
+			a.Map[name] = register.Register
+		}
+		s.server.AddService(a)
+		s.admin = a
+	}
+
+	if config.Mqtt.Broker != "" {
+		for _, unit := range units {
+			s.server.AddService(newBridge(config, s.models[unit]))
+		}
+	}
+
+	if config.Console {
+		s.console = console.NewConsole(s.models[units[0]])
+		for _, unit := range units[1:] {
+			s.console.AddUnit(s.models[unit])
+		}
+		for name, register := range config.Registers {
+			s.console.Map[name] = register.Register
+		}
+		for _, transport := range s.server.Transports() {
+			switch t := transport.(type) {
+			case *mbslave.RtuTransport:
+				t.Capture = s.console.Capturer(mbslave.LinkTypeRtu)
+			case *mbslave.TcpTransport:
+				if t.Rtu {
+					t.Capture = s.console.Capturer(mbslave.LinkTypeRtu)
+				} else {
+					t.Capture = s.console.Capturer(mbslave.LinkTypeTcp)
+				}
+			}
+		}
+	}
+	return s, nil
+}
+
+// newBridge - publishes the registers of the map under Prefix/<unit>
+func newBridge(config *Config, dm *mbslave.DefaultDataModel) *mqtt.Bridge {
+	b := mqtt.NewBridge(dm, config.Mqtt.Broker)
+	b.Prefix = fmt.Sprintf("%s/%d", config.Mqtt.Prefix, dm.SlaveId)
+	b.Username = config.Mqtt.Username
+	b.Password = config.Mqtt.Password
+	b.QoS = config.Mqtt.QoS
+	b.Retain = config.Mqtt.Retain
+	for name, register := range config.Registers {
+		b.Map[name] = mqtt.Register{Register: register.Register, Unit: register.Unit, Scale: register.Scale}
+	}
+	return b
+}
+
+// newDataModel - the tables of the unit in memory or in the file of the storage
+func (s *simulator) newDataModel(spec string, config *mbslave.Config) (*mbslave.DefaultDataModel, error) {
+	kind, dir, _ := parseStorage(spec)
+	if kind == "" {
+		return mbslave.NewDefaultDataModel(config), nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	onError := func(err error) {
+		logrus.WithError(err).Error("storage")
+	}
+	var store interface {
+		mbslave.Storage
+		io.Closer
+	}
+	if kind == "bolt" {
+		db, err := storage.OpenBolt(filepath.Join(dir, fmt.Sprintf("unit-%d.db", config.SlaveId)), config)
+		if err != nil {
+			return nil, err
+		}
+		db.OnError = onError
+		store = db
+	} else {
+		open := storage.OpenFile
+		if kind == "mmap" {
+			open = storage.OpenMmap
+		}
+		file, err := open(filepath.Join(dir, fmt.Sprintf("unit-%d.tables", config.SlaveId)), config)
+		if err != nil {
+			return nil, err
+		}
+		file.OnError = onError
+		store = file
+	}
+	dm := mbslave.NewStorageDataModel(config, store)
+	// the data model stops watching before the storage closes
+	s.stores = append(s.stores, dm, store)
+	return dm, nil
+}
+
+// close - closes the data models and their storage files
+func (s *simulator) close() {
+	for _, store := range s.stores {
+		_ = store.Close()
+	}
+	s.stores = nil
+}
+
+// loadTls - the server certificate and the pool of client CAs
+func loadTls(config TlsConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(config.Cert, config.Key)
+	if err != nil {
+		return nil, fmt.Errorf("tls %s: %w", config.Address, err)
+	}
+	pem, err := os.ReadFile(config.ClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("tls %s: %w", config.Address, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("tls %s: no certificate in %s", config.Address, config.ClientCA)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, ClientCAs: pool}, nil
+}
+
+// setValues - applies the initial values of the config to all units
+func (s *simulator) setValues(config *Config) error {
+	for _, dm := range s.models {
+		for name, register := range config.Registers {
+			if register.Value == nil {
+				continue
+			}
+			words := register.Type.Encode(*register.Value)
+			if register.Table.IsBit() {
+				words = mbslave.TypeBool.Encode(*register.Value)
+			}
+			if register.SwapWords {
+				mbslave.SwapWords(words)
+			}
+			for i, word := range words {
+				if err := dm.Set(register.Table, register.Address+uint16(i), word); err != nil {
+					return fmt.Errorf("register %s: %w", name, err)
+				}
+			}
+		}
+	}
+	for _, v := range config.Values {
+		for unit, dm := range s.models {
+			if v.Unit != 0 && v.Unit != int(unit) {
+				continue
+			}
+			if err := dm.Set(v.Table, v.Address, v.Value); err != nil {
+				return fmt.Errorf("%s:%d: %w", v.Table, v.Address, err)
+			}
+		}
+	}
+	return nil
+}
+
+// startAudit - records the writes of all units to the file until stop is called,
+// the audit of newSimulator records nothing before
+func (s *simulator) startAudit(config AuditConfig) (stop func(), err error) {
+	sink, err := audit.NewFileSink(config.Path, config.MaxSize, config.MaxFiles)
+	if err != nil {
+		return nil, err
+	}
+	a := s.audit
+	a.Sink = sink
+	a.OnError = func(err error) {
+		logrus.WithError(err).Error("audit log")
+	}
+	var cancels []func()
+	for _, dm := range s.models {
+		cancels = append(cancels, a.Watch(dm))
+	}
+	if s.admin != nil {
+		s.admin.Audit = a
+	}
+	return func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+		_ = sink.Close()
+	}, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/schnack/mbslave"
+)
+
+// snapshot - non-zero values by unit, table and address
+type snapshot map[string]map[string]map[string]uint16
+
+var tables = []mbslave.Table{
+	mbslave.TableDiscreteInputs,
+	mbslave.TableCoils,
+	mbslave.TableInputRegisters,
+	mbslave.TableHoldingRegisters,
+}
+
+func takeSnapshot(models map[uint8]*mbslave.DefaultDataModel) snapshot {
+	s := make(snapshot)
+	for unit, dm := range models {
+		unitTables := make(map[string]map[string]uint16)
+		for _, table := range tables {
+			values := make(map[string]uint16)
+			for address := 0; address < dm.Length(table); address++ {
+				if value := dm.Get(table, uint16(address)); value != 0 {
+					values[strconv.Itoa(address)] = value
+				}
+			}
+			if len(values) > 0 {
+				unitTables[table.String()] = values
+			}
+		}
+		s[strconv.Itoa(int(unit))] = unitTables
+	}
+	return s
+}
+
+// restore - writes the values into the models, unknown units and addresses are skipped
+func (s snapshot) restore(models map[uint8]*mbslave.DefaultDataModel) error {
+	for unit, unitTables := range s {
+		u, err := strconv.ParseUint(unit, 10, 8)
+		if err != nil {
+			return err
+		}
+		dm, ok := models[uint8(u)]
+		if !ok {
+			continue
+		}
+		for name, values := range unitTables {
+			table, err := mbslave.ParseTable(name)
+			if err != nil {
+				return err
+			}
+			for address, value := range values {
+				a, err := strconv.ParseUint(address, 10, 16)
+				if err != nil {
+					return err
+				}
+				_ = dm.Set(table, uint16(a), value)
+			}
+		}
+	}
+	return nil
+}
+
+// loadSnapshot - a missing file is an empty snapshot
+func loadSnapshot(path string) (snapshot, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return snapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := make(snapshot)
+	return s, json.Unmarshal(data, &s)
+}
+
+// save - writes the snapshot through a temporary file, so a crash keeps the old one
+func (s snapshot) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
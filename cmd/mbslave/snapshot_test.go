@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/schnack/gotest"
+	"github.com/schnack/mbslave"
+)
+
+func TestSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	config := &mbslave.Config{SizeCoils: 8, SizeHoldingRegisters: 8}
+	models := map[uint8]*mbslave.DefaultDataModel{1: mbslave.NewDefaultDataModel(config)}
+	_ = models[1].SetCoils(3, true)
+	_ = models[1].SetHoldingRegisters(7, 0xbeef)
+	if err := gotest.Expect(takeSnapshot(models).save(path)).NotError(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := map[uint8]*mbslave.DefaultDataModel{1: mbslave.NewDefaultDataModel(config)}
+	s, err := loadSnapshot(path)
+	if err := gotest.Expect(err).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(s.restore(restored)).NotError(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(restored[1].GetCoils(3)).True(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(restored[1].GetHoldingRegisters(7)).Eq(uint16(0xbeef)); err != nil {
+		t.Error(err)
+	}
+
+	missing, err := loadSnapshot(filepath.Join(t.TempDir(), "missing.json"))
+	if err := gotest.Expect(err).NotError(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(len(missing)).Eq(0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	config := defaultConfig()
+	config.Tcp = []string{"127.0.0.1:0"}
+	config.Snapshot = filepath.Join(t.TempDir(), "state.json")
+	config.Audit.Path = filepath.Join(t.TempDir(), "audit.jsonl")
+	config.Values = []Value{{Table: mbslave.TableHoldingRegisters, Address: 1, Value: 5}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := gotest.Expect(run(ctx, config)).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := loadSnapshot(config.Snapshot)
+	if err := gotest.Expect(err).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(s["1"]["hr"]["1"]).Eq(uint16(5)); err != nil {
+		t.Error(err)
+	}
+	// the initial values are not audited
+	if data, err := os.ReadFile(config.Audit.Path); err != nil || len(data) != 0 {
+		t.Errorf("audit log %q: %v", data, err)
+	}
+}
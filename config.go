@@ -35,6 +35,15 @@ type Config struct {
 	StopBits serial.StopBits
 	// Интервал между adu
 	SilentInterval time.Duration
+	// Pause between two bytes of one frame that ends it, RtuCharTimeout (the 1.5
+	// characters of the specification) without it. A negative value disables the
+	// check for USB adapters, they deliver the bytes in chunks with longer gaps.
+	CharTimeout time.Duration
+	// Direction control of half-duplex RS-485 adapters
+	Rs485 Rs485Config
+
+	// Address of the Modbus/TCP listener, e.g. ":502"
+	Address string
 
 	SlaveId              uint8
 	SizeDiscreteInputs   uint16
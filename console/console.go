@@ -0,0 +1,650 @@
+// Package console is an interactive command line for a running simulator:
+// reading and writing the tables, watching changes, injecting faults and
+// showing the decoded traffic of the transports.
+package console
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/schnack/mbslave"
+	"github.com/schnack/mbslave/admin"
+)
+
+const help = `commands:
+  get <table> <address> [count]        read values, tables: di coils ir hr
+  get <name>                           read a register of the map
+  set <table> <address> <value>...     write values from the address on
+  set <name> <value>                   write a register of the map
+  watch <table> <from>[..<to>]         print changes of the range
+  watch <name>                         print changes of a register of the map
+  unwatch                              stop all watches
+  traffic on|off                       show the decoded frames
+  fault drop fc<n>                     leave requests of the function unanswered
+  fault exception fc<n> <code>         answer the function with an exception
+  fault delay fc<n> <duration>         delay the responses, e.g. 500ms
+  fault clear [fc<n>]                  remove faults
+  fault                                list faults
+  unit [<id>]                          select the unit of the commands
+  quit`
+
+var errQuit = errors.New("quit")
+
+var commands = []string{"get", "set", "watch", "unwatch", "traffic", "fault", "unit", "help", "quit"}
+
+// Console - commands operate on the live data model of the selected unit, see Run
+type Console struct {
+	// DataModel - the selected unit, see AddUnit
+	DataModel *mbslave.DefaultDataModel
+	// Map - named registers usable in place of a table and an address
+	Map    map[string]admin.Register
+	Prompt string
+
+	mu      sync.Mutex
+	out     io.Writer
+	editor  *editor
+	traffic bool
+	watches []func()
+	units   map[uint8]*mbslave.DefaultDataModel
+	faults  map[faultKey]fault
+}
+
+type faultKey struct {
+	unit     uint8
+	function uint8
+}
+
+type fault struct {
+	kind  string
+	code  uint8
+	delay time.Duration
+}
+
+func (f fault) String() string {
+	switch f.kind {
+	case "exception":
+		return fmt.Sprintf("exception %d", f.code)
+	case "delay":
+		return fmt.Sprintf("delay %s", f.delay)
+	}
+	return f.kind
+}
+
+// NewConsole - the data model is the selected unit, call it before Listen
+func NewConsole(dataModel *mbslave.DefaultDataModel) *Console {
+	c := &Console{
+		DataModel: dataModel,
+		Map:       make(map[string]admin.Register),
+		Prompt:    "mbslave> ",
+		out:       os.Stdout,
+		traffic:   true,
+		units:     make(map[uint8]*mbslave.DefaultDataModel),
+		faults:    make(map[faultKey]fault),
+	}
+	c.AddUnit(dataModel)
+	return c
+}
+
+// AddUnit - the unit can be selected with the unit command, its faults are
+// injected by a middleware of the data model. Call it before Listen.
+func (c *Console) AddUnit(dataModel *mbslave.DefaultDataModel) {
+	c.mu.Lock()
+	c.units[dataModel.SlaveId] = dataModel
+	c.mu.Unlock()
+	dataModel.Use(c.faulty(dataModel.SlaveId))
+}
+
+// Run - executes commands until quit or the end of the input. A terminal gets
+// line editing and completion, other inputs are read line by line without a prompt.
+// Watches and faults are removed when Run returns.
+func (c *Console) Run(in io.Reader, out io.Writer) error {
+	c.mu.Lock()
+	c.out = out
+	c.mu.Unlock()
+	defer c.Close()
+
+	if f, ok := in.(*os.File); ok {
+		if restore, ok := makeRaw(f); ok {
+			defer restore()
+			ed := newEditor(f, out, c.Prompt, c.complete)
+			c.mu.Lock()
+			c.editor = ed
+			c.mu.Unlock()
+			return c.loop(ed.readLine)
+		}
+	}
+	scanner := bufio.NewScanner(in)
+	return c.loop(func() (string, error) {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+		return scanner.Text(), nil
+	})
+}
+
+// Close - removes watches and the faults of all units
+func (c *Console) Close() {
+	c.unwatch()
+	c.mu.Lock()
+	c.faults = make(map[faultKey]fault)
+	c.editor = nil
+	c.mu.Unlock()
+}
+
+// Write - prints log output above the prompt
+func (c *Console) Write(p []byte) (int, error) {
+	c.print(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+// Capturer - prints the frames of a transport while traffic is on, the link type
+// tells whether the ADU starts with the MBAP header or ends with the CRC
+func (c *Console) Capturer(linkType uint16) mbslave.Capturer {
+	return captureFunc(func(direction mbslave.Direction, _ time.Time, adu []byte) error {
+		c.mu.Lock()
+		traffic := c.traffic
+		c.mu.Unlock()
+		if traffic {
+			c.print(decode(direction, linkType, adu))
+		}
+		return nil
+	})
+}
+
+type captureFunc func(direction mbslave.Direction, t time.Time, adu []byte) error
+
+func (f captureFunc) Capture(direction mbslave.Direction, t time.Time, adu []byte) error {
+	return f(direction, t, adu)
+}
+
+// decode - one line for a frame: the unit, the function and the fields of the PDU
+func decode(direction mbslave.Direction, linkType uint16, adu []byte) string {
+	arrow := "<-"
+	if direction == mbslave.DirectionOut {
+		arrow = "->"
+	}
+	var pdu []byte
+	var unit uint8
+	switch {
+	case linkType == mbslave.LinkTypeTcp && len(adu) >= 8:
+		unit, pdu = adu[6], adu[7:]
+	case linkType == mbslave.LinkTypeRtu && len(adu) >= 4:
+		unit, pdu = adu[0], adu[1:len(adu)-2]
+	default:
+		return fmt.Sprintf("%s % x", arrow, adu)
+	}
+	function := pdu[0]
+	line := fmt.Sprintf("%s unit %d fc %d", arrow, unit, function&0x7f)
+	switch {
+	case function&0x80 != 0 && len(pdu) >= 2:
+		return fmt.Sprintf("%s exception %d", line, pdu[1])
+	case direction == mbslave.DirectionIn && len(pdu) >= 5:
+		address := uint16(pdu[1])<<8 | uint16(pdu[2])
+		value := uint16(pdu[3])<<8 | uint16(pdu[4])
+		switch function {
+		case mbslave.FuncWriteSingleCoil, mbslave.FuncWriteSingleRegister:
+			return fmt.Sprintf("%s addr %d value %d", line, address, value)
+		default:
+			return fmt.Sprintf("%s addr %d qty %d", line, address, value)
+		}
+	case len(pdu) > 1:
+		return fmt.Sprintf("%s data % x", line, pdu[1:])
+	}
+	return line
+}
+
+func (c *Console) loop(read func() (string, error)) error {
+	for {
+		line, err := read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		out, err := c.Exec(line)
+		switch {
+		case err == errQuit:
+			return nil
+		case err != nil:
+			c.print("error: " + err.Error())
+		case out != "":
+			c.print(out)
+		}
+	}
+}
+
+// print - writes a line, the input line of the editor is redrawn below it
+func (c *Console) print(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.editor != nil {
+		c.editor.interrupt(line)
+		return
+	}
+	fmt.Fprintln(c.out, line)
+}
+
+// Exec - executes one command and returns its output
+func (c *Console) Exec(line string) (string, error) {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return "", nil
+	}
+	switch args[0] {
+	case "get":
+		return c.get(args[1:])
+	case "set":
+		return "", c.set(args[1:])
+	case "watch":
+		return "", c.watch(args[1:])
+	case "unwatch":
+		c.unwatch()
+		return "", nil
+	case "traffic":
+		if len(args) != 2 || (args[1] != "on" && args[1] != "off") {
+			return "", errors.New("usage: traffic on|off")
+		}
+		c.mu.Lock()
+		c.traffic = args[1] == "on"
+		c.mu.Unlock()
+		return "", nil
+	case "fault":
+		return c.fault(args[1:])
+	case "unit":
+		return c.unit(args[1:])
+	case "help", "?":
+		return help, nil
+	case "quit", "exit":
+		return "", errQuit
+	}
+	return "", fmt.Errorf("unknown command %q, try help", args[0])
+}
+
+func (c *Console) get(args []string) (string, error) {
+	if len(args) == 1 {
+		register, ok := c.Map[args[0]]
+		if !ok {
+			return "", fmt.Errorf("unknown register %q", args[0])
+		}
+		return fmt.Sprintf("%s = %g", args[0], c.readRegister(register)), nil
+	}
+	if len(args) != 2 && len(args) != 3 {
+		return "", errors.New("usage: get <table> <address> [count] or get <name>")
+	}
+	table, address, err := c.address(args[0], args[1])
+	if err != nil {
+		return "", err
+	}
+	count := 1
+	if len(args) == 3 {
+		if count, err = strconv.Atoi(args[2]); err != nil || count < 1 {
+			return "", fmt.Errorf("invalid count %q", args[2])
+		}
+	}
+	if int(address)+count > c.DataModel.Length(table) {
+		return "", fmt.Errorf("%s has %d values", table, c.DataModel.Length(table))
+	}
+	// no Modbus read, the read callbacks are not called
+	values := make([]uint16, count)
+	if err := c.DataModel.Peek(table, address, values); err != nil {
+		return "", err
+	}
+	lines := make([]string, count)
+	for i, value := range values {
+		lines[i] = formatValue(table, address+uint16(i), value)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func (c *Console) set(args []string) error {
+	if register, ok := c.Map[firstArg(args)]; ok {
+		if len(args) != 2 {
+			return errors.New("usage: set <name> <value>")
+		}
+		value, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return fmt.Errorf("invalid value %q", args[1])
+		}
+		return c.writeRegister(register, value)
+	}
+	if len(args) < 3 {
+		return errors.New("usage: set <table> <address> <value>... or set <name> <value>")
+	}
+	table, address, err := c.address(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if int(address)+len(args)-2 > c.DataModel.Length(table) {
+		return fmt.Errorf("%s has %d values", table, c.DataModel.Length(table))
+	}
+	values := make([]uint16, len(args)-2)
+	for i, arg := range args[2:] {
+		if values[i], err = parseValue(arg, table.IsBit()); err != nil {
+			return err
+		}
+	}
+	return c.DataModel.SetRange(table, address, values)
+}
+
+func (c *Console) watch(args []string) error {
+	var matches func(mbslave.Change) bool
+	var format func(mbslave.Change) string
+	switch {
+	case len(args) == 1 && c.Map[args[0]] != (admin.Register{}):
+		name, register := args[0], c.Map[args[0]]
+		matches = func(change mbslave.Change) bool {
+			return change.Table == register.Table && change.Address >= register.Address &&
+				int(change.Address) < int(register.Address)+words(register)
+		}
+		format = func(mbslave.Change) string {
+			return fmt.Sprintf("%s = %g", name, c.readRegister(register))
+		}
+	case len(args) == 2:
+		from, to, _ := strings.Cut(args[1], "..")
+		table, first, err := c.address(args[0], from)
+		if err != nil {
+			return err
+		}
+		last := first
+		if to != "" {
+			if _, last, err = c.address(args[0], to); err != nil {
+				return err
+			}
+		}
+		matches = func(change mbslave.Change) bool {
+			return change.Table == table && change.Address >= first && change.Address <= last
+		}
+		format = func(change mbslave.Change) string {
+			return formatValue(change.Table, change.Address, change.Value)
+		}
+	default:
+		return errors.New("usage: watch <table> <from>[..<to>] or watch <name>")
+	}
+
+	// the callback runs while the table is locked, so the register is read after it
+	cancel := c.DataModel.Watch(func(change mbslave.Change) {
+		if matches(change) {
+			go c.print("watch: " + format(change))
+		}
+	})
+	c.mu.Lock()
+	c.watches = append(c.watches, cancel)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Console) unwatch() {
+	c.mu.Lock()
+	watches := c.watches
+	c.watches = nil
+	c.mu.Unlock()
+	for _, cancel := range watches {
+		cancel()
+	}
+}
+
+// fault - the faults of the selected unit
+func (c *Console) fault(args []string) (string, error) {
+	unit := c.DataModel.SlaveId
+	if len(args) == 0 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		lines := make([]string, 0, len(c.faults))
+		for key, f := range c.faults {
+			if key.unit == unit {
+				lines = append(lines, fmt.Sprintf("fc%d %s", key.function, f))
+			}
+		}
+		sort.Strings(lines)
+		if len(lines) == 0 {
+			return "no faults", nil
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+
+	if args[0] == "clear" {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for key := range c.faults {
+			if key.unit != unit {
+				continue
+			}
+			if len(args) > 1 && args[1] != fmt.Sprintf("fc%d", key.function) && args[1] != strconv.Itoa(int(key.function)) {
+				continue
+			}
+			delete(c.faults, key)
+		}
+		return "", nil
+	}
+
+	if len(args) < 2 {
+		return "", errors.New("usage: fault drop|exception|delay fc<n> [argument]")
+	}
+	function, err := parseFunction(args[1])
+	if err != nil {
+		return "", err
+	}
+	f := fault{kind: args[0]}
+	switch {
+	case f.kind == "drop" && len(args) == 2:
+	case f.kind == "exception" && len(args) == 3:
+		code, err := strconv.ParseUint(args[2], 0, 8)
+		if err != nil || code == 0 {
+			return "", fmt.Errorf("invalid exception code %q", args[2])
+		}
+		f.code = uint8(code)
+	case f.kind == "delay" && len(args) == 3:
+		if f.delay, err = time.ParseDuration(args[2]); err != nil {
+			return "", err
+		}
+	default:
+		return "", errors.New("usage: fault drop fc<n>, fault exception fc<n> <code> or fault delay fc<n> <duration>")
+	}
+	c.mu.Lock()
+	c.faults[faultKey{unit, function}] = f
+	c.mu.Unlock()
+	return "", nil
+}
+
+// faulty - the middleware of the unit, it looks the fault up on every request
+func (c *Console) faulty(unit uint8) mbslave.Middleware {
+	return func(next mbslave.Handler) mbslave.Handler {
+		return func(ctx context.Context, req mbslave.Request, resp mbslave.Response) {
+			c.mu.Lock()
+			f, ok := c.faults[faultKey{unit, req.GetFunction()}]
+			c.mu.Unlock()
+			switch {
+			case !ok:
+			case f.kind == "drop":
+				resp.Unanswered(true)
+				return
+			case f.kind == "exception":
+				resp.SetError(f.code)
+				return
+			case f.kind == "delay":
+				select {
+				case <-time.After(f.delay):
+				case <-ctx.Done():
+					resp.Unanswered(true)
+					return
+				}
+			}
+			next(ctx, req, resp)
+		}
+	}
+}
+
+// unit - selects the data model of the commands, without an id the units are listed
+func (c *Console) unit(args []string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch len(args) {
+	case 0:
+		ids := make([]string, 0, len(c.units))
+		for _, id := range c.unitIds() {
+			ids = append(ids, strconv.Itoa(int(id)))
+		}
+		return fmt.Sprintf("unit %d of %s", c.DataModel.SlaveId, strings.Join(ids, ", ")), nil
+	case 1:
+		id, err := strconv.ParseUint(args[0], 0, 8)
+		if err != nil || c.units[uint8(id)] == nil {
+			return "", fmt.Errorf("unknown unit %q", args[0])
+		}
+		c.DataModel = c.units[uint8(id)]
+		return "", nil
+	}
+	return "", errors.New("usage: unit [<id>]")
+}
+
+// unitIds - the ids of the units in order, c.mu is held
+func (c *Console) unitIds() []uint8 {
+	ids := make([]uint8, 0, len(c.units))
+	for id := range c.units {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (c *Console) address(tableName, address string) (mbslave.Table, uint16, error) {
+	table, err := mbslave.ParseTable(tableName)
+	if err != nil {
+		return 0, 0, err
+	}
+	a, err := strconv.ParseUint(address, 0, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid address %q", address)
+	}
+	if int(a) >= c.DataModel.Length(table) {
+		return 0, 0, fmt.Errorf("%s has %d values", table, c.DataModel.Length(table))
+	}
+	return table, uint16(a), nil
+}
+
+func (c *Console) readRegister(register admin.Register) float64 {
+	values := make([]uint16, words(register))
+	_ = c.DataModel.Peek(register.Table, register.Address, values)
+	if register.SwapWords {
+		mbslave.SwapWords(values)
+	}
+	if register.Table.IsBit() {
+		return mbslave.TypeBool.Decode(values)
+	}
+	return register.Type.Decode(values)
+}
+
+func (c *Console) writeRegister(register admin.Register, value float64) error {
+	values := register.Type.Encode(value)
+	if register.Table.IsBit() {
+		values = mbslave.TypeBool.Encode(value)
+	}
+	if register.SwapWords {
+		mbslave.SwapWords(values)
+	}
+	// all words at once, the watchers never see half of a value
+	return c.DataModel.SetRange(register.Table, register.Address, values)
+}
+
+// complete - candidates for the last word of the line
+func (c *Console) complete(line string) []string {
+	args := strings.Fields(line)
+	if len(args) == 0 || strings.HasSuffix(line, " ") {
+		args = append(args, "")
+	}
+	var words []string
+	switch position := len(args) - 1; {
+	case position == 0:
+		words = commands
+	case position == 1 && (args[0] == "get" || args[0] == "set" || args[0] == "watch"):
+		words = []string{"di", "coils", "ir", "hr"}
+		for name := range c.Map {
+			words = append(words, name)
+		}
+	case position == 1 && args[0] == "traffic":
+		words = []string{"on", "off"}
+	case position == 1 && args[0] == "unit":
+		c.mu.Lock()
+		for _, id := range c.unitIds() {
+			words = append(words, strconv.Itoa(int(id)))
+		}
+		c.mu.Unlock()
+	case position == 1 && args[0] == "fault":
+		words = []string{"drop", "exception", "delay", "clear"}
+	case position == 2 && args[0] == "fault":
+		for _, function := range []uint8{1, 2, 3, 4, 5, 6, 15, 16, 22, 23} {
+			words = append(words, fmt.Sprintf("fc%d", function))
+		}
+	}
+	prefix := args[len(args)-1]
+	var candidates []string
+	for _, w := range words {
+		if strings.HasPrefix(w, prefix) {
+			candidates = append(candidates, w)
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+func words(register admin.Register) int {
+	if register.Table.IsBit() {
+		return 1
+	}
+	return register.Type.Words()
+}
+
+func formatValue(table mbslave.Table, address uint16, value uint16) string {
+	if table.IsBit() {
+		return fmt.Sprintf("%s[%d] = %d", table, address, value)
+	}
+	return fmt.Sprintf("%s[%d] = %d (0x%04x)", table, address, value, value)
+}
+
+// parseValue - bits accept 0/1, true/false and on/off, registers accept unsigned and signed 16-bit numbers
+func parseValue(s string, bit bool) (uint16, error) {
+	if bit {
+		switch strings.ToLower(s) {
+		case "1", "true", "on":
+			return 1, nil
+		case "0", "false", "off":
+			return 0, nil
+		}
+		return 0, fmt.Errorf("invalid bit %q", s)
+	}
+	if v, err := strconv.ParseUint(s, 0, 16); err == nil {
+		return uint16(v), nil
+	}
+	v, err := strconv.ParseInt(s, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return uint16(v), nil
+}
+
+func parseFunction(s string) (uint8, error) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "fc"), 0, 8)
+	if err != nil || v == 0 || v > 127 {
+		return 0, fmt.Errorf("invalid function %q", s)
+	}
+	return uint8(v), nil
+}
+
+func firstArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
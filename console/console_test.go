@@ -0,0 +1,280 @@
+package console
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/schnack/gotest"
+	"github.com/schnack/mbslave"
+	"github.com/schnack/mbslave/admin"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func newConsole() (*Console, *mbslave.DefaultDataModel) {
+	dm := mbslave.NewDefaultDataModel(&mbslave.Config{
+		SlaveId:              1,
+		SizeDiscreteInputs:   8,
+		SizeCoils:            8,
+		SizeInputRegisters:   8,
+		SizeHoldingRegisters: 8,
+	})
+	c := NewConsole(dm)
+	c.Map["temperature"] = admin.Register{Table: mbslave.TableInputRegisters, Address: 2, Type: mbslave.TypeFloat32}
+	return c, dm
+}
+
+func TestConsole_Exec(t *testing.T) {
+	c, dm := newConsole()
+
+	if _, err := c.Exec("set hr 1 5 -1"); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(dm.GetHoldingRegisters(2)).Eq(uint16(0xffff)); err != nil {
+		t.Error(err)
+	}
+	out, err := c.Exec("get hr 1 2")
+	if err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(out).Eq("hr[1] = 5 (0x0005)\nhr[2] = 65535 (0xffff)"); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := c.Exec("set coils 3 on"); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(dm.GetCoils(3)).True(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := c.Exec("set temperature 21.5"); err != nil {
+		t.Error(err)
+	}
+	out, _ = c.Exec("get temperature")
+	if err := gotest.Expect(out).Eq("temperature = 21.5"); err != nil {
+		t.Error(err)
+	}
+
+	for _, line := range []string{"get hr 8", "set hr 7 1 2", "get xx 1", "set coils 1 2", "bogus"} {
+		if _, err := c.Exec(line); err == nil {
+			t.Errorf("%q: expected an error", line)
+		}
+	}
+	if _, err := c.Exec("quit"); err != errQuit {
+		t.Error(err)
+	}
+}
+
+func TestConsole_Fault(t *testing.T) {
+	c, _ := newConsole()
+	handle := func() mbslave.Response {
+		request := mbslave.NewRtuRequest([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0a})
+		response := mbslave.NewRtuResponse(request)
+		c.DataModel.Handler(request, response)
+		return response
+	}
+
+	if _, err := c.Exec("fault exception fc3 4"); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(handle().GetError()).Eq(uint8(4)); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.Exec("fault drop fc3"); err != nil {
+		t.Error(err)
+	}
+	if _, err := handle().GetADU(); err == nil {
+		t.Error("expected no answer")
+	}
+	out, _ := c.Exec("fault")
+	if err := gotest.Expect(out).Eq("fc3 drop"); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := c.Exec("fault clear fc3"); err != nil {
+		t.Error(err)
+	}
+	response := handle()
+	if err := gotest.Expect(response.GetError()).Eq(uint8(0)); err != nil {
+		t.Error(err)
+	}
+	if _, err := response.GetADU(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConsole_Unit(t *testing.T) {
+	c, one := newConsole()
+	two := mbslave.NewDefaultDataModel(&mbslave.Config{SlaveId: 2, SizeHoldingRegisters: 4})
+	c.AddUnit(two)
+	handle := func(dm *mbslave.DefaultDataModel) uint8 {
+		request := mbslave.NewRtuRequest(mbslave.AppendCrc([]byte{dm.SlaveId, 0x03, 0x00, 0x00, 0x00, 0x01}))
+		response := mbslave.NewRtuResponse(request)
+		dm.Handler(request, response)
+		return response.GetError()
+	}
+
+	if _, err := c.Exec("unit 2"); err != nil {
+		t.Fatal(err)
+	}
+	out, _ := c.Exec("unit")
+	if err := gotest.Expect(out).Eq("unit 2 of 1, 2"); err != nil {
+		t.Error(err)
+	}
+	_, _ = c.Exec("set hr 1 7")
+	_, _ = c.Exec("fault exception fc3 4")
+	if err := gotest.Expect(two.GetHoldingRegisters(1)).Eq(uint16(7)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(one.GetHoldingRegisters(1)).Eq(uint16(0)); err != nil {
+		t.Error(err)
+	}
+
+	// the fault stays when the function is replaced, e.g. by a script
+	two.SetFunction(mbslave.FuncReadHoldingRegisters, func(req mbslave.Request, resp mbslave.Response) {})
+	if err := gotest.Expect(handle(two)).Eq(uint8(4)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(handle(one)).Eq(uint8(0)); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.Exec("unit 3"); err == nil {
+		t.Error("an unknown unit was selected")
+	}
+}
+
+func TestConsole_Run(t *testing.T) {
+	c, dm := newConsole()
+	out := &syncBuffer{}
+	script := "watch hr 1..2\nset hr 2 7\nset hr 3 8\nbogus\nquit\nset hr 4 1\n"
+	if err := c.Run(strings.NewReader(script), out); err != nil {
+		t.Error(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(out.String(), "watch: hr[2] = 7") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !strings.Contains(out.String(), "watch: hr[2] = 7") {
+		t.Error(out.String())
+	}
+	if !strings.Contains(out.String(), "error: unknown command") {
+		t.Error(out.String())
+	}
+	if strings.Contains(out.String(), "hr[3]") {
+		t.Error("a change outside the range was printed")
+	}
+	if err := gotest.Expect(dm.GetHoldingRegisters(4)).Eq(uint16(0)); err != nil {
+		t.Error("a command after quit was executed")
+	}
+}
+
+func TestConsole_Capturer(t *testing.T) {
+	c, _ := newConsole()
+	out := &syncBuffer{}
+	c.out = out
+	capturer := c.Capturer(mbslave.LinkTypeRtu)
+
+	_ = capturer.Capture(mbslave.DirectionIn, time.Now(), []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x02, 0xc4, 0x0b})
+	_ = capturer.Capture(mbslave.DirectionOut, time.Now(), []byte{0x01, 0x83, 0x02, 0xc0, 0xf1})
+	_, _ = c.Exec("traffic off")
+	_ = capturer.Capture(mbslave.DirectionIn, time.Now(), []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x02, 0xc4, 0x0b})
+
+	if err := gotest.Expect(out.String()).Eq("<- unit 1 fc 3 addr 0 qty 2\n-> unit 1 fc 3 exception 2\n"); err != nil {
+		t.Error(err)
+	}
+
+	tcp := c.Capturer(mbslave.LinkTypeTcp)
+	_, _ = c.Exec("traffic on")
+	_ = tcp.Capture(mbslave.DirectionOut, time.Now(), []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x05, 0x02, 0x03, 0x02, 0x00, 0x2a})
+	if !strings.Contains(out.String(), "-> unit 2 fc 3 data 02 00 2a") {
+		t.Error(out.String())
+	}
+}
+
+func TestEditor(t *testing.T) {
+	c, _ := newConsole()
+	out := &bytes.Buffer{}
+	e := newEditor(strings.NewReader("se\thr 1 5\rge\x7f\x7fx\x1b[A\r"), out, "> ", c.complete)
+
+	line, err := e.readLine()
+	if err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(line).Eq("set hr 1 5"); err != nil {
+		t.Error(err)
+	}
+	// the history replaces the typed text
+	line, _ = e.readLine()
+	if err := gotest.Expect(line).Eq("set hr 1 5"); err != nil {
+		t.Error(err)
+	}
+	if _, err := e.readLine(); err == nil {
+		t.Error("expected the end of the input")
+	}
+}
+
+func TestConsole_Complete(t *testing.T) {
+	c, _ := newConsole()
+	if err := gotest.Expect(c.complete("wa")).Eq([]string{"watch"}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(c.complete("get t")).Eq([]string{"temperature"}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(c.complete("fault drop fc1")).Eq([]string{"fc1", "fc15", "fc16"}); err != nil {
+		t.Error(err)
+	}
+}
+
+// rangeStorage - a SliceStorage recording the lengths of the writes
+type rangeStorage struct {
+	*mbslave.SliceStorage
+	sets []int
+}
+
+func (s *rangeStorage) Set(table mbslave.Table, address uint16, values []uint16) ([]uint16, error) {
+	s.sets = append(s.sets, len(values))
+	return s.SliceStorage.Set(table, address, values)
+}
+
+func TestConsole_Ranges(t *testing.T) {
+	config := &mbslave.Config{SlaveId: 1, SizeInputRegisters: 8}
+	s := &rangeStorage{SliceStorage: mbslave.NewSliceStorage(config)}
+	c, _ := newConsole()
+	c.DataModel = mbslave.NewStorageDataModel(config, s)
+	c.DataModel.SetCallbackInputRegisters(2, func(event mbslave.Event, address uint16, value uint16) {
+		if event == mbslave.EventRead {
+			t.Errorf("the read callback of %d was called", address)
+		}
+	})
+
+	// every write at once, the reads are no Modbus reads
+	for _, line := range []string{"set temperature 21.5", "set ir 4 1 2 3", "get temperature", "get ir 2 2"} {
+		if _, err := c.Exec(line); err != nil {
+			t.Errorf("%q: %s", line, err)
+		}
+	}
+	if err := gotest.Expect(s.sets).Eq([]int{2, 3}); err != nil {
+		t.Error(err)
+	}
+}
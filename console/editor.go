@@ -0,0 +1,148 @@
+package console
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// editor - a minimal line editor for a terminal in raw mode: backspace, history
+// on the arrow keys and completion of the last word on tab
+type editor struct {
+	reader   *bufio.Reader
+	out      io.Writer
+	prompt   string
+	complete func(line string) []string
+
+	mu      sync.Mutex
+	buf     []rune
+	history []string
+}
+
+func newEditor(in io.Reader, out io.Writer, prompt string, complete func(string) []string) *editor {
+	return &editor{reader: bufio.NewReader(in), out: out, prompt: prompt, complete: complete}
+}
+
+// readLine - ctrl-d on an empty line returns io.EOF, ctrl-c returns "quit"
+func (e *editor) readLine() (string, error) {
+	e.mu.Lock()
+	e.buf = e.buf[:0]
+	position := len(e.history)
+	fmt.Fprint(e.out, e.prompt)
+	e.mu.Unlock()
+
+	for {
+		ch, _, err := e.reader.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch ch {
+		case '\r', '\n':
+			e.mu.Lock()
+			line := string(e.buf)
+			fmt.Fprint(e.out, "\n")
+			if strings.TrimSpace(line) != "" {
+				e.history = append(e.history, line)
+			}
+			e.mu.Unlock()
+			return line, nil
+		case 0x7f, 0x08:
+			e.mu.Lock()
+			if len(e.buf) > 0 {
+				e.buf = e.buf[:len(e.buf)-1]
+				fmt.Fprint(e.out, "\b \b")
+			}
+			e.mu.Unlock()
+		case 0x03:
+			fmt.Fprint(e.out, "^C\n")
+			return "quit", nil
+		case 0x04:
+			e.mu.Lock()
+			empty := len(e.buf) == 0
+			e.mu.Unlock()
+			if empty {
+				fmt.Fprint(e.out, "\n")
+				return "", io.EOF
+			}
+		case '\t':
+			e.completeLine()
+		case 0x1b:
+			// arrow keys are ESC [ A and ESC [ B
+			if next, _, _ := e.reader.ReadRune(); next != '[' {
+				continue
+			}
+			key, _, _ := e.reader.ReadRune()
+			switch {
+			case key == 'A' && position > 0:
+				position--
+				e.replace(e.history[position])
+			case key == 'B' && position < len(e.history)-1:
+				position++
+				e.replace(e.history[position])
+			case key == 'B':
+				position = len(e.history)
+				e.replace("")
+			}
+		default:
+			if unicode.IsPrint(ch) {
+				e.mu.Lock()
+				e.buf = append(e.buf, ch)
+				fmt.Fprint(e.out, string(ch))
+				e.mu.Unlock()
+			}
+		}
+	}
+}
+
+// interrupt - prints a line above the prompt and redraws the input
+func (e *editor) interrupt(line string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	fmt.Fprintf(e.out, "\r\033[K%s\n%s%s", line, e.prompt, string(e.buf))
+}
+
+func (e *editor) replace(line string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.buf = []rune(line)
+	fmt.Fprintf(e.out, "\r\033[K%s%s", e.prompt, line)
+}
+
+// completeLine - completes the last word, several candidates are extended to their
+// common prefix or listed
+func (e *editor) completeLine() {
+	e.mu.Lock()
+	line := string(e.buf)
+	e.mu.Unlock()
+
+	candidates := e.complete(line)
+	if len(candidates) == 0 {
+		return
+	}
+	head := line[:strings.LastIndexAny(line, " ")+1]
+	word := line[len(head):]
+	completion := commonPrefix(candidates)
+	if len(candidates) == 1 {
+		completion += " "
+	}
+	if len(completion) > len(word) {
+		e.replace(head + completion)
+		return
+	}
+	sort.Strings(candidates)
+	e.interrupt(strings.Join(candidates, "  "))
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
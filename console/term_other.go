@@ -0,0 +1,10 @@
+//go:build !linux
+
+package console
+
+import "os"
+
+// makeRaw - line editing is only supported on linux, other systems read plain lines
+func makeRaw(*os.File) (restore func(), ok bool) {
+	return nil, false
+}
@@ -9,6 +9,8 @@ const (
 	FuncWriteSingleRegister    = uint8(6)
 	FuncWriteMultipleCoils     = uint8(15)
 	FuncWriteMultipleRegisters = uint8(16)
+	FuncMaskWriteRegister      = uint8(22)
+	FuncReadWriteRegisters     = uint8(23)
 
 	ErrorFunction = uint8(1)
 	ErrorAddress  = uint8(2)
@@ -17,6 +19,10 @@ const (
 	ErrorDelay    = uint8(5)
 	ErrorWait     = uint8(6)
 	ErrorFail     = uint8(7)
+	// ErrorGatewayPath - the gateway has no route to the unit
+	ErrorGatewayPath = uint8(0x0a)
+	// ErrorGatewayTarget - the unit behind the gateway did not respond
+	ErrorGatewayTarget = uint8(0x0b)
 )
 
 type DataModel interface {
@@ -0,0 +1,140 @@
+package mbslave
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// DataType - how a value is packed into one or more 16-bit registers.
+// Multi-register types use big-endian word order, see SwapWords for the reverse.
+type DataType int
+
+const (
+	TypeUint16 = DataType(iota)
+	TypeInt16
+	TypeUint32
+	TypeInt32
+	TypeFloat32
+	TypeBool
+)
+
+var dataTypeNames = map[DataType]string{
+	TypeUint16:  "uint16",
+	TypeInt16:   "int16",
+	TypeUint32:  "uint32",
+	TypeInt32:   "int32",
+	TypeFloat32: "float32",
+	TypeBool:    "bool",
+}
+
+func (dt DataType) String() string {
+	if name, ok := dataTypeNames[dt]; ok {
+		return name
+	}
+	return fmt.Sprintf("type(%d)", int(dt))
+}
+
+// Words - number of registers occupied by the type
+func (dt DataType) Words() int {
+	switch dt {
+	case TypeUint32, TypeInt32, TypeFloat32:
+		return 2
+	}
+	return 1
+}
+
+// Encode - converts the value to registers, integers are rounded and saturated
+func (dt DataType) Encode(value float64) []uint16 {
+	switch dt {
+	case TypeInt16:
+		return []uint16{uint16(int16(clamp(value, math.MinInt16, math.MaxInt16)))}
+	case TypeUint32:
+		v := uint32(clamp(value, 0, math.MaxUint32))
+		return []uint16{uint16(v >> 16), uint16(v)}
+	case TypeInt32:
+		v := uint32(int32(clamp(value, math.MinInt32, math.MaxInt32)))
+		return []uint16{uint16(v >> 16), uint16(v)}
+	case TypeFloat32:
+		v := math.Float32bits(float32(value))
+		return []uint16{uint16(v >> 16), uint16(v)}
+	case TypeBool:
+		if value != 0 {
+			return []uint16{1}
+		}
+		return []uint16{0}
+	}
+	return []uint16{uint16(clamp(value, 0, math.MaxUint16))}
+}
+
+// Decode - the reverse of Encode, words must hold at least Words() registers
+func (dt DataType) Decode(words []uint16) float64 {
+	if len(words) < dt.Words() {
+		return 0
+	}
+	switch dt {
+	case TypeInt16:
+		return float64(int16(words[0]))
+	case TypeUint32:
+		return float64(uint32(words[0])<<16 | uint32(words[1]))
+	case TypeInt32:
+		return float64(int32(uint32(words[0])<<16 | uint32(words[1])))
+	case TypeFloat32:
+		return float64(math.Float32frombits(uint32(words[0])<<16 | uint32(words[1])))
+	case TypeBool:
+		if words[0] != 0 {
+			return 1
+		}
+		return 0
+	}
+	return float64(words[0])
+}
+
+// ParseDataType - accepts the names returned by DataType.String
+func ParseDataType(name string) (DataType, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for dt, n := range dataTypeNames {
+		if n == name {
+			return dt, nil
+		}
+	}
+	switch name {
+	case "", "word", "u16":
+		return TypeUint16, nil
+	case "float", "real", "f32":
+		return TypeFloat32, nil
+	}
+	return 0, fmt.Errorf("unknown data type %q", name)
+}
+
+// SwapWords - reverses the word order in place for little-endian word devices
+func SwapWords(words []uint16) []uint16 {
+	for i, j := 0, len(words)-1; i < j; i, j = i+1, j-1 {
+		words[i], words[j] = words[j], words[i]
+	}
+	return words
+}
+
+func clamp(value, min, max float64) float64 {
+	value = math.Round(value)
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
+
+func (dt DataType) MarshalText() ([]byte, error) {
+	return []byte(dt.String()), nil
+}
+
+func (dt *DataType) UnmarshalText(text []byte) error {
+	t, err := ParseDataType(string(text))
+	if err != nil {
+		return err
+	}
+	*dt = t
+	return nil
+}
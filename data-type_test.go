@@ -0,0 +1,36 @@
+package mbslave
+
+import (
+	"github.com/schnack/gotest"
+	"testing"
+)
+
+func TestDataType_Encode(t *testing.T) {
+	if err := gotest.Expect(TypeInt16.Encode(-2)).Eq([]uint16{0xfffe}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(TypeUint16.Encode(70000)).Eq([]uint16{0xffff}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(TypeUint32.Encode(0x12345678)).Eq([]uint16{0x1234, 0x5678}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(TypeFloat32.Encode(1.5)).Eq([]uint16{0x3fc0, 0x0000}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(SwapWords(TypeInt32.Encode(-1))).Eq([]uint16{0xffff, 0xffff}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDataType_Decode(t *testing.T) {
+	if err := gotest.Expect(TypeInt32.Decode([]uint16{0xffff, 0xfffe})).Eq(-2.0); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(TypeFloat32.Decode([]uint16{0x3fc0, 0x0000})).Eq(1.5); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(TypeUint32.Decode([]uint16{1})).Eq(0.0); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,9 +1,10 @@
 package mbslave
 
 import (
+	"context"
 	"encoding/binary"
-	"fmt"
 	"sync"
+	"time"
 )
 
 type Event int
@@ -13,12 +14,21 @@ const (
 	EventWrite
 )
 
+// Change - a value written to the data model, bits are 0 or 1
+type Change struct {
+	Table   Table
+	Address uint16
+	Value   uint16
+	// Old - the value before the write
+	Old uint16
+	// Source - the request that wrote the value, nil for calls of the Set methods
+	Source *RequestInfo
+}
+
 type DefaultDataModel struct {
 	BaseDataModel
-	discreteInputs   []bool
-	coils            []bool
-	inputRegisters   []uint16
-	holdingRegisters []uint16
+	storage Storage
+	unwatch func()
 
 	callbackDiscreteInputs   []func(event Event, addr uint16, value bool)
 	callbackCoils            []func(event Event, addr uint16, value bool)
@@ -29,31 +39,47 @@ type DefaultDataModel struct {
 	muCoils            sync.RWMutex
 	muInputRegisters   sync.RWMutex
 	muHoldingRegisters sync.RWMutex
+
+	muWatchers  sync.RWMutex
+	watchers    map[int]func(Change)
+	nextWatcher int
 }
 
+// NewDefaultDataModel - the tables are slices of the sizes of the config
 func NewDefaultDataModel(config *Config) *DefaultDataModel {
+	return NewStorageDataModel(config, NewSliceStorage(config))
+}
+
+// NewStorageDataModel - the tables are kept by the storage, the sizes of the config
+// are not used. The values others write to the storage reach the callbacks and
+// watchers for as long as the storage is watched, see Storage.Watch.
+func NewStorageDataModel(config *Config, storage Storage) *DefaultDataModel {
 	dm := &DefaultDataModel{
-		discreteInputs:           make([]bool, config.SizeDiscreteInputs),
-		coils:                    make([]bool, config.SizeCoils),
-		inputRegisters:           make([]uint16, config.SizeInputRegisters),
-		holdingRegisters:         make([]uint16, config.SizeHoldingRegisters),
-		callbackDiscreteInputs:   make([]func(event Event, addr uint16, value bool), config.SizeDiscreteInputs),
-		callbackCoils:            make([]func(event Event, addr uint16, value bool), config.SizeCoils),
-		callbackInputRegisters:   make([]func(event Event, addr uint16, value uint16), config.SizeInputRegisters),
-		callbackHoldingRegisters: make([]func(event Event, addr uint16, value uint16), config.SizeHoldingRegisters),
+		storage:                  storage,
+		callbackDiscreteInputs:   make([]func(event Event, addr uint16, value bool), storage.Length(TableDiscreteInputs)),
+		callbackCoils:            make([]func(event Event, addr uint16, value bool), storage.Length(TableCoils)),
+		callbackInputRegisters:   make([]func(event Event, addr uint16, value uint16), storage.Length(TableInputRegisters)),
+		callbackHoldingRegisters: make([]func(event Event, addr uint16, value uint16), storage.Length(TableHoldingRegisters)),
 	}
 	dm.SetSlaveId(config.SlaveId)
 	dm.SetFunction(FuncReadCoils, dm.ReadCoils)
 	dm.SetFunction(FuncReadDiscreteInputs, dm.ReadDiscreteInputs)
 	dm.SetFunction(FuncReadHoldingRegisters, dm.ReadHoldingRegisters)
 	dm.SetFunction(FuncReadInputRegisters, dm.ReadInputRegisters)
-	dm.SetFunction(FuncWriteSingleCoil, dm.WriteSingleCoil)
-	dm.SetFunction(FuncWriteSingleRegister, dm.WriteSingleRegister)
-	dm.SetFunction(FuncWriteMultipleCoils, dm.WriteMultipleCoils)
-	dm.SetFunction(FuncWriteMultipleRegisters, dm.WriteMultipleRegisters)
+	dm.SetContextFunction(FuncWriteSingleCoil, dm.writeSingleCoil)
+	dm.SetContextFunction(FuncWriteSingleRegister, dm.writeSingleRegister)
+	dm.SetContextFunction(FuncWriteMultipleCoils, dm.writeMultipleCoils)
+	dm.SetContextFunction(FuncWriteMultipleRegisters, dm.writeMultipleRegisters)
+	dm.unwatch = storage.Watch(dm.external)
 	return dm
 }
 
+// Close - stops watching the storage, the storage itself is closed by its owner
+func (dm *DefaultDataModel) Close() error {
+	dm.unwatch()
+	return nil
+}
+
 func (dm *DefaultDataModel) SetCallbackDiscreteInputs(addr uint16, f func(event Event, addr uint16, value bool)) {
 	dm.callbackDiscreteInputs[addr] = f
 }
@@ -70,120 +96,215 @@ func (dm *DefaultDataModel) SetCallbackHoldingRegisters(addr uint16, f func(even
 	dm.callbackHoldingRegisters[addr] = f
 }
 
+// Watch - f receives every value written to the data model until cancel is called.
+// It is called while the table is locked, so it must not block nor write to the data model.
+func (dm *DefaultDataModel) Watch(f func(Change)) (cancel func()) {
+	dm.muWatchers.Lock()
+	defer dm.muWatchers.Unlock()
+	if dm.watchers == nil {
+		dm.watchers = make(map[int]func(Change))
+	}
+	id := dm.nextWatcher
+	dm.nextWatcher++
+	dm.watchers[id] = f
+	return func() {
+		dm.muWatchers.Lock()
+		delete(dm.watchers, id)
+		dm.muWatchers.Unlock()
+	}
+}
+
+func (dm *DefaultDataModel) notify(ctx context.Context, change Change) {
+	dm.muWatchers.RLock()
+	defer dm.muWatchers.RUnlock()
+	if len(dm.watchers) == 0 {
+		return
+	}
+	_, span := dm.tracer().Start(ctx, "modbus.watch", time.Time{},
+		Field{Key: "table", Value: change.Table.String()},
+		FieldAddress(change.Address),
+		Field{Key: "watchers", Value: len(dm.watchers)},
+	)
+	defer span.End()
+	for _, f := range dm.watchers {
+		f(change)
+	}
+}
+
+// external - a value others wrote to the storage
+func (dm *DefaultDataModel) external(change Change) {
+	mu := dm.mutex(change.Table)
+	mu.Lock()
+	defer mu.Unlock()
+	dm.callback(EventWrite, change.Table, change.Address, change.Value)
+	dm.notify(context.Background(), change)
+}
+
+func (dm *DefaultDataModel) mutex(table Table) *sync.RWMutex {
+	switch table {
+	case TableDiscreteInputs:
+		return &dm.muDiscreteInputs
+	case TableCoils:
+		return &dm.muCoils
+	case TableInputRegisters:
+		return &dm.muInputRegisters
+	}
+	return &dm.muHoldingRegisters
+}
+
+// callback - runs the callback of the address in its own goroutine
+func (dm *DefaultDataModel) callback(event Event, table Table, address uint16, value uint16) {
+	a := int(address)
+	switch table {
+	case TableDiscreteInputs:
+		if len(dm.callbackDiscreteInputs) > a && dm.callbackDiscreteInputs[a] != nil {
+			go dm.callbackDiscreteInputs[a](event, address, value != 0)
+		}
+	case TableCoils:
+		if len(dm.callbackCoils) > a && dm.callbackCoils[a] != nil {
+			go dm.callbackCoils[a](event, address, value != 0)
+		}
+	case TableInputRegisters:
+		if len(dm.callbackInputRegisters) > a && dm.callbackInputRegisters[a] != nil {
+			go dm.callbackInputRegisters[a](event, address, value)
+		}
+	case TableHoldingRegisters:
+		if len(dm.callbackHoldingRegisters) > a && dm.callbackHoldingRegisters[a] != nil {
+			go dm.callbackHoldingRegisters[a](event, address, value)
+		}
+	}
+}
+
+// get - a value of the table, zero for missing addresses and failed reads
+func (dm *DefaultDataModel) get(table Table, address uint16) uint16 {
+	mu := dm.mutex(table)
+	mu.RLock()
+	defer mu.RUnlock()
+	values := []uint16{0}
+	if err := dm.storage.Get(table, address, values); err != nil {
+		return 0
+	}
+	dm.callback(EventRead, table, address, values[0])
+	return values[0]
+}
+
+// set - writes the values from address on under one lock of the table,
+// the RequestInfo of ctx is the source of the changes
+func (dm *DefaultDataModel) set(ctx context.Context, table Table, address uint16, values []uint16) error {
+	mu := dm.mutex(table)
+	mu.Lock()
+	defer mu.Unlock()
+	old, err := dm.storage.Set(table, address, values)
+	if err != nil {
+		return err
+	}
+	source := requestSource(ctx)
+	for i, value := range values {
+		a := address + uint16(i)
+		dm.callback(EventWrite, table, a, value)
+		dm.notify(ctx, Change{Table: table, Address: a, Value: value, Old: old[i], Source: source})
+	}
+	return nil
+}
+
+// Length - size of the table
+func (dm *DefaultDataModel) Length(table Table) int {
+	return dm.storage.Length(table)
+}
+
+// Get - value of a register or bit of the table, bits are 0 or 1
+func (dm *DefaultDataModel) Get(table Table, address uint16) uint16 {
+	return dm.get(table, address)
+}
+
+// Peek - reads len(values) values from address on at once without the read callbacks,
+// for observers like a bridge that are no Modbus reads
+func (dm *DefaultDataModel) Peek(table Table, address uint16, values []uint16) error {
+	mu := dm.mutex(table)
+	mu.RLock()
+	defer mu.RUnlock()
+	return dm.storage.Get(table, address, values)
+}
+
+// Set - writes a register or bit of the table, any non-zero value sets a bit
+func (dm *DefaultDataModel) Set(table Table, address uint16, value uint16) error {
+	return dm.SetRange(table, address, []uint16{value})
+}
+
+// SetRange - writes the values from address on at once, a reader sees either
+// none or all of them. Any non-zero value sets a bit.
+func (dm *DefaultDataModel) SetRange(table Table, address uint16, values []uint16) error {
+	if table.IsBit() {
+		bits := make([]uint16, len(values))
+		for i, value := range values {
+			bits[i] = bitValue(value != 0)
+		}
+		values = bits
+	}
+	return dm.set(context.Background(), table, address, values)
+}
+
+func bitValue(value bool) uint16 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
 func (dm *DefaultDataModel) LengthDiscreteInputs() int {
-	return len(dm.discreteInputs)
+	return dm.storage.Length(TableDiscreteInputs)
 }
 
 func (dm *DefaultDataModel) LengthCoils() int {
-	return len(dm.coils)
+	return dm.storage.Length(TableCoils)
 }
 
 func (dm *DefaultDataModel) LengthInputRegisters() int {
-	return len(dm.inputRegisters)
+	return dm.storage.Length(TableInputRegisters)
 }
 
 func (dm *DefaultDataModel) LengthHoldingRegisters() int {
-	return len(dm.holdingRegisters)
+	return dm.storage.Length(TableHoldingRegisters)
 }
 
 func (dm *DefaultDataModel) SetDiscreteInputs(address uint16, value bool) error {
-	dm.muDiscreteInputs.Lock()
-	defer dm.muDiscreteInputs.Unlock()
-	if len(dm.discreteInputs) <= int(address) {
-		return fmt.Errorf("there is no register at this address")
-	}
-	dm.discreteInputs[int(address)] = value
-	if len(dm.callbackDiscreteInputs) > int(address) && dm.callbackDiscreteInputs[int(address)] != nil {
-		go dm.callbackDiscreteInputs[int(address)](EventWrite, address, value)
-	}
-	return nil
+	return dm.set(context.Background(), TableDiscreteInputs, address, []uint16{bitValue(value)})
 }
 
 func (dm *DefaultDataModel) SetCoils(address uint16, value bool) error {
-	dm.muCoils.Lock()
-	defer dm.muCoils.Unlock()
-	if len(dm.coils) <= int(address) {
-		return fmt.Errorf("there is no register at this address")
-	}
-	dm.coils[int(address)] = value
-	if len(dm.callbackCoils) > int(address) && dm.callbackCoils[int(address)] != nil {
-		go dm.callbackCoils[int(address)](EventWrite, address, value)
-	}
-	return nil
+	return dm.setCoils(context.Background(), address, value)
+}
+
+func (dm *DefaultDataModel) setCoils(ctx context.Context, address uint16, value bool) error {
+	return dm.set(ctx, TableCoils, address, []uint16{bitValue(value)})
 }
 
 func (dm *DefaultDataModel) SetHoldingRegisters(address uint16, value uint16) error {
-	dm.muHoldingRegisters.Lock()
-	defer dm.muHoldingRegisters.Unlock()
-	if len(dm.holdingRegisters) <= int(address) {
-		return fmt.Errorf("there is no register at this address")
-	}
-	dm.holdingRegisters[int(address)] = value
-	if len(dm.callbackHoldingRegisters) > int(address) && dm.callbackHoldingRegisters[int(address)] != nil {
-		go dm.callbackHoldingRegisters[int(address)](EventWrite, address, value)
-	}
-	return nil
+	return dm.setHoldingRegisters(context.Background(), address, value)
+}
+
+func (dm *DefaultDataModel) setHoldingRegisters(ctx context.Context, address uint16, value uint16) error {
+	return dm.set(ctx, TableHoldingRegisters, address, []uint16{value})
 }
 
 func (dm *DefaultDataModel) SetInputRegisters(address uint16, value uint16) error {
-	dm.muInputRegisters.Lock()
-	defer dm.muInputRegisters.Unlock()
-	if len(dm.inputRegisters) <= int(address) {
-		return fmt.Errorf("there is no register at this address")
-	}
-	dm.inputRegisters[int(address)] = value
-	if len(dm.callbackInputRegisters) > int(address) && dm.callbackInputRegisters[int(address)] != nil {
-		go dm.callbackInputRegisters[int(address)](EventWrite, address, value)
-	}
-	return nil
+	return dm.set(context.Background(), TableInputRegisters, address, []uint16{value})
 }
 
 func (dm *DefaultDataModel) GetDiscreteInputs(address uint16) bool {
-	dm.muDiscreteInputs.RLock()
-	defer dm.muDiscreteInputs.RUnlock()
-	if len(dm.discreteInputs) <= int(address) {
-		return false
-	}
-	if len(dm.callbackDiscreteInputs) > int(address) && dm.callbackDiscreteInputs[int(address)] != nil {
-		go dm.callbackDiscreteInputs[int(address)](EventRead, address, dm.discreteInputs[int(address)])
-	}
-	return dm.discreteInputs[int(address)]
+	return dm.get(TableDiscreteInputs, address) != 0
 }
 
 func (dm *DefaultDataModel) GetCoils(address uint16) bool {
-	dm.muCoils.RLock()
-	defer dm.muCoils.RUnlock()
-	if len(dm.coils) <= int(address) {
-		return false
-	}
-	if len(dm.callbackCoils) > int(address) && dm.callbackCoils[int(address)] != nil {
-		go dm.callbackCoils[int(address)](EventRead, address, dm.coils[int(address)])
-	}
-	return dm.coils[int(address)]
+	return dm.get(TableCoils, address) != 0
 }
 
 func (dm *DefaultDataModel) GetHoldingRegisters(address uint16) uint16 {
-	dm.muHoldingRegisters.RLock()
-	defer dm.muHoldingRegisters.RUnlock()
-	if len(dm.holdingRegisters) <= int(address) {
-		return 0
-	}
-	if len(dm.callbackHoldingRegisters) > int(address) && dm.callbackHoldingRegisters[int(address)] != nil {
-		go dm.callbackHoldingRegisters[int(address)](EventRead, address, dm.holdingRegisters[int(address)])
-	}
-	return dm.holdingRegisters[int(address)]
+	return dm.get(TableHoldingRegisters, address)
 }
 
 func (dm *DefaultDataModel) GetInputRegisters(address uint16) uint16 {
-	dm.muInputRegisters.RLock()
-	defer dm.muInputRegisters.RUnlock()
-	if len(dm.inputRegisters) <= int(address) {
-		return 0
-	}
-	if len(dm.callbackInputRegisters) > int(address) && dm.callbackInputRegisters[int(address)] != nil {
-		go dm.callbackInputRegisters[int(address)](EventRead, address, dm.inputRegisters[int(address)])
-	}
-	return dm.inputRegisters[int(address)]
+	return dm.get(TableInputRegisters, address)
 }
 
 func (dm *DefaultDataModel) ReadCoils(request Request, resp Response) {
@@ -264,7 +385,11 @@ func (dm *DefaultDataModel) ReadInputRegisters(request Request, resp Response) {
 }
 
 func (dm *DefaultDataModel) WriteSingleCoil(request Request, resp Response) {
-	if err := dm.SetCoils(request.GetAddress(), binary.BigEndian.Uint16(request.GetData()) != 0); err != nil {
+	dm.writeSingleCoil(context.Background(), request, resp)
+}
+
+func (dm *DefaultDataModel) writeSingleCoil(ctx context.Context, request Request, resp Response) {
+	if err := dm.setCoils(ctx, request.GetAddress(), binary.BigEndian.Uint16(request.GetData()) != 0); err != nil {
 		resp.SetError(ErrorAddress)
 		return
 	}
@@ -272,7 +397,11 @@ func (dm *DefaultDataModel) WriteSingleCoil(request Request, resp Response) {
 }
 
 func (dm *DefaultDataModel) WriteSingleRegister(request Request, resp Response) {
-	if err := dm.SetHoldingRegisters(request.GetAddress(), binary.BigEndian.Uint16(request.GetData())); err != nil {
+	dm.writeSingleRegister(context.Background(), request, resp)
+}
+
+func (dm *DefaultDataModel) writeSingleRegister(ctx context.Context, request Request, resp Response) {
+	if err := dm.setHoldingRegisters(ctx, request.GetAddress(), binary.BigEndian.Uint16(request.GetData())); err != nil {
 		resp.SetError(ErrorAddress)
 		return
 	}
@@ -280,8 +409,12 @@ func (dm *DefaultDataModel) WriteSingleRegister(request Request, resp Response)
 }
 
 func (dm *DefaultDataModel) WriteMultipleCoils(request Request, resp Response) {
+	dm.writeMultipleCoils(context.Background(), request, resp)
+}
+
+func (dm *DefaultDataModel) writeMultipleCoils(ctx context.Context, request Request, resp Response) {
 	endAddress := uint32(request.GetAddress()) + uint32(request.GetQuantity())
-	if endAddress >= uint32(len(dm.coils)) {
+	if endAddress >= uint32(dm.LengthCoils()) {
 		resp.SetError(ErrorAddress)
 		return
 	}
@@ -292,7 +425,7 @@ func (dm *DefaultDataModel) WriteMultipleCoils(request Request, resp Response) {
 			if targetAddress > int(endAddress) {
 				break
 			}
-			if err := dm.SetCoils(uint16(targetAddress), value>>ii&0x01 == 1); err != nil {
+			if err := dm.setCoils(ctx, uint16(targetAddress), value>>ii&0x01 == 1); err != nil {
 				resp.SetError(ErrorAddress)
 				return
 			}
@@ -302,8 +435,12 @@ func (dm *DefaultDataModel) WriteMultipleCoils(request Request, resp Response) {
 }
 
 func (dm *DefaultDataModel) WriteMultipleRegisters(request Request, resp Response) {
+	dm.writeMultipleRegisters(context.Background(), request, resp)
+}
+
+func (dm *DefaultDataModel) writeMultipleRegisters(ctx context.Context, request Request, resp Response) {
 	endAddress := uint32(request.GetAddress()) + uint32(request.GetQuantity())
-	if endAddress >= uint32(len(dm.coils)) {
+	if endAddress >= uint32(dm.LengthCoils()) {
 		resp.SetError(ErrorAddress)
 		return
 	}
@@ -313,10 +450,18 @@ func (dm *DefaultDataModel) WriteMultipleRegisters(request Request, resp Respons
 	}
 
 	for i := 0; i <= int(request.GetQuantity()); i++ {
-		if err := dm.SetHoldingRegisters(uint16(int(request.GetAddress())+i), binary.BigEndian.Uint16(request.GetData()[i*2:(i+1)*2])); err != nil {
+		if err := dm.setHoldingRegisters(ctx, uint16(int(request.GetAddress())+i), binary.BigEndian.Uint16(request.GetData()[i*2:(i+1)*2])); err != nil {
 			resp.SetError(ErrorAddress)
 			return
 		}
 	}
 	resp.SetMultiWrite(request.GetAddress(), request.GetQuantity())
 }
+
+// requestSource - the info of the transport for Change.Source, nil without it
+func requestSource(ctx context.Context) *RequestInfo {
+	if info, ok := RequestInfoFrom(ctx); ok {
+		return &info
+	}
+	return nil
+}
@@ -20,19 +20,19 @@ func TestNewDefaultDataModel(t *testing.T) {
 		t.Error(err)
 	}
 
-	if err := gotest.Expect(len(ddm.discreteInputs)).Eq(math.MaxUint16); err != nil {
+	if err := gotest.Expect(ddm.LengthDiscreteInputs()).Eq(math.MaxUint16); err != nil {
 		t.Error(err)
 	}
 
-	if err := gotest.Expect(len(ddm.coils)).Eq(math.MaxUint16); err != nil {
+	if err := gotest.Expect(ddm.LengthCoils()).Eq(math.MaxUint16); err != nil {
 		t.Error(err)
 	}
 
-	if err := gotest.Expect(len(ddm.inputRegisters)).Eq(math.MaxUint16); err != nil {
+	if err := gotest.Expect(ddm.LengthInputRegisters()).Eq(math.MaxUint16); err != nil {
 		t.Error(err)
 	}
 
-	if err := gotest.Expect(len(ddm.holdingRegisters)).Eq(math.MaxUint16); err != nil {
+	if err := gotest.Expect(ddm.LengthHoldingRegisters()).Eq(math.MaxUint16); err != nil {
 		t.Error(err)
 	}
 
@@ -441,8 +441,8 @@ func TestDefaultDataModel_ReadCoils(t *testing.T) {
 		SizeInputRegisters:   math.MaxUint16,
 		SizeHoldingRegisters: math.MaxUint16,
 	})
-	ddm.coils[0] = true
-	ddm.coils[2] = true
+	_ = ddm.SetCoils(0, true)
+	_ = ddm.SetCoils(2, true)
 	request := NewRtuRequest([]byte{0x01, 0x01, 0x00, 0x00, 0x00, 0x08, 0x3d, 0xcc})
 
 	if err := gotest.Expect(request.Parse()).NotError(); err != nil {
@@ -465,8 +465,8 @@ func TestDefaultDataModel_ReadDiscreteInputs(t *testing.T) {
 		SizeInputRegisters:   math.MaxUint16,
 		SizeHoldingRegisters: math.MaxUint16,
 	})
-	ddm.discreteInputs[0] = true
-	ddm.discreteInputs[1] = true
+	_ = ddm.SetDiscreteInputs(0, true)
+	_ = ddm.SetDiscreteInputs(1, true)
 	request := NewRtuRequest([]byte{0x01, 0x02, 0x00, 0x00, 0x00, 0x08, 0x79, 0xcc})
 
 	if err := gotest.Expect(request.Parse()).NotError(); err != nil {
@@ -489,8 +489,8 @@ func TestDefaultDataModel_ReadHoldingRegisters(t *testing.T) {
 		SizeInputRegisters:   math.MaxUint16,
 		SizeHoldingRegisters: math.MaxUint16,
 	})
-	ddm.holdingRegisters[0] = 0x0001
-	ddm.holdingRegisters[1] = 0x0002
+	_ = ddm.SetHoldingRegisters(0, 0x0001)
+	_ = ddm.SetHoldingRegisters(1, 0x0002)
 	request := NewRtuRequest([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x02, 0xc4, 0x0b})
 
 	if err := gotest.Expect(request.Parse()).NotError(); err != nil {
@@ -513,8 +513,8 @@ func TestDefaultDataModel_ReadInputRegisters(t *testing.T) {
 		SizeInputRegisters:   math.MaxUint16,
 		SizeHoldingRegisters: math.MaxUint16,
 	})
-	ddm.inputRegisters[0] = 0x0003
-	ddm.inputRegisters[1] = 0x0004
+	_ = ddm.SetInputRegisters(0, 0x0003)
+	_ = ddm.SetInputRegisters(1, 0x0004)
 	request := NewRtuRequest([]byte{0x01, 0x04, 0x00, 0x00, 0x00, 0x02, 0x71, 0xcb})
 
 	if err := gotest.Expect(request.Parse()).NotError(); err != nil {
@@ -546,7 +546,7 @@ func TestDefaultDataModel_WriteSingleCoil(t *testing.T) {
 
 	ddm.WriteSingleCoil(request, response)
 
-	if err := gotest.Expect(ddm.coils[0]).Eq(true); err != nil {
+	if err := gotest.Expect(ddm.GetCoils(0)).Eq(true); err != nil {
 		t.Error(err)
 	}
 }
@@ -568,7 +568,7 @@ func TestDefaultDataModel_WriteSingleRegister(t *testing.T) {
 
 	ddm.WriteSingleRegister(request, response)
 
-	if err := gotest.Expect(ddm.holdingRegisters[0]).Eq(uint16(258)); err != nil {
+	if err := gotest.Expect(ddm.GetHoldingRegisters(0)).Eq(uint16(258)); err != nil {
 		t.Error(err)
 	}
 }
@@ -590,10 +590,10 @@ func TestDefaultDataModel_WriteMultipleCoils(t *testing.T) {
 
 	ddm.WriteMultipleCoils(request, response)
 
-	if err := gotest.Expect(ddm.coils[0]).Eq(true); err != nil {
+	if err := gotest.Expect(ddm.GetCoils(0)).Eq(true); err != nil {
 		t.Error(err)
 	}
-	if err := gotest.Expect(ddm.coils[1]).Eq(true); err != nil {
+	if err := gotest.Expect(ddm.GetCoils(1)).Eq(true); err != nil {
 		t.Error(err)
 	}
 }
@@ -615,10 +615,10 @@ func TestDefaultDataModel_WriteMultipleRegisters(t *testing.T) {
 
 	ddm.WriteMultipleRegisters(request, response)
 
-	if err := gotest.Expect(ddm.holdingRegisters[0]).Eq(uint16(1)); err != nil {
+	if err := gotest.Expect(ddm.GetHoldingRegisters(0)).Eq(uint16(1)); err != nil {
 		t.Error(err)
 	}
-	if err := gotest.Expect(ddm.holdingRegisters[1]).Eq(uint16(2)); err != nil {
+	if err := gotest.Expect(ddm.GetHoldingRegisters(1)).Eq(uint16(2)); err != nil {
 		t.Error(err)
 	}
 }
@@ -640,7 +640,7 @@ func TestDefaultDataModel_Handler(t *testing.T) {
 
 	ddm.Handler(request, response)
 
-	if err := gotest.Expect(ddm.coils[0]).Eq(true); err != nil {
+	if err := gotest.Expect(ddm.GetCoils(0)).Eq(true); err != nil {
 		t.Error(err)
 	}
 
@@ -671,3 +671,65 @@ func TestDefaultDataModel_HandlerError(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestDefaultDataModel_Watch(t *testing.T) {
+	dm := NewDefaultDataModel(&Config{SizeCoils: 2, SizeHoldingRegisters: 2})
+	var changes []Change
+	cancel := dm.Watch(func(change Change) { changes = append(changes, change) })
+	_ = dm.SetCoils(1, true)
+	_ = dm.SetHoldingRegisters(0, 7)
+	_ = dm.SetHoldingRegisters(5, 7)
+	cancel()
+	_ = dm.SetHoldingRegisters(1, 8)
+
+	if err := gotest.Expect(changes).Eq([]Change{
+		{Table: TableCoils, Address: 1, Value: 1},
+		{Table: TableHoldingRegisters, Address: 0, Value: 7},
+	}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDefaultDataModel_SetRange(t *testing.T) {
+	dm := NewDefaultDataModel(&Config{SizeCoils: 2, SizeHoldingRegisters: 4})
+	var changes []Change
+	dm.Watch(func(change Change) { changes = append(changes, change) })
+	if err := gotest.Expect(dm.SetRange(TableHoldingRegisters, 1, []uint16{7, 8})).NotError(); err != nil {
+		t.Error(err)
+	}
+	_ = dm.SetRange(TableCoils, 0, []uint16{5, 0})
+	if err := dm.SetRange(TableHoldingRegisters, 3, []uint16{1, 2}); err == nil {
+		t.Error("a range past the table was written")
+	}
+
+	if err := gotest.Expect(changes).Eq([]Change{
+		{Table: TableHoldingRegisters, Address: 1, Value: 7},
+		{Table: TableHoldingRegisters, Address: 2, Value: 8},
+		{Table: TableCoils, Address: 0, Value: 1},
+		{Table: TableCoils, Address: 1, Value: 0},
+	}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(dm.GetHoldingRegisters(3)).Eq(uint16(0)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDefaultDataModel_Peek(t *testing.T) {
+	dm := NewDefaultDataModel(&Config{SizeHoldingRegisters: 4})
+	_ = dm.SetRange(TableHoldingRegisters, 1, []uint16{7, 8})
+	dm.SetCallbackHoldingRegisters(1, func(e Event, a uint16, v uint16) {
+		t.Errorf("the callback of %d was called", a)
+	})
+
+	values := make([]uint16, 2)
+	if err := gotest.Expect(dm.Peek(TableHoldingRegisters, 1, values)).NotError(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(values).Eq([]uint16{7, 8}); err != nil {
+		t.Error(err)
+	}
+	if err := dm.Peek(TableHoldingRegisters, 3, values); err == nil {
+		t.Error("a range past the table was read")
+	}
+}
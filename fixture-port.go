@@ -3,9 +3,18 @@ package mbslave
 import (
 	"bytes"
 	"go.bug.st/serial"
+	"io"
+	"strings"
 )
 
+// PtyPrefix - a Port starting with it opens a pseudo-terminal, the rest is the
+// path of the symlink for the other end, e.g. "pty:/tmp/ttyMB0"
+const PtyPrefix = "pty:"
+
 var OpenSerialPort = func(config *Config) (serial.Port, error) {
+	if link, ok := strings.CutPrefix(config.Port, PtyPrefix); ok {
+		return openPtyPort(link, config)
+	}
 	return serial.Open(config.Port, &serial.Mode{
 		BaudRate: config.BaudRate,
 		DataBits: config.DataBits,
@@ -24,6 +33,8 @@ type inoutSerialPort struct {
 	Out    map[string]*bytes.Buffer
 	Error  map[string]error
 	Closed map[string]bool
+	Rts    map[string][]bool
+	Dtr    map[string][]bool
 }
 
 func (i *inoutSerialPort) Load() {
@@ -32,6 +43,8 @@ func (i *inoutSerialPort) Load() {
 	i.Out = make(map[string]*bytes.Buffer)
 	i.Error = make(map[string]error)
 	i.Closed = make(map[string]bool)
+	i.Rts = make(map[string][]bool)
+	i.Dtr = make(map[string][]bool)
 
 	OpenSerialPort = func(config *Config) (serial.Port, error) {
 		i.Config[config.Port] = config
@@ -90,13 +103,64 @@ func (f *fixtureSerialPort) ResetOutputBuffer() error {
 }
 
 func (f *fixtureSerialPort) SetDTR(dtr bool) error {
+	f.fixture.Dtr[f.address] = append(f.fixture.Dtr[f.address], dtr)
 	return nil
 }
 
 func (f *fixtureSerialPort) SetRTS(rts bool) error {
+	f.fixture.Rts[f.address] = append(f.fixture.Rts[f.address], rts)
 	return nil
 }
 
 func (f *fixtureSerialPort) GetModemStatusBits() (*serial.ModemStatusBits, error) {
 	return &serial.ModemStatusBits{}, nil
 }
+
+// NewPipeSerialPorts - two connected in-memory serial ports, what is written to one is read from the other
+func NewPipeSerialPorts() (serial.Port, serial.Port) {
+	ar, bw := io.Pipe()
+	br, aw := io.Pipe()
+	return &pipeSerialPort{r: ar, w: aw}, &pipeSerialPort{r: br, w: bw}
+}
+
+type pipeSerialPort struct {
+	r *io.PipeReader
+	w *io.PipeWriter
+}
+
+func (p *pipeSerialPort) Read(b []byte) (int, error) {
+	return p.r.Read(b)
+}
+
+func (p *pipeSerialPort) Write(b []byte) (int, error) {
+	return p.w.Write(b)
+}
+
+func (p *pipeSerialPort) Close() error {
+	_ = p.r.Close()
+	return p.w.Close()
+}
+
+func (p *pipeSerialPort) SetMode(mode *serial.Mode) error {
+	return nil
+}
+
+func (p *pipeSerialPort) ResetInputBuffer() error {
+	return nil
+}
+
+func (p *pipeSerialPort) ResetOutputBuffer() error {
+	return nil
+}
+
+func (p *pipeSerialPort) SetDTR(dtr bool) error {
+	return nil
+}
+
+func (p *pipeSerialPort) SetRTS(rts bool) error {
+	return nil
+}
+
+func (p *pipeSerialPort) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{}, nil
+}
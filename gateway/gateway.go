@@ -0,0 +1,152 @@
+// Package gateway routes requests by unit id to local data models or to
+// devices on a serial bus reached through an RTU master.
+package gateway
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/schnack/mbslave"
+	"github.com/schnack/mbslave/client"
+)
+
+// Gateway - implements mbslave.DataModel, so local and forwarded units
+// are served by one Server
+type Gateway struct {
+	// Timeout for a forwarded request, the client timeout is used when zero
+	Timeout time.Duration
+
+	mu     sync.RWMutex
+	local  map[uint8]mbslave.DataModel
+	remote map[uint8]*client.Client
+}
+
+func NewGateway() *Gateway {
+	return &Gateway{
+		local:  make(map[uint8]mbslave.DataModel),
+		remote: make(map[uint8]*client.Client),
+	}
+}
+
+// AddLocal - the unit is served by the data model
+func (g *Gateway) AddLocal(unit uint8, dataModel mbslave.DataModel) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	dataModel.SetSlaveId(unit)
+	delete(g.remote, unit)
+	g.local[unit] = dataModel
+}
+
+// AddRemote - requests for the unit are forwarded through the master.
+// Units on the same bus share one client, which serializes access to the bus.
+func (g *Gateway) AddRemote(unit uint8, master *client.Client) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.local, unit)
+	g.remote[unit] = master
+}
+
+// Remove - requests for the unit are answered with ErrorGatewayPath
+func (g *Gateway) Remove(unit uint8) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.local, unit)
+	delete(g.remote, unit)
+}
+
+// SetSlaveId - the gateway has no own unit id, see AddLocal
+func (g *Gateway) SetSlaveId(uint8) {}
+
+// SetFunction - registers the function in every local data model
+func (g *Gateway) SetFunction(code uint8, f func(mbslave.Request, mbslave.Response)) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, dm := range g.local {
+		dm.SetFunction(code, f)
+	}
+}
+
+func (g *Gateway) Handler(req mbslave.Request, resp mbslave.Response) {
+	g.HandleContext(context.Background(), req, resp)
+}
+
+// HandleContext - the context reaches the local data models and cancels the forwarded request
+func (g *Gateway) HandleContext(ctx context.Context, req mbslave.Request, resp mbslave.Response) {
+	unit := req.GetSlaveId()
+	g.mu.RLock()
+	dm, isLocal := g.local[unit]
+	master, isRemote := g.remote[unit]
+	g.mu.RUnlock()
+
+	switch {
+	case isLocal:
+		mbslave.DataModelHandler(dm)(ctx, req, resp)
+	case isRemote:
+		g.forward(ctx, master, req, resp)
+	default:
+		// nobody waits for the answer of a broadcast
+		if err := req.Parse(); err != nil || unit == 0 || mbslave.IsBroadcast(ctx, req) {
+			resp.Unanswered(true)
+			return
+		}
+		resp.SetError(mbslave.ErrorGatewayPath)
+	}
+}
+
+func (g *Gateway) forward(ctx context.Context, master *client.Client, req mbslave.Request, resp mbslave.Response) {
+	if err := req.Parse(); err != nil {
+		resp.Unanswered(true)
+		return
+	}
+
+	if g.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.Timeout)
+		defer cancel()
+	}
+
+	pdu, err := master.Send(ctx, req.GetSlaveId(), req.GetPDU())
+	var exception *client.ExceptionError
+	switch {
+	case errors.As(err, &exception):
+		resp.SetError(exception.Code)
+	case errors.Is(err, client.ErrTimeout), errors.Is(err, client.ErrInvalidResponse), errors.Is(err, context.DeadlineExceeded):
+		resp.SetError(mbslave.ErrorGatewayTarget)
+	case err != nil:
+		resp.SetError(mbslave.ErrorGatewayPath)
+	case pdu == nil:
+		// broadcast
+		resp.Unanswered(true)
+	default:
+		setPdu(resp, pdu)
+	}
+}
+
+// setPdu - copies the response PDU of the device into the response
+func setPdu(resp mbslave.Response, pdu []byte) {
+	switch pdu[0] {
+	case mbslave.FuncReadCoils, mbslave.FuncReadDiscreteInputs, mbslave.FuncReadHoldingRegisters, mbslave.FuncReadInputRegisters:
+		if len(pdu) > 2 {
+			resp.SetRead(pdu[2:])
+			return
+		}
+	case mbslave.FuncWriteSingleCoil, mbslave.FuncWriteSingleRegister:
+		if len(pdu) == 5 {
+			resp.SetSingleWrite(binary.BigEndian.Uint16(pdu[1:3]), pdu[3:5])
+			return
+		}
+	case mbslave.FuncWriteMultipleCoils, mbslave.FuncWriteMultipleRegisters:
+		if len(pdu) == 5 {
+			resp.SetMultiWrite(binary.BigEndian.Uint16(pdu[1:3]), binary.BigEndian.Uint16(pdu[3:5]))
+			return
+		}
+	default:
+		// other functions are sent as is
+		resp.SetRead(pdu[1:])
+		return
+	}
+	resp.SetError(mbslave.ErrorGatewayTarget)
+}
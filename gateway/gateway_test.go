@@ -0,0 +1,143 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/schnack/gotest"
+	"github.com/schnack/mbslave"
+	"github.com/schnack/mbslave/client"
+	"go.bug.st/serial"
+)
+
+func newConfig(unit uint8) *mbslave.Config {
+	return &mbslave.Config{
+		Port:                 "bus",
+		BaudRate:             115200,
+		Address:              "127.0.0.1:0",
+		SlaveId:              unit,
+		SizeDiscreteInputs:   16,
+		SizeCoils:            16,
+		SizeInputRegisters:   16,
+		SizeHoldingRegisters: 16,
+	}
+}
+
+// startBus - an RTU slave with the unit on an in-memory serial line and the master for it
+func startBus(t *testing.T, unit uint8) (*client.Client, *mbslave.DefaultDataModel) {
+	master, slave := mbslave.NewPipeSerialPorts()
+	open := mbslave.OpenSerialPort
+	mbslave.OpenSerialPort = func(*mbslave.Config) (serial.Port, error) {
+		return slave, nil
+	}
+	dm := mbslave.NewDefaultDataModel(newConfig(unit))
+	transport := mbslave.NewRtuTransport(newConfig(unit))
+	transport.Log = mbslave.NopLogger{}
+	server := mbslave.NewServer(transport, dm)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = server.Listen()
+	}()
+	t.Cleanup(func() {
+		_ = master.Close()
+		_ = server.Close()
+		<-done
+		mbslave.OpenSerialPort = open
+	})
+
+	c := client.NewClient(client.NewRtuTransport(master))
+	c.Timeout = 50 * time.Millisecond
+	return c, dm
+}
+
+func startGateway(t *testing.T, gw *Gateway) *client.Client {
+	transport := mbslave.NewTcpTransport(newConfig(0))
+	transport.Log = mbslave.NopLogger{}
+	server := mbslave.NewServer(transport, gw)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = server.Listen()
+	}()
+	t.Cleanup(func() {
+		_ = server.Close()
+		<-done
+	})
+
+	c, err := client.NewTcpClient(transport.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestGateway(t *testing.T) {
+	bus, remote := startBus(t, 0x02)
+	_ = remote.SetHoldingRegisters(3, 0x0203)
+
+	local := mbslave.NewDefaultDataModel(newConfig(0x01))
+	_ = local.SetHoldingRegisters(3, 0x0103)
+
+	gw := NewGateway()
+	gw.AddLocal(0x01, local)
+	gw.AddRemote(0x02, bus)
+	gw.AddRemote(0x03, bus)
+	c := startGateway(t, gw)
+	ctx := context.Background()
+
+	values, err := c.ReadHoldingRegisters(ctx, 0x01, 3, 1)
+	if err := gotest.Expect(err).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(values).Eq([]uint16{0x0103}); err != nil {
+		t.Error(err)
+	}
+
+	values, err = c.ReadHoldingRegisters(ctx, 0x02, 3, 1)
+	if err := gotest.Expect(err).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(values).Eq([]uint16{0x0203}); err != nil {
+		t.Error(err)
+	}
+
+	if err := gotest.Expect(c.WriteMultipleRegisters(ctx, 0x02, 5, []uint16{7, 8})).NotError(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(remote.GetHoldingRegisters(6)).Eq(uint16(8)); err != nil {
+		t.Error(err)
+	}
+
+	for unit, code := range map[uint8]uint8{
+		0x02: mbslave.ErrorAddress,
+		0x03: mbslave.ErrorGatewayTarget,
+		0x09: mbslave.ErrorGatewayPath,
+	} {
+		_, err := c.ReadHoldingRegisters(ctx, unit, 100, 1)
+		var exception *client.ExceptionError
+		if !errors.As(err, &exception) {
+			t.Errorf("unit %d: expected exception, got %v", unit, err)
+			continue
+		}
+		if err := gotest.Expect(exception.Code).Eq(code); err != nil {
+			t.Errorf("unit %d: %s", unit, err)
+		}
+	}
+}
+
+func TestGateway_Broadcast(t *testing.T) {
+	gw := NewGateway()
+	gw.AddLocal(0x01, mbslave.NewDefaultDataModel(newConfig(0x01)))
+
+	// a broadcast without a route is not answered
+	request := mbslave.NewTcpRequest(mbslave.MbapFrame(1, 0x00, []byte{0x06, 0x00, 0x01, 0x00, 0x07}))
+	response := mbslave.NewTcpResponse(request)
+	gw.Handler(request, response)
+	if adu, err := response.GetADU(); err == nil {
+		t.Errorf("the broadcast was answered with % x", adu)
+	}
+}
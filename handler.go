@@ -0,0 +1,91 @@
+package mbslave
+
+import (
+	"context"
+	"crypto/x509"
+	"time"
+)
+
+// Handler - answers one request by filling the response. The transports pass a
+// context with the RequestInfo, it is cancelled when the transport or the TCP
+// connection closes.
+type Handler func(ctx context.Context, req Request, resp Response)
+
+// HandlerFunc - adapts a handler without context, nil stays nil
+func HandlerFunc(f func(Request, Response)) Handler {
+	if f == nil {
+		return nil
+	}
+	return func(_ context.Context, req Request, resp Response) {
+		f(req, resp)
+	}
+}
+
+// ContextDataModel - a data model that passes the context on to its handlers
+type ContextDataModel interface {
+	HandleContext(ctx context.Context, req Request, resp Response)
+}
+
+// DataModelHandler - the context-aware handler of the data model
+func DataModelHandler(dm DataModel) Handler {
+	if cdm, ok := dm.(ContextDataModel); ok {
+		return cdm.HandleContext
+	}
+	return HandlerFunc(dm.Handler)
+}
+
+// RequestInfo - where and when a request was received
+type RequestInfo struct {
+	// Transport - "rtu", "tcp", "tls" or "rtu-over-tcp"
+	Transport string
+	// Local - the serial port or the listening address
+	Local string
+	// Remote - the address of the TCP peer, empty for serial ports
+	Remote   string
+	Unit     uint8
+	Function uint8
+	// Received - the time the frame was complete
+	Received time.Time
+	// Frame - the ADU as received
+	Frame []byte
+	// TransactionId - the MBAP transaction identifier, zero for RTU frames
+	TransactionId uint16
+	// Certificate - the client certificate of a TLS connection
+	Certificate *x509.Certificate
+	// Role - the Modbus role of the client certificate, see OidModbusRole
+	Role string
+	// Broadcast - the request is executed but not answered: the unit 0 (and 255 as
+	// before) of RTU frames. Modbus/TCP answers the units 0 and 255 as its own.
+	Broadcast bool
+}
+
+type requestInfoKey struct{}
+
+// WithRequestInfo - a context carrying the info, for transports and tests
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// RequestInfoFrom - the info of the request, ok is false for a context without it
+func RequestInfoFrom(ctx context.Context) (info RequestInfo, ok bool) {
+	info, ok = ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info, ok
+}
+
+// IsBroadcast - RequestInfo.Broadcast, without the info the units 0 and 255 of RTU requests
+func IsBroadcast(ctx context.Context, req Request) bool {
+	if info, ok := RequestInfoFrom(ctx); ok {
+		return info.Broadcast
+	}
+	return rtuBroadcast(req)
+}
+
+// rtuBroadcast - the unit 0 of the specification and 255 of earlier versions,
+// a Modbus/TCP request is never a broadcast
+func rtuBroadcast(req Request) bool {
+	if _, ok := req.(*TcpRequest); ok {
+		return false
+	}
+	unit := req.GetSlaveId()
+	return unit == 0 || unit == 255
+}
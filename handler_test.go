@@ -0,0 +1,109 @@
+package mbslave
+
+import (
+	"context"
+	"github.com/schnack/gotest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_RequestInfo(t *testing.T) {
+	config := &Config{Address: "127.0.0.1:0", SlaveId: 0x11, SizeHoldingRegisters: 10}
+	transport := NewTcpTransport(config)
+	transport.Log = NopLogger{}
+	dm := NewDefaultDataModel(config)
+	infos := make(chan RequestInfo, 1)
+	var connCtx context.Context
+	dm.SetContextFunction(FuncReadHoldingRegisters, func(ctx context.Context, req Request, resp Response) {
+		info, _ := RequestInfoFrom(ctx)
+		connCtx = ctx
+		infos <- info
+		resp.SetRead([]byte{0x00, 0x2a})
+	})
+	server := NewServer(transport, dm)
+	done := make(chan error)
+	go func() { done <- server.Listen() }()
+
+	resp := tcpRequest(t, transport.Addr(), []byte{0x03, 0x00, 0x01, 0x00, 0x01})
+	if err := gotest.Expect(resp).Eq([]byte{0x03, 0x02, 0x00, 0x2a}); err != nil {
+		t.Error(err)
+	}
+	info := <-infos
+	if err := gotest.Expect(info.Transport).Eq("tcp"); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(info.Local).Eq(transport.Addr().String()); err != nil {
+		t.Error(err)
+	}
+	if !strings.HasPrefix(info.Remote, "127.0.0.1:") {
+		t.Errorf("remote %q", info.Remote)
+	}
+	if err := gotest.Expect(info.Unit).Eq(uint8(0x11)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(info.TransactionId).Eq(uint16(1)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(info.Frame).Eq(MbapFrame(1, 0x11, []byte{0x03, 0x00, 0x01, 0x00, 0x01})); err != nil {
+		t.Error(err)
+	}
+	if time.Since(info.Received) > time.Second {
+		t.Errorf("received at %s", info.Received)
+	}
+
+	// tcpRequest closes the connection
+	select {
+	case <-connCtx.Done():
+	case <-time.After(time.Second):
+		t.Error("the context was not cancelled with the connection")
+	}
+
+	_ = server.Close()
+	<-done
+}
+
+func TestRtuTransport_RequestInfo(t *testing.T) {
+	InoutSerialPort.Load()
+	defer InoutSerialPort.Unload()
+	config := &Config{Port: "COM7", BaudRate: 9600, SilentInterval: time.Hour}
+	InoutSerialPort.GetOut(config.Port).Write([]byte{0x01, 0x05, 0x00, 0x01, 0xff, 0x00, 0xdd, 0xfa})
+
+	var info RequestInfo
+	rt := NewRtuTransport(config)
+	rt.Log = NopLogger{}
+	rt.SetContextHandler(func(ctx context.Context, req Request, resp Response) {
+		info, _ = RequestInfoFrom(ctx)
+		resp.Unanswered(true)
+	})
+	_ = rt.Listen()
+
+	if err := gotest.Expect(info.Transport).Eq("rtu"); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(info.Local).Eq("COM7"); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(info.Frame).Eq([]byte{0x01, 0x05, 0x00, 0x01, 0xff, 0x00, 0xdd, 0xfa}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBaseDataModel_GetFunction_Context(t *testing.T) {
+	bdm := BaseDataModel{SlaveId: 0x01}
+	var got bool
+	bdm.SetContextFunction(0x01, func(ctx context.Context, req Request, resp Response) {
+		_, got = RequestInfoFrom(ctx)
+	})
+	request := NewRtuRequest([]byte{0x01, 0x01, 0x00, 0x00, 0x00, 0x08, 0x3d, 0xcc})
+	bdm.HandleContext(WithRequestInfo(context.Background(), RequestInfo{Unit: 1}), request, NewRtuResponse(request))
+	if err := gotest.Expect(got).True(); err != nil {
+		t.Error(err)
+	}
+
+	// the plain form runs without the info
+	bdm.GetFunction(0x01)(request, NewRtuResponse(request))
+	if err := gotest.Expect(got).False(); err != nil {
+		t.Error(err)
+	}
+}
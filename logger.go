@@ -0,0 +1,228 @@
+package mbslave
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Level - severity of a log record
+type Level int
+
+const (
+	LevelDebug = Level(iota)
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// Field - structured key/value attached to a log record
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger - the logging interface used by the package. Callers check Enabled
+// before building fields, so a disabled level costs no formatting.
+type Logger interface {
+	Enabled(level Level) bool
+	Log(level Level, msg string, fields ...Field)
+}
+
+// Hex - bytes printed as "[01 02 03]" only when the record is formatted
+type Hex []byte
+
+func (h Hex) String() string {
+	return fmt.Sprintf("[% x]", []byte(h))
+}
+
+func (h Hex) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+func FieldUnit(unit uint8) Field {
+	return Field{Key: "unit", Value: unit}
+}
+
+func FieldFunction(function uint8) Field {
+	return Field{Key: "function", Value: function}
+}
+
+func FieldAddress(address uint16) Field {
+	return Field{Key: "address", Value: address}
+}
+
+func FieldQuantity(quantity uint16) Field {
+	return Field{Key: "quantity", Value: quantity}
+}
+
+func FieldException(code uint8) Field {
+	return Field{Key: "exception", Value: code}
+}
+
+func FieldDuration(d time.Duration) Field {
+	return Field{Key: "duration", Value: d}
+}
+
+func FieldRaw(raw []byte) Field {
+	return Field{Key: "raw", Value: Hex(raw)}
+}
+
+func FieldError(err error) Field {
+	return Field{Key: "error", Value: err}
+}
+
+// FieldBytes - size of a frame, key is "request_bytes" or "response_bytes"
+func FieldBytes(key string, n int) Field {
+	return Field{Key: key, Value: n}
+}
+
+// NopLogger - discards everything
+type NopLogger struct{}
+
+func (NopLogger) Enabled(Level) bool { return false }
+
+func (NopLogger) Log(Level, string, ...Field) {}
+
+type logrusLogger struct {
+	log logrus.FieldLogger
+}
+
+// NewLogrusLogger - adapter for logrus, fields become logrus fields
+func NewLogrusLogger(l logrus.FieldLogger) Logger {
+	return &logrusLogger{log: l}
+}
+
+func (l *logrusLogger) Enabled(level Level) bool {
+	switch log := l.log.(type) {
+	case *logrus.Logger:
+		return log.IsLevelEnabled(logrusLevel(level))
+	case *logrus.Entry:
+		return log.Logger.IsLevelEnabled(logrusLevel(level))
+	}
+	return true
+}
+
+func (l *logrusLogger) Log(level Level, msg string, fields ...Field) {
+	entry := l.log
+	if len(fields) != 0 {
+		f := make(logrus.Fields, len(fields))
+		for _, field := range fields {
+			f[field.Key] = field.Value
+		}
+		entry = l.log.WithFields(f)
+	}
+	switch level {
+	case LevelDebug:
+		entry.Debug(msg)
+	case LevelInfo:
+		entry.Info(msg)
+	case LevelWarn:
+		entry.Warn(msg)
+	default:
+		entry.Error(msg)
+	}
+}
+
+func logrusLevel(level Level) logrus.Level {
+	switch level {
+	case LevelDebug:
+		return logrus.DebugLevel
+	case LevelInfo:
+		return logrus.InfoLevel
+	case LevelWarn:
+		return logrus.WarnLevel
+	}
+	return logrus.ErrorLevel
+}
+
+type slogLogger struct {
+	log *slog.Logger
+}
+
+// NewSlogLogger - adapter for log/slog, nil means slog.Default()
+func NewSlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &slogLogger{log: l}
+}
+
+func (l *slogLogger) Enabled(level Level) bool {
+	return l.log.Enabled(context.Background(), slogLevel(level))
+}
+
+func (l *slogLogger) Log(level Level, msg string, fields ...Field) {
+	attrs := make([]slog.Attr, len(fields))
+	for i, field := range fields {
+		if s, ok := field.Value.(fmt.Stringer); ok {
+			attrs[i] = slog.String(field.Key, s.String())
+		} else {
+			attrs[i] = slog.Any(field.Key, field.Value)
+		}
+	}
+	l.log.LogAttrs(context.Background(), slogLevel(level), msg, attrs...)
+}
+
+func slogLevel(level Level) slog.Level {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelInfo:
+		return slog.LevelInfo
+	case LevelWarn:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
+
+type stdLogger struct {
+	log   *log.Logger
+	level Level
+}
+
+// NewStdLogger - adapter for the standard log package, records below level are dropped.
+// Nil means log.Default().
+func NewStdLogger(l *log.Logger, level Level) Logger {
+	if l == nil {
+		l = log.Default()
+	}
+	return &stdLogger{log: l, level: level}
+}
+
+func (l *stdLogger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+func (l *stdLogger) Log(level Level, msg string, fields ...Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(strings.ToUpper(level.String()))
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for _, field := range fields {
+		fmt.Fprintf(&b, " %s=%v", field.Key, field.Value)
+	}
+	l.log.Print(b.String())
+}
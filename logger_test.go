@@ -0,0 +1,66 @@
+package mbslave
+
+import (
+	"bytes"
+	"github.com/schnack/gotest"
+	"github.com/sirupsen/logrus"
+	"log"
+	"log/slog"
+	"testing"
+)
+
+func TestNewStdLogger(t *testing.T) {
+	out := new(bytes.Buffer)
+	logger := NewStdLogger(log.New(out, "", 0), LevelInfo)
+
+	if err := gotest.Expect(logger.Enabled(LevelDebug)).False(); err != nil {
+		t.Error(err)
+	}
+	logger.Log(LevelDebug, "hidden")
+	logger.Log(LevelInfo, "request", FieldUnit(1), FieldRaw([]byte{0x01, 0x03}))
+
+	if err := gotest.Expect(out.String()).Eq("INFO request unit=1 raw=[01 03]\n"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewLogrusLogger(t *testing.T) {
+	out := new(bytes.Buffer)
+	l := logrus.New()
+	l.Out = out
+	l.Formatter = &logrus.TextFormatter{DisableTimestamp: true, DisableColors: true}
+	l.Level = logrus.InfoLevel
+	logger := NewLogrusLogger(l)
+
+	if err := gotest.Expect(logger.Enabled(LevelDebug)).False(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(NewLogrusLogger(l.WithField("a", 1)).Enabled(LevelWarn)).True(); err != nil {
+		t.Error(err)
+	}
+	logger.Log(LevelWarn, "frame", FieldRaw([]byte{0xff}))
+	if err := gotest.Expect(out.String()).Eq("level=warning msg=frame raw=\"[ff]\"\n"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewSlogLogger(t *testing.T) {
+	out := new(bytes.Buffer)
+	logger := NewSlogLogger(slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})))
+
+	if err := gotest.Expect(logger.Enabled(LevelDebug)).False(); err != nil {
+		t.Error(err)
+	}
+	logger.Log(LevelError, "response", FieldException(ErrorAddress), FieldRaw([]byte{0x01}))
+	if err := gotest.Expect(out.String()).Eq("level=ERROR msg=response exception=2 raw=[01]\n"); err != nil {
+		t.Error(err)
+	}
+}
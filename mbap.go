@@ -0,0 +1,47 @@
+package mbslave
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// MbapHeaderSize - length of the Modbus/TCP application protocol header
+const MbapHeaderSize = 7
+
+// MbapHeader - the header preceding every Modbus/TCP PDU
+type MbapHeader struct {
+	TransactionId uint16
+	ProtocolId    uint16
+	// Length of the unit id and the PDU
+	Length uint16
+	UnitId uint8
+}
+
+// ParseMbapHeader - decodes and checks the first MbapHeaderSize bytes
+func ParseMbapHeader(b []byte) (MbapHeader, error) {
+	if len(b) < MbapHeaderSize {
+		return MbapHeader{}, ErrFrameDamaged
+	}
+	header := MbapHeader{
+		TransactionId: binary.BigEndian.Uint16(b[0:2]),
+		ProtocolId:    binary.BigEndian.Uint16(b[2:4]),
+		Length:        binary.BigEndian.Uint16(b[4:6]),
+		UnitId:        b[6],
+	}
+	if header.ProtocolId != 0 {
+		return header, fmt.Errorf("unknown protocol id %d", header.ProtocolId)
+	}
+	if header.Length < 2 || header.Length > 254 {
+		return header, fmt.Errorf("invalid length %d", header.Length)
+	}
+	return header, nil
+}
+
+// MbapFrame - builds a Modbus/TCP ADU from the PDU
+func MbapFrame(transactionId uint16, unitId uint8, pdu []byte) []byte {
+	b := make([]byte, MbapHeaderSize, MbapHeaderSize+len(pdu))
+	binary.BigEndian.PutUint16(b[0:2], transactionId)
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(pdu)+1))
+	b[6] = unitId
+	return append(b, pdu...)
+}
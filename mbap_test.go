@@ -0,0 +1,28 @@
+package mbslave
+
+import (
+	"github.com/schnack/gotest"
+	"testing"
+)
+
+func TestMbapFrame(t *testing.T) {
+	frame := MbapFrame(0x0102, 0x11, []byte{0x03, 0x00, 0x00, 0x00, 0x01})
+	if err := gotest.Expect(frame).Eq([]byte{0x01, 0x02, 0x00, 0x00, 0x00, 0x06, 0x11, 0x03, 0x00, 0x00, 0x00, 0x01}); err != nil {
+		t.Error(err)
+	}
+
+	header, err := ParseMbapHeader(frame)
+	if err := gotest.Expect(err).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(header).Eq(MbapHeader{TransactionId: 0x0102, Length: 6, UnitId: 0x11}); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := ParseMbapHeader([]byte{0x00, 0x01, 0x00, 0x01, 0x00, 0x06, 0x01}); err == nil {
+		t.Error("expected protocol id error")
+	}
+	if err := gotest.Expect(func() error { _, err := ParseMbapHeader([]byte{0x00}); return err }()).Error("frame damaged"); err != nil {
+		t.Error(err)
+	}
+}
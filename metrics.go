@@ -0,0 +1,356 @@
+package mbslave
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics - hook called by transports and data models, implement it to feed
+// another metrics system. Embed NopMetrics to implement only a part of it.
+type Metrics interface {
+	FrameReceived(size int)
+	FrameSent(size int)
+	CrcError()
+	ParseError()
+	Request(unit, function uint8)
+	Exception(function, code uint8)
+	Unanswered(unit uint8)
+	Broadcast()
+	HandlerDuration(function uint8, d time.Duration)
+	// PortState - the serial port was opened or lost
+	PortState(connected bool)
+	// Connection - a TCP connection was accepted, refused or closed
+	Connection(event ConnEvent)
+}
+
+// ConnEvent - what happened to a TCP connection
+type ConnEvent int
+
+const (
+	ConnAccepted = ConnEvent(iota)
+	ConnClosed
+	// ConnDenied - the address is not allowed, see TcpTransport.Allow
+	ConnDenied
+	// ConnRejected - MaxConns was reached
+	ConnRejected
+	// ConnEvicted - closed for a new connection, see EvictOldestIdle
+	ConnEvicted
+	// ConnIdle - closed after IdleTimeout without a request
+	ConnIdle
+	// ConnRateLimited - a request over RateLimit was answered with ErrorWait
+	ConnRateLimited
+)
+
+var connEventNames = [...]string{"accepted", "closed", "denied", "rejected", "evicted", "idle", "rate_limited"}
+
+func (e ConnEvent) String() string {
+	if e >= 0 && int(e) < len(connEventNames) {
+		return connEventNames[e]
+	}
+	return fmt.Sprintf("conn_event(%d)", int(e))
+}
+
+func (e ConnEvent) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
+
+// countFrameError - a damaged frame is a crc or a parse error
+func countFrameError(m Metrics, err error) {
+	if _, ok := err.(*CrcError); ok {
+		m.CrcError()
+	} else {
+		m.ParseError()
+	}
+}
+
+// NopMetrics - ignores everything
+type NopMetrics struct{}
+
+func (NopMetrics) FrameReceived(int)                    {}
+func (NopMetrics) FrameSent(int)                        {}
+func (NopMetrics) CrcError()                            {}
+func (NopMetrics) ParseError()                          {}
+func (NopMetrics) Request(uint8, uint8)                 {}
+func (NopMetrics) Exception(uint8, uint8)               {}
+func (NopMetrics) Unanswered(uint8)                     {}
+func (NopMetrics) Broadcast()                           {}
+func (NopMetrics) HandlerDuration(uint8, time.Duration) {}
+func (NopMetrics) PortState(bool)                       {}
+func (NopMetrics) Connection(ConnEvent)                 {}
+
+// DefaultLatencyBuckets - upper bounds of the handler latency histogram in seconds
+var DefaultLatencyBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+// Stats - in-memory Metrics implementation with a Prometheus text exporter.
+// It is an http.Handler serving the exposition format.
+type Stats struct {
+	framesReceived uint64
+	framesSent     uint64
+	crcErrors      uint64
+	parseErrors    uint64
+	broadcasts     uint64
+	disconnects    uint64
+	connected      uint32
+	connections    [len(connEventNames)]uint64
+
+	buckets []float64
+
+	mu         sync.Mutex
+	requests   map[[2]uint8]uint64
+	exceptions map[[2]uint8]uint64
+	unanswered map[uint8]uint64
+	latency    map[uint8]*Histogram
+}
+
+// Histogram - cumulative counts per bucket upper bound
+type Histogram struct {
+	Buckets []float64
+	Counts  []uint64
+	Count   uint64
+	Sum     float64
+}
+
+// StatsSnapshot - copy of the collected values
+type StatsSnapshot struct {
+	FramesReceived uint64
+	FramesSent     uint64
+	CrcErrors      uint64
+	ParseErrors    uint64
+	Broadcasts     uint64
+	Disconnects    uint64
+	Connected      bool
+	// Connections - TCP connection events
+	Connections map[ConnEvent]uint64
+	// Requests by [unit, function]
+	Requests map[[2]uint8]uint64
+	// Exceptions by [function, code]
+	Exceptions map[[2]uint8]uint64
+	// Unanswered by unit
+	Unanswered map[uint8]uint64
+	// Latency by function
+	Latency map[uint8]Histogram
+}
+
+// NewStats - nil buckets means DefaultLatencyBuckets
+func NewStats(buckets []float64) *Stats {
+	if buckets == nil {
+		buckets = DefaultLatencyBuckets
+	}
+	return &Stats{
+		buckets:    buckets,
+		requests:   make(map[[2]uint8]uint64),
+		exceptions: make(map[[2]uint8]uint64),
+		unanswered: make(map[uint8]uint64),
+		latency:    make(map[uint8]*Histogram),
+	}
+}
+
+func (s *Stats) FrameReceived(int) {
+	atomic.AddUint64(&s.framesReceived, 1)
+}
+
+func (s *Stats) FrameSent(int) {
+	atomic.AddUint64(&s.framesSent, 1)
+}
+
+func (s *Stats) CrcError() {
+	atomic.AddUint64(&s.crcErrors, 1)
+}
+
+func (s *Stats) ParseError() {
+	atomic.AddUint64(&s.parseErrors, 1)
+}
+
+func (s *Stats) Broadcast() {
+	atomic.AddUint64(&s.broadcasts, 1)
+}
+
+func (s *Stats) PortState(connected bool) {
+	if connected {
+		atomic.StoreUint32(&s.connected, 1)
+	} else if atomic.SwapUint32(&s.connected, 0) == 1 {
+		atomic.AddUint64(&s.disconnects, 1)
+	}
+}
+
+func (s *Stats) Connection(event ConnEvent) {
+	if event >= 0 && int(event) < len(s.connections) {
+		atomic.AddUint64(&s.connections[event], 1)
+	}
+}
+
+func (s *Stats) Request(unit, function uint8) {
+	s.mu.Lock()
+	s.requests[[2]uint8{unit, function}]++
+	s.mu.Unlock()
+}
+
+func (s *Stats) Exception(function, code uint8) {
+	s.mu.Lock()
+	s.exceptions[[2]uint8{function, code}]++
+	s.mu.Unlock()
+}
+
+func (s *Stats) Unanswered(unit uint8) {
+	s.mu.Lock()
+	s.unanswered[unit]++
+	s.mu.Unlock()
+}
+
+func (s *Stats) HandlerDuration(function uint8, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.latency[function]
+	if !ok {
+		h = &Histogram{Buckets: s.buckets, Counts: make([]uint64, len(s.buckets))}
+		s.latency[function] = h
+	}
+	seconds := d.Seconds()
+	for i, le := range h.Buckets {
+		if seconds <= le {
+			h.Counts[i]++
+		}
+	}
+	h.Count++
+	h.Sum += seconds
+}
+
+func (s *Stats) Snapshot() StatsSnapshot {
+	snapshot := StatsSnapshot{
+		FramesReceived: atomic.LoadUint64(&s.framesReceived),
+		FramesSent:     atomic.LoadUint64(&s.framesSent),
+		CrcErrors:      atomic.LoadUint64(&s.crcErrors),
+		ParseErrors:    atomic.LoadUint64(&s.parseErrors),
+		Broadcasts:     atomic.LoadUint64(&s.broadcasts),
+		Disconnects:    atomic.LoadUint64(&s.disconnects),
+		Connected:      atomic.LoadUint32(&s.connected) == 1,
+		Connections:    make(map[ConnEvent]uint64),
+		Requests:       make(map[[2]uint8]uint64),
+		Exceptions:     make(map[[2]uint8]uint64),
+		Unanswered:     make(map[uint8]uint64),
+		Latency:        make(map[uint8]Histogram),
+	}
+	for i := range s.connections {
+		if v := atomic.LoadUint64(&s.connections[i]); v > 0 {
+			snapshot.Connections[ConnEvent(i)] = v
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range s.requests {
+		snapshot.Requests[k] = v
+	}
+	for k, v := range s.exceptions {
+		snapshot.Exceptions[k] = v
+	}
+	for k, v := range s.unanswered {
+		snapshot.Unanswered[k] = v
+	}
+	for k, v := range s.latency {
+		h := *v
+		h.Counts = append([]uint64(nil), v.Counts...)
+		snapshot.Latency[k] = h
+	}
+	return snapshot
+}
+
+// WritePrometheus - writes the metrics in the Prometheus text exposition format
+func (s *Stats) WritePrometheus(w io.Writer) error {
+	snapshot := s.Snapshot()
+	ew := &errWriter{w: w}
+
+	counter := func(name, help string, value uint64) {
+		ew.printf("# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+	}
+	counter("mbslave_frames_received_total", "Frames received by transports.", snapshot.FramesReceived)
+	counter("mbslave_frames_sent_total", "Frames sent by transports.", snapshot.FramesSent)
+	counter("mbslave_crc_errors_total", "Frames with a wrong checksum.", snapshot.CrcErrors)
+	counter("mbslave_parse_errors_total", "Frames that could not be parsed.", snapshot.ParseErrors)
+	counter("mbslave_broadcasts_total", "Broadcast requests.", snapshot.Broadcasts)
+	counter("mbslave_port_disconnects_total", "Serial port losses.", snapshot.Disconnects)
+	connected := 0
+	if snapshot.Connected {
+		connected = 1
+	}
+	ew.printf("# HELP mbslave_port_connected Serial port is open.\n# TYPE mbslave_port_connected gauge\nmbslave_port_connected %d\n", connected)
+
+	ew.printf("# HELP mbslave_tcp_connections_total TCP connection events.\n# TYPE mbslave_tcp_connections_total counter\n")
+	for i := range connEventNames {
+		if v, ok := snapshot.Connections[ConnEvent(i)]; ok {
+			ew.printf("mbslave_tcp_connections_total{event=\"%s\"} %d\n", ConnEvent(i), v)
+		}
+	}
+
+	ew.printf("# HELP mbslave_requests_total Requests by unit and function.\n# TYPE mbslave_requests_total counter\n")
+	for _, k := range sortedPairs(snapshot.Requests) {
+		ew.printf("mbslave_requests_total{unit=\"%d\",function=\"%d\"} %d\n", k[0], k[1], snapshot.Requests[k])
+	}
+
+	ew.printf("# HELP mbslave_exceptions_total Exception responses by function and code.\n# TYPE mbslave_exceptions_total counter\n")
+	for _, k := range sortedPairs(snapshot.Exceptions) {
+		ew.printf("mbslave_exceptions_total{function=\"%d\",code=\"%d\"} %d\n", k[0], k[1], snapshot.Exceptions[k])
+	}
+
+	ew.printf("# HELP mbslave_unanswered_total Frames left without a response by unit.\n# TYPE mbslave_unanswered_total counter\n")
+	units := make([]int, 0, len(snapshot.Unanswered))
+	for unit := range snapshot.Unanswered {
+		units = append(units, int(unit))
+	}
+	sort.Ints(units)
+	for _, unit := range units {
+		ew.printf("mbslave_unanswered_total{unit=\"%d\"} %d\n", unit, snapshot.Unanswered[uint8(unit)])
+	}
+
+	ew.printf("# HELP mbslave_handler_duration_seconds Handler latency by function.\n# TYPE mbslave_handler_duration_seconds histogram\n")
+	functions := make([]int, 0, len(snapshot.Latency))
+	for function := range snapshot.Latency {
+		functions = append(functions, int(function))
+	}
+	sort.Ints(functions)
+	for _, function := range functions {
+		h := snapshot.Latency[uint8(function)]
+		for i, le := range h.Buckets {
+			ew.printf("mbslave_handler_duration_seconds_bucket{function=\"%d\",le=\"%g\"} %d\n", function, le, h.Counts[i])
+		}
+		ew.printf("mbslave_handler_duration_seconds_bucket{function=\"%d\",le=\"+Inf\"} %d\n", function, h.Count)
+		ew.printf("mbslave_handler_duration_seconds_sum{function=\"%d\"} %g\n", function, h.Sum)
+		ew.printf("mbslave_handler_duration_seconds_count{function=\"%d\"} %d\n", function, h.Count)
+	}
+	return ew.err
+}
+
+func (s *Stats) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = s.WritePrometheus(w)
+}
+
+func sortedPairs(m map[[2]uint8]uint64) [][2]uint8 {
+	keys := make([][2]uint8, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
+
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err != nil {
+		return
+	}
+	_, ew.err = fmt.Fprintf(ew.w, format, args...)
+}
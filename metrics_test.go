@@ -0,0 +1,73 @@
+package mbslave
+
+import (
+	"bytes"
+	"github.com/schnack/gotest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStats_Handler(t *testing.T) {
+	stats := NewStats(nil)
+	bdm := BaseDataModel{SlaveId: 0x01, Metrics: stats}
+	bdm.SetFunction(FuncReadCoils, func(req Request, resp Response) {
+		resp.SetError(ErrorAddress)
+	})
+
+	for _, adu := range [][]byte{
+		{0x01, 0x01, 0x00, 0x00, 0x00, 0x08, 0x3d, 0xcc},
+		{0x01, 0x01, 0x00, 0x00, 0x00, 0x08, 0x3d, 0xcd},
+		{0x01, 0x01, 0x00, 0x00, 0x00, 0x3d, 0xcc},
+		AppendCrc([]byte{0xff, 0x01, 0x00, 0x00, 0x00, 0x08}),
+	} {
+		request := NewRtuRequest(adu)
+		bdm.Handler(request, NewRtuResponse(request))
+	}
+
+	// the damaged frames are counted by the transport
+	snapshot := stats.Snapshot()
+	if err := gotest.Expect(snapshot.CrcErrors + snapshot.ParseErrors).Eq(uint64(0)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(snapshot.Broadcasts).Eq(uint64(1)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(snapshot.Requests[[2]uint8{0x01, FuncReadCoils}]).Eq(uint64(1)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(snapshot.Exceptions[[2]uint8{FuncReadCoils, ErrorAddress}]).Eq(uint64(2)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(snapshot.Latency[FuncReadCoils].Count).Eq(uint64(2)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStats_WritePrometheus(t *testing.T) {
+	stats := NewStats([]float64{0.001, 0.01})
+	stats.FrameReceived(8)
+	stats.Request(1, 3)
+	stats.Unanswered(2)
+	stats.HandlerDuration(3, 5*time.Millisecond)
+	stats.Connection(ConnRateLimited)
+
+	out := new(bytes.Buffer)
+	if err := gotest.Expect(stats.WritePrometheus(out)).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"mbslave_frames_received_total 1",
+		`mbslave_requests_total{unit="1",function="3"} 1`,
+		`mbslave_unanswered_total{unit="2"} 1`,
+		`mbslave_handler_duration_seconds_bucket{function="3",le="0.001"} 0`,
+		`mbslave_handler_duration_seconds_bucket{function="3",le="0.01"} 1`,
+		`mbslave_handler_duration_seconds_bucket{function="3",le="+Inf"} 1`,
+		`mbslave_handler_duration_seconds_count{function="3"} 1`,
+		`mbslave_tcp_connections_total{event="rate_limited"} 1`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("missing %q in\n%s", line, out.String())
+		}
+	}
+}
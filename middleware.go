@@ -0,0 +1,75 @@
+package mbslave
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// Middleware - wraps a handler, it may act before and after next or not call it at all
+type Middleware func(next Handler) Handler
+
+// chain - applies the middlewares so that the first one is the outermost
+func chain(h Handler, middlewares []Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// LogMiddleware - logs every handled request with its exception and duration at info level
+func LogMiddleware(log Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, req Request, resp Response) {
+			start := time.Now()
+			next(ctx, req, resp)
+			if log.Enabled(LevelInfo) {
+				log.Log(LevelInfo, "handled",
+					FieldUnit(req.GetSlaveId()),
+					FieldFunction(req.GetFunction()),
+					FieldAddress(req.GetAddress()),
+					FieldQuantity(req.GetQuantity()),
+					FieldException(resp.GetError()),
+					FieldDuration(time.Since(start)),
+				)
+			}
+		}
+	}
+}
+
+// RecoverMiddleware - a panic of the handler is answered with ErrorFatal (0x04)
+// and logged with the stack instead of stopping the process
+func RecoverMiddleware(log Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, req Request, resp Response) {
+			defer func() {
+				r := recover()
+				if r == nil {
+					return
+				}
+				resp.SetError(ErrorFatal)
+				if log.Enabled(LevelError) {
+					log.Log(LevelError, "handler panic",
+						FieldUnit(req.GetSlaveId()),
+						FieldFunction(req.GetFunction()),
+						FieldError(fmt.Errorf("%v", r)),
+						Field{Key: "stack", Value: string(debug.Stack())},
+					)
+				}
+			}()
+			next(ctx, req, resp)
+		}
+	}
+}
+
+// TimingMiddleware - passes the duration of every handled request to observe
+func TimingMiddleware(observe func(req Request, resp Response, d time.Duration)) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, req Request, resp Response) {
+			start := time.Now()
+			next(ctx, req, resp)
+			observe(req, resp, time.Since(start))
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package mbslave
+
+import (
+	"bytes"
+	"context"
+	"github.com/schnack/gotest"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func handle(h func(Request, Response), adu []byte) Response {
+	request := NewRtuRequest(adu)
+	response := NewRtuResponse(request)
+	h(request, response)
+	return response
+}
+
+func TestBaseDataModel_Use(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, req Request, resp Response) {
+				calls = append(calls, name+" "+string(rune('0'+req.GetFunction())))
+				next(ctx, req, resp)
+			}
+		}
+	}
+	bdm := BaseDataModel{SlaveId: 0x01}
+	bdm.Use(trace("a"), trace("b"))
+	bdm.SetFunction(0x01, func(req Request, resp Response) {
+		calls = append(calls, "handler")
+		resp.SetRead([]byte{0xff})
+	})
+
+	handle(bdm.Handler, []byte{0x01, 0x01, 0x00, 0x00, 0x00, 0x08, 0x3d, 0xcc})
+	response := handle(bdm.Handler, AppendCrc([]byte{0x01, 0x02, 0x00, 0x00, 0x00, 0x08}))
+	if err := gotest.Expect(calls).Eq([]string{"a 1", "b 1", "handler", "a 2", "b 2"}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(response.GetError()).Eq(ErrorFunction); err != nil {
+		t.Error(err)
+	}
+
+	// requests of other units do not reach the middlewares
+	handle(bdm.Handler, AppendCrc([]byte{0x02, 0x01, 0x00, 0x00, 0x00, 0x08}))
+	if err := gotest.Expect(len(calls)).Eq(5); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	out := new(bytes.Buffer)
+	bdm := BaseDataModel{SlaveId: 0x01}
+	bdm.Use(RecoverMiddleware(NewStdLogger(log.New(out, "", 0), LevelError)))
+	bdm.SetFunction(0x01, func(req Request, resp Response) {
+		panic("broken handler")
+	})
+
+	response := handle(bdm.Handler, []byte{0x01, 0x01, 0x00, 0x00, 0x00, 0x08, 0x3d, 0xcc})
+	if err := gotest.Expect(response.GetError()).Eq(ErrorFatal); err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(out.String(), "handler panic") || !strings.Contains(out.String(), "broken handler") {
+		t.Error(out.String())
+	}
+}
+
+func TestServer_Use(t *testing.T) {
+	out := new(bytes.Buffer)
+	dm := NewDefaultDataModel(&Config{SlaveId: 1, SizeHoldingRegisters: 8})
+	var observed []time.Duration
+	s := NewServer(nil, dm)
+	s.Use(
+		LogMiddleware(NewStdLogger(log.New(out, "", 0), LevelInfo)),
+		TimingMiddleware(func(req Request, resp Response, d time.Duration) {
+			observed = append(observed, d)
+		}),
+	)
+
+	response := handle(func(req Request, resp Response) { s.handle(context.Background(), req, resp) }, AppendCrc([]byte{0x01, 0x03, 0x00, 0x09, 0x00, 0x01}))
+	if err := gotest.Expect(response.GetError()).Eq(ErrorAddress); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(len(observed)).Eq(1); err != nil {
+		t.Error(err)
+	}
+	if !strings.HasPrefix(out.String(), "INFO handled unit=1 function=3 address=9 quantity=1 exception=2") {
+		t.Error(out.String())
+	}
+}
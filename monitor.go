@@ -0,0 +1,244 @@
+package mbslave
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MonitorRecord - a request seen on the bus and the response paired with it
+type MonitorRecord struct {
+	Time     time.Time
+	Unit     uint8
+	Function uint8
+	Address  uint16
+	Quantity uint16
+	// Request and Response ADU, Response is nil for broadcasts and unanswered requests
+	Request  []byte
+	Response []byte
+	// Data of the response: read values or the echo of a write
+	Data      []byte
+	Exception uint8
+	// Err describes bytes that could not be framed
+	Err error
+}
+
+// Monitor - decodes the traffic of a bus in listen-only mode, see RtuTransport.Monitor
+type Monitor struct {
+	// OnRecord receives every decoded record
+	OnRecord func(MonitorRecord)
+	Log      Logger
+	Capture  Capturer
+	// Mirror receives the writes acknowledged by the slaves and the broadcast writes
+	// to the units 0 and 255, like the server executes them
+	Mirror *DefaultDataModel
+
+	mu      sync.Mutex
+	pending *MonitorRecord
+}
+
+// Feed - processes the bytes received between two silent intervals.
+// Back-to-back frames are split by the length expected for their function.
+func (m *Monitor) Feed(t time.Time, chunk []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for len(chunk) > 0 {
+		if m.pending != nil {
+			if n := m.responseLength(chunk); n > 0 {
+				m.pending.Response = append([]byte(nil), chunk[:n]...)
+				m.capture(DirectionOut, t, m.pending.Response)
+				m.emit()
+				chunk = chunk[n:]
+				continue
+			}
+			// a new request, the previous one stays unanswered
+			m.emit()
+		}
+
+		n := frameLength(chunk, RtuRequestLength)
+		if n <= 0 {
+			m.record(MonitorRecord{Time: t, Request: append([]byte(nil), chunk...), Err: fmt.Errorf("unable to frame %d bytes", len(chunk))})
+			return
+		}
+		request := &RtuRequest{raw: append([]byte(nil), chunk[:n]...)}
+		chunk = chunk[n:]
+		m.capture(DirectionIn, t, request.raw)
+
+		record := &MonitorRecord{
+			Time:     t,
+			Unit:     request.GetSlaveId(),
+			Function: request.GetFunction(),
+			Request:  request.raw,
+		}
+		if err := request.Parse(); err != nil {
+			record.Err = err
+			m.record(*record)
+			continue
+		}
+		record.Address = request.GetAddress()
+		record.Quantity = request.GetQuantity()
+		if rtuBroadcast(request) {
+			m.mirror(request)
+			m.record(*record)
+			continue
+		}
+		m.pending = record
+	}
+}
+
+// FrameLength - the expected length of the frame at the start of adu, an RtuFramer.Length.
+// It is the response of the pending request when the unit and function match and
+// a request otherwise; a response failing its checksum is framed as a request.
+func (m *Monitor) FrameLength(adu []byte) int {
+	m.mu.Lock()
+	pending := m.pending
+	m.mu.Unlock()
+	if pending == nil || len(adu) < 2 || adu[0] != pending.Unit || adu[1]&0x7f != pending.Function {
+		return RtuRequestLength(adu)
+	}
+	response, request := RtuResponseLength(adu), RtuRequestLength(adu)
+	valid := func(n int) bool { return n > 0 && n <= len(adu) && CheckCrc(adu[:n]) }
+	open := func(n int) bool { return n == 0 || n > len(adu) }
+	switch {
+	case valid(response):
+		return response
+	case valid(request):
+		return request
+	case open(response) && open(request):
+		if response == 0 || request == 0 {
+			return 0
+		}
+		return min(response, request)
+	case open(request):
+		return request
+	}
+	return response
+}
+
+// Discard - reports bytes that are not part of a frame
+func (m *Monitor) Discard(t time.Time, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.record(MonitorRecord{Time: t, Request: append([]byte(nil), data...), Err: fmt.Errorf("unable to frame %d bytes", len(data))})
+}
+
+// Flush - reports the request still waiting for a response
+func (m *Monitor) Flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.pending != nil {
+		m.emit()
+	}
+}
+
+// responseLength - length of the response to the pending request at the start of the chunk or 0
+func (m *Monitor) responseLength(chunk []byte) int {
+	if len(chunk) < 2 || chunk[0] != m.pending.Unit || chunk[1]&0x7f != m.pending.Function {
+		return 0
+	}
+	n := frameLength(chunk, RtuResponseLength)
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// emit - the caller holds m.mu
+func (m *Monitor) emit() {
+	record := m.pending
+	m.pending = nil
+	if resp := record.Response; len(resp) >= 4 {
+		if resp[1]&0x80 != 0 {
+			record.Exception = resp[2]
+		} else {
+			data := resp[2 : len(resp)-2]
+			if (record.Function <= FuncReadInputRegisters || record.Function == FuncReadWriteRegisters) && len(data) > 0 {
+				data = data[1:]
+			}
+			record.Data = data
+			m.mirror(&RtuRequest{raw: record.Request})
+		}
+	}
+	m.record(*record)
+}
+
+// mirror - applies an acknowledged write to the mirror at once, the caller holds m.mu
+func (m *Monitor) mirror(request *RtuRequest) {
+	if m.Mirror == nil || request.Parse() != nil {
+		return
+	}
+	data := request.GetData()
+	address := request.GetAddress()
+	var values []uint16
+	table := TableHoldingRegisters
+	switch request.GetFunction() {
+	case FuncWriteSingleCoil:
+		table = TableCoils
+		if binary.BigEndian.Uint16(data) != 0 {
+			values = []uint16{1}
+		} else {
+			values = []uint16{0}
+		}
+	case FuncWriteSingleRegister:
+		values = []uint16{binary.BigEndian.Uint16(data)}
+	case FuncWriteMultipleCoils:
+		table = TableCoils
+		for i := 0; i < int(request.GetQuantity()) && i/8 < len(data); i++ {
+			values = append(values, uint16(data[i/8]>>(i%8)&0x01))
+		}
+	case FuncWriteMultipleRegisters:
+		for i := 0; i < int(request.GetQuantity()) && i*2+1 < len(data); i++ {
+			values = append(values, binary.BigEndian.Uint16(data[i*2:]))
+		}
+	}
+	if len(values) > 0 {
+		_ = m.Mirror.SetRange(table, address, values)
+	}
+}
+
+// record - the caller holds m.mu
+func (m *Monitor) record(record MonitorRecord) {
+	if m.Log != nil && m.Log.Enabled(LevelInfo) {
+		fields := []Field{
+			FieldUnit(record.Unit),
+			FieldFunction(record.Function),
+			FieldAddress(record.Address),
+			FieldQuantity(record.Quantity),
+			Field{Key: "request", Value: Hex(record.Request)},
+			Field{Key: "response", Value: Hex(record.Response)},
+		}
+		if record.Exception != 0 {
+			fields = append(fields, FieldException(record.Exception))
+		}
+		if record.Err != nil {
+			fields = append(fields, FieldError(record.Err))
+		}
+		m.Log.Log(LevelInfo, "monitor", fields...)
+	}
+	if m.OnRecord != nil {
+		m.OnRecord(record)
+	}
+}
+
+func (m *Monitor) capture(direction Direction, t time.Time, adu []byte) {
+	if m.Capture != nil {
+		_ = m.Capture.Capture(direction, t, adu)
+	}
+}
+
+// frameLength - length of the frame at the start of the chunk with a valid checksum.
+// Functions with unknown length are accepted when the whole chunk is one frame.
+func frameLength(chunk []byte, expected func([]byte) int) int {
+	n := expected(chunk)
+	switch {
+	case n > 0 && n <= len(chunk) && CheckCrc(chunk[:n]):
+		return n
+	case n == 0 || n > len(chunk):
+		return -1
+	case n < 0 && CheckCrc(chunk):
+		return len(chunk)
+	}
+	return -1
+}
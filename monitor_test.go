@@ -0,0 +1,138 @@
+package mbslave
+
+import (
+	"github.com/schnack/gotest"
+	"testing"
+	"time"
+)
+
+func TestMonitor_Feed(t *testing.T) {
+	var records []MonitorRecord
+	mirror := NewDefaultDataModel(&Config{SizeHoldingRegisters: 10, SizeCoils: 10})
+	m := &Monitor{
+		OnRecord: func(r MonitorRecord) { records = append(records, r) },
+		Mirror:   mirror,
+	}
+	now := time.Now()
+
+	readRequest := AppendCrc([]byte{0x02, 0x03, 0x00, 0x01, 0x00, 0x02})
+	readResponse := AppendCrc([]byte{0x02, 0x03, 0x04, 0x00, 0x0a, 0x00, 0x0b})
+	writeRequest := AppendCrc([]byte{0x02, 0x10, 0x00, 0x03, 0x00, 0x02, 0x04, 0x12, 0x34, 0x56, 0x78})
+	writeResponse := AppendCrc([]byte{0x02, 0x10, 0x00, 0x03, 0x00, 0x02})
+	exceptionResponse := AppendCrc([]byte{0x02, 0x83, 0x02})
+
+	// request and response merged into one chunk
+	m.Feed(now, append(append([]byte(nil), readRequest...), readResponse...))
+	// separate chunks
+	m.Feed(now, writeRequest)
+	m.Feed(now, writeResponse)
+	// unanswered request followed by another one
+	m.Feed(now, readRequest)
+	m.Feed(now, readRequest)
+	m.Feed(now, exceptionResponse)
+	// garbage
+	m.Feed(now, []byte{0x00, 0xff, 0x13})
+	// a broadcast to the unit 255 the server executes as well
+	m.Feed(now, AppendCrc([]byte{0xff, 0x06, 0x00, 0x05, 0x00, 0x2a}))
+	m.Flush()
+
+	if err := gotest.Expect(len(records)).Eq(6); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(records[0].Data).Eq([]byte{0x00, 0x0a, 0x00, 0x0b}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(records[0].Address).Eq(uint16(1)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(records[1].Function).Eq(FuncWriteMultipleRegisters); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(records[1].Response).Eq(writeResponse); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(records[2].Response).Nil(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(records[3].Exception).Eq(ErrorAddress); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(records[4].Err).Error("unable to frame 3 bytes"); err != nil {
+		t.Error(err)
+	}
+
+	if err := gotest.Expect(mirror.GetHoldingRegisters(3)).Eq(uint16(0x1234)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(mirror.GetHoldingRegisters(4)).Eq(uint16(0x5678)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(mirror.GetHoldingRegisters(5)).Eq(uint16(0x2a)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMonitor_FrameLength(t *testing.T) {
+	m := &Monitor{}
+	readRequest := AppendCrc([]byte{0x02, 0x03, 0x00, 0x01, 0x00, 0x01})
+	if err := gotest.Expect(m.FrameLength(readRequest)).Eq(8); err != nil {
+		t.Error(err)
+	}
+	m.Feed(time.Now(), readRequest)
+
+	// the response of the pending request, or the next request when it is not one
+	if err := gotest.Expect(m.FrameLength(AppendCrc([]byte{0x02, 0x03, 0x02, 0x00, 0x0a}))).Eq(7); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(m.FrameLength(readRequest[:7])).Eq(8); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(m.FrameLength(readRequest[:2])).Eq(0); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(m.FrameLength(AppendCrc([]byte{0x01, 0x03, 0x00, 0x01, 0x00, 0x01}))).Eq(8); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRtuTransport_Monitor(t *testing.T) {
+	setupRtuTransport()
+	defer teardownRtuTransport()
+	config := &Config{Port: "monitor", BaudRate: 9600, SilentInterval: 2 * time.Hour}
+	InoutSerialPort.GetOut(config.Port).Write([]byte{0x01, 0x05, 0x00, 0x01, 0xff, 0x00, 0xdd, 0xfa})
+	InoutSerialPort.GetOut(config.Port).Write([]byte{0x01, 0x05, 0x00, 0x01, 0xff, 0x00, 0xdd, 0xfa})
+	// a write and its response without a silent interval between them
+	InoutSerialPort.GetOut(config.Port).Write(append(
+		AppendCrc([]byte{0x01, 0x10, 0x00, 0x02, 0x00, 0x02, 0x04, 0x12, 0x34, 0x56, 0x78}),
+		AppendCrc([]byte{0x01, 0x10, 0x00, 0x02, 0x00, 0x02})...))
+
+	var records []MonitorRecord
+	var changes []Change
+	mirror := NewDefaultDataModel(&Config{SizeHoldingRegisters: 10})
+	mirror.Watch(func(change Change) { changes = append(changes, change) })
+	rt := NewRtuTransport(config)
+	rt.Monitor = &Monitor{Mirror: mirror, OnRecord: func(r MonitorRecord) { records = append(records, r) }}
+	rt.SetHandler(func(request Request, resp Response) {
+		t.Error("handler called in monitor mode")
+	})
+	_ = rt.Listen()
+
+	if err := gotest.Expect(InoutSerialPort.GetIn(config.Port).Len()).Eq(0); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(len(records)).Eq(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(records[0].Data).Eq([]byte{0x00, 0x01, 0xff, 0x00}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(records[1].Function).Eq(FuncWriteMultipleRegisters); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(changes).Eq([]Change{
+		{Table: TableHoldingRegisters, Address: 2, Value: 0x1234},
+		{Table: TableHoldingRegisters, Address: 3, Value: 0x5678},
+	}); err != nil {
+		t.Error(err)
+	}
+}
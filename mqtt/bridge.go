@@ -0,0 +1,385 @@
+// Package mqtt mirrors the registers of a map to an MQTT broker and writes the
+// commands it receives to the data model. With the prefix mbslave/1:
+//
+//	mbslave/1/setpoint      {"value":20.5,"type":"float32","unit":"°C","scale":0.1,...}
+//	mbslave/1/setpoint/set  21.5 or {"value":21.5}, written to the register
+//	mbslave/1/$status       online or offline, retained
+//
+// The values are published on every change and again after every connect.
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/schnack/mbslave"
+	"github.com/schnack/mbslave/admin"
+	"github.com/sirupsen/logrus"
+)
+
+// Register - a register of the map with its engineering unit
+type Register struct {
+	admin.Register
+	// Unit - e.g. "°C", only published
+	Unit string `json:"unit,omitempty"`
+	// Scale - the value is the decoded register times the scale, 1 without it
+	Scale float64 `json:"scale,omitempty"`
+}
+
+// Payload - the message of a value topic
+type Payload struct {
+	Value   float64          `json:"value"`
+	Type    mbslave.DataType `json:"type"`
+	Unit    string           `json:"unit,omitempty"`
+	Scale   float64          `json:"scale"`
+	Table   mbslave.Table    `json:"table"`
+	Address uint16           `json:"address"`
+	Time    time.Time        `json:"time"`
+}
+
+// Bridge - a mbslave.Service publishing the registers of Map
+type Bridge struct {
+	DataModel *mbslave.DefaultDataModel
+	// Map - the published registers, the names are topic levels without / + #
+	Map map[string]Register
+	// Broker - e.g. "tcp://localhost:1883" or "ssl://broker:8883"
+	Broker   string
+	ClientId string
+	Username string
+	Password string
+	// Prefix - of all topics, "mbslave/<unit>" without it
+	Prefix string
+	// QoS - 0, 1 or 2 for the values, the status and the commands
+	QoS byte
+	// Retain - the broker keeps the last value of every register for new subscribers
+	Retain bool
+	// ReadOnly - the command topics are not subscribed
+	ReadOnly bool
+	// MaxReconnectInterval - the longest wait between attempts to reach the broker, 1 minute without it
+	MaxReconnectInterval time.Duration
+	// Timeout - of a publish, 10 seconds without it
+	Timeout time.Duration
+	Log     mbslave.Logger
+
+	mu      sync.Mutex
+	client  paho.Client
+	cancel  func()
+	dirty   map[string]bool
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	byTable map[mbslave.Table]map[uint16][]string
+}
+
+func NewBridge(dataModel *mbslave.DefaultDataModel, broker string) *Bridge {
+	return &Bridge{
+		DataModel: dataModel,
+		Map:       make(map[string]Register),
+		Broker:    broker,
+		Log:       mbslave.NewLogrusLogger(logrus.StandardLogger()),
+	}
+}
+
+// Start - connects in the background, the connection is retried until Stop
+func (b *Bridge) Start() error {
+	for name := range b.Map {
+		if name == "" || strings.ContainsAny(name, "/+#") {
+			return fmt.Errorf("register %q is not a topic level", name)
+		}
+	}
+	if b.QoS > 2 {
+		return fmt.Errorf("qos %d is not 0, 1 or 2", b.QoS)
+	}
+	b.index()
+
+	options := paho.NewClientOptions().
+		AddBroker(b.Broker).
+		SetClientID(b.clientId()).
+		SetUsername(b.Username).
+		SetPassword(b.Password).
+		SetCleanSession(true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(time.Second).
+		SetMaxReconnectInterval(b.maxReconnectInterval()).
+		SetWill(b.topic("$status"), "offline", b.QoS, true).
+		SetOnConnectHandler(b.connected).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			b.log(mbslave.LevelWarn, "mqtt connection lost", mbslave.FieldError(err))
+		})
+
+	client := paho.NewClient(options)
+	b.mu.Lock()
+	b.client = client
+	b.dirty = make(map[string]bool)
+	b.wake = make(chan struct{}, 1)
+	b.stop = make(chan struct{})
+	b.done = make(chan struct{})
+	b.mu.Unlock()
+	// the watchers of the data model may wait for the lock
+	cancel := b.DataModel.Watch(b.changed)
+	b.mu.Lock()
+	b.cancel = cancel
+	b.mu.Unlock()
+	go b.publisher(client, b.stop, b.done)
+	client.Connect()
+	return nil
+}
+
+// Stop - publishes the offline status and disconnects
+func (b *Bridge) Stop() error {
+	b.mu.Lock()
+	client := b.client
+	if client == nil {
+		b.mu.Unlock()
+		return nil
+	}
+	b.client = nil
+	cancel, stop, done := b.cancel, b.stop, b.done
+	b.mu.Unlock()
+	cancel()
+	close(stop)
+	<-done
+
+	if client.IsConnectionOpen() {
+		client.Publish(b.topic("$status"), b.QoS, true, "offline").WaitTimeout(b.timeout())
+	}
+	client.Disconnect(250)
+	return nil
+}
+
+// connected - subscribes the commands and publishes all values again
+func (b *Bridge) connected(client paho.Client) {
+	b.log(mbslave.LevelInfo, "mqtt connected", mbslave.Field{Key: "broker", Value: b.Broker})
+	client.Publish(b.topic("$status"), b.QoS, true, "online")
+	if !b.ReadOnly {
+		token := client.Subscribe(b.topic("+/set"), b.QoS, b.command)
+		if token.WaitTimeout(b.timeout()) && token.Error() != nil {
+			b.log(mbslave.LevelError, "mqtt subscribe", mbslave.FieldError(token.Error()))
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for name := range b.Map {
+		b.dirty[name] = true
+	}
+	b.signal()
+}
+
+// changed - marks the registers of the address, it runs while the table is locked
+func (b *Bridge) changed(change mbslave.Change) {
+	names := b.byTable[change.Table][change.Address]
+	if len(names) == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, name := range names {
+		b.dirty[name] = true
+	}
+	b.signal()
+}
+
+func (b *Bridge) signal() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// publisher - publishes the marked registers, the words of a register
+// written together are published once
+func (b *Bridge) publisher(client paho.Client, stop, done chan struct{}) {
+	defer close(done)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-b.wake:
+		}
+		b.mu.Lock()
+		names := make([]string, 0, len(b.dirty))
+		for name := range b.dirty {
+			names = append(names, name)
+		}
+		b.dirty = make(map[string]bool)
+		b.mu.Unlock()
+		sort.Strings(names)
+		// a reconnect publishes all values
+		if !client.IsConnectionOpen() {
+			continue
+		}
+		for _, name := range names {
+			b.publish(client, name)
+		}
+	}
+}
+
+func (b *Bridge) publish(client paho.Client, name string) {
+	data, _ := json.Marshal(b.Payload(name))
+	token := client.Publish(b.topic(name), b.QoS, b.Retain, data)
+	if !token.WaitTimeout(b.timeout()) {
+		b.log(mbslave.LevelWarn, "mqtt publish timed out", mbslave.Field{Key: "register", Value: name})
+	} else if err := token.Error(); err != nil {
+		b.log(mbslave.LevelWarn, "mqtt publish", mbslave.Field{Key: "register", Value: name}, mbslave.FieldError(err))
+	}
+}
+
+// Payload - the current value of the register, the read callbacks of the data model are not called
+func (b *Bridge) Payload(name string) Payload {
+	register := b.Map[name]
+	dataType := register.Type
+	if register.Table.IsBit() {
+		dataType = mbslave.TypeBool
+	}
+	words := make([]uint16, dataType.Words())
+	// zero outside the table like Get
+	_ = b.DataModel.Peek(register.Table, register.Address, words)
+	if register.SwapWords {
+		mbslave.SwapWords(words)
+	}
+	value := dataType.Decode(words)
+	if !register.Table.IsBit() {
+		value *= register.scale()
+	}
+	return Payload{
+		Value:   value,
+		Type:    dataType,
+		Unit:    register.Unit,
+		Scale:   register.scale(),
+		Table:   register.Table,
+		Address: register.Address,
+		Time:    time.Now(),
+	}
+}
+
+// command - writes the value of a set topic to its register
+func (b *Bridge) command(_ paho.Client, message paho.Message) {
+	name := strings.TrimSuffix(strings.TrimPrefix(message.Topic(), b.topic("")), "/set")
+	if err := b.Write(name, message.Payload()); err != nil {
+		b.log(mbslave.LevelWarn, "mqtt command rejected",
+			mbslave.Field{Key: "topic", Value: message.Topic()},
+			mbslave.FieldError(err),
+		)
+	}
+}
+
+// Write - writes a payload of a set topic, a number, true, false or {"value": ...}.
+// Only holding registers and coils are written.
+func (b *Bridge) Write(name string, payload []byte) error {
+	register, ok := b.Map[name]
+	if !ok {
+		return fmt.Errorf("unknown register %q", name)
+	}
+	if register.Table != mbslave.TableHoldingRegisters && register.Table != mbslave.TableCoils {
+		return fmt.Errorf("register %q is in %s, only hr and coils are written", name, register.Table)
+	}
+	value, err := parseValue(payload)
+	if err != nil {
+		return err
+	}
+	var words []uint16
+	if register.Table.IsBit() {
+		words = mbslave.TypeBool.Encode(value)
+	} else {
+		words = register.Type.Encode(value / register.scale())
+	}
+	if int(register.Address)+len(words) > b.DataModel.Length(register.Table) {
+		return fmt.Errorf("register %q is outside of the table", name)
+	}
+	if register.SwapWords {
+		mbslave.SwapWords(words)
+	}
+	// the words of a value are written at once, a reader never sees half of it
+	return b.DataModel.SetRange(register.Table, register.Address, words)
+}
+
+func parseValue(payload []byte) (float64, error) {
+	var v interface{}
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return 0, fmt.Errorf("invalid payload %q", payload)
+	}
+	if m, ok := v.(map[string]interface{}); ok {
+		v = m["value"]
+	}
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, errors.New("the payload has no value")
+}
+
+// index - the names of the registers by the addresses of their words
+func (b *Bridge) index() {
+	b.byTable = make(map[mbslave.Table]map[uint16][]string)
+	for name, register := range b.Map {
+		words := register.Type.Words()
+		if register.Table.IsBit() {
+			words = 1
+		}
+		addresses := b.byTable[register.Table]
+		if addresses == nil {
+			addresses = make(map[uint16][]string)
+			b.byTable[register.Table] = addresses
+		}
+		for i := 0; i < words; i++ {
+			address := register.Address + uint16(i)
+			addresses[address] = append(addresses[address], name)
+		}
+	}
+}
+
+func (b *Bridge) topic(name string) string {
+	prefix := b.Prefix
+	if prefix == "" {
+		prefix = fmt.Sprintf("mbslave/%d", b.DataModel.SlaveId)
+	}
+	return prefix + "/" + name
+}
+
+func (b *Bridge) clientId() string {
+	if b.ClientId != "" {
+		return b.ClientId
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("mbslave-%s-%d-%d", host, os.Getpid(), b.DataModel.SlaveId)
+}
+
+func (b *Bridge) maxReconnectInterval() time.Duration {
+	if b.MaxReconnectInterval > 0 {
+		return b.MaxReconnectInterval
+	}
+	return time.Minute
+}
+
+func (b *Bridge) timeout() time.Duration {
+	if b.Timeout > 0 {
+		return b.Timeout
+	}
+	return 10 * time.Second
+}
+
+func (b *Bridge) log(level mbslave.Level, msg string, fields ...mbslave.Field) {
+	if b.Log != nil && b.Log.Enabled(level) {
+		b.Log.Log(level, msg, fields...)
+	}
+}
+
+func (r Register) scale() float64 {
+	if r.Scale == 0 {
+		return 1
+	}
+	return r.Scale
+}
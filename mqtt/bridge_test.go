@@ -0,0 +1,203 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	server "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/schnack/gotest"
+	"github.com/schnack/mbslave"
+	"github.com/schnack/mbslave/admin"
+)
+
+type message struct {
+	topic   string
+	payload string
+}
+
+// startBroker - an embedded broker passing all messages to the channel
+func startBroker(t *testing.T, address string) (*server.Server, string, chan message) {
+	broker := server.New(&server.Options{InlineClient: true, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	_ = broker.AddHook(new(auth.AllowHook), nil)
+	tcp := listeners.NewTCP(listeners.Config{ID: "tcp", Address: address})
+	if err := broker.AddListener(tcp); err != nil {
+		t.Fatal(err)
+	}
+	messages := make(chan message, 64)
+	_ = broker.Subscribe("mbslave/#", 1, func(cl *server.Client, sub packets.Subscription, pk packets.Packet) {
+		messages <- message{pk.TopicName, string(pk.Payload)}
+	})
+	go func() { _ = broker.Serve() }()
+	return broker, tcp.Address(), messages
+}
+
+// next - the next message of the topic, others are skipped
+func next(t *testing.T, messages chan message, topic string) string {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case m := <-messages:
+			if m.topic == topic {
+				return m.payload
+			}
+		case <-timeout:
+			t.Fatalf("no message on %s", topic)
+		}
+	}
+}
+
+func newBridge(address string) (*Bridge, *mbslave.DefaultDataModel) {
+	dm := mbslave.NewDefaultDataModel(&mbslave.Config{SlaveId: 1, SizeCoils: 8, SizeInputRegisters: 8, SizeHoldingRegisters: 8})
+	b := NewBridge(dm, "tcp://"+address)
+	b.Log = mbslave.NopLogger{}
+	b.QoS = 1
+	b.Retain = true
+	b.MaxReconnectInterval = 100 * time.Millisecond
+	b.Map["setpoint"] = Register{Register: admin.Register{Table: mbslave.TableHoldingRegisters, Address: 4, Type: mbslave.TypeFloat32}, Unit: "°C", Scale: 0.1}
+	b.Map["alarm"] = Register{Register: admin.Register{Table: mbslave.TableCoils, Address: 2}}
+	b.Map["temperature"] = Register{Register: admin.Register{Table: mbslave.TableInputRegisters, Address: 0, Type: mbslave.TypeInt16}}
+	return b, dm
+}
+
+func TestBridge(t *testing.T) {
+	broker, address, messages := startBroker(t, "127.0.0.1:0")
+	defer broker.Close()
+	b, dm := newBridge(address)
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gotest.Expect(next(t, messages, "mbslave/1/$status")).Eq("online"); err != nil {
+		t.Error(err)
+	}
+	next(t, messages, "mbslave/1/setpoint")
+
+	// both words of the register are published as one value
+	words := mbslave.TypeFloat32.Encode(215)
+	_ = dm.SetHoldingRegisters(4, words[0])
+	_ = dm.SetHoldingRegisters(5, words[1])
+	var payload Payload
+	for payload.Value != 21.5 {
+		if err := json.Unmarshal([]byte(next(t, messages, "mbslave/1/setpoint")), &payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := gotest.Expect(payload.Unit).Eq("°C"); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(payload.Type).Eq(mbslave.TypeFloat32); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(payload.Scale).Eq(0.1); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(len(broker.Topics.Messages("mbslave/1/setpoint"))).Eq(1); err != nil {
+		t.Error("the value is not retained")
+	}
+
+	// commands are written and published back
+	_ = broker.Publish("mbslave/1/alarm/set", []byte(`{"value": true}`), false, 1)
+	for payload.Value != 1 {
+		_ = json.Unmarshal([]byte(next(t, messages, "mbslave/1/alarm")), &payload)
+	}
+	if err := gotest.Expect(dm.GetCoils(2)).True(); err != nil {
+		t.Error(err)
+	}
+	_ = broker.Publish("mbslave/1/setpoint/set", []byte(`22`), false, 1)
+	for payload.Value != 22 {
+		_ = json.Unmarshal([]byte(next(t, messages, "mbslave/1/setpoint")), &payload)
+	}
+
+	// input registers are not written
+	if err := b.Write("temperature", []byte("5")); err == nil {
+		t.Error("an input register was written")
+	}
+
+	_ = b.Stop()
+	if err := gotest.Expect(next(t, messages, "mbslave/1/$status")).Eq("offline"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBridge_Reconnect(t *testing.T) {
+	broker, address, _ := startBroker(t, "127.0.0.1:0")
+	b, dm := newBridge(address)
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.Now().Add(5 * time.Second)
+	for !b.client.IsConnectionOpen() && time.Now().Before(timeout) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_ = broker.Close()
+
+	// the change while the broker is gone is published after the reconnect
+	_ = dm.SetCoils(2, true)
+	broker, _, messages := startBroker(t, address)
+	defer broker.Close()
+	var payload Payload
+	if err := json.Unmarshal([]byte(next(t, messages, "mbslave/1/alarm")), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(payload.Value).Eq(1.0); err != nil {
+		t.Error(err)
+	}
+	_ = b.Stop()
+}
+
+func TestBridge_Start(t *testing.T) {
+	b, _ := newBridge("127.0.0.1:1883")
+	b.Map["a/b"] = Register{}
+	if err := b.Start(); err == nil {
+		t.Error("a name with a / was accepted")
+	}
+}
+
+// rangeStorage - a SliceStorage recording the lengths of the writes
+type rangeStorage struct {
+	*mbslave.SliceStorage
+	sets []int
+}
+
+func (s *rangeStorage) Set(table mbslave.Table, address uint16, values []uint16) ([]uint16, error) {
+	s.sets = append(s.sets, len(values))
+	return s.SliceStorage.Set(table, address, values)
+}
+
+func TestBridge_Write(t *testing.T) {
+	config := &mbslave.Config{SlaveId: 1, SizeCoils: 8, SizeHoldingRegisters: 8}
+	s := &rangeStorage{SliceStorage: mbslave.NewSliceStorage(config)}
+	b, _ := newBridge("127.0.0.1:1883")
+	b.DataModel = mbslave.NewStorageDataModel(config, s)
+	reads := make(chan uint16, 2)
+	b.DataModel.SetCallbackHoldingRegisters(4, func(event mbslave.Event, addr uint16, value uint16) {
+		if event == mbslave.EventRead {
+			reads <- addr
+		}
+	})
+
+	// both words of the value in one write
+	if err := b.Write("setpoint", []byte("21.5")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(s.sets).Eq([]int{2}); err != nil {
+		t.Error(err)
+	}
+
+	// the payload is no Modbus read
+	if err := gotest.Expect(b.Payload("setpoint").Value).Eq(21.5); err != nil {
+		t.Error(err)
+	}
+	select {
+	case addr := <-reads:
+		t.Errorf("the read callback of %d was called", addr)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,92 @@
+// Package otel adapts an OpenTelemetry tracer to mbslave.Tracer, the fields of
+// the spans become attributes prefixed with "modbus.".
+//
+//	server.SetTracer(otel.NewTracer(provider.Tracer("mbslave")))
+package otel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/schnack/mbslave"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer - mbslave.Tracer starting OpenTelemetry spans
+type Tracer struct {
+	Tracer trace.Tracer
+	// Parent - the context of the parent of a request span when the context of
+	// the transport has no span, e.g. the span of the master in a test. The
+	// context passed to it holds the mbslave.RequestInfo. Optional.
+	Parent func(ctx context.Context) context.Context
+}
+
+func NewTracer(tracer trace.Tracer) *Tracer {
+	return &Tracer{Tracer: tracer}
+}
+
+func (t *Tracer) Start(ctx context.Context, name string, start time.Time, fields ...mbslave.Field) (context.Context, mbslave.Span) {
+	options := []trace.SpanStartOption{trace.WithAttributes(Attributes(fields...)...)}
+	if !start.IsZero() {
+		options = append(options, trace.WithTimestamp(start))
+	}
+	if name == "modbus.request" {
+		options = append(options, trace.WithSpanKind(trace.SpanKindServer))
+		if t.Parent != nil && !trace.SpanContextFromContext(ctx).IsValid() {
+			ctx = trace.ContextWithSpanContext(ctx, trace.SpanContextFromContext(t.Parent(ctx)))
+		}
+	}
+	ctx, span := t.Tracer.Start(ctx, name, options...)
+	return ctx, Span{span}
+}
+
+// Span - mbslave.Span of an OpenTelemetry span
+type Span struct {
+	trace.Span
+}
+
+func (s Span) SetAttributes(fields ...mbslave.Field) {
+	s.Span.SetAttributes(Attributes(fields...)...)
+}
+
+func (s Span) RecordError(err error) {
+	s.Span.RecordError(err)
+	s.Span.SetStatus(codes.Error, err.Error())
+}
+
+func (s Span) End() {
+	s.Span.End()
+}
+
+// Attributes - the fields as "modbus." attributes, values of other types than
+// numbers, strings and booleans are formatted with fmt
+func Attributes(fields ...mbslave.Field) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(fields))
+	for _, f := range fields {
+		key := attribute.Key("modbus." + f.Key)
+		switch v := f.Value.(type) {
+		case string:
+			attrs = append(attrs, key.String(v))
+		case bool:
+			attrs = append(attrs, key.Bool(v))
+		case int:
+			attrs = append(attrs, key.Int(v))
+		case uint8:
+			attrs = append(attrs, key.Int(int(v)))
+		case uint16:
+			attrs = append(attrs, key.Int(int(v)))
+		case int64:
+			attrs = append(attrs, key.Int64(v))
+		case float64:
+			attrs = append(attrs, key.Float64(v))
+		case time.Duration:
+			attrs = append(attrs, key.Float64(v.Seconds()))
+		default:
+			attrs = append(attrs, key.String(fmt.Sprint(v)))
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,135 @@
+package otel
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/schnack/gotest"
+	"github.com/schnack/mbslave"
+	"github.com/schnack/mbslave/client"
+	"go.bug.st/serial"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer := NewTracer(provider.Tracer("test"))
+
+	// the span of the master is the parent of the request span
+	var mu sync.Mutex
+	var masterCtx context.Context
+	tracer.Parent = func(context.Context) context.Context {
+		mu.Lock()
+		defer mu.Unlock()
+		return masterCtx
+	}
+
+	master, slave := mbslave.NewPipeSerialPorts()
+	open := mbslave.OpenSerialPort
+	mbslave.OpenSerialPort = func(*mbslave.Config) (serial.Port, error) {
+		return slave, nil
+	}
+	defer func() { mbslave.OpenSerialPort = open }()
+	config := &mbslave.Config{Port: "pipe", BaudRate: 115200, SlaveId: 0x11, SizeHoldingRegisters: 8}
+	dm := mbslave.NewDefaultDataModel(config)
+	defer dm.Watch(func(mbslave.Change) {})()
+	transport := mbslave.NewRtuTransport(config)
+	transport.Log = mbslave.NopLogger{}
+	server := mbslave.NewServer(transport, dm)
+	server.SetTracer(tracer)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = server.Listen()
+	}()
+	defer func() {
+		_ = master.Close()
+		_ = server.Close()
+		<-done
+	}()
+
+	c := client.NewClient(client.NewRtuTransport(master))
+	c.Timeout = time.Second
+	ctx, span := provider.Tracer("master").Start(context.Background(), "poll")
+	mu.Lock()
+	masterCtx = ctx
+	mu.Unlock()
+	if err := c.WriteSingleRegister(ctx, 0x11, 3, 42); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReadHoldingRegisters(ctx, 0x11, 7, 2); err == nil {
+		t.Error("the read beyond the table succeeded")
+	}
+	span.End()
+	_ = server.Close()
+	<-done
+
+	spans := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = append(spans[s.Name()], s)
+	}
+	for name, count := range map[string]int{"modbus.request": 2, "modbus.frame": 2, "modbus.handle": 2, "modbus.parse": 2, "modbus.function": 2, "modbus.watch": 1, "modbus.write": 2} {
+		if err := gotest.Expect(len(spans[name])).Eq(count); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	parent := func(name string, i int) string {
+		id := spans[name][i].Parent().SpanID()
+		for _, s := range recorder.Ended() {
+			if s.SpanContext().SpanID() == id {
+				return s.Name()
+			}
+		}
+		return ""
+	}
+	for child, want := range map[string]string{
+		"modbus.request":  "poll",
+		"modbus.frame":    "modbus.request",
+		"modbus.handle":   "modbus.request",
+		"modbus.write":    "modbus.request",
+		"modbus.parse":    "modbus.handle",
+		"modbus.function": "modbus.handle",
+		"modbus.watch":    "modbus.function",
+	} {
+		if err := gotest.Expect(parent(child, 0)).Eq(want); err != nil {
+			t.Errorf("%s: %s", child, err)
+		}
+	}
+
+	attrs := func(s sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+		m := make(map[attribute.Key]attribute.Value)
+		for _, kv := range s.Attributes() {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+	request := attrs(spans["modbus.request"][1])
+	if err := gotest.Expect(request["modbus.transport"].AsString()).Eq("rtu"); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(request["modbus.unit"].AsInt64()).Eq(int64(0x11)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(request["modbus.exception"].AsInt64()).Eq(int64(mbslave.ErrorAddress)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(request["modbus.request_bytes"].AsInt64()).Eq(int64(8)); err != nil {
+		t.Error(err)
+	}
+	function := attrs(spans["modbus.function"][0])
+	if err := gotest.Expect([]int64{function["modbus.function"].AsInt64(), function["modbus.address"].AsInt64()}).Eq([]int64{6, 3}); err != nil {
+		t.Error(err)
+	}
+	if spans["modbus.frame"][0].StartTime().After(spans["modbus.frame"][0].EndTime()) {
+		t.Error("the frame span ends before it starts")
+	}
+}
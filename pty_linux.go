@@ -0,0 +1,213 @@
+//go:build linux
+
+package mbslave
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+	"golang.org/x/sys/unix"
+)
+
+// PtyPort - the master end of a pseudo-terminal used as a serial port. Another
+// process opens Path or Link as its serial port. The bytes are delayed to the
+// speed of the baud rate and the character format of the config.
+type PtyPort struct {
+	// Path - the slave end, e.g. /dev/pts/3
+	Path string
+	// Link - symlink to Path, removed by Close
+	Link string
+
+	master *os.File
+	// slave - kept open so the master does not read EIO between clients
+	slave *os.File
+
+	mu     sync.Mutex
+	config Config
+	rxFree time.Time
+	txFree time.Time
+}
+
+// OpenPty - creates a pseudo-terminal in raw mode, link may be empty. A stale
+// symlink at link is replaced, any other file there is an error.
+func OpenPty(link string, config *Config) (*PtyPort, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	p := &PtyPort{Link: link, master: master, config: *config}
+	if err := p.open(); err != nil {
+		_ = master.Close()
+		return nil, err
+	}
+	if link != "" {
+		if err := replaceLink(p.Path, link); err != nil {
+			_ = p.slave.Close()
+			_ = master.Close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// open - unlocks the slave end, opens it and switches it to raw mode
+func (p *PtyPort) open() error {
+	conn, err := p.master.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var number int
+	var ctlErr error
+	// Fd() would switch the master to blocking mode and Close would not interrupt Read
+	err = conn.Control(func(fd uintptr) {
+		if ctlErr = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0); ctlErr != nil {
+			return
+		}
+		number, ctlErr = unix.IoctlGetInt(int(fd), unix.TIOCGPTN)
+	})
+	if err != nil {
+		return err
+	}
+	if ctlErr != nil {
+		return ctlErr
+	}
+	p.Path = "/dev/pts/" + strconv.Itoa(number)
+
+	p.slave, err = os.OpenFile(p.Path, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return err
+	}
+	fd := int(p.slave.Fd())
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		_ = p.slave.Close()
+		return err
+	}
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, termios); err != nil {
+		_ = p.slave.Close()
+		return err
+	}
+	return nil
+}
+
+func replaceLink(target, link string) error {
+	if info, err := os.Lstat(link); err == nil {
+		if info.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("%s exists and is not a symlink", link)
+		}
+		if err := os.Remove(link); err != nil {
+			return err
+		}
+	}
+	return os.Symlink(target, link)
+}
+
+// Read - returns one character, not earlier than its last bit would arrive on the line
+func (p *PtyPort) Read(b []byte) (int, error) {
+	if len(b) > 1 {
+		b = b[:1]
+	}
+	n, err := p.master.Read(b)
+	if n > 0 {
+		time.Sleep(time.Until(p.pace(&p.rxFree)))
+	}
+	return n, err
+}
+
+// Write - passes the characters one by one at the time they would arrive at the
+// other end, it returns after the last one like a drained UART
+func (p *PtyPort) Write(b []byte) (int, error) {
+	for i := range b {
+		time.Sleep(time.Until(p.pace(&p.txFree)))
+		if _, err := p.master.Write(b[i : i+1]); err != nil {
+			return i, err
+		}
+	}
+	return len(b), nil
+}
+
+// pace - the end of the next character on a line free at *free. A line that was
+// free for less than a character is busy, oversleeping does not add up to gaps.
+func (p *PtyPort) pace(free *time.Time) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	char := p.config.TransmissionTime(1)
+	start := time.Now()
+	if start.Sub(*free) < char {
+		start = *free
+	}
+	*free = start.Add(char)
+	return *free
+}
+
+func (p *PtyPort) Close() error {
+	if p.Link != "" {
+		if target, err := os.Readlink(p.Link); err == nil && target == p.Path {
+			_ = os.Remove(p.Link)
+		}
+	}
+	return errors.Join(p.master.Close(), p.slave.Close())
+}
+
+// SetMode - changes the pacing, the other end may use any mode
+func (p *PtyPort) SetMode(mode *serial.Mode) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.config.BaudRate = mode.BaudRate
+	p.config.DataBits = mode.DataBits
+	p.config.Parity = mode.Parity
+	p.config.StopBits = mode.StopBits
+	return nil
+}
+
+func (p *PtyPort) ResetInputBuffer() error {
+	return p.flush(unix.TCIFLUSH)
+}
+
+func (p *PtyPort) ResetOutputBuffer() error {
+	return p.flush(unix.TCOFLUSH)
+}
+
+func (p *PtyPort) flush(queue int) error {
+	conn, err := p.master.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ctlErr error
+	if err := conn.Control(func(fd uintptr) {
+		ctlErr = unix.IoctlSetInt(int(fd), unix.TCFLSH, queue)
+	}); err != nil {
+		return err
+	}
+	return ctlErr
+}
+
+// SetDTR - a pseudo-terminal has no modem lines
+func (p *PtyPort) SetDTR(dtr bool) error {
+	return nil
+}
+
+// SetRTS - a pseudo-terminal has no modem lines
+func (p *PtyPort) SetRTS(rts bool) error {
+	return nil
+}
+
+func (p *PtyPort) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{}, nil
+}
+
+func openPtyPort(link string, config *Config) (serial.Port, error) {
+	return OpenPty(link, config)
+}
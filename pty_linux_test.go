@@ -0,0 +1,84 @@
+//go:build linux
+
+package mbslave
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/schnack/gotest"
+)
+
+func TestRtuTransport_Pty(t *testing.T) {
+	link := filepath.Join(t.TempDir(), "ttyMB0")
+	// the scheduler of a loaded test run may pause longer than 1.5 characters
+	rt := NewRtuTransport(&Config{Port: PtyPrefix + link, BaudRate: 9600, DataBits: 8, StopBits: OneStopBit, SilentInterval: 20 * time.Millisecond, CharTimeout: -1})
+	rt.Log = NopLogger{}
+	rt.SetHandler(func(request Request, resp Response) {
+		_ = request.Parse()
+		resp.SetSingleWrite(request.GetAddress(), request.GetData())
+	})
+	states := make(chan PortState, 2)
+	rt.OnState = func(state PortState, err error) { states <- state }
+	done := make(chan error)
+	go func() { done <- rt.Listen() }()
+	if err := gotest.Expect(<-states).Eq(PortConnected); err != nil {
+		t.Fatal(err)
+	}
+
+	master, err := os.OpenFile(link, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+	_ = master.SetDeadline(time.Now().Add(5 * time.Second))
+
+	request := []byte{0x01, 0x05, 0x00, 0x01, 0xff, 0x00, 0xdd, 0xfa}
+	start := time.Now()
+	if _, err := master.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	response := make([]byte, 0, len(request))
+	b := make([]byte, 16)
+	for len(response) < len(request) {
+		n, err := master.Read(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response = append(response, b[:n]...)
+	}
+	if err := gotest.Expect(response).Eq(request); err != nil {
+		t.Error(err)
+	}
+	// the request and the response on the line take 8.3ms each at 9600 baud
+	if elapsed := time.Since(start); elapsed < 2*rt.TransmissionTime(len(request)) {
+		t.Errorf("the response came after %s", elapsed)
+	}
+
+	_ = rt.Close()
+	<-done
+	if _, err := os.Lstat(link); !os.IsNotExist(err) {
+		t.Error("the symlink was not removed")
+	}
+}
+
+func TestOpenPty_Link(t *testing.T) {
+	link := filepath.Join(t.TempDir(), "ttyMB0")
+	_ = os.WriteFile(link, nil, 0o644)
+	if _, err := OpenPty(link, &Config{}); err == nil {
+		t.Error("a regular file was replaced")
+	}
+
+	_ = os.Remove(link)
+	_ = os.Symlink("/dev/pts/stale", link)
+	p, err := OpenPty(link, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if target, _ := os.Readlink(link); target != p.Path {
+		t.Errorf("the link points to %s instead of %s", target, p.Path)
+	}
+}
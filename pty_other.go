@@ -0,0 +1,13 @@
+//go:build !linux
+
+package mbslave
+
+import (
+	"errors"
+
+	"go.bug.st/serial"
+)
+
+func openPtyPort(link string, config *Config) (serial.Port, error) {
+	return nil, errors.New("pseudo-terminal ports are supported on linux only")
+}
@@ -0,0 +1,132 @@
+package mbslave
+
+import (
+	"os"
+	"time"
+)
+
+// PortState - state of the serial port reported through RtuTransport.OnState
+type PortState int
+
+const (
+	PortDisconnected PortState = iota
+	PortConnected
+)
+
+func (s PortState) String() string {
+	if s == PortConnected {
+		return "connected"
+	}
+	return "disconnected"
+}
+
+// Reconnect - reopening of a lost serial port with exponential backoff
+type Reconnect struct {
+	// MinDelay - pause before the first attempt, 100ms when zero
+	MinDelay time.Duration
+	// MaxDelay - upper bound of the doubled pause, 30s when zero
+	MaxDelay time.Duration
+	// WaitPath - the port is opened only when its path (e.g. /dev/serial/by-id/...) exists,
+	// the path is checked every MinDelay
+	WaitPath bool
+}
+
+func (r *Reconnect) minDelay() time.Duration {
+	if r.MinDelay <= 0 {
+		return 100 * time.Millisecond
+	}
+	return r.MinDelay
+}
+
+func (r *Reconnect) maxDelay() time.Duration {
+	if r.MaxDelay <= 0 {
+		return 30 * time.Second
+	}
+	return r.MaxDelay
+}
+
+// supervise - serves the port and reopens it until Close
+func (rt *RtuTransport) supervise() error {
+	delay := rt.Reconnect.minDelay()
+	for {
+		if rt.Reconnect.WaitPath && !rt.waitPath() {
+			return ErrTransportClosed
+		}
+		opened, err := rt.listen()
+		if rt.isClosed() {
+			if opened {
+				rt.setState(PortDisconnected, nil)
+			}
+			return ErrTransportClosed
+		}
+		if opened {
+			rt.setState(PortDisconnected, err)
+			delay = rt.Reconnect.minDelay()
+		} else if rt.Log.Enabled(LevelDebug) {
+			rt.Log.Log(LevelDebug, "unable to open port", Field{Key: "port", Value: rt.Config.Port}, FieldError(err))
+		}
+
+		if !rt.sleep(delay) {
+			return ErrTransportClosed
+		}
+		if delay *= 2; delay > rt.Reconnect.maxDelay() {
+			delay = rt.Reconnect.maxDelay()
+		}
+	}
+}
+
+// waitPath - waits until the port path exists, false when the transport was closed
+func (rt *RtuTransport) waitPath() bool {
+	for {
+		if _, err := os.Stat(rt.Config.Port); err == nil {
+			return true
+		}
+		if !rt.sleep(rt.Reconnect.minDelay()) {
+			return false
+		}
+	}
+}
+
+// sleep - false when the transport was closed during the pause
+func (rt *RtuTransport) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-rt.stopChan():
+		return false
+	}
+}
+
+func (rt *RtuTransport) stopChan() <-chan struct{} {
+	rt.muPort.Lock()
+	defer rt.muPort.Unlock()
+	if rt.stop == nil {
+		rt.stop = make(chan struct{})
+		if rt.closed {
+			close(rt.stop)
+		}
+	}
+	return rt.stop
+}
+
+func (rt *RtuTransport) isClosed() bool {
+	rt.muPort.Lock()
+	defer rt.muPort.Unlock()
+	return rt.closed
+}
+
+func (rt *RtuTransport) setState(state PortState, err error) {
+	rt.metrics().PortState(state == PortConnected)
+	if rt.Log.Enabled(LevelInfo) {
+		fields := []Field{{Key: "port", Value: rt.Config.Port}, {Key: "state", Value: state.String()}}
+		if err != nil {
+			fields = append(fields, FieldError(err))
+		}
+		rt.Log.Log(LevelInfo, "port state", fields...)
+	}
+	if rt.OnState != nil {
+		rt.OnState(state, err)
+	}
+}
@@ -0,0 +1,111 @@
+package mbslave
+
+import (
+	"errors"
+	"github.com/schnack/gotest"
+	"go.bug.st/serial"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRtuTransport_Reconnect(t *testing.T) {
+	ports := make(chan serial.Port, 1)
+	var attempts int
+	open := OpenSerialPort
+	OpenSerialPort = func(*Config) (serial.Port, error) {
+		attempts++
+		select {
+		case port := <-ports:
+			return port, nil
+		default:
+			return nil, errors.New("no such device")
+		}
+	}
+	defer func() { OpenSerialPort = open }()
+
+	var mu sync.Mutex
+	var states []PortState
+	changed := make(chan struct{}, 10)
+	stats := NewStats(nil)
+
+	rt := NewRtuTransport(&Config{Port: "usb", BaudRate: 115200})
+	rt.Log = NopLogger{}
+	rt.Metrics = stats
+	rt.Reconnect = &Reconnect{MinDelay: time.Millisecond, MaxDelay: 4 * time.Millisecond}
+	rt.OnState = func(state PortState, err error) {
+		mu.Lock()
+		states = append(states, state)
+		mu.Unlock()
+		changed <- struct{}{}
+	}
+	rt.SetHandler(func(request Request, resp Response) {
+		_ = request.Parse()
+		resp.SetSingleWrite(request.GetAddress(), request.GetData())
+	})
+	done := make(chan error)
+	go func() { done <- rt.Listen() }()
+
+	// the device appears and is unplugged
+	master, slave := NewPipeSerialPorts()
+	ports <- slave
+	<-changed
+	_ = master.Close()
+	<-changed
+
+	// and comes back
+	master, slave = NewPipeSerialPorts()
+	ports <- slave
+	<-changed
+	request := []byte{0x01, 0x05, 0x00, 0x01, 0xff, 0x00, 0xdd, 0xfa}
+	if _, err := master.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	response := make([]byte, len(request))
+	for n := 0; n < len(response); {
+		m, err := master.Read(response[n:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += m
+	}
+	if err := gotest.Expect(response).Eq(request); err != nil {
+		t.Error(err)
+	}
+
+	_ = rt.Close()
+	if err := gotest.Expect(<-done).Eq(ErrTransportClosed); err != nil {
+		t.Error(err)
+	}
+	_ = master.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if err := gotest.Expect(states).Eq([]PortState{PortConnected, PortDisconnected, PortConnected, PortDisconnected}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(attempts >= 2).True(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(stats.Snapshot().Disconnects).Eq(uint64(2)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRtuTransport_ReconnectClose(t *testing.T) {
+	open := OpenSerialPort
+	OpenSerialPort = func(*Config) (serial.Port, error) {
+		return nil, errors.New("no such device")
+	}
+	defer func() { OpenSerialPort = open }()
+
+	rt := NewRtuTransport(&Config{Port: "/dev/serial/by-id/missing"})
+	rt.Log = NopLogger{}
+	rt.Reconnect = &Reconnect{MinDelay: time.Hour, WaitPath: true}
+	done := make(chan error)
+	go func() { done <- rt.Listen() }()
+	_ = rt.Close()
+	if err := gotest.Expect(<-done).Eq(ErrTransportClosed); err != nil {
+		t.Error(err)
+	}
+}
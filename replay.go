@@ -0,0 +1,100 @@
+package mbslave
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ReplayMismatch - a request whose response differs from the recorded one.
+// Nil Expected or Got means no response.
+type ReplayMismatch struct {
+	Index    int
+	Request  []byte
+	Expected []byte
+	Got      []byte
+}
+
+func (m ReplayMismatch) String() string {
+	return fmt.Sprintf("request #%d [% x]: expected [% x], got [% x]", m.Index, m.Request, m.Expected, m.Got)
+}
+
+// ReplayReport - result of replaying a capture
+type ReplayReport struct {
+	Requests   int
+	Mismatches []ReplayMismatch
+}
+
+func (r *ReplayReport) Ok() bool {
+	return len(r.Mismatches) == 0
+}
+
+// Replay - feeds the received frames of an RTU or TCP capture into the handler and compares
+// the responses with the transmitted frames that follow them in the capture
+func Replay(r io.Reader, handler func(Request, Response)) (*ReplayReport, error) {
+	reader := NewPcapReader(r)
+	report := &ReplayReport{}
+
+	var pending *ReplayMismatch
+	flush := func() {
+		if pending != nil && !bytes.Equal(pending.Expected, pending.Got) {
+			report.Mismatches = append(report.Mismatches, *pending)
+		}
+		pending = nil
+	}
+
+	for {
+		packet, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, err
+		}
+		newRequest, newResponse := NewRtuRequest, NewRtuResponse
+		switch packet.LinkType {
+		case LinkTypeRtu:
+		case LinkTypeTcp:
+			newRequest, newResponse = NewTcpRequest, NewTcpResponse
+		default:
+			return report, fmt.Errorf("replay: unsupported link type %d", packet.LinkType)
+		}
+
+		switch packet.Direction {
+		case DirectionIn:
+			flush()
+			request := newRequest(packet.Data)
+			response := newResponse(request)
+			handler(request, response)
+			got, err := response.GetADU()
+			if err != nil {
+				got = nil
+			}
+			pending = &ReplayMismatch{Index: report.Requests, Request: packet.Data, Got: got}
+			report.Requests++
+		case DirectionOut:
+			if pending != nil && pending.Expected == nil {
+				pending.Expected = packet.Data
+			}
+		}
+	}
+	flush()
+	return report, nil
+}
+
+// Replay - replays a capture through the middlewares and the data model of the server
+func (s *Server) Replay(r io.Reader) (*ReplayReport, error) {
+	return Replay(r, func(req Request, resp Response) {
+		ctx := WithRequestInfo(context.Background(), RequestInfo{
+			Transport: "replay",
+			Unit:      req.GetSlaveId(),
+			Function:  req.GetFunction(),
+			Received:  time.Now(),
+			Frame:     req.GetADU(),
+			Broadcast: rtuBroadcast(req),
+		})
+		s.handle(ctx, req, resp)
+	})
+}
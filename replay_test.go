@@ -0,0 +1,72 @@
+package mbslave
+
+import (
+	"bytes"
+	"context"
+	"github.com/schnack/gotest"
+	"testing"
+	"time"
+)
+
+func TestReplay(t *testing.T) {
+	dm := NewDefaultDataModel(&Config{SlaveId: 0x01, SizeHoldingRegisters: 10})
+	_ = dm.SetHoldingRegisters(1, 0x1234)
+
+	capture := new(bytes.Buffer)
+	pw, _ := NewPcapWriter(capture, LinkTypeRtu)
+	now := time.Now()
+	// read hr 1 answered correctly
+	_ = pw.Capture(DirectionIn, now, AppendCrc([]byte{0x01, 0x03, 0x00, 0x01, 0x00, 0x01}))
+	_ = pw.Capture(DirectionOut, now, AppendCrc([]byte{0x01, 0x03, 0x02, 0x12, 0x34}))
+	// another unit, no answer expected
+	_ = pw.Capture(DirectionIn, now, AppendCrc([]byte{0x02, 0x03, 0x00, 0x01, 0x00, 0x01}))
+	// recorded answer differs from the current table
+	_ = pw.Capture(DirectionIn, now, AppendCrc([]byte{0x01, 0x03, 0x00, 0x01, 0x00, 0x01}))
+	_ = pw.Capture(DirectionOut, now, AppendCrc([]byte{0x01, 0x03, 0x02, 0x00, 0x00}))
+
+	report, err := NewServer(NewRtuTransport(&Config{}), dm).Replay(capture)
+	if err := gotest.Expect(err).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(report.Requests).Eq(3); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(len(report.Mismatches)).Eq(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(report.Mismatches[0].Index).Eq(2); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(report.Mismatches[0].Got).Eq(AppendCrc([]byte{0x01, 0x03, 0x02, 0x12, 0x34})); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServer_Replay(t *testing.T) {
+	config := &Config{SlaveId: 0x11, SizeHoldingRegisters: 10}
+	capture := new(bytes.Buffer)
+	pw, _ := NewPcapWriter(capture, LinkTypeTcp)
+	_ = pw.Capture(DirectionIn, time.Now(), MbapFrame(1, 0x11, []byte{0x06, 0x00, 0x01, 0x12, 0x34}))
+	_ = pw.Capture(DirectionOut, time.Now(), MbapFrame(1, 0x11, []byte{0x86, ErrorFunction}))
+
+	// the middlewares of the server see the replayed requests
+	server := NewServer(NewTcpTransport(config), NewDefaultDataModel(config))
+	var transports []string
+	server.Use(func(next Handler) Handler {
+		return func(ctx context.Context, req Request, resp Response) {
+			info, _ := RequestInfoFrom(ctx)
+			transports = append(transports, info.Transport)
+			resp.SetError(ErrorFunction)
+		}
+	})
+	report, err := server.Replay(capture)
+	if err := gotest.Expect(err).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(report.Ok()).True(); err != nil {
+		t.Error(report.Mismatches)
+	}
+	if err := gotest.Expect(transports).Eq([]string{"replay"}); err != nil {
+		t.Error(err)
+	}
+}
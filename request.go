@@ -10,5 +10,6 @@ type Request interface {
 	GetCrc() uint16
 	Validate() error
 	GetADU() []byte
+	GetPDU() []byte
 	Parse() error
 }
@@ -7,6 +7,7 @@ type Response interface {
 	GetError() uint8
 	GetData() []byte
 	GetADU() ([]byte, error)
+	GetPDU() ([]byte, error)
 	SetError(errCode uint8)
 	SetRead(data []byte)
 	SetSingleWrite(address uint16, data []byte)
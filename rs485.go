@@ -0,0 +1,104 @@
+package mbslave
+
+import (
+	"time"
+)
+
+// Rs485Pin - modem line that switches a half-duplex adapter to transmit
+type Rs485Pin int
+
+const (
+	// Rs485None - the adapter switches the direction by itself (default)
+	Rs485None Rs485Pin = iota
+	Rs485Rts
+	Rs485Dtr
+)
+
+// Rs485Config - direction control of half-duplex RS-485 adapters
+type Rs485Config struct {
+	// Pin enables the transmitter while a response is written
+	Pin Rs485Pin
+	// ActiveLow drives the pin low to transmit
+	ActiveLow bool
+	// DelayBefore - pause between enabling the transmitter and the first byte
+	DelayBefore time.Duration
+	// DelayAfter - pause between the end of the transmission and releasing the pin
+	DelayAfter time.Duration
+	// DiscardEcho drops our own bytes when the adapter loops TX back to RX
+	DiscardEcho bool
+}
+
+// TransmissionTime - time to send n characters with the configured character format
+func (c *Config) TransmissionTime(n int) time.Duration {
+	if c.BaudRate <= 0 {
+		return 0
+	}
+	dataBits := c.DataBits
+	if dataBits == 0 {
+		dataBits = 8
+	}
+	// in half bits because of 1.5 stop bits
+	halfBits := 2 * (1 + dataBits)
+	if c.Parity != NoParity {
+		halfBits += 2
+	}
+	switch c.StopBits {
+	case OnePointFiveStopBits:
+		halfBits += 3
+	case TwoStopBits:
+		halfBits += 4
+	default:
+		halfBits += 2
+	}
+	return time.Duration(n) * time.Duration(halfBits) * time.Second / time.Duration(2*c.BaudRate)
+}
+
+// write - sends the ADU, switching the direction of a half-duplex line around it
+func (rt *RtuTransport) write(adu []byte) error {
+	rs := rt.Config.Rs485
+	if rs.DiscardEcho {
+		rt.echo = append(rt.echo[:0], adu...)
+	}
+	if rs.Pin == Rs485None {
+		_, err := rt.Port.Write(adu)
+		return err
+	}
+
+	if err := rt.setTransmit(true); err != nil {
+		return err
+	}
+	time.Sleep(rs.DelayBefore)
+	start := time.Now()
+	_, err := rt.Port.Write(adu)
+	// Write returns once the bytes are queued, wait until the last one has left the line
+	time.Sleep(rt.TransmissionTime(len(adu)) - time.Since(start) + rs.DelayAfter)
+	if pinErr := rt.setTransmit(false); err == nil {
+		err = pinErr
+	}
+	return err
+}
+
+// setTransmit - drives the direction pin
+func (rt *RtuTransport) setTransmit(transmit bool) error {
+	level := transmit != rt.Config.Rs485.ActiveLow
+	switch rt.Config.Rs485.Pin {
+	case Rs485Rts:
+		return rt.Port.SetRTS(level)
+	case Rs485Dtr:
+		return rt.Port.SetDTR(level)
+	}
+	return nil
+}
+
+// skipEcho - drops the looped back bytes of the last response
+func (rt *RtuTransport) skipEcho(b byte) bool {
+	if len(rt.echo) == 0 {
+		return false
+	}
+	if rt.echo[0] != b {
+		rt.echo = rt.echo[:0]
+		return false
+	}
+	rt.echo = rt.echo[1:]
+	return true
+}
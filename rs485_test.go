@@ -0,0 +1,51 @@
+package mbslave
+
+import (
+	"github.com/schnack/gotest"
+	"testing"
+	"time"
+)
+
+func TestRtuTransport_TransmissionTime(t *testing.T) {
+	rt := &RtuTransport{Config: &Config{BaudRate: 9600}}
+	if err := gotest.Expect(rt.TransmissionTime(8).String()).Eq("8.333333ms"); err != nil {
+		t.Error(err)
+	}
+	rt = &RtuTransport{Config: &Config{BaudRate: 19200, DataBits: 8, Parity: EvenParity, StopBits: OnePointFiveStopBits}}
+	if err := gotest.Expect(rt.TransmissionTime(2).String()).Eq("1.197916ms"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRtuTransport_Rs485(t *testing.T) {
+	setupRtuTransport()
+	defer teardownRtuTransport()
+	request := []byte{0x01, 0x05, 0x00, 0x01, 0xff, 0x00, 0xdd, 0xfa}
+	config := &Config{
+		Port:           "rs485",
+		BaudRate:       115200,
+		SilentInterval: 2 * time.Hour,
+		Rs485:          Rs485Config{Pin: Rs485Rts, ActiveLow: true, DiscardEcho: true},
+	}
+	// the request and the echo of the response
+	InoutSerialPort.GetOut(config.Port).Write(request)
+	InoutSerialPort.GetOut(config.Port).Write(request)
+
+	rt := NewRtuTransport(config)
+	rt.Log = NopLogger{}
+	rt.SetHandler(func(request Request, resp Response) {
+		_ = request.Parse()
+		resp.SetSingleWrite(request.GetAddress(), request.GetData())
+	})
+	_ = rt.Listen()
+
+	if err := gotest.Expect(InoutSerialPort.GetIn(config.Port).Bytes()).Eq(request); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(InoutSerialPort.Rts[config.Port]).Eq([]bool{true, false, true}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(len(InoutSerialPort.Dtr[config.Port])).Eq(0); err != nil {
+		t.Error(err)
+	}
+}
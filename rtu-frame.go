@@ -0,0 +1,66 @@
+package mbslave
+
+// RtuRequestLength - expected length of a request ADU from its first bytes.
+// Returns 0 when more bytes are needed and -1 for functions with unknown length.
+func RtuRequestLength(adu []byte) int {
+	if len(adu) < 2 {
+		return 0
+	}
+	switch adu[1] {
+	case FuncReadCoils, FuncReadDiscreteInputs, FuncReadHoldingRegisters, FuncReadInputRegisters,
+		FuncWriteSingleCoil, FuncWriteSingleRegister:
+		return 8
+	case FuncWriteMultipleCoils, FuncWriteMultipleRegisters:
+		if len(adu) < 7 {
+			return 0
+		}
+		return 9 + int(adu[6])
+	case FuncMaskWriteRegister:
+		return 10
+	case FuncReadWriteRegisters:
+		if len(adu) < 11 {
+			return 0
+		}
+		return 13 + int(adu[10])
+	}
+	return -1
+}
+
+// RtuResponseLength - expected length of a response ADU from its first bytes.
+// Returns 0 when more bytes are needed and -1 for functions with unknown length.
+func RtuResponseLength(adu []byte) int {
+	if len(adu) < 2 {
+		return 0
+	}
+	if adu[1]&0x80 != 0 {
+		return 5
+	}
+	switch adu[1] {
+	case FuncReadCoils, FuncReadDiscreteInputs, FuncReadHoldingRegisters, FuncReadInputRegisters,
+		FuncReadWriteRegisters:
+		if len(adu) < 3 {
+			return 0
+		}
+		return 5 + int(adu[2])
+	case FuncWriteSingleCoil, FuncWriteSingleRegister, FuncWriteMultipleCoils, FuncWriteMultipleRegisters:
+		return 8
+	case FuncMaskWriteRegister:
+		return 10
+	}
+	return -1
+}
+
+// CheckCrc - the last two bytes of the ADU hold a valid checksum
+func CheckCrc(adu []byte) bool {
+	if len(adu) < 4 {
+		return false
+	}
+	crc := CalcCRC(adu[:len(adu)-2])
+	return adu[len(adu)-2] == byte(crc) && adu[len(adu)-1] == byte(crc>>8)
+}
+
+// AppendCrc - appends the checksum of the frame to it
+func AppendCrc(frame []byte) []byte {
+	crc := CalcCRC(frame)
+	return append(frame, byte(crc), byte(crc>>8))
+}
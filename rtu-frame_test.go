@@ -0,0 +1,51 @@
+package mbslave
+
+import (
+	"github.com/schnack/gotest"
+	"testing"
+)
+
+func TestRtuRequestLength(t *testing.T) {
+	if err := gotest.Expect(RtuRequestLength([]byte{0x01})).Eq(0); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(RtuRequestLength([]byte{0x01, 0x03})).Eq(8); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(RtuRequestLength([]byte{0x01, 0x10, 0x00, 0x01, 0x00})).Eq(0); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(RtuRequestLength([]byte{0x01, 0x10, 0x00, 0x01, 0x00, 0x02, 0x04})).Eq(13); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(RtuRequestLength([]byte{0x01, 0x2b})).Eq(-1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRtuResponseLength(t *testing.T) {
+	if err := gotest.Expect(RtuResponseLength([]byte{0x01, 0x83})).Eq(5); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(RtuResponseLength([]byte{0x01, 0x03})).Eq(0); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(RtuResponseLength([]byte{0x01, 0x03, 0x04})).Eq(9); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(RtuResponseLength([]byte{0x01, 0x10})).Eq(8); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckCrc(t *testing.T) {
+	if err := gotest.Expect(CheckCrc([]byte{0x01, 0x05, 0x00, 0x01, 0xff, 0x00, 0xdd, 0xfa})).True(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(CheckCrc([]byte{0x01, 0x05, 0x00, 0x01, 0xff, 0x00, 0xdd, 0xfb})).False(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(AppendCrc([]byte{0x01, 0x05, 0x00, 0x01, 0xff, 0x00})).Eq([]byte{0x01, 0x05, 0x00, 0x01, 0xff, 0x00, 0xdd, 0xfa}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,129 @@
+package mbslave
+
+import "time"
+
+// MaxRtuAduSize - the largest RTU ADU allowed by the specification
+const MaxRtuAduSize = 256
+
+// RtuFramer - splits the byte stream of a serial line into ADUs.
+// A frame ends as soon as the length expected for its function is received with a valid
+// checksum, so back-to-back frames and adapter latency do not depend on the silent interval.
+// Bytes that do not start a valid frame are dropped one by one until the stream is in sync again.
+type RtuFramer struct {
+	// Length - expected ADU length from its first bytes, RtuRequestLength by default
+	Length func([]byte) int
+	// CharTimeout - the 1.5 character timeout, a longer pause inside a frame ends it.
+	// Zero disables the check.
+	CharTimeout time.Duration
+	// OnDiscard receives the bytes that are not part of a valid frame
+	OnDiscard func(data []byte)
+
+	buf  []byte
+	crc  uint16
+	last time.Time
+}
+
+func NewRtuFramer(charTimeout time.Duration) *RtuFramer {
+	return &RtuFramer{
+		Length:      RtuRequestLength,
+		CharTimeout: charTimeout,
+		crc:         0xffff,
+	}
+}
+
+// Push - adds a byte received at t, returns the frames completed by it
+func (f *RtuFramer) Push(b byte, t time.Time) (frames [][]byte) {
+	if len(f.buf) > 0 && f.CharTimeout > 0 && t.Sub(f.last) > f.CharTimeout {
+		frames = f.scan(true)
+	}
+	f.last = t
+	f.buf = append(f.buf, b)
+	f.crc = (f.crc >> 8) ^ initTableCRC()[(f.crc^uint16(b))&0x00ff]
+	return append(frames, f.scan(false)...)
+}
+
+// Gap - the frame gap (3.5 characters) has elapsed, returns the last frames and drops incomplete ones
+func (f *RtuFramer) Gap() [][]byte {
+	return f.scan(true)
+}
+
+// Buffered - number of bytes waiting for the rest of a frame
+func (f *RtuFramer) Buffered() int {
+	return len(f.buf)
+}
+
+// scan - takes the complete frames from the start of the buffer. When final is set
+// nothing more belongs to the buffered frame, so incomplete frames are dropped.
+func (f *RtuFramer) scan(final bool) (frames [][]byte) {
+	var dropped []byte
+	for len(f.buf) > 0 {
+		n := f.Length(f.buf)
+		switch {
+		case n > MaxRtuAduSize:
+		case n < 0:
+			// unknown function, the frame ends with the gap
+			if !final && len(f.buf) < MaxRtuAduSize {
+				if i := f.resync(); i > 0 {
+					dropped = append(dropped, f.take(i)...)
+					continue
+				}
+				return f.discard(frames, dropped)
+			}
+			if f.valid(len(f.buf)) {
+				frames = append(frames, f.take(len(f.buf)))
+				continue
+			}
+		case n == 0 || n > len(f.buf):
+			if !final {
+				if i := f.resync(); i > 0 {
+					dropped = append(dropped, f.take(i)...)
+					continue
+				}
+				return f.discard(frames, dropped)
+			}
+		case f.valid(n):
+			frames = append(frames, f.take(n))
+			continue
+		}
+		dropped = append(dropped, f.take(1)...)
+	}
+	return f.discard(frames, dropped)
+}
+
+// valid - the first n bytes of the buffer hold a frame with a valid checksum.
+// The running checksum of the whole buffer is zero when the buffer is one valid frame.
+func (f *RtuFramer) valid(n int) bool {
+	if n < 4 {
+		return false
+	}
+	if n == len(f.buf) {
+		return f.crc == 0
+	}
+	return CheckCrc(f.buf[:n])
+}
+
+// resync - offset of a valid frame that ends with the last received byte, 0 when none.
+// It finds a frame behind garbage that does not complete a frame of its own.
+func (f *RtuFramer) resync() int {
+	for i := 1; i <= len(f.buf)-4; i++ {
+		if n := f.Length(f.buf[i:]); i+n == len(f.buf) && CheckCrc(f.buf[i:]) {
+			return i
+		}
+	}
+	return 0
+}
+
+// take - removes the first n bytes from the buffer
+func (f *RtuFramer) take(n int) []byte {
+	frame := append([]byte(nil), f.buf[:n]...)
+	f.buf = append(f.buf[:0], f.buf[n:]...)
+	f.crc = CalcCRC(f.buf)
+	return frame
+}
+
+func (f *RtuFramer) discard(frames [][]byte, dropped []byte) [][]byte {
+	if len(dropped) > 0 && f.OnDiscard != nil {
+		f.OnDiscard(dropped)
+	}
+	return frames
+}
@@ -0,0 +1,196 @@
+package mbslave
+
+import (
+	"github.com/schnack/gotest"
+	"go.bug.st/serial"
+	"io"
+	"testing"
+	"time"
+)
+
+func pushAll(f *RtuFramer, data []byte, t time.Time) (frames [][]byte) {
+	for _, b := range data {
+		frames = append(frames, f.Push(b, t)...)
+	}
+	return
+}
+
+func TestRtuFramer_Push(t *testing.T) {
+	read := AppendCrc([]byte{0x01, 0x03, 0x00, 0x01, 0x00, 0x02})
+	write := AppendCrc([]byte{0x01, 0x10, 0x00, 0x01, 0x00, 0x01, 0x02, 0x12, 0x34})
+	unknown := AppendCrc([]byte{0x01, 0x2b, 0x0e, 0x01, 0x00})
+
+	var discarded []byte
+	f := NewRtuFramer(0)
+	f.OnDiscard = func(data []byte) { discarded = append(discarded, data...) }
+	now := time.Now()
+
+	// back-to-back frames are split without a gap
+	stream := append(append([]byte(nil), read...), write...)
+	frames := pushAll(f, stream, now)
+	if err := gotest.Expect(frames).Eq([][]byte{read, write}); err != nil {
+		t.Error(err)
+	}
+
+	// garbage before a frame is dropped
+	frames = pushAll(f, append([]byte{0x00, 0xff}, read...), now)
+	if err := gotest.Expect(frames).Eq([][]byte{read}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(discarded).Eq([]byte{0x00, 0xff}); err != nil {
+		t.Error(err)
+	}
+
+	// a frame of unknown length ends with the gap
+	frames = pushAll(f, unknown, now)
+	if err := gotest.Expect(len(frames)).Eq(0); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(f.Gap()).Eq([][]byte{unknown}); err != nil {
+		t.Error(err)
+	}
+
+	// a damaged frame is dropped with the gap
+	discarded = nil
+	damaged := append([]byte(nil), read...)
+	damaged[7] ^= 0xff
+	frames = pushAll(f, damaged, now)
+	frames = append(frames, f.Gap()...)
+	if err := gotest.Expect(len(frames)).Eq(0); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(discarded).Eq(damaged); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(f.Buffered()).Eq(0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRtuFramer_CharTimeout(t *testing.T) {
+	read := AppendCrc([]byte{0x01, 0x03, 0x00, 0x01, 0x00, 0x02})
+
+	var discarded []byte
+	f := NewRtuFramer(time.Millisecond)
+	f.OnDiscard = func(data []byte) { discarded = append(discarded, data...) }
+	now := time.Now()
+
+	// the pause inside the first frame interrupts it
+	frames := pushAll(f, read[:4], now)
+	frames = append(frames, pushAll(f, read, now.Add(2*time.Millisecond))...)
+	if err := gotest.Expect(frames).Eq([][]byte{read}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(discarded).Eq(read[:4]); err != nil {
+		t.Error(err)
+	}
+
+	// pauses shorter than the timeout are accepted
+	frames = nil
+	for i, b := range read {
+		frames = append(frames, f.Push(b, now.Add(time.Duration(10+i)*time.Millisecond/2))...)
+	}
+	if err := gotest.Expect(frames).Eq([][]byte{read}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRtuTransport_CharTimeout(t *testing.T) {
+	for baud, expected := range map[int]string{9600: "1.562ms", 115200: "750µs"} {
+		if err := gotest.Expect(RtuCharTimeout(baud).String()).Eq(expected); err != nil {
+			t.Error(err)
+		}
+	}
+	// the 1.5 characters unless set, negative disables the check
+	rt := &RtuTransport{Config: &Config{BaudRate: 9600}}
+	if err := gotest.Expect(rt.CharTimeout()).Eq(1562 * time.Microsecond); err != nil {
+		t.Error(err)
+	}
+	rt.Config.CharTimeout = 5 * time.Millisecond
+	if err := gotest.Expect(rt.CharTimeout()).Eq(5 * time.Millisecond); err != nil {
+		t.Error(err)
+	}
+	rt.Config.CharTimeout = -1
+	if err := gotest.Expect(rt.CharTimeout()).Eq(time.Duration(0)); err != nil {
+		t.Error(err)
+	}
+}
+
+// startPipeServer - an RTU server of the config on an in-memory serial line, the
+// master side is returned
+func startPipeServer(t *testing.T, config *Config, dm DataModel) serial.Port {
+	master, slave := NewPipeSerialPorts()
+	open := OpenSerialPort
+	OpenSerialPort = func(*Config) (serial.Port, error) {
+		return slave, nil
+	}
+	transport := NewRtuTransport(config)
+	transport.Log = NopLogger{}
+	server := NewServer(transport, dm)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = server.Listen()
+	}()
+	t.Cleanup(func() {
+		_ = master.Close()
+		_ = server.Close()
+		<-done
+		OpenSerialPort = open
+	})
+	return master
+}
+
+// readResponse - the next n bytes of the port, nil after the timeout
+func readResponse(port serial.Port, n int, timeout time.Duration) []byte {
+	result := make(chan []byte, 1)
+	go func() {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(port, b); err == nil {
+			result <- b
+		}
+	}()
+	select {
+	case b := <-result:
+		return b
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+func TestRtuTransport_AdapterGaps(t *testing.T) {
+	// a USB adapter delivers a frame in chunks some milliseconds apart,
+	// far more than 1.5 characters at 9600 baud
+	chunked := func(port serial.Port, frame []byte) {
+		for _, chunk := range [][]byte{frame[:3], frame[3:6], frame[6:]} {
+			_, _ = port.Write(chunk)
+			time.Sleep(4 * time.Millisecond)
+		}
+	}
+	config := func() *Config {
+		return &Config{Port: "usb", BaudRate: 9600, SlaveId: 0x11, SilentInterval: 50 * time.Millisecond, CharTimeout: -1, SizeHoldingRegisters: 4}
+	}
+	dm := NewDefaultDataModel(config())
+	_ = dm.SetHoldingRegisters(1, 0xabcd)
+	_ = dm.SetHoldingRegisters(2, 0x1234)
+	readOne := AppendCrc([]byte{0x11, 0x03, 0x00, 0x01, 0x00, 0x01})
+	readTwo := AppendCrc([]byte{0x11, 0x03, 0x00, 0x02, 0x00, 0x01})
+
+	// with the check disabled the frame is complete
+	port := startPipeServer(t, config(), dm)
+	go chunked(port, readOne)
+	if err := gotest.Expect(readResponse(port, 7, time.Second)).Eq(AppendCrc([]byte{0x11, 0x03, 0x02, 0xab, 0xcd})); err != nil {
+		t.Error(err)
+	}
+
+	// with the default 1.5 characters the gaps end the frame, the bytes of one read belong together
+	strict := config()
+	strict.Port = "uart"
+	strict.CharTimeout = 0
+	port = startPipeServer(t, strict, dm)
+	chunked(port, readOne)
+	_, _ = port.Write(readTwo)
+	if err := gotest.Expect(readResponse(port, 7, time.Second)).Eq(AppendCrc([]byte{0x11, 0x03, 0x02, 0x12, 0x34})); err != nil {
+		t.Error(err)
+	}
+}
@@ -2,9 +2,23 @@ package mbslave
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 )
 
+// ErrFrameDamaged - the ADU length does not match the function
+var ErrFrameDamaged = errors.New("frame damaged")
+
+// CrcError - the checksum of the ADU is wrong
+type CrcError struct {
+	Crc  uint16
+	Calc uint16
+}
+
+func (e *CrcError) Error() string {
+	return fmt.Sprintf("crc: 0x%04x, calc: 0x%04x", e.Crc, e.Calc)
+}
+
 type RtuRequest struct {
 	SlaveId   uint8
 	Function  uint8
@@ -24,7 +38,7 @@ func NewRtuRequest(b []byte) Request {
 func (rr *RtuRequest) Parse() error {
 	countFrame := len(rr.raw)
 	if countFrame < 4 {
-		return fmt.Errorf("frame damaged")
+		return ErrFrameDamaged
 	}
 
 	rr.SlaveId = rr.raw[0]
@@ -33,7 +47,7 @@ func (rr *RtuRequest) Parse() error {
 	switch rr.Function {
 	case FuncWriteSingleCoil, FuncWriteSingleRegister:
 		if countFrame != 8 {
-			return fmt.Errorf("frame damaged")
+			return ErrFrameDamaged
 		}
 		rr.Address = binary.BigEndian.Uint16(rr.raw[2:4])
 		rr.Quantity = 1
@@ -42,14 +56,14 @@ func (rr *RtuRequest) Parse() error {
 
 	case FuncWriteMultipleCoils, FuncWriteMultipleRegisters:
 		if countFrame < 7 {
-			return fmt.Errorf("frame damaged")
+			return ErrFrameDamaged
 		}
 		rr.Address = binary.BigEndian.Uint16(rr.raw[2:4])
 		rr.Quantity = binary.BigEndian.Uint16(rr.raw[4:6])
 		rr.CountByte = rr.raw[6]
 
 		if countFrame != (9 + int(rr.CountByte)) {
-			return fmt.Errorf("frame damaged")
+			return ErrFrameDamaged
 		}
 		rr.Data = rr.raw[7 : 7+int(rr.CountByte)]
 
@@ -57,7 +71,7 @@ func (rr *RtuRequest) Parse() error {
 
 	case FuncReadDiscreteInputs, FuncReadCoils, FuncReadInputRegisters, FuncReadHoldingRegisters:
 		if countFrame != 8 {
-			return fmt.Errorf("frame damaged")
+			return ErrFrameDamaged
 		}
 		rr.Address = binary.BigEndian.Uint16(rr.raw[2:4])
 		rr.Quantity = binary.BigEndian.Uint16(rr.raw[4:6])
@@ -113,7 +127,7 @@ func (rr *RtuRequest) GetCrc() uint16 {
 func (rr *RtuRequest) Validate() error {
 	calc := CalcCRC(rr.raw[:len(rr.raw)-2])
 	if rr.GetCrc() != calc {
-		return fmt.Errorf("crc: 0x%04x, calc: 0x%04x", rr.GetCrc(), calc)
+		return &CrcError{Crc: rr.GetCrc(), Calc: calc}
 	}
 	return nil
 }
@@ -121,3 +135,11 @@ func (rr *RtuRequest) Validate() error {
 func (rr *RtuRequest) GetADU() []byte {
 	return rr.raw
 }
+
+// GetPDU - the request without the address and the checksum
+func (rr *RtuRequest) GetPDU() []byte {
+	if len(rr.raw) < 4 {
+		return nil
+	}
+	return rr.raw[1 : len(rr.raw)-2]
+}
@@ -67,7 +67,16 @@ func (rr *RtuResponse) GetData() []byte {
 	return rr.data
 }
 
-func (rr *RtuResponse) GetADU() (b []byte, err error) {
+func (rr *RtuResponse) GetADU() ([]byte, error) {
+	pdu, err := rr.GetPDU()
+	if err != nil {
+		return nil, err
+	}
+	return AppendCrc(append([]byte{rr.slaveId}, pdu...)), nil
+}
+
+// GetPDU - the response without the address and the checksum
+func (rr *RtuResponse) GetPDU() (b []byte, err error) {
 	if rr.unanswered {
 		return nil, fmt.Errorf("not answer")
 	}
@@ -75,7 +84,6 @@ func (rr *RtuResponse) GetADU() (b []byte, err error) {
 	address := make([]byte, 2)
 	binary.BigEndian.PutUint16(address, rr.address)
 
-	b = append(b, rr.slaveId)
 	b = append(b, rr.function)
 	switch rr.function {
 	case FuncReadCoils, FuncReadDiscreteInputs, FuncReadInputRegisters, FuncReadHoldingRegisters:
@@ -92,25 +100,14 @@ func (rr *RtuResponse) GetADU() (b []byte, err error) {
 		} else {
 			return nil, fmt.Errorf("there is no data to answer")
 		}
-	case ExceptionFunction(FuncReadCoils),
-		ExceptionFunction(FuncReadDiscreteInputs),
-		ExceptionFunction(FuncReadHoldingRegisters),
-		ExceptionFunction(FuncReadInputRegisters),
-		ExceptionFunction(FuncWriteSingleCoil),
-		ExceptionFunction(FuncWriteSingleRegister),
-		ExceptionFunction(FuncWriteMultipleCoils),
-		ExceptionFunction(FuncWriteMultipleRegisters):
-		if rr.err != 0 {
+	default:
+		if rr.function&0x80 == 0 {
+			b = append(b, rr.data...)
+		} else if rr.err != 0 {
 			b = append(b, rr.err)
 		} else {
 			return nil, fmt.Errorf("the error cannot be 0")
 		}
-	default:
-		b = append(b, rr.data...)
 	}
-
-	crc := make([]byte, 2)
-	binary.LittleEndian.PutUint16(crc, CalcCRC(b))
-	b = append(b, crc...)
 	return
 }
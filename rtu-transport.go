@@ -2,6 +2,7 @@ package mbslave
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"go.bug.st/serial"
@@ -11,31 +12,101 @@ import (
 
 type RtuTransport struct {
 	*Config
-	handler        func(request Request, response Response)
-	Port           serial.Port
-	Log            logrus.FieldLogger
+	handler Handler
+	Port    serial.Port
+	Log     Logger
+	Metrics Metrics
+	Tracer  Tracer
+	// Capture receives every received and transmitted ADU
+	Capture Capturer
+	// Monitor switches the transport to listen-only mode, the handler is not called
+	// and nothing is written to the port
+	Monitor *Monitor
+	// Reconnect keeps Listen running when the port is lost, nil returns the error
+	Reconnect *Reconnect
+	// OnState receives the changes of the port state, err is the cause of a loss
+	OnState func(state PortState, err error)
+	// Clock times the silent interval and the received bytes, nil is the SystemClock
+	Clock          Clock
 	silentInterval time.Duration
+	// echo - bytes of the last response still expected back from the adapter
+	echo   []byte
+	muPort sync.Mutex
+	closed bool
+	stop   chan struct{}
+	// ctx - cancelled when the port is closed
+	ctx context.Context
 }
 
 func NewRtuTransport(config *Config) *RtuTransport {
 	return &RtuTransport{
 		Config: config,
-		Log:    logrus.StandardLogger(),
+		Log:    NewLogrusLogger(logrus.StandardLogger()),
 	}
 }
 
+func (rt *RtuTransport) SetMetrics(m Metrics) {
+	rt.Metrics = m
+}
+
+func (rt *RtuTransport) SetTracer(t Tracer) {
+	rt.Tracer = t
+}
+
 func (rt *RtuTransport) SetHandler(f func(request Request, response Response)) {
-	rt.handler = f
+	rt.handler = HandlerFunc(f)
+}
+
+// SetContextHandler - the handler receives the RequestInfo of every frame
+func (rt *RtuTransport) SetContextHandler(h Handler) {
+	rt.handler = h
 }
 
-func (rt *RtuTransport) Listen() (exitError error) {
+func (rt *RtuTransport) Listen() error {
 	rt.silentInterval = rt.SilentInterval()
-	var err error
-	if rt.Port, err = OpenSerialPort(rt.Config); err != nil {
-		return err
+	if rt.Reconnect != nil {
+		return rt.supervise()
+	}
+	opened, err := rt.listen()
+	if opened {
+		rt.setState(PortDisconnected, err)
+	}
+	if rt.isClosed() {
+		return ErrTransportClosed
+	}
+	return err
+}
+
+// listen - serves the port until a read error, opened reports whether the port was opened
+func (rt *RtuTransport) listen() (opened bool, exitError error) {
+	port, err := OpenSerialPort(rt.Config)
+	if err != nil {
+		return false, err
+	}
+	rt.muPort.Lock()
+	// Close before or while the port was opened
+	if rt.closed {
+		rt.muPort.Unlock()
+		_ = port.Close()
+		return false, ErrTransportClosed
+	}
+	rt.Port = port
+	rt.muPort.Unlock()
+	defer port.Close()
+	var cancel context.CancelFunc
+	rt.ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	if err := rt.setTransmit(false); err != nil {
+		return true, err
+	}
+	rt.setState(PortConnected, nil)
+	if rt.Log.Enabled(LevelDebug) {
+		rt.Log.Log(LevelDebug, "start listening",
+			Field{Key: "port", Value: rt.Config.Port},
+			Field{Key: "baud", Value: rt.BaudRate},
+			Field{Key: "data_bits", Value: rt.DataBits},
+		)
 	}
-	defer rt.Port.Close()
-	rt.Log.Debugf("start listing %s %d %d", rt.Config.Port, rt.BaudRate, rt.DataBits)
 
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -43,29 +114,52 @@ func (rt *RtuTransport) Listen() (exitError error) {
 	go func() {
 		defer wg.Done()
 
-		// Буфер фрейма
-		buff := new(bytes.Buffer)
-		var muBuff sync.Mutex
+		framer := rt.newFramer()
+		// first - the time of the first byte of the buffered frame
+		var first time.Time
+		push := func(data byte, now time.Time) error {
+			if framer.Buffered() == 0 {
+				first = now
+			}
+			frames := framer.Push(data, now)
+			if len(frames) > 0 && framer.Buffered() > 0 {
+				// the byte started the next frame
+				defer func() { first = now }()
+			}
+			return rt.newFrames(frames, first)
+		}
 
 		cb, ce := rt.readChan(rt.Port)
 
 		for {
 			select {
-			case data := <-cb:
-				muBuff.Lock()
-				buff.WriteByte(data)
-				muBuff.Unlock()
+			case rx := <-cb:
+				if rt.skipEcho(rx.data) {
+					continue
+				}
+				if err := push(rx.data, rx.time); err != nil {
+					exitError = err
+					return
+				}
 			case exitError = <-ce:
 				// Обрабатываем финальный пакет удобно для тестов
-				for data := range cb {
-					muBuff.Lock()
-					buff.WriteByte(data)
-					muBuff.Unlock()
+				for rx := range cb {
+					if !rt.skipEcho(rx.data) {
+						_ = push(rx.data, rx.time)
+					}
+				}
+				_ = rt.newFrames(framer.Gap(), first)
+				if rt.Monitor != nil {
+					rt.Monitor.Flush()
 				}
-				_ = rt.newFrame(buff, muBuff)
 				return
-			case <-time.After(rt.silentInterval):
-				if err := rt.newFrame(buff, muBuff); err != nil {
+			case <-rt.clock().After(rt.silentInterval):
+				if len(cb) > 0 {
+					// the bytes were received before the interval passed
+					continue
+				}
+				rt.echo = rt.echo[:0]
+				if err := rt.newFrames(framer.Gap(), first); err != nil {
 					exitError = err
 					return
 				}
@@ -73,15 +167,38 @@ func (rt *RtuTransport) Listen() (exitError error) {
 		}
 	}()
 	wg.Wait()
-	return
+	return true, exitError
+}
+
+// Close - closes the serial port, Listen returns ErrTransportClosed
+func (rt *RtuTransport) Close() error {
+	rt.muPort.Lock()
+	defer rt.muPort.Unlock()
+	if !rt.closed {
+		rt.closed = true
+		if rt.stop != nil {
+			close(rt.stop)
+		}
+	}
+	if rt.Port == nil {
+		return nil
+	}
+	return rt.Port.Close()
+}
+
+// rxByte - a byte and the time it was read from the port, the bytes of one
+// read share the time
+type rxByte struct {
+	data byte
+	time time.Time
 }
 
-func (*RtuTransport) readChan(port serial.Port) (<-chan byte, <-chan error) {
-	cb := make(chan byte, 256)
+func (rt *RtuTransport) readChan(port serial.Port) (<-chan rxByte, <-chan error) {
+	cb := make(chan rxByte, MaxRtuAduSize)
 	ce := make(chan error)
 
 	go func() {
-		b := make([]byte, 1)
+		b := make([]byte, MaxRtuAduSize)
 		defer close(cb)
 		defer close(ce)
 		for {
@@ -91,7 +208,10 @@ func (*RtuTransport) readChan(port serial.Port) (<-chan byte, <-chan error) {
 				return
 			}
 			if n != 0 {
-				cb <- b[0]
+				now := rt.clock().Now()
+				for _, data := range b[:n] {
+					cb <- rxByte{data, now}
+				}
 			} else {
 				ce <- fmt.Errorf("unable to read data from serial port")
 				return
@@ -102,7 +222,7 @@ func (*RtuTransport) readChan(port serial.Port) (<-chan byte, <-chan error) {
 }
 
 // getFrame - синхронизирует буфер
-func (*RtuTransport) getFrame(buff *bytes.Buffer, mu sync.Mutex) []byte {
+func (*RtuTransport) getFrame(buff *bytes.Buffer, mu *sync.Mutex) []byte {
 	mu.Lock()
 	defer mu.Unlock()
 	if buff.Len() == 0 {
@@ -112,47 +232,155 @@ func (*RtuTransport) getFrame(buff *bytes.Buffer, mu sync.Mutex) []byte {
 	return buff.Bytes()
 }
 
-func (rt *RtuTransport) newFrame(buff *bytes.Buffer, muBuff sync.Mutex) error {
-	adu := rt.getFrame(buff, muBuff)
-	if len(adu) == 0 {
+// newFramer - in monitor mode the frames are requests or the responses the monitor expects
+func (rt *RtuTransport) newFramer() *RtuFramer {
+	framer := NewRtuFramer(rt.CharTimeout())
+	if rt.Monitor != nil {
+		framer.Length = rt.Monitor.FrameLength
+	}
+	framer.OnDiscard = func(data []byte) {
+		rt.metrics().CrcError()
+		if rt.Log.Enabled(LevelDebug) {
+			rt.Log.Log(LevelDebug, "discarded", FieldRaw(data))
+		}
+		if rt.Monitor != nil {
+			rt.Monitor.Discard(rt.clock().Now(), data)
+		}
+	}
+	return framer
+}
+
+// newFrames - first is the time of the first byte, the frame span of the frames
+// after the first one is too long
+func (rt *RtuTransport) newFrames(frames [][]byte, first time.Time) error {
+	for _, adu := range frames {
+		if err := rt.handleFrame(adu, first); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rt *RtuTransport) handleFrame(adu []byte, first time.Time) error {
+	metrics := rt.metrics()
+	metrics.FrameReceived(len(adu))
+	if rt.Monitor != nil {
+		if first.IsZero() {
+			first = rt.clock().Now()
+		}
+		rt.Monitor.Feed(first, adu)
 		return nil
 	}
+	rt.capture(DirectionIn, adu)
 
 	request := NewRtuRequest(adu)
-	rt.Log.Debugf("<- in  raw(%03d): [% x]", len(adu), adu)
+	if rt.Log.Enabled(LevelDebug) {
+		rt.Log.Log(LevelDebug, "<- in", FieldRaw(adu))
+	}
 
 	response := NewRtuResponse(request)
 
-	if rt.handler != nil {
-		rt.handler(request, response)
-	}
-	rt.Log.Debugf("request   id: %02x func: %02x addr: %04x quat: %04x size: %02x data: [% x] crc: %04x",
-		request.GetSlaveId(),
-		request.GetFunction(),
-		request.GetAddress(),
-		request.GetQuantity(),
-		request.GetCountByte(),
-		request.GetData(),
-		request.GetCrc(),
-	)
-
-	if adu, err := response.GetADU(); err == nil {
-		rt.Log.Debugf("response  id: %02x func: %02x addr: %04x data: [% x] err: %02x",
-			response.GetSlaveId(),
-			response.GetFunction(),
-			response.GetAddress(),
-			response.GetData(),
-			response.GetError(),
+	start := rt.clock().Now()
+	if first.IsZero() || first.After(start) {
+		first = start
+	}
+	ctx := WithRequestInfo(rt.ctx, RequestInfo{
+		Transport: "rtu",
+		Local:     rt.Config.Port,
+		Unit:      request.GetSlaveId(),
+		Function:  request.GetFunction(),
+		Received:  start,
+		Frame:     adu,
+		Broadcast: rtuBroadcast(request),
+	})
+	ctx, span := startRequestSpan(ctx, rt.tracer(), "rtu", len(adu), request, first)
+	defer span.End()
+	if err := request.Parse(); err != nil {
+		// counted whatever unit the frame is for
+		countFrameError(metrics, err)
+		span.RecordError(err)
+		response.Unanswered(true)
+	} else if rt.handler != nil {
+		ctx, handleSpan := rt.tracer().Start(ctx, "modbus.handle", time.Time{})
+		rt.handler(ctx, request, response)
+		handleSpan.End()
+	}
+	duration := rt.clock().Now().Sub(start)
+
+	if rt.Log.Enabled(LevelDebug) {
+		rt.Log.Log(LevelDebug, "request",
+			FieldUnit(request.GetSlaveId()),
+			FieldFunction(request.GetFunction()),
+			FieldAddress(request.GetAddress()),
+			FieldQuantity(request.GetQuantity()),
+			Field{Key: "count_byte", Value: request.GetCountByte()},
+			Field{Key: "data", Value: Hex(request.GetData())},
+			Field{Key: "crc", Value: request.GetCrc()},
+			FieldDuration(duration),
 		)
-		n, err := rt.Port.Write(adu)
-		if err != nil {
-			return err
-		}
-		rt.Log.Debugf("-> out raw(%03d): [% x]", n, adu)
+	}
+
+	adu, err := response.GetADU()
+	if err != nil {
+		metrics.Unanswered(request.GetSlaveId())
+		span.SetAttributes(Field{Key: "unanswered", Value: true})
+		return nil
+	}
+	span.SetAttributes(FieldException(response.GetError()), FieldBytes("response_bytes", len(adu)))
+	if rt.Log.Enabled(LevelDebug) {
+		rt.Log.Log(LevelDebug, "response",
+			FieldUnit(response.GetSlaveId()),
+			FieldFunction(response.GetFunction()),
+			FieldAddress(response.GetAddress()),
+			Field{Key: "data", Value: Hex(response.GetData())},
+			FieldException(response.GetError()),
+		)
+	}
+	_, writeSpan := rt.tracer().Start(ctx, "modbus.write", time.Time{})
+	err = rt.write(adu)
+	writeSpan.End()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	metrics.FrameSent(len(adu))
+	rt.capture(DirectionOut, adu)
+	if rt.Log.Enabled(LevelDebug) {
+		rt.Log.Log(LevelDebug, "-> out", FieldRaw(adu))
 	}
 	return nil
 }
 
+func (rt *RtuTransport) capture(direction Direction, adu []byte) {
+	if rt.Capture == nil {
+		return
+	}
+	if err := rt.Capture.Capture(direction, rt.clock().Now(), adu); err != nil && rt.Log.Enabled(LevelError) {
+		rt.Log.Log(LevelError, "capture failed", FieldError(err))
+	}
+}
+
+func (rt *RtuTransport) clock() Clock {
+	if rt.Clock == nil {
+		return SystemClock{}
+	}
+	return rt.Clock
+}
+
+func (rt *RtuTransport) tracer() Tracer {
+	if rt.Tracer == nil {
+		return NopTracer{}
+	}
+	return rt.Tracer
+}
+
+func (rt *RtuTransport) metrics() Metrics {
+	if rt.Metrics == nil {
+		return NopMetrics{}
+	}
+	return rt.Metrics
+}
+
 func (rt *RtuTransport) SilentInterval() (frameDelay time.Duration) {
 	if rt.Config.SilentInterval.Nanoseconds() != 0 {
 		frameDelay = rt.Config.SilentInterval
@@ -163,3 +391,23 @@ func (rt *RtuTransport) SilentInterval() (frameDelay time.Duration) {
 	}
 	return
 }
+
+// CharTimeout - the longest pause between two bytes of one frame, zero when disabled
+func (rt *RtuTransport) CharTimeout() time.Duration {
+	switch {
+	case rt.Config.CharTimeout > 0:
+		return rt.Config.CharTimeout
+	case rt.Config.CharTimeout < 0:
+		return 0
+	}
+	return RtuCharTimeout(rt.BaudRate)
+}
+
+// RtuCharTimeout - 1.5 characters at the baud rate, fixed above 19200 baud as
+// the specification recommends. The default of Config.CharTimeout.
+func RtuCharTimeout(baudRate int) time.Duration {
+	if baudRate <= 0 || baudRate > 19200 {
+		return 750 * time.Microsecond
+	}
+	return time.Duration(15000000/baudRate) * time.Microsecond
+}
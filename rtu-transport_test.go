@@ -2,6 +2,7 @@ package mbslave
 
 import (
 	"bytes"
+	"context"
 	"github.com/schnack/gotest"
 	"github.com/sirupsen/logrus"
 	"sync"
@@ -31,11 +32,11 @@ func TestRtuTransport_Listen(t *testing.T) {
 	rt := &RtuTransport{
 		Config: config,
 		Port:   port,
-		handler: func(request Request, resp Response) {
+		handler: HandlerFunc(func(request Request, resp Response) {
 			_ = request.Parse()
 			resp.SetSingleWrite(request.GetAddress(), request.GetData())
-		},
-		Log: logrus.StandardLogger(),
+		}),
+		Log: NewLogrusLogger(logrus.StandardLogger()),
 	}
 
 	if err := gotest.Expect(rt.Listen()).Error("EOF"); err != nil {
@@ -48,11 +49,74 @@ func TestRtuTransport_Listen(t *testing.T) {
 
 }
 
+func TestRtuTransport_handleFrame(t *testing.T) {
+	stats := NewStats(nil)
+	dm := NewDefaultDataModel(&Config{SlaveId: 0x01, SizeCoils: 8})
+	rt := &RtuTransport{
+		Config:  &Config{},
+		Log:     NopLogger{},
+		Metrics: stats,
+		ctx:     context.Background(),
+	}
+	NewServer(nil, dm).AddTransport(rt, TransportOptions{Units: []uint8{0x01}})
+
+	// the damaged frames of other units are counted too
+	for _, adu := range [][]byte{
+		{0x02, 0x01, 0x00, 0x00, 0x00, 0x08, 0x3d, 0xcd},
+		{0x02, 0x01, 0x00, 0x00, 0x00, 0x3d, 0xcc},
+		{0x01, 0x01, 0x00, 0x00, 0x00, 0x08, 0x3d, 0xcd},
+	} {
+		if err := gotest.Expect(rt.handleFrame(adu, time.Time{})).NotError(); err != nil {
+			t.Error(err)
+		}
+	}
+	snapshot := stats.Snapshot()
+	if err := gotest.Expect(snapshot.CrcErrors).Eq(uint64(2)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(snapshot.ParseErrors).Eq(uint64(1)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRtuTransport_Broadcast(t *testing.T) {
+	stats := NewStats(nil)
+	dm := NewDefaultDataModel(&Config{SlaveId: 0x01, SizeHoldingRegisters: 8})
+	dm.Metrics = stats
+	// nothing may be written, the transport has no port
+	rt := &RtuTransport{Config: &Config{}, Log: NopLogger{}, Metrics: stats, ctx: context.Background()}
+	rt.SetContextHandler(dm.HandleContext)
+
+	adu := AppendCrc([]byte{0x00, 0x06, 0x00, 0x02, 0x00, 0x07})
+	if err := gotest.Expect(rt.handleFrame(adu, time.Time{})).NotError(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(dm.GetHoldingRegisters(2)).Eq(uint16(7)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(stats.Snapshot().Broadcasts).Eq(uint64(1)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRtuTransport_CloseBeforeListen(t *testing.T) {
+	setupRtuTransport()
+	defer teardownRtuTransport()
+	rt := NewRtuTransport(&Config{Port: "closed", BaudRate: 9600})
+	rt.Log = NopLogger{}
+	if err := gotest.Expect(rt.Close()).NotError(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(rt.Listen()).Eq(ErrTransportClosed); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestRtuTransport_getFrame(t *testing.T) {
 	var mu sync.Mutex
 	buff := bytes.NewBuffer([]byte{0x01, 0x02})
 
-	if err := gotest.Expect((&RtuTransport{}).getFrame(buff, mu)).Eq([]byte{1, 2}); err != nil {
+	if err := gotest.Expect((&RtuTransport{}).getFrame(buff, &mu)).Eq([]byte{1, 2}); err != nil {
 		t.Error(err)
 	}
 	if err := gotest.Expect(buff.Bytes()).Eq([]byte{}); err != nil {
@@ -0,0 +1,164 @@
+package script
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/schnack/mbslave"
+	"go.starlark.net/starlark"
+)
+
+// predeclared - the builtins available to scripts, the caller holds e.mu
+func (e *Engine) predeclared(state *starlark.Dict) starlark.StringDict {
+	return starlark.StringDict{
+		"state":     state,
+		"get":       starlark.NewBuiltin("get", e.builtinGet),
+		"set":       starlark.NewBuiltin("set", e.builtinSet),
+		"after":     starlark.NewBuiltin("after", e.builtinSchedule),
+		"every":     starlark.NewBuiltin("every", e.builtinSchedule),
+		"cancel":    starlark.NewBuiltin("cancel", e.builtinCancel),
+		"exception": starlark.NewBuiltin("exception", builtinException),
+		"log":       starlark.NewBuiltin("log", e.builtinLog),
+	}
+}
+
+func (e *Engine) builtinGet(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	var address int
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &name, &address); err != nil {
+		return nil, err
+	}
+	table, err := mbslave.ParseTable(name)
+	if err != nil {
+		return nil, err
+	}
+	if address < 0 || address > 0xffff {
+		return nil, fmt.Errorf("%s: invalid address %d", b.Name(), address)
+	}
+
+	switch table {
+	case mbslave.TableDiscreteInputs:
+		return starlark.Bool(e.DataModel.GetDiscreteInputs(uint16(address))), nil
+	case mbslave.TableCoils:
+		return starlark.Bool(e.DataModel.GetCoils(uint16(address))), nil
+	case mbslave.TableInputRegisters:
+		return starlark.MakeInt(int(e.DataModel.GetInputRegisters(uint16(address)))), nil
+	}
+	return starlark.MakeInt(int(e.DataModel.GetHoldingRegisters(uint16(address)))), nil
+}
+
+func (e *Engine) builtinSet(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	var address int
+	var value starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 3, &name, &address, &value); err != nil {
+		return nil, err
+	}
+	table, err := mbslave.ParseTable(name)
+	if err != nil {
+		return nil, err
+	}
+	if address < 0 || address > 0xffff {
+		return nil, fmt.Errorf("%s: invalid address %d", b.Name(), address)
+	}
+
+	if table.IsBit() {
+		set := e.DataModel.SetCoils
+		if table == mbslave.TableDiscreteInputs {
+			set = e.DataModel.SetDiscreteInputs
+		}
+		return starlark.None, set(uint16(address), bool(value.Truth()))
+	}
+
+	var register int
+	switch v := value.(type) {
+	case starlark.Int:
+		if err := starlark.AsInt(v, &register); err != nil {
+			return nil, err
+		}
+	case starlark.Float:
+		register = int(v)
+	case starlark.Bool:
+		if v {
+			register = 1
+		}
+	default:
+		return nil, fmt.Errorf("%s: want int, got %s", b.Name(), value.Type())
+	}
+	if register < -0x8000 || register > 0xffff {
+		return nil, fmt.Errorf("%s: value %d does not fit a register", b.Name(), register)
+	}
+	set := e.DataModel.SetHoldingRegisters
+	if table == mbslave.TableInputRegisters {
+		set = e.DataModel.SetInputRegisters
+	}
+	return starlark.None, set(uint16(address), uint16(register))
+}
+
+// builtinSchedule - after(seconds, fn, *args) and every(seconds, fn, *args), returns the timer id
+func (e *Engine) builtinSchedule(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(kwargs) != 0 {
+		return nil, fmt.Errorf("%s: unexpected keyword arguments", b.Name())
+	}
+	if len(args) < 2 {
+		return nil, fmt.Errorf("%s: want seconds and function", b.Name())
+	}
+	seconds, ok := starlark.AsFloat(args[0])
+	if !ok || seconds < 0 {
+		return nil, fmt.Errorf("%s: invalid interval %s", b.Name(), args[0])
+	}
+	fn, ok := args[1].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s is not callable", b.Name(), args[1].Type())
+	}
+
+	delay := time.Duration(seconds * float64(time.Second))
+	var period time.Duration
+	if b.Name() == "every" {
+		if delay <= 0 {
+			return nil, fmt.Errorf("%s: interval must be positive", b.Name())
+		}
+		period = delay
+	}
+	return starlark.MakeInt64(e.schedule(delay, period, fn, args[2:])), nil
+}
+
+func (e *Engine) builtinCancel(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var id int64
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &id); err != nil {
+		return nil, err
+	}
+	if t, ok := e.timers[id]; ok {
+		t.t.Stop()
+		delete(e.timers, id)
+		return starlark.True, nil
+	}
+	return starlark.False, nil
+}
+
+// builtinException - aborts the hook, the request is answered with the exception code
+func builtinException(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var code int
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &code); err != nil {
+		return nil, err
+	}
+	if code <= 0 || code > 0xff {
+		return nil, fmt.Errorf("%s: invalid code %d", b.Name(), code)
+	}
+	thread.SetLocal(exceptionKey, uint8(code))
+	return nil, fmt.Errorf("modbus exception %02x", code)
+}
+
+func (e *Engine) builtinLog(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		if s, ok := starlark.AsString(arg); ok {
+			parts[i] = s
+		} else {
+			parts[i] = arg.String()
+		}
+	}
+	e.Log.Log(mbslave.LevelInfo, strings.Join(parts, " "), mbslave.Field{Key: "script", Value: e.Path})
+	return starlark.None, nil
+}
@@ -0,0 +1,370 @@
+// Package script emulates device logic with Starlark scripts running against a DefaultDataModel.
+//
+// A script may define the hooks
+//
+//	def on_read(table, address, quantity): ...
+//	def on_write(table, address, values): ...
+//
+// which are called before the request is served. Tables are passed as "di", "coils", "ir" and "hr".
+// The builtins get(table, address), set(table, address, value), after(seconds, fn, *args),
+// every(seconds, fn, *args), cancel(id), exception(code) and log(*args) are available,
+// as well as the mutable dict state that survives between calls (but not reloads).
+package script
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/schnack/mbslave"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+const exceptionKey = "exception"
+
+// DefaultMaxSteps - the Starlark steps of a call when Engine.MaxSteps is zero
+const DefaultMaxSteps = 1000000
+
+// Engine - loads a script file, hooks it into the data model function handlers
+// and reloads it when the file changes. Engine implements mbslave.Service.
+type Engine struct {
+	DataModel *mbslave.DefaultDataModel
+	Path      string
+	Log       mbslave.Logger
+	// How often the file is checked for changes, zero disables hot reload
+	PollInterval time.Duration
+	// MaxSteps - the Starlark steps a load, hook or timer call may take before it is
+	// cancelled as a script error, DefaultMaxSteps without it. The hooks run on the
+	// request path, an endless loop would stop every transport.
+	MaxSteps uint64
+
+	mu       sync.Mutex
+	globals  starlark.StringDict
+	state    *starlark.Dict
+	modTime  time.Time
+	timers   map[int64]*timer
+	timerId  int64
+	original map[uint8]mbslave.Handler
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+type timer struct {
+	t      *time.Timer
+	period time.Duration
+}
+
+func NewEngine(dataModel *mbslave.DefaultDataModel, path string) *Engine {
+	return &Engine{
+		DataModel:    dataModel,
+		Path:         path,
+		Log:          mbslave.NewLogrusLogger(logrus.StandardLogger()),
+		PollInterval: time.Second,
+	}
+}
+
+// Load - compiles and executes the script file. On error the previously
+// loaded script stays active.
+func (e *Engine) Load() error {
+	info, err := os.Stat(e.Path)
+	if err != nil {
+		return err
+	}
+	src, err := os.ReadFile(e.Path)
+	if err != nil {
+		return err
+	}
+	return e.load(src, info.ModTime())
+}
+
+// LoadSource - executes the script from memory, used for tests and embedded scripts
+func (e *Engine) LoadSource(src string) error {
+	return e.load([]byte(src), time.Time{})
+}
+
+func (e *Engine) load(src []byte, modTime time.Time) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// timers scheduled by the new script are kept apart until it loads successfully
+	previous := e.timers
+	e.timers = nil
+
+	state := starlark.NewDict(0)
+	globals, err := starlark.ExecFileOptions(&syntax.FileOptions{
+		While:           true,
+		TopLevelControl: true,
+		GlobalReassign:  true,
+		Recursion:       true,
+	}, e.thread("load"), e.Path, src, e.predeclared(state))
+	e.modTime = modTime
+	if err == nil {
+		err = checkHooks(globals)
+	}
+	if err != nil {
+		e.cancelTimers()
+		e.timers = previous
+		return err
+	}
+
+	e.timers, previous = previous, e.timers
+	e.cancelTimers()
+	e.timers = previous
+	globals.Freeze()
+	e.globals = globals
+	e.state = state
+	return nil
+}
+
+func checkHooks(globals starlark.StringDict) error {
+	for _, name := range []string{"on_read", "on_write"} {
+		if hook, ok := globals[name]; ok {
+			if _, ok := hook.(starlark.Callable); !ok {
+				return fmt.Errorf("%s is not a function", name)
+			}
+		}
+	}
+	return nil
+}
+
+// Start - loads the script, installs the hooks and starts watching the file
+func (e *Engine) Start() error {
+	if err := e.Load(); err != nil {
+		return err
+	}
+	e.install()
+	if e.PollInterval <= 0 {
+		return nil
+	}
+
+	e.mu.Lock()
+	e.stop = make(chan struct{})
+	e.done = make(chan struct{})
+	stop, done := e.stop, e.done
+	e.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(e.PollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				e.reload()
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop - removes the hooks, cancels the timers and stops watching the file
+func (e *Engine) Stop() error {
+	e.mu.Lock()
+	stop, done := e.stop, e.done
+	e.stop, e.done = nil, nil
+	e.cancelTimers()
+	e.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	e.uninstall()
+	return nil
+}
+
+func (e *Engine) reload() {
+	info, err := os.Stat(e.Path)
+	if err != nil {
+		e.error(err)
+		return
+	}
+	e.mu.Lock()
+	changed := !info.ModTime().Equal(e.modTime)
+	e.mu.Unlock()
+	if !changed {
+		return
+	}
+	if err := e.Load(); err != nil {
+		e.error(err)
+		return
+	}
+	e.Log.Log(mbslave.LevelInfo, "script reloaded", mbslave.Field{Key: "script", Value: e.Path})
+}
+
+// install - wraps the function handlers of the data model
+func (e *Engine) install() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.original != nil {
+		return
+	}
+	e.original = make(map[uint8]mbslave.Handler)
+	for _, code := range []uint8{
+		mbslave.FuncReadCoils,
+		mbslave.FuncReadDiscreteInputs,
+		mbslave.FuncReadHoldingRegisters,
+		mbslave.FuncReadInputRegisters,
+		mbslave.FuncWriteSingleCoil,
+		mbslave.FuncWriteSingleRegister,
+		mbslave.FuncWriteMultipleCoils,
+		mbslave.FuncWriteMultipleRegisters,
+	} {
+		next := e.DataModel.GetContextFunction(code)
+		if next == nil {
+			continue
+		}
+		e.original[code] = next
+		e.DataModel.SetContextFunction(code, e.wrap(next))
+	}
+}
+
+func (e *Engine) uninstall() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for code, f := range e.original {
+		e.DataModel.SetContextFunction(code, f)
+	}
+	e.original = nil
+}
+
+// wrap - the context of the transport reaches next, so the changes keep their source
+func (e *Engine) wrap(next mbslave.Handler) mbslave.Handler {
+	return func(ctx context.Context, req mbslave.Request, resp mbslave.Response) {
+		if code := e.hook(req); code != 0 {
+			resp.SetError(code)
+			return
+		}
+		next(ctx, req, resp)
+	}
+}
+
+// hook - calls on_read or on_write and returns the exception raised by the script
+func (e *Engine) hook(req mbslave.Request) uint8 {
+	var table mbslave.Table
+	var values *starlark.List
+	name := "on_read"
+
+	switch req.GetFunction() {
+	case mbslave.FuncReadCoils:
+		table = mbslave.TableCoils
+	case mbslave.FuncReadDiscreteInputs:
+		table = mbslave.TableDiscreteInputs
+	case mbslave.FuncReadHoldingRegisters:
+		table = mbslave.TableHoldingRegisters
+	case mbslave.FuncReadInputRegisters:
+		table = mbslave.TableInputRegisters
+	case mbslave.FuncWriteSingleCoil, mbslave.FuncWriteMultipleCoils:
+		table, name = mbslave.TableCoils, "on_write"
+		values = coilValues(req)
+	case mbslave.FuncWriteSingleRegister, mbslave.FuncWriteMultipleRegisters:
+		table, name = mbslave.TableHoldingRegisters, "on_write"
+		values = registerValues(req)
+	default:
+		return 0
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	fn, ok := e.globals[name].(starlark.Callable)
+	if !ok {
+		return 0
+	}
+	args := starlark.Tuple{starlark.String(table.String()), starlark.MakeInt(int(req.GetAddress()))}
+	if values != nil {
+		args = append(args, values)
+	} else {
+		args = append(args, starlark.MakeInt(int(req.GetQuantity())))
+	}
+
+	thread := e.thread(name)
+	if _, err := starlark.Call(thread, fn, args, nil); err != nil {
+		if code, ok := thread.Local(exceptionKey).(uint8); ok {
+			return code
+		}
+		e.error(err)
+	}
+	return 0
+}
+
+func coilValues(req mbslave.Request) *starlark.List {
+	data := req.GetData()
+	if req.GetFunction() == mbslave.FuncWriteSingleCoil {
+		return starlark.NewList([]starlark.Value{starlark.Bool(len(data) > 1 && binary.BigEndian.Uint16(data) != 0)})
+	}
+	values := make([]starlark.Value, 0, req.GetQuantity())
+	for i := 0; i < int(req.GetQuantity()) && i/8 < len(data); i++ {
+		values = append(values, starlark.Bool(data[i/8]>>(i%8)&0x01 == 1))
+	}
+	return starlark.NewList(values)
+}
+
+func registerValues(req mbslave.Request) *starlark.List {
+	data := req.GetData()
+	values := make([]starlark.Value, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		values = append(values, starlark.MakeInt(int(binary.BigEndian.Uint16(data[i:i+2]))))
+	}
+	return starlark.NewList(values)
+}
+
+func (e *Engine) error(err error) {
+	e.Log.Log(mbslave.LevelError, "script error", mbslave.Field{Key: "script", Value: e.Path}, mbslave.FieldError(err))
+}
+
+func (e *Engine) thread(name string) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			e.Log.Log(mbslave.LevelInfo, msg, mbslave.Field{Key: "script", Value: e.Path})
+		},
+	}
+	maxSteps := e.MaxSteps
+	if maxSteps == 0 {
+		maxSteps = DefaultMaxSteps
+	}
+	thread.SetMaxExecutionSteps(maxSteps)
+	return thread
+}
+
+// cancelTimers - the caller holds e.mu
+func (e *Engine) cancelTimers() {
+	for id, t := range e.timers {
+		t.t.Stop()
+		delete(e.timers, id)
+	}
+}
+
+// schedule - the caller holds e.mu
+func (e *Engine) schedule(delay, period time.Duration, fn starlark.Callable, args starlark.Tuple) int64 {
+	if e.timers == nil {
+		e.timers = make(map[int64]*timer)
+	}
+	e.timerId++
+	id := e.timerId
+	t := &timer{period: period}
+	t.t = time.AfterFunc(delay, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.timers[id] != t {
+			return
+		}
+		if t.period > 0 {
+			t.t.Reset(t.period)
+		} else {
+			delete(e.timers, id)
+		}
+		if _, err := starlark.Call(e.thread("timer"), fn, args, nil); err != nil {
+			e.error(err)
+		}
+	})
+	e.timers[id] = t
+	return id
+}
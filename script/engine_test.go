@@ -0,0 +1,193 @@
+package script
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/schnack/gotest"
+	"github.com/schnack/mbslave"
+)
+
+const testScript = `
+def on_write(table, address, values):
+    if table == "coils" and address == 0:
+        set("di", 0, values[0])
+        if values[0]:
+            state["speed"] = 0
+            every(0.001, ramp)
+
+def on_read(table, address, quantity):
+    if table == "hr" and address == 9:
+        exception(2)
+
+def ramp():
+    state["speed"] += 100
+    set("ir", 0, state["speed"])
+`
+
+func newDataModel() *mbslave.DefaultDataModel {
+	return mbslave.NewDefaultDataModel(&mbslave.Config{
+		SlaveId:              0x01,
+		SizeDiscreteInputs:   16,
+		SizeCoils:            16,
+		SizeInputRegisters:   16,
+		SizeHoldingRegisters: 16,
+	})
+}
+
+func adu(pdu ...byte) []byte {
+	crc := make([]byte, 2)
+	binary.LittleEndian.PutUint16(crc, mbslave.CalcCRC(pdu))
+	return append(pdu, crc...)
+}
+
+func call(dm mbslave.DataModel, frame []byte) mbslave.Response {
+	request := mbslave.NewRtuRequest(frame)
+	response := mbslave.NewRtuResponse(request)
+	dm.Handler(request, response)
+	return response
+}
+
+func TestEngine_hooks(t *testing.T) {
+	dm := newDataModel()
+	engine := NewEngine(dm, "test.star")
+	engine.PollInterval = 0
+	if err := gotest.Expect(engine.LoadSource(testScript)).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	engine.install()
+	defer engine.Stop()
+
+	resp := call(dm, adu(0x01, mbslave.FuncWriteSingleCoil, 0x00, 0x00, 0xff, 0x00))
+	if err := gotest.Expect(resp.GetError()).Eq(uint8(0)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(dm.GetDiscreteInputs(0)).True(); err != nil {
+		t.Error(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if dm.GetInputRegisters(0) == 0 {
+		t.Error("speed is not ramping")
+	}
+
+	resp = call(dm, adu(0x01, mbslave.FuncReadHoldingRegisters, 0x00, 0x09, 0x00, 0x01))
+	if err := gotest.Expect(resp.GetError()).Eq(mbslave.ErrorAddress); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(resp.GetFunction()).Eq(mbslave.ExceptionFunction(mbslave.FuncReadHoldingRegisters)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEngine_Source(t *testing.T) {
+	dm := newDataModel()
+	engine := NewEngine(dm, "test.star")
+	engine.PollInterval = 0
+	if err := engine.LoadSource(testScript); err != nil {
+		t.Fatal(err)
+	}
+	engine.install()
+	defer engine.Stop()
+
+	var changes []mbslave.Change
+	cancel := dm.Watch(func(change mbslave.Change) { changes = append(changes, change) })
+	defer cancel()
+
+	ctx := mbslave.WithRequestInfo(context.Background(), mbslave.RequestInfo{Transport: "tcp", Remote: "192.0.2.1:49152"})
+	request := mbslave.NewRtuRequest(adu(0x01, mbslave.FuncWriteSingleRegister, 0x00, 0x01, 0x00, 0x2a))
+	dm.HandleContext(ctx, request, mbslave.NewRtuResponse(request))
+
+	if err := gotest.Expect(len(changes)).Eq(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(changes[0].Source).NotNil(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(changes[0].Source.Remote).Eq("192.0.2.1:49152"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEngine_LoadSource(t *testing.T) {
+	engine := NewEngine(newDataModel(), "test.star")
+	if err := gotest.Expect(engine.LoadSource("on_read = 1")).Error("on_read is not a function"); err != nil {
+		t.Error(err)
+	}
+	if err := engine.LoadSource("def on_read(t, a, q):\n    pass\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.LoadSource("def broken(:"); err == nil {
+		t.Error("expected syntax error")
+	}
+	if err := gotest.Expect(engine.globals["on_read"]).NotNil(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEngine_MaxSteps(t *testing.T) {
+	dm := newDataModel()
+	engine := NewEngine(dm, "test.star")
+	engine.PollInterval = 0
+	engine.MaxSteps = 10000
+	var buf bytes.Buffer
+	engine.Log = mbslave.NewStdLogger(log.New(&buf, "", 0), mbslave.LevelError)
+	if err := engine.LoadSource("while True:\n    pass\n"); err == nil {
+		t.Error("an endless load was not cancelled")
+	}
+	if err := engine.LoadSource("def on_read(t, a, q):\n    while True:\n        pass\n"); err != nil {
+		t.Fatal(err)
+	}
+	engine.install()
+	defer engine.Stop()
+
+	// the request is served and the cancelled hook is reported
+	resp := call(dm, adu(0x01, mbslave.FuncReadHoldingRegisters, 0x00, 0x00, 0x00, 0x01))
+	if err := gotest.Expect(resp.GetError()).Eq(uint8(0)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(bytes.Contains(buf.Bytes(), []byte("too many steps"))).True(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEngine_reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "device.star")
+	if err := os.WriteFile(path, []byte("def on_read(t, a, q):\n    exception(4)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dm := newDataModel()
+	engine := NewEngine(dm, path)
+	engine.PollInterval = time.Millisecond
+	if err := engine.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Stop()
+
+	frame := adu(0x01, mbslave.FuncReadInputRegisters, 0x00, 0x00, 0x00, 0x01)
+	if err := gotest.Expect(call(dm, frame).GetError()).Eq(mbslave.ErrorFatal); err != nil {
+		t.Error(err)
+	}
+
+	// a broken script keeps the previous one active
+	modTime := time.Now().Add(time.Second)
+	_ = os.WriteFile(path, []byte("def on_read(:"), 0644)
+	_ = os.Chtimes(path, modTime, modTime)
+	time.Sleep(20 * time.Millisecond)
+	if err := gotest.Expect(call(dm, frame).GetError()).Eq(mbslave.ErrorFatal); err != nil {
+		t.Error(err)
+	}
+
+	modTime = modTime.Add(time.Second)
+	_ = os.WriteFile(path, []byte("def on_read(t, a, q):\n    pass\n"), 0644)
+	_ = os.Chtimes(path, modTime, modTime)
+	time.Sleep(20 * time.Millisecond)
+	if err := gotest.Expect(call(dm, frame).GetError()).Eq(uint8(0)); err != nil {
+		t.Error(err)
+	}
+}
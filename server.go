@@ -1,8 +1,38 @@
 package mbslave
 
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Service - a component started together with the server and stopped when it exits
+type Service interface {
+	Start() error
+	Stop() error
+}
+
+// TransportOptions - restrictions of the requests served through one transport
+type TransportOptions struct {
+	// Units accepted by the transport, requests for other units are not answered.
+	// All units are accepted when empty.
+	Units []uint8
+	// ReadOnly answers the write functions with ErrorFunction. Set Units as well
+	// on a bus shared with other slaves, otherwise their writes are answered too.
+	ReadOnly bool
+}
+
 type Server struct {
 	DataModel DataModel
-	Transport Transport
+	// Transport - the transport passed to NewServer, see AddTransport for more
+	Transport   Transport
+	transports  []Transport
+	services    []Service
+	middlewares []Middleware
+
+	mu      sync.Mutex
+	closing bool
 }
 
 func NewRtuServer(config *Config) *Server {
@@ -10,14 +40,222 @@ func NewRtuServer(config *Config) *Server {
 	return NewServer(transport, NewDefaultDataModel(config))
 }
 
+func NewTcpServer(config *Config) *Server {
+	transport := NewTcpTransport(config)
+	return NewServer(transport, NewDefaultDataModel(config))
+}
+
+// NewServer - the transport may be nil when all transports are added with AddTransport
 func NewServer(transport Transport, dataModel DataModel) *Server {
-	transport.SetHandler(dataModel.Handler)
-	return &Server{
+	s := &Server{
 		DataModel: dataModel,
 		Transport: transport,
 	}
+	if transport != nil {
+		setHandler(transport, s.handle)
+		setRejectHandler(transport, s.rejected)
+	}
+	return s
+}
+
+// AddTransport - serves the data model through one more transport, all transports run concurrently
+func (s *Server) AddTransport(transport Transport, options TransportOptions) {
+	setHandler(transport, options.units(func(ctx context.Context, req Request, resp Response) {
+		chain(options.readOnly(DataModelHandler(s.DataModel)), s.middlewares)(ctx, req, resp)
+	}))
+	setRejectHandler(transport, s.rejected)
+	s.transports = append(s.transports, transport)
+}
+
+// Use - wraps the handler of the data model for all transports. Requests of other
+// units reach the middlewares unless the TransportOptions filter them, the writes
+// rejected by ReadOnly and the requests a transport rejects by itself reach them
+// with the exception set. Call it before Listen.
+func (s *Server) Use(middlewares ...Middleware) {
+	s.middlewares = append(s.middlewares, middlewares...)
+}
+
+func (s *Server) handle(ctx context.Context, req Request, resp Response) {
+	chain(DataModelHandler(s.DataModel), s.middlewares)(ctx, req, resp)
+}
+
+// rejected - the middlewares see the requests answered by the transport, the
+// exception it set stays in the response
+func (s *Server) rejected(ctx context.Context, req Request, resp Response) {
+	chain(func(context.Context, Request, Response) {}, s.middlewares)(ctx, req, resp)
+}
+
+// setHandler - transports without SetContextHandler get context.Background
+func setHandler(transport Transport, h Handler) {
+	if ct, ok := transport.(ContextTransport); ok {
+		ct.SetContextHandler(h)
+		return
+	}
+	transport.SetHandler(func(req Request, resp Response) {
+		h(context.Background(), req, resp)
+	})
+}
+
+func setRejectHandler(transport Transport, h Handler) {
+	if rt, ok := transport.(RejectingTransport); ok {
+		rt.SetRejectHandler(h)
+	}
+}
+
+// Transports - all transports of the server
+func (s *Server) Transports() []Transport {
+	if s.Transport == nil {
+		return s.transports
+	}
+	return append([]Transport{s.Transport}, s.transports...)
+}
+
+// AddService - registers a service, it is started by Listen before the transport
+func (s *Server) AddService(service Service) {
+	s.services = append(s.services, service)
+}
+
+// SetTracer - passes the tracer to the transports and the data model
+func (s *Server) SetTracer(t Tracer) {
+	targets := []interface{}{s.DataModel}
+	for _, transport := range s.Transports() {
+		targets = append(targets, transport)
+	}
+	for _, target := range targets {
+		if tr, ok := target.(interface{ SetTracer(Tracer) }); ok {
+			tr.SetTracer(t)
+		}
+	}
 }
 
-func (s *Server) Listen() error {
-	return s.Transport.Listen()
+// SetMetrics - passes the metrics hook to the transport and the data model
+func (s *Server) SetMetrics(m Metrics) {
+	targets := []interface{}{s.DataModel}
+	for _, transport := range s.Transports() {
+		targets = append(targets, transport)
+	}
+	for _, target := range targets {
+		if t, ok := target.(interface{ SetMetrics(Metrics) }); ok {
+			t.SetMetrics(m)
+		}
+	}
+}
+
+func (s *Server) Listen() (err error) {
+	for i, service := range s.services {
+		if err := service.Start(); err != nil {
+			s.stopServices(s.services[:i])
+			return err
+		}
+	}
+	defer s.stopServices(s.services)
+
+	s.mu.Lock()
+	s.closing = false
+	s.mu.Unlock()
+
+	transports := s.Transports()
+	if len(transports) == 1 {
+		err := transports[0].Listen()
+		if s.isClosing() {
+			// the error of a closed port
+			return nil
+		}
+		return err
+	}
+
+	// the first transport that stops closes the others
+	errs := make([]error, len(transports))
+	var wg sync.WaitGroup
+	for i, transport := range transports {
+		wg.Add(1)
+		go func(i int, transport Transport) {
+			defer wg.Done()
+			err := transport.Listen()
+			if !s.isClosing() {
+				errs[i] = err
+				_ = s.Close()
+			}
+		}(i, transport)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Close - stops all transports that implement io.Closer, Listen returns nil after that
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+
+	var errs []error
+	for _, transport := range s.Transports() {
+		if closer, ok := transport.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Server) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+func (s *Server) stopServices(services []Service) {
+	for i := len(services) - 1; i >= 0; i-- {
+		_ = services[i].Stop()
+	}
+}
+
+// units - the requests for other units are not answered
+func (o TransportOptions) units(next Handler) Handler {
+	if len(o.Units) == 0 {
+		return next
+	}
+	return func(ctx context.Context, req Request, resp Response) {
+		if !o.accepts(req.GetSlaveId()) {
+			resp.Unanswered(true)
+			return
+		}
+		next(ctx, req, resp)
+	}
+}
+
+// readOnly - the writes are answered with ErrorFunction
+func (o TransportOptions) readOnly(next Handler) Handler {
+	if !o.ReadOnly {
+		return next
+	}
+	return func(ctx context.Context, req Request, resp Response) {
+		if isWriteFunction(req.GetFunction()) {
+			// broadcasts are dropped, a response would collide on the bus
+			if err := req.Parse(); err != nil || IsBroadcast(ctx, req) {
+				resp.Unanswered(true)
+				return
+			}
+			resp.SetError(ErrorFunction)
+			return
+		}
+		next(ctx, req, resp)
+	}
+}
+
+func (o TransportOptions) accepts(unit uint8) bool {
+	for _, u := range o.Units {
+		if u == unit {
+			return true
+		}
+	}
+	return false
+}
+
+func isWriteFunction(function uint8) bool {
+	switch function {
+	case FuncWriteSingleCoil, FuncWriteSingleRegister, FuncWriteMultipleCoils, FuncWriteMultipleRegisters,
+		FuncMaskWriteRegister, FuncReadWriteRegisters:
+		return true
+	}
+	return false
 }
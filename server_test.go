@@ -0,0 +1,160 @@
+package mbslave
+
+import (
+	"context"
+	"github.com/schnack/gotest"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func tcpRequest(t *testing.T, addr net.Addr, pdu []byte) []byte {
+	conn, err := net.DialTimeout("tcp", addr.String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write(MbapFrame(1, 0x11, pdu)); err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, MbapHeaderSize)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatal(err)
+	}
+	mbap, _ := ParseMbapHeader(header)
+	resp := make([]byte, int(mbap.Length)-1)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestServer_AddTransport(t *testing.T) {
+	config := &Config{Address: "127.0.0.1:0", SlaveId: 0x11, SizeHoldingRegisters: 10}
+	a := NewTcpTransport(config)
+	a.Log = NopLogger{}
+	b := NewTcpTransport(config)
+	b.Log = NopLogger{}
+
+	server := NewServer(a, NewDefaultDataModel(config))
+	server.AddTransport(b, TransportOptions{ReadOnly: true})
+	if err := gotest.Expect(len(server.Transports())).Eq(2); err != nil {
+		t.Error(err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- server.Listen()
+	}()
+
+	resp := tcpRequest(t, a.Addr(), []byte{0x06, 0x00, 0x01, 0x12, 0x34})
+	if err := gotest.Expect(resp).Eq([]byte{0x06, 0x00, 0x01, 0x12, 0x34}); err != nil {
+		t.Error(err)
+	}
+	resp = tcpRequest(t, b.Addr(), []byte{0x03, 0x00, 0x01, 0x00, 0x01})
+	if err := gotest.Expect(resp).Eq([]byte{0x03, 0x02, 0x12, 0x34}); err != nil {
+		t.Error(err)
+	}
+	resp = tcpRequest(t, b.Addr(), []byte{0x06, 0x00, 0x01, 0x00, 0x00})
+	if err := gotest.Expect(resp).Eq([]byte{0x86, ErrorFunction}); err != nil {
+		t.Error(err)
+	}
+
+	if err := gotest.Expect(server.Close()).NotError(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(<-done).NotError(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServer_ListenError(t *testing.T) {
+	config := &Config{Address: "127.0.0.1:0", SlaveId: 0x11}
+	good := NewTcpTransport(config)
+	good.Log = NopLogger{}
+	bad := NewTcpTransport(&Config{Address: "256.0.0.1:0"})
+	bad.Log = NopLogger{}
+
+	server := NewServer(nil, NewDefaultDataModel(config))
+	server.AddTransport(good, TransportOptions{})
+	server.AddTransport(bad, TransportOptions{})
+	if err := server.Listen(); err == nil {
+		t.Error("expected an error of the bad transport")
+	}
+}
+
+// listenUntil - a Transport without Close, Listen returns when the channel is closed
+type listenUntil chan struct{}
+
+func (l listenUntil) Listen() error                      { <-l; return nil }
+func (l listenUntil) SetHandler(func(Request, Response)) {}
+
+func TestServer_Close(t *testing.T) {
+	config := &Config{Address: "127.0.0.1:0", SlaveId: 0x11}
+	tcp := NewTcpTransport(config)
+	tcp.Log = NopLogger{}
+	stop := make(listenUntil)
+	server := NewServer(stop, NewDefaultDataModel(config))
+	server.AddTransport(tcp, TransportOptions{})
+	done := make(chan error)
+	go func() {
+		done <- server.Listen()
+	}()
+	tcpRequest(t, tcp.Addr(), []byte{0x03, 0x00, 0x00, 0x00, 0x00})
+
+	// the transports without Close are skipped
+	if err := gotest.Expect(server.Close()).NotError(); err != nil {
+		t.Error(err)
+	}
+	close(stop)
+	if err := gotest.Expect(<-done).NotError(); err != nil {
+		t.Error(err)
+	}
+}
+
+// handlerTransport - a Transport keeping the handler the server sets
+type handlerTransport struct {
+	handler Handler
+}
+
+func (*handlerTransport) Listen() error                        { return nil }
+func (*handlerTransport) SetHandler(func(Request, Response))   {}
+func (ht *handlerTransport) SetContextHandler(handler Handler) { ht.handler = handler }
+
+func TestServer_AddTransportOptions(t *testing.T) {
+	dm := NewDefaultDataModel(&Config{SlaveId: 0x11, SizeHoldingRegisters: 10})
+	server := NewServer(nil, dm)
+	// the units are filtered before the middlewares, ReadOnly behind them
+	var seen []uint8
+	server.Use(func(next Handler) Handler {
+		return func(ctx context.Context, req Request, resp Response) {
+			next(ctx, req, resp)
+			seen = append(seen, resp.GetError())
+		}
+	})
+	transport := &handlerTransport{}
+	server.AddTransport(transport, TransportOptions{Units: []uint8{0x11, 0xff}, ReadOnly: true})
+	handler := transport.handler
+
+	for _, tc := range []struct {
+		request  []byte
+		response []byte
+	}{
+		{AppendCrc([]byte{0x11, 0x03, 0x00, 0x01, 0x00, 0x01}), AppendCrc([]byte{0x11, 0x03, 0x02, 0x00, 0x00})},
+		{AppendCrc([]byte{0x11, 0x06, 0x00, 0x01, 0x00, 0x01}), AppendCrc([]byte{0x11, 0x86, ErrorFunction})},
+		{AppendCrc([]byte{0x12, 0x03, 0x00, 0x01, 0x00, 0x01}), nil},
+		{AppendCrc([]byte{0xff, 0x06, 0x00, 0x01, 0x00, 0x01}), nil},
+	} {
+		request := NewRtuRequest(tc.request)
+		response := NewRtuResponse(request)
+		handler(context.Background(), request, response)
+		adu, _ := response.GetADU()
+		if err := gotest.Expect(adu).Eq(tc.response); err != nil {
+			t.Errorf("% x: %s", tc.request, err)
+		}
+	}
+	if err := gotest.Expect(seen).Eq([]uint8{0, ErrorFunction, 0}); err != nil {
+		t.Error(err)
+	}
+}
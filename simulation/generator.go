@@ -0,0 +1,209 @@
+package simulation
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Generator - produces a value for the time elapsed since the simulator start
+type Generator interface {
+	Value(t time.Duration) float64
+}
+
+// seeder - generators that use random numbers get a seed from the simulator
+type seeder interface {
+	seed(seed int64)
+}
+
+// Constant - always returns the same value
+type Constant float64
+
+func (c Constant) Value(time.Duration) float64 {
+	return float64(c)
+}
+
+// Ramp - rises linearly from From to To during Period and starts again
+type Ramp struct {
+	From   float64
+	To     float64
+	Period time.Duration
+}
+
+func (r *Ramp) Value(t time.Duration) float64 {
+	return r.From + (r.To-r.From)*phase(t, r.Period)
+}
+
+// Sine - Offset + Amplitude*sin(2*pi*t/Period + Phase)
+type Sine struct {
+	Amplitude float64
+	Offset    float64
+	Period    time.Duration
+	// Phase in radians
+	Phase float64
+}
+
+func (s *Sine) Value(t time.Duration) float64 {
+	return s.Offset + s.Amplitude*math.Sin(2*math.Pi*phase(t, s.Period)+s.Phase)
+}
+
+// Square - High during the Duty part of the Period, Low otherwise.
+// Zero Duty means 0.5.
+type Square struct {
+	Low    float64
+	High   float64
+	Period time.Duration
+	Duty   float64
+}
+
+func (s *Square) Value(t time.Duration) float64 {
+	duty := s.Duty
+	if duty <= 0 {
+		duty = 0.5
+	}
+	if phase(t, s.Period) < duty {
+		return s.High
+	}
+	return s.Low
+}
+
+// Counter - adds Step every Period and rolls over to Start after Max
+type Counter struct {
+	Start  float64
+	Step   float64
+	Max    float64
+	Period time.Duration
+}
+
+func (c *Counter) Value(t time.Duration) float64 {
+	if c.Period <= 0 || c.Step == 0 {
+		return c.Start
+	}
+	ticks := float64(t / c.Period)
+	span := c.Max - c.Start + c.Step
+	if span <= 0 {
+		return c.Start
+	}
+	return c.Start + math.Mod(ticks*c.Step, span)
+}
+
+// RandomWalk - every call moves the value by a random amount within [-Step, Step]
+// and keeps it between Min and Max. With a fixed Seed the sequence is reproducible,
+// a zero Seed is replaced by the simulator one.
+type RandomWalk struct {
+	Start float64
+	Step  float64
+	Min   float64
+	Max   float64
+	Seed  int64
+
+	mu      sync.Mutex
+	rnd     *rand.Rand
+	current float64
+}
+
+func (rw *RandomWalk) seed(seed int64) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.Seed == 0 {
+		rw.Seed = seed
+	}
+	rw.rnd = nil
+}
+
+func (rw *RandomWalk) Value(time.Duration) float64 {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.rnd == nil {
+		rw.rnd = rand.New(rand.NewSource(rw.Seed))
+		rw.current = rw.Start
+		return rw.current
+	}
+	rw.current += (rw.rnd.Float64()*2 - 1) * rw.Step
+	if rw.Max > rw.Min {
+		rw.current = math.Max(rw.Min, math.Min(rw.Max, rw.current))
+	}
+	return rw.current
+}
+
+// Sample - one point of a recorded time series
+type Sample struct {
+	At    time.Duration
+	Value float64
+}
+
+// Replay - plays back a time series holding each value until the next sample
+type Replay struct {
+	Samples []Sample
+	Loop    bool
+}
+
+// LoadCSV - reads "seconds,value" rows, a header row and blank lines are skipped
+func LoadCSV(r io.Reader) (*Replay, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	replay := &Replay{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 2 || strings.HasPrefix(record[0], "#") {
+			continue
+		}
+		at, errAt := strconv.ParseFloat(record[0], 64)
+		value, errValue := strconv.ParseFloat(record[1], 64)
+		if errAt != nil || errValue != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: invalid sample %q", line, strings.Join(record, ","))
+		}
+		replay.Samples = append(replay.Samples, Sample{
+			At:    time.Duration(at * float64(time.Second)),
+			Value: value,
+		})
+	}
+	if len(replay.Samples) == 0 {
+		return nil, fmt.Errorf("no samples")
+	}
+	sort.SliceStable(replay.Samples, func(i, j int) bool {
+		return replay.Samples[i].At < replay.Samples[j].At
+	})
+	return replay, nil
+}
+
+func (r *Replay) Value(t time.Duration) float64 {
+	if len(r.Samples) == 0 {
+		return 0
+	}
+	if last := r.Samples[len(r.Samples)-1].At; r.Loop && last > 0 {
+		t %= last
+	}
+	i := sort.Search(len(r.Samples), func(i int) bool {
+		return r.Samples[i].At > t
+	})
+	if i == 0 {
+		return r.Samples[0].Value
+	}
+	return r.Samples[i-1].Value
+}
+
+// phase - position inside the period in the range [0, 1)
+func phase(t, period time.Duration) float64 {
+	if period <= 0 {
+		return 0
+	}
+	return float64(t%period) / float64(period)
+}
@@ -0,0 +1,72 @@
+package simulation
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schnack/gotest"
+)
+
+func TestGenerators(t *testing.T) {
+	if err := gotest.Expect(Constant(7).Value(time.Hour)).Eq(7.0); err != nil {
+		t.Error(err)
+	}
+	ramp := &Ramp{From: 0, To: 100, Period: 10 * time.Second}
+	if err := gotest.Expect(ramp.Value(15 * time.Second)).Eq(50.0); err != nil {
+		t.Error(err)
+	}
+	sine := &Sine{Amplitude: 10, Offset: 20, Period: 4 * time.Second}
+	if err := gotest.Expect(sine.Value(time.Second)).Eq(30.0); err != nil {
+		t.Error(err)
+	}
+	square := &Square{Low: 1, High: 2, Period: time.Second}
+	if err := gotest.Expect(square.Value(100 * time.Millisecond)).Eq(2.0); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(square.Value(600 * time.Millisecond)).Eq(1.0); err != nil {
+		t.Error(err)
+	}
+	counter := &Counter{Start: 0, Step: 1, Max: 3, Period: time.Second}
+	if err := gotest.Expect(counter.Value(3 * time.Second)).Eq(3.0); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(counter.Value(4 * time.Second)).Eq(0.0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRandomWalk_Value(t *testing.T) {
+	first := &RandomWalk{Start: 50, Step: 5, Min: 0, Max: 100, Seed: 42}
+	second := &RandomWalk{Start: 50, Step: 5, Min: 0, Max: 100, Seed: 42}
+	for i := 0; i < 100; i++ {
+		a, b := first.Value(0), second.Value(0)
+		if err := gotest.Expect(a).Eq(b); err != nil {
+			t.Fatal(err)
+		}
+		if a < 0 || a > 100 {
+			t.Fatalf("value %f out of range", a)
+		}
+	}
+}
+
+func TestLoadCSV(t *testing.T) {
+	replay, err := LoadCSV(strings.NewReader("time,value\n2,20\n0,0\n1,10\n"))
+	if err := gotest.Expect(err).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(replay.Value(1500 * time.Millisecond)).Eq(10.0); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(replay.Value(time.Minute)).Eq(20.0); err != nil {
+		t.Error(err)
+	}
+	replay.Loop = true
+	if err := gotest.Expect(replay.Value(3 * time.Second)).Eq(10.0); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := LoadCSV(strings.NewReader("0,1\nx,y\n")); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,168 @@
+// Package simulation animates DefaultDataModel tables with signal generators.
+package simulation
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/schnack/mbslave"
+)
+
+// Binding - attaches a generator to an address of a data model table.
+// The written value is Generator.Value()*Scale + Offset encoded as Type.
+type Binding struct {
+	Table     mbslave.Table
+	Address   uint16
+	Type      mbslave.DataType
+	Scale     float64
+	Offset    float64
+	SwapWords bool
+	Generator Generator
+}
+
+// Simulator - periodically writes generator values into the data model.
+// It implements mbslave.Service so it can be started together with the server.
+type Simulator struct {
+	DataModel *mbslave.DefaultDataModel
+	Interval  time.Duration
+	// Seed for random generators without their own seed
+	Seed int64
+
+	mu       sync.Mutex
+	bindings []*Binding
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewSimulator(dataModel *mbslave.DefaultDataModel, interval time.Duration) *Simulator {
+	return &Simulator{
+		DataModel: dataModel,
+		Interval:  interval,
+		Seed:      1,
+	}
+}
+
+// Bind - adds a binding, zero Scale means 1
+func (s *Simulator) Bind(binding Binding) error {
+	if binding.Generator == nil {
+		return fmt.Errorf("binding %s %d: no generator", binding.Table, binding.Address)
+	}
+	if binding.Scale == 0 {
+		binding.Scale = 1
+	}
+	if binding.Table.IsBit() {
+		binding.Type = mbslave.TypeBool
+	}
+	if err := s.check(&binding); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sd, ok := binding.Generator.(seeder); ok {
+		sd.seed(s.Seed + int64(len(s.bindings)))
+	}
+	s.bindings = append(s.bindings, &binding)
+	return nil
+}
+
+func (s *Simulator) check(binding *Binding) error {
+	var length int
+	switch binding.Table {
+	case mbslave.TableDiscreteInputs:
+		length = s.DataModel.LengthDiscreteInputs()
+	case mbslave.TableCoils:
+		length = s.DataModel.LengthCoils()
+	case mbslave.TableInputRegisters:
+		length = s.DataModel.LengthInputRegisters()
+	case mbslave.TableHoldingRegisters:
+		length = s.DataModel.LengthHoldingRegisters()
+	default:
+		return fmt.Errorf("unknown table %s", binding.Table)
+	}
+	if int(binding.Address)+binding.Type.Words() > length {
+		return fmt.Errorf("binding %s %d: address out of range", binding.Table, binding.Address)
+	}
+	return nil
+}
+
+// Step - writes the values of all bindings for the elapsed time t.
+// Tests call it directly instead of Start to get reproducible tables.
+func (s *Simulator) Step(t time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, binding := range s.bindings {
+		if err := s.apply(binding, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Simulator) apply(binding *Binding, t time.Duration) error {
+	value := binding.Generator.Value(t)*binding.Scale + binding.Offset
+	switch binding.Table {
+	case mbslave.TableDiscreteInputs:
+		return s.DataModel.SetDiscreteInputs(binding.Address, value != 0)
+	case mbslave.TableCoils:
+		return s.DataModel.SetCoils(binding.Address, value != 0)
+	}
+
+	words := binding.Type.Encode(value)
+	if binding.SwapWords {
+		mbslave.SwapWords(words)
+	}
+	// a master never reads half of a new value
+	return s.DataModel.SetRange(binding.Table, binding.Address, words)
+}
+
+// Start - runs Step every Interval until Stop
+func (s *Simulator) Start() error {
+	if s.Interval <= 0 {
+		return fmt.Errorf("simulation interval must be positive")
+	}
+	start := time.Now()
+	if err := s.Step(0); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.stop != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("simulation already started")
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	stop, done := s.stop, s.done
+	s.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				_ = s.Step(now.Sub(start))
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop - stops the generators and waits for the last step to finish
+func (s *Simulator) Stop() error {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mu.Unlock()
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+	<-done
+	return nil
+}
@@ -0,0 +1,97 @@
+package simulation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/schnack/gotest"
+	"github.com/schnack/mbslave"
+)
+
+func newDataModel() *mbslave.DefaultDataModel {
+	return mbslave.NewDefaultDataModel(&mbslave.Config{
+		SizeDiscreteInputs:   10,
+		SizeCoils:            10,
+		SizeInputRegisters:   10,
+		SizeHoldingRegisters: 10,
+	})
+}
+
+func TestSimulator_Step(t *testing.T) {
+	dm := newDataModel()
+	sim := NewSimulator(dm, time.Second)
+
+	if err := sim.Bind(Binding{Table: mbslave.TableInputRegisters, Address: 0, Generator: Constant(12.5), Scale: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.Bind(Binding{Table: mbslave.TableInputRegisters, Address: 2, Type: mbslave.TypeFloat32, Generator: Constant(1.5)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.Bind(Binding{Table: mbslave.TableDiscreteInputs, Address: 1, Generator: &Square{Low: 0, High: 1, Period: time.Second}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(sim.Bind(Binding{Table: mbslave.TableInputRegisters, Address: 9, Type: mbslave.TypeInt32, Generator: Constant(1)})).Error("binding ir 9: address out of range"); err != nil {
+		t.Error(err)
+	}
+
+	if err := sim.Step(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(dm.GetInputRegisters(0)).Eq(uint16(125)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(dm.GetInputRegisters(2)).Eq(uint16(0x3fc0)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(dm.GetInputRegisters(3)).Eq(uint16(0x0000)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(dm.GetDiscreteInputs(1)).True(); err != nil {
+		t.Error(err)
+	}
+
+	if err := sim.Step(700 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(dm.GetDiscreteInputs(1)).False(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSimulator_Seed(t *testing.T) {
+	run := func() []uint16 {
+		dm := newDataModel()
+		sim := NewSimulator(dm, time.Second)
+		sim.Seed = 7
+		_ = sim.Bind(Binding{Table: mbslave.TableHoldingRegisters, Address: 0, Generator: &RandomWalk{Start: 1000, Step: 100}})
+		var values []uint16
+		for i := 0; i < 10; i++ {
+			_ = sim.Step(time.Duration(i) * time.Second)
+			values = append(values, dm.GetHoldingRegisters(0))
+		}
+		return values
+	}
+	if err := gotest.Expect(run()).Eq(run()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSimulator_Start(t *testing.T) {
+	dm := newDataModel()
+	sim := NewSimulator(dm, time.Millisecond)
+	_ = sim.Bind(Binding{Table: mbslave.TableCoils, Address: 3, Generator: Constant(1)})
+
+	if err := gotest.Expect(sim.Start()).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(sim.Start()).Error("simulation already started"); err != nil {
+		t.Error(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := gotest.Expect(sim.Stop()).NotError(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(dm.GetCoils(3)).True(); err != nil {
+		t.Error(err)
+	}
+}
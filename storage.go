@@ -0,0 +1,85 @@
+package mbslave
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Storage - keeps the four tables of a DefaultDataModel, bits are 0 or 1.
+// It must be safe for concurrent use, the data model locks each table on its own.
+type Storage interface {
+	// Length - size of the table
+	Length(table Table) int
+	// Get - reads len(values) values of the table from address on
+	Get(table Table, address uint16, values []uint16) error
+	// Set - writes the values to the table from address on and returns the previous ones
+	Set(table Table, address uint16, values []uint16) (old []uint16, err error)
+	// Watch - f receives the values written by others than this Storage,
+	// e.g. other processes sharing the file, until cancel is called.
+	// Change.Source is nil.
+	Watch(f func(Change)) (cancel func())
+}
+
+// SliceStorage - the tables in memory, the storage of NewDefaultDataModel
+type SliceStorage struct {
+	mu     sync.RWMutex
+	tables [4][]uint16
+}
+
+func NewSliceStorage(config *Config) *SliceStorage {
+	s := &SliceStorage{}
+	s.tables[TableDiscreteInputs] = make([]uint16, config.SizeDiscreteInputs)
+	s.tables[TableCoils] = make([]uint16, config.SizeCoils)
+	s.tables[TableInputRegisters] = make([]uint16, config.SizeInputRegisters)
+	s.tables[TableHoldingRegisters] = make([]uint16, config.SizeHoldingRegisters)
+	return s
+}
+
+func (s *SliceStorage) Length(table Table) int {
+	if !validTable(table) {
+		return 0
+	}
+	return len(s.tables[table])
+}
+
+func (s *SliceStorage) Get(table Table, address uint16, values []uint16) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := CheckRange(s, table, address, len(values)); err != nil {
+		return err
+	}
+	copy(values, s.tables[table][address:])
+	return nil
+}
+
+func (s *SliceStorage) Set(table Table, address uint16, values []uint16) ([]uint16, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := CheckRange(s, table, address, len(values)); err != nil {
+		return nil, err
+	}
+	old := make([]uint16, len(values))
+	copy(old, s.tables[table][address:])
+	copy(s.tables[table][address:], values)
+	return old, nil
+}
+
+// Watch - nobody else writes to the slices, f is never called
+func (s *SliceStorage) Watch(f func(Change)) (cancel func()) {
+	return func() {}
+}
+
+// CheckRange - an error for a range that is not inside the table of the storage
+func CheckRange(s Storage, table Table, address uint16, quantity int) error {
+	if !validTable(table) {
+		return fmt.Errorf("unknown %s", table)
+	}
+	if int(address)+quantity > s.Length(table) {
+		return fmt.Errorf("there is no register at this address")
+	}
+	return nil
+}
+
+func validTable(table Table) bool {
+	return table >= TableDiscreteInputs && table <= TableHoldingRegisters
+}
@@ -0,0 +1,311 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/schnack/mbslave"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketMeta = []byte("meta")
+	keySizes   = []byte("sizes")
+)
+
+// settled - the age of the file time after which a file with the same time is
+// unchanged, the file systems keep the time in coarse ticks
+const settled = 2 * time.Second
+
+// Bolt - the tables in a bbolt database, see OpenBolt. It implements mbslave.Storage.
+type Bolt struct {
+	// Interval - how often Watch checks the database for writes of others, 100ms without it.
+	// Set it before the first Watch.
+	Interval time.Duration
+	// OnError - receives the errors of these checks
+	OnError func(error)
+	// Timeout - how long an access waits for the lock of other processes, 1s without it
+	Timeout time.Duration
+
+	path  string
+	sizes [4]int
+
+	mu          sync.Mutex
+	closed      bool
+	seq         uint64
+	seen        [4][]uint16
+	watchers    map[int]func(mbslave.Change)
+	nextWatcher int
+	poller      *poller
+	// stat - the file at the last check, seen holds its values while it is unchanged
+	stat    os.FileInfo
+	checked time.Time
+}
+
+// OpenBolt - the tables in a bbolt database file, an embedded key-value store.
+// Every table is a bucket with the big-endian address as key and the big-endian
+// value, missing addresses are 0. The database is opened for the accesses that
+// may see the writes of other processes, so other processes with OpenBolt read
+// and write it in between. Reads and checks of a file unchanged since the last
+// check take the values in memory without opening it. A new database is
+// created with the sizes of the config, an existing one must have them. A config
+// without sizes opens an existing database with its sizes.
+func OpenBolt(path string, config *mbslave.Config) (*Bolt, error) {
+	b := &Bolt{path: path}
+	want := [4]int{
+		int(config.SizeDiscreteInputs),
+		int(config.SizeCoils),
+		int(config.SizeInputRegisters),
+		int(config.SizeHoldingRegisters),
+	}
+	err := b.update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+		if err != nil {
+			return err
+		}
+		if sizes := meta.Get(keySizes); sizes != nil {
+			for i := range b.sizes {
+				b.sizes[i] = int(binary.BigEndian.Uint16(sizes[i*2:]))
+			}
+		} else {
+			b.sizes = want
+			sizes := make([]byte, 8)
+			for i, size := range want {
+				binary.BigEndian.PutUint16(sizes[i*2:], uint16(size))
+			}
+			if err := meta.Put(keySizes, sizes); err != nil {
+				return err
+			}
+		}
+		if want != [4]int{} && want != b.sizes {
+			return fmt.Errorf("the database has the sizes %v instead of %v", b.sizes, want)
+		}
+		for _, table := range tables {
+			if _, err := tx.CreateBucketIfNotExists([]byte(table.String())); err != nil {
+				return err
+			}
+		}
+		b.seq = meta.Sequence()
+		for i, table := range tables {
+			b.seen[i] = make([]uint16, b.sizes[i])
+			readBucket(tx, table, 0, b.seen[i])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return b, nil
+}
+
+func (b *Bolt) Length(table mbslave.Table) int {
+	if table < mbslave.TableDiscreteInputs || table > mbslave.TableHoldingRegisters {
+		return 0
+	}
+	return b.sizes[table]
+}
+
+func (b *Bolt) Get(table mbslave.Table, address uint16, values []uint16) error {
+	if err := mbslave.CheckRange(b, table, address, len(values)); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return errClosed
+	}
+	if b.unchanged() {
+		copy(values, b.seen[table][address:])
+		return nil
+	}
+	return b.view(func(tx *bolt.Tx) error {
+		readBucket(tx, table, address, values)
+		return nil
+	})
+}
+
+func (b *Bolt) Set(table mbslave.Table, address uint16, values []uint16) ([]uint16, error) {
+	if err := mbslave.CheckRange(b, table, address, len(values)); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, errClosed
+	}
+	old := make([]uint16, len(values))
+	written := make([]uint16, len(values))
+	var seq uint64
+	err := b.update(func(tx *bolt.Tx) error {
+		readBucket(tx, table, address, old)
+		bucket := tx.Bucket([]byte(table.String()))
+		for i, v := range values {
+			if table.IsBit() && v != 0 {
+				v = 1
+			}
+			if err := bucket.Put(binary.BigEndian.AppendUint16(nil, address+uint16(i)), binary.BigEndian.AppendUint16(nil, v)); err != nil {
+				return err
+			}
+			written[i] = v
+		}
+		var err error
+		seq, err = tx.Bucket(bucketMeta).NextSequence()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	copy(b.seen[table][address:], written)
+	// without writes of others in between the next check has nothing to compare
+	if seq-1 == b.seq {
+		b.seq = seq
+	}
+	return old, nil
+}
+
+// Watch - the database is checked every Interval while there are watchers
+func (b *Bolt) Watch(f func(mbslave.Change)) (cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.watchers == nil {
+		b.watchers = make(map[int]func(mbslave.Change))
+	}
+	id := b.nextWatcher
+	b.nextWatcher++
+	b.watchers[id] = f
+	if b.poller == nil && !b.closed {
+		b.poller = &poller{stop: make(chan struct{}), done: make(chan struct{})}
+		go b.poller.run(b.Interval, b.OnError, b.check)
+	}
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.watchers, id)
+		if len(b.watchers) == 0 && b.poller != nil {
+			close(b.poller.stop)
+			b.poller = nil
+		}
+	}
+}
+
+// check - the values that changed since the last check or write of the Bolt
+func (b *Bolt) check() (changes []mbslave.Change, watchers []func(mbslave.Change), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed || b.unchanged() {
+		return nil, nil, nil
+	}
+	// the file before the read, a write in between changes it for the next check
+	stat, statErr := os.Stat(b.path)
+	checked := time.Now()
+	err = b.view(func(tx *bolt.Tx) error {
+		seq := tx.Bucket(bucketMeta).Sequence()
+		if seq == b.seq {
+			return nil
+		}
+		for i, table := range tables {
+			values := make([]uint16, b.sizes[i])
+			readBucket(tx, table, 0, values)
+			for address, value := range values {
+				if old := b.seen[i][address]; old != value {
+					changes = append(changes, mbslave.Change{Table: table, Address: uint16(address), Value: value, Old: old})
+				}
+			}
+			b.seen[i] = values
+		}
+		b.seq = seq
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if statErr == nil {
+		b.stat, b.checked = stat, checked
+	}
+	for _, f := range b.watchers {
+		watchers = append(watchers, f)
+	}
+	return changes, watchers, nil
+}
+
+// Close - stops the checks, the tables stay in the database
+func (b *Bolt) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	p := b.poller
+	b.poller = nil
+	b.mu.Unlock()
+	if p != nil {
+		close(p.stop)
+		<-p.done
+	}
+	return nil
+}
+
+// unchanged - the file is the one of the last check and was written well before it,
+// a write in the same tick of the file time leaves the time as it was
+func (b *Bolt) unchanged() bool {
+	if b.stat == nil {
+		return false
+	}
+	stat, err := os.Stat(b.path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(stat, b.stat) && stat.ModTime().Equal(b.stat.ModTime()) &&
+		stat.Size() == b.stat.Size() && b.checked.Sub(stat.ModTime()) > settled
+}
+
+// view - a read-only access, it shares the lock of the file with other readers
+func (b *Bolt) view(f func(tx *bolt.Tx) error) error {
+	db, err := bolt.Open(b.path, 0o644, &bolt.Options{Timeout: b.timeout(), ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	err = db.View(f)
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// update - a read-write access, it holds the lock of the file alone
+func (b *Bolt) update(f func(tx *bolt.Tx) error) error {
+	db, err := bolt.Open(b.path, 0o644, &bolt.Options{Timeout: b.timeout()})
+	if err != nil {
+		return err
+	}
+	err = db.Update(f)
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (b *Bolt) timeout() time.Duration {
+	if b.Timeout <= 0 {
+		return time.Second
+	}
+	return b.Timeout
+}
+
+// readBucket - the values of the table from address on, bits other than 0 are read as 1
+func readBucket(tx *bolt.Tx, table mbslave.Table, address uint16, values []uint16) {
+	bucket := tx.Bucket([]byte(table.String()))
+	for i := range values {
+		values[i] = 0
+		if v := bucket.Get(binary.BigEndian.AppendUint16(nil, address+uint16(i))); len(v) == 2 {
+			values[i] = binary.BigEndian.Uint16(v)
+		}
+		if table.IsBit() && values[i] != 0 {
+			values[i] = 1
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package storage
+
+import (
+	"github.com/schnack/mbslave"
+)
+
+// OpenFile - the tables in a file that is read and written with every access.
+// A new file is created with the sizes of the config, an existing one must have
+// them. A config without sizes opens an existing file with its sizes.
+func OpenFile(path string, config *mbslave.Config) (*Store, error) {
+	s, err := openFile(path, config)
+	if err != nil {
+		return nil, err
+	}
+	s.backend = s.file
+	return s, nil
+}
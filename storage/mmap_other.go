@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package storage
+
+import (
+	"errors"
+
+	"github.com/schnack/mbslave"
+)
+
+func OpenMmap(path string, config *mbslave.Config) (*Store, error) {
+	return nil, errors.New("mapped storage files are supported on linux and bsd only")
+}
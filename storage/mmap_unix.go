@@ -0,0 +1,54 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package storage
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/schnack/mbslave"
+	"golang.org/x/sys/unix"
+)
+
+// OpenMmap - the tables in a file mapped into memory, e.g. in /dev/shm. Other
+// processes map the same file to share the tables, see OpenFile for the sizes.
+func OpenMmap(path string, config *mbslave.Config) (*Store, error) {
+	s, err := openFile(path, config)
+	if err != nil {
+		return nil, err
+	}
+	size := headerSize
+	for _, n := range s.sizes {
+		size += n * 2
+	}
+	data, err := unix.Mmap(int(s.file.Fd()), 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		_ = s.file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	s.backend = &mapping{data: data}
+	return s, nil
+}
+
+// mapping - the mapped file
+type mapping struct {
+	data []byte
+}
+
+func (m *mapping) ReadAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	return copy(p, m.data[off:]), nil
+}
+
+func (m *mapping) WriteAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > int64(len(m.data)) {
+		return 0, io.ErrShortWrite
+	}
+	return copy(m.data[off:], p), nil
+}
+
+func (m *mapping) Close() error {
+	return unix.Munmap(m.data)
+}
@@ -0,0 +1,351 @@
+// Package storage keeps the tables of a data model in a file that other
+// processes read and write as well:
+//
+//	store, err := storage.OpenMmap("/dev/shm/mbslave-1", config)
+//	...
+//	defer store.Close()
+//	dm := mbslave.NewStorageDataModel(config, store)
+//
+// The file starts with a header of 32 bytes, the tables follow in the order
+// di, coils, ir, hr as little-endian 16-bit words, bits are 0 or 1:
+//
+//	0  magic "MBSTORE\x01"
+//	8  sizes of di, coils, ir and hr, uint16 each
+//	16 sequence, uint64, incremented by every write
+//	24 reserved
+//
+// Writers hold an exclusive flock(2) of the file, readers a shared one.
+//
+// OpenBolt keeps the tables in a bbolt database file instead, an embedded
+// key-value store that other processes open with OpenBolt as well.
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/schnack/mbslave"
+)
+
+const headerSize = 32
+
+var magic = []byte("MBSTORE\x01")
+
+var errClosed = errors.New("storage is closed")
+
+var tables = []mbslave.Table{
+	mbslave.TableDiscreteInputs,
+	mbslave.TableCoils,
+	mbslave.TableInputRegisters,
+	mbslave.TableHoldingRegisters,
+}
+
+// backend - the bytes of the file
+type backend interface {
+	ReadAt(p []byte, off int64) (int, error)
+	WriteAt(p []byte, off int64) (int, error)
+	Close() error
+}
+
+// Store - the tables in a file, see OpenFile and OpenMmap. It implements mbslave.Storage.
+type Store struct {
+	// Interval - how often Watch checks the file for writes of others, 100ms without it.
+	// Set it before the first Watch.
+	Interval time.Duration
+	// OnError - receives the errors of these checks
+	OnError func(error)
+
+	file    *os.File
+	backend backend
+	sizes   [4]int
+	offsets [4]int64
+
+	mu          sync.Mutex
+	closed      bool
+	seq         uint64
+	seen        [4][]uint16
+	watchers    map[int]func(mbslave.Change)
+	nextWatcher int
+	poller      *poller
+}
+
+type poller struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// openFile - opens or creates the file, a config without sizes takes the sizes of the file
+func openFile(path string, config *mbslave.Config) (*Store, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{file: f}
+	if err := s.init(config); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// init - writes the header of an empty file or checks the one there is
+func (s *Store) init(config *mbslave.Config) error {
+	want := [4]int{
+		int(config.SizeDiscreteInputs),
+		int(config.SizeCoils),
+		int(config.SizeInputRegisters),
+		int(config.SizeHoldingRegisters),
+	}
+	if err := flock(s.file, true); err != nil {
+		return err
+	}
+	defer funlock(s.file)
+
+	header := make([]byte, headerSize)
+	n, err := s.file.ReadAt(header, 0)
+	switch {
+	case n == 0:
+		copy(header, magic)
+		for i, size := range want {
+			binary.LittleEndian.PutUint16(header[8+i*2:], uint16(size))
+		}
+		if _, err := s.file.WriteAt(header, 0); err != nil {
+			return err
+		}
+	case n < headerSize:
+		return fmt.Errorf("short header: %w", err)
+	case !bytes.Equal(header[:8], magic):
+		return fmt.Errorf("not a storage file")
+	}
+
+	var size int64 = headerSize
+	for i := range s.sizes {
+		s.sizes[i] = int(binary.LittleEndian.Uint16(header[8+i*2:]))
+		s.offsets[i] = size
+		size += int64(s.sizes[i]) * 2
+	}
+	if want != [4]int{} && want != s.sizes {
+		return fmt.Errorf("the file has the sizes %v instead of %v", s.sizes, want)
+	}
+	if err := s.file.Truncate(size); err != nil {
+		return err
+	}
+	s.seq = binary.LittleEndian.Uint64(header[16:])
+	for i := range s.seen {
+		s.seen[i] = make([]uint16, s.sizes[i])
+		if err := s.read(tables[i], 0, s.seen[i], s.file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) Length(table mbslave.Table) int {
+	if table < mbslave.TableDiscreteInputs || table > mbslave.TableHoldingRegisters {
+		return 0
+	}
+	return s.sizes[table]
+}
+
+func (s *Store) Get(table mbslave.Table, address uint16, values []uint16) error {
+	if err := mbslave.CheckRange(s, table, address, len(values)); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errClosed
+	}
+	if err := flock(s.file, false); err != nil {
+		return err
+	}
+	defer funlock(s.file)
+	return s.read(table, address, values, s.backend)
+}
+
+func (s *Store) Set(table mbslave.Table, address uint16, values []uint16) ([]uint16, error) {
+	if err := mbslave.CheckRange(s, table, address, len(values)); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errClosed
+	}
+	if err := flock(s.file, true); err != nil {
+		return nil, err
+	}
+	defer funlock(s.file)
+
+	old := make([]uint16, len(values))
+	if err := s.read(table, address, old, s.backend); err != nil {
+		return nil, err
+	}
+	b := make([]byte, len(values)*2)
+	for i, v := range values {
+		if table.IsBit() && v != 0 {
+			v = 1
+		}
+		binary.LittleEndian.PutUint16(b[i*2:], v)
+		s.seen[table][int(address)+i] = v
+	}
+	if _, err := s.backend.WriteAt(b, s.offsets[table]+int64(address)*2); err != nil {
+		return nil, err
+	}
+	seq, err := s.sequence()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.backend.WriteAt(binary.LittleEndian.AppendUint64(nil, seq+1), 16); err != nil {
+		return nil, err
+	}
+	// without writes of others in between the next check has nothing to compare
+	if seq == s.seq {
+		s.seq = seq + 1
+	}
+	return old, nil
+}
+
+// Watch - the file is checked every Interval while there are watchers
+func (s *Store) Watch(f func(mbslave.Change)) (cancel func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.watchers == nil {
+		s.watchers = make(map[int]func(mbslave.Change))
+	}
+	id := s.nextWatcher
+	s.nextWatcher++
+	s.watchers[id] = f
+	if s.poller == nil && !s.closed {
+		s.poller = &poller{stop: make(chan struct{}), done: make(chan struct{})}
+		go s.poll(s.poller)
+	}
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.watchers, id)
+		if len(s.watchers) == 0 && s.poller != nil {
+			close(s.poller.stop)
+			s.poller = nil
+		}
+	}
+}
+
+func (s *Store) poll(p *poller) {
+	p.run(s.Interval, s.OnError, s.check)
+}
+
+// run - passes the changes of check to its watchers every interval, 100ms without it
+func (p *poller) run(interval time.Duration, onError func(error), check func() ([]mbslave.Change, []func(mbslave.Change), error)) {
+	defer close(p.done)
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+		changes, watchers, err := check()
+		if err != nil {
+			if onError != nil {
+				onError(err)
+			}
+			continue
+		}
+		for _, change := range changes {
+			for _, f := range watchers {
+				f(change)
+			}
+		}
+	}
+}
+
+// check - the values that changed since the last check or write of the Store
+func (s *Store) check() (changes []mbslave.Change, watchers []func(mbslave.Change), err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, nil, nil
+	}
+	if err := flock(s.file, false); err != nil {
+		return nil, nil, err
+	}
+	defer funlock(s.file)
+	seq, err := s.sequence()
+	if err != nil || seq == s.seq {
+		return nil, nil, err
+	}
+	for i, table := range tables {
+		values := make([]uint16, s.sizes[i])
+		if err := s.read(table, 0, values, s.backend); err != nil {
+			return nil, nil, err
+		}
+		for address, value := range values {
+			if old := s.seen[i][address]; old != value {
+				changes = append(changes, mbslave.Change{Table: table, Address: uint16(address), Value: value, Old: old})
+			}
+		}
+		s.seen[i] = values
+	}
+	s.seq = seq
+	for _, f := range s.watchers {
+		watchers = append(watchers, f)
+	}
+	return changes, watchers, nil
+}
+
+// read - bits other than 0 are read as 1
+func (s *Store) read(table mbslave.Table, address uint16, values []uint16, r backend) error {
+	b := make([]byte, len(values)*2)
+	if _, err := r.ReadAt(b, s.offsets[table]+int64(address)*2); err != nil {
+		return err
+	}
+	for i := range values {
+		values[i] = binary.LittleEndian.Uint16(b[i*2:])
+		if table.IsBit() && values[i] != 0 {
+			values[i] = 1
+		}
+	}
+	return nil
+}
+
+func (s *Store) sequence() (uint64, error) {
+	b := make([]byte, 8)
+	if _, err := s.backend.ReadAt(b, 16); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// Close - stops the checks and closes the file, the tables stay in the file
+func (s *Store) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	p := s.poller
+	s.poller = nil
+	s.mu.Unlock()
+	if p != nil {
+		close(p.stop)
+		<-p.done
+	}
+	err := s.backend.Close()
+	if s.backend != backend(s.file) {
+		if cerr := s.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
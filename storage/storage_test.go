@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/schnack/gotest"
+	"github.com/schnack/mbslave"
+	bolt "go.etcd.io/bbolt"
+)
+
+var config = &mbslave.Config{SlaveId: 1, SizeDiscreteInputs: 4, SizeCoils: 8, SizeInputRegisters: 4, SizeHoldingRegisters: 16}
+
+func TestOpenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unit-1.tables")
+	s, err := OpenFile(path, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(s.Length(mbslave.TableHoldingRegisters)).Eq(16); err != nil {
+		t.Error(err)
+	}
+	if _, err := s.Set(mbslave.TableHoldingRegisters, 14, []uint16{7, 8}); err != nil {
+		t.Fatal(err)
+	}
+	old, err := s.Set(mbslave.TableHoldingRegisters, 15, []uint16{9})
+	if err := gotest.Expect(err).NotError(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(old).Eq([]uint16{8}); err != nil {
+		t.Error(err)
+	}
+	if _, err := s.Set(mbslave.TableHoldingRegisters, 15, []uint16{1, 2}); err == nil {
+		t.Error("a range past the table was written")
+	}
+	_, _ = s.Set(mbslave.TableCoils, 7, []uint16{1})
+	_ = s.Close()
+
+	// the values stay in the file, a config without sizes takes them from it
+	s, err = OpenFile(path, &mbslave.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	values := make([]uint16, 3)
+	if err := gotest.Expect(s.Get(mbslave.TableHoldingRegisters, 13, values)).NotError(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(values).Eq([]uint16{0, 7, 9}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(s.Length(mbslave.TableCoils)).Eq(8); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := OpenFile(path, &mbslave.Config{SizeHoldingRegisters: 10}); err == nil {
+		t.Error("a file of other sizes was opened")
+	}
+}
+
+func TestOpenMmap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unit-1.tables")
+	m, err := OpenMmap(path, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	f, err := OpenFile(path, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// both see the same bytes, the bits are 0 or 1
+	_, _ = f.Set(mbslave.TableCoils, 2, []uint16{5})
+	_, _ = m.Set(mbslave.TableInputRegisters, 0, []uint16{0x1234})
+	values := make([]uint16, 1)
+	_ = m.Get(mbslave.TableCoils, 2, values)
+	if err := gotest.Expect(values).Eq([]uint16{1}); err != nil {
+		t.Error(err)
+	}
+	_ = f.Get(mbslave.TableInputRegisters, 0, values)
+	if err := gotest.Expect(values).Eq([]uint16{0x1234}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStore_Watch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unit-1.tables")
+	s, err := OpenMmap(path, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Interval = 5 * time.Millisecond
+	other, err := OpenFile(path, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	dm := mbslave.NewStorageDataModel(config, s)
+	changes := make(chan mbslave.Change, 4)
+	dm.Watch(func(change mbslave.Change) { changes <- change })
+
+	// the own writes are reported by the data model only
+	_ = dm.SetHoldingRegisters(1, 10)
+	_, _ = other.Set(mbslave.TableHoldingRegisters, 3, []uint16{42})
+	expected := []mbslave.Change{
+		{Table: mbslave.TableHoldingRegisters, Address: 1, Value: 10},
+		{Table: mbslave.TableHoldingRegisters, Address: 3, Value: 42},
+	}
+	for _, e := range expected {
+		select {
+		case change := <-changes:
+			if err := gotest.Expect(change).Eq(e); err != nil {
+				t.Error(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no change of %d", e.Address)
+		}
+	}
+	select {
+	case change := <-changes:
+		t.Errorf("unexpected %+v", change)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the handlers read the values of others
+	request := mbslave.NewRtuRequest(mbslave.AppendCrc([]byte{0x01, 0x03, 0x00, 0x03, 0x00, 0x01}))
+	response := mbslave.NewRtuResponse(request)
+	dm.Handler(request, response)
+	if err := gotest.Expect(response.GetData()).Eq([]byte{0x00, 0x2a}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOpenBolt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unit-1.db")
+	s, err := OpenBolt(path, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Set(mbslave.TableHoldingRegisters, 14, []uint16{7, 8}); err != nil {
+		t.Fatal(err)
+	}
+	old, err := s.Set(mbslave.TableHoldingRegisters, 15, []uint16{9})
+	if err := gotest.Expect(err).NotError(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(old).Eq([]uint16{8}); err != nil {
+		t.Error(err)
+	}
+	if _, err := s.Set(mbslave.TableHoldingRegisters, 15, []uint16{1, 2}); err == nil {
+		t.Error("a range past the table was written")
+	}
+	_, _ = s.Set(mbslave.TableCoils, 7, []uint16{5})
+	_ = s.Close()
+
+	// the values stay in the database, a config without sizes takes them from it
+	s, err = OpenBolt(path, &mbslave.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	values := make([]uint16, 3)
+	if err := gotest.Expect(s.Get(mbslave.TableHoldingRegisters, 13, values)).NotError(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(values).Eq([]uint16{0, 7, 9}); err != nil {
+		t.Error(err)
+	}
+	_ = s.Get(mbslave.TableCoils, 7, values[:1])
+	if err := gotest.Expect(values[0]).Eq(uint16(1)); err != nil {
+		t.Error(err)
+	}
+	if _, err := OpenBolt(path, &mbslave.Config{SizeHoldingRegisters: 10}); err == nil {
+		t.Error("a database of other sizes was opened")
+	}
+}
+
+func TestBolt_Watch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unit-1.db")
+	s, err := OpenBolt(path, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Interval = 5 * time.Millisecond
+	// another process opens the same database
+	other, err := OpenBolt(path, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	dm := mbslave.NewStorageDataModel(config, s)
+	defer dm.Close()
+	changes := make(chan mbslave.Change, 4)
+	dm.Watch(func(change mbslave.Change) { changes <- change })
+
+	_ = dm.SetHoldingRegisters(1, 10)
+	_, _ = other.Set(mbslave.TableHoldingRegisters, 3, []uint16{42})
+	expected := []mbslave.Change{
+		{Table: mbslave.TableHoldingRegisters, Address: 1, Value: 10},
+		{Table: mbslave.TableHoldingRegisters, Address: 3, Value: 42},
+	}
+	for _, e := range expected {
+		select {
+		case change := <-changes:
+			if err := gotest.Expect(change).Eq(e); err != nil {
+				t.Error(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no change of %d", e.Address)
+		}
+	}
+	select {
+	case change := <-changes:
+		t.Errorf("unexpected %+v", change)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBolt_Unchanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unit-1.db")
+	s, err := OpenBolt(path, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Timeout = 20 * time.Millisecond
+	_, _ = s.Set(mbslave.TableHoldingRegisters, 1, []uint16{7})
+	// the last write was long before the check
+	past := time.Now().Add(-time.Minute)
+	_ = os.Chtimes(path, past, past)
+	if _, _, err := s.check(); err != nil {
+		t.Fatal(err)
+	}
+
+	// another process holds the database, the unchanged file is not opened
+	db, err := bolt.Open(path, 0o644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, _, err := s.check(); err != nil {
+		t.Error(err)
+	}
+	values := make([]uint16, 2)
+	if err := gotest.Expect(s.Get(mbslave.TableHoldingRegisters, 0, values)).NotError(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(values).Eq([]uint16{0, 7}); err != nil {
+		t.Error(err)
+	}
+
+	// a write changes the file
+	_ = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.Bucket(bucketMeta).NextSequence()
+		return err
+	})
+	if err := s.Get(mbslave.TableHoldingRegisters, 0, values); err == nil {
+		t.Error("the changed file was not opened")
+	}
+}
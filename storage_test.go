@@ -0,0 +1,87 @@
+package mbslave
+
+import (
+	"github.com/schnack/gotest"
+	"testing"
+	"time"
+)
+
+// sharedStorage - a SliceStorage that others write to through write
+type sharedStorage struct {
+	*SliceStorage
+	watch func(Change)
+}
+
+func (s *sharedStorage) Watch(f func(Change)) func() {
+	s.watch = f
+	return func() { s.watch = nil }
+}
+
+func (s *sharedStorage) write(table Table, address uint16, value uint16) {
+	old, _ := s.Set(table, address, []uint16{value})
+	s.watch(Change{Table: table, Address: address, Value: value, Old: old[0]})
+}
+
+func TestSliceStorage(t *testing.T) {
+	s := NewSliceStorage(&Config{SizeCoils: 4, SizeHoldingRegisters: 8})
+	if err := gotest.Expect(s.Length(TableHoldingRegisters)).Eq(8); err != nil {
+		t.Error(err)
+	}
+	if _, err := s.Set(TableHoldingRegisters, 6, []uint16{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	old, _ := s.Set(TableHoldingRegisters, 5, []uint16{3, 4})
+	if err := gotest.Expect(old).Eq([]uint16{0, 1}); err != nil {
+		t.Error(err)
+	}
+	values := make([]uint16, 3)
+	if err := gotest.Expect(s.Get(TableHoldingRegisters, 5, values)).NotError(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(values).Eq([]uint16{3, 4, 2}); err != nil {
+		t.Error(err)
+	}
+	if err := s.Get(TableHoldingRegisters, 6, values); err == nil {
+		t.Error("a range past the table was read")
+	}
+	if _, err := s.Set(TableInputRegisters, 0, []uint16{1}); err == nil {
+		t.Error("an empty table was written")
+	}
+}
+
+func TestNewStorageDataModel(t *testing.T) {
+	s := &sharedStorage{SliceStorage: NewSliceStorage(&Config{SizeHoldingRegisters: 8})}
+	dm := NewStorageDataModel(&Config{SlaveId: 1, SizeHoldingRegisters: 100}, s)
+	if err := gotest.Expect(dm.LengthHoldingRegisters()).Eq(8); err != nil {
+		t.Error(err)
+	}
+	callbacks := make(chan uint16, 1)
+	dm.SetCallbackHoldingRegisters(2, func(event Event, addr uint16, value uint16) {
+		if event == EventWrite {
+			callbacks <- value
+		}
+	})
+	var changes []Change
+	dm.Watch(func(change Change) { changes = append(changes, change) })
+
+	s.write(TableHoldingRegisters, 2, 42)
+	if err := gotest.Expect(dm.GetHoldingRegisters(2)).Eq(uint16(42)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(changes).Eq([]Change{{Table: TableHoldingRegisters, Address: 2, Value: 42}}); err != nil {
+		t.Error(err)
+	}
+	select {
+	case value := <-callbacks:
+		if err := gotest.Expect(value).Eq(uint16(42)); err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("the callback was not called")
+	}
+
+	_ = dm.Close()
+	if err := gotest.Expect(s.watch == nil).True(); err != nil {
+		t.Error("the storage is still watched after Close")
+	}
+}
@@ -0,0 +1,63 @@
+package mbslave
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Table - one of the four modbus data tables
+type Table int
+
+const (
+	TableDiscreteInputs = Table(iota)
+	TableCoils
+	TableInputRegisters
+	TableHoldingRegisters
+)
+
+var tableNames = map[Table]string{
+	TableDiscreteInputs:   "di",
+	TableCoils:            "coils",
+	TableInputRegisters:   "ir",
+	TableHoldingRegisters: "hr",
+}
+
+func (t Table) String() string {
+	if name, ok := tableNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("table(%d)", int(t))
+}
+
+// IsBit - the table stores single bits instead of 16-bit registers
+func (t Table) IsBit() bool {
+	return t == TableDiscreteInputs || t == TableCoils
+}
+
+// ParseTable - accepts the short names (di, coils, ir, hr) and the full ones
+func ParseTable(name string) (Table, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "di", "discrete", "discreteinputs", "discrete_inputs", "discrete-inputs":
+		return TableDiscreteInputs, nil
+	case "co", "coil", "coils":
+		return TableCoils, nil
+	case "ir", "input", "inputregisters", "input_registers", "input-registers":
+		return TableInputRegisters, nil
+	case "hr", "holding", "holdingregisters", "holding_registers", "holding-registers":
+		return TableHoldingRegisters, nil
+	}
+	return 0, fmt.Errorf("unknown table %q", name)
+}
+
+func (t Table) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *Table) UnmarshalText(text []byte) error {
+	table, err := ParseTable(string(text))
+	if err != nil {
+		return err
+	}
+	*t = table
+	return nil
+}
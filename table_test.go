@@ -0,0 +1,19 @@
+package mbslave
+
+import (
+	"github.com/schnack/gotest"
+	"testing"
+)
+
+func TestParseTable(t *testing.T) {
+	table, err := ParseTable("HR")
+	if err := gotest.Expect(err).NotError(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(table).Eq(TableHoldingRegisters); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseTable("x"); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,138 @@
+package mbslave
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnPolicy - what a TcpTransport does with a new connection over MaxConns
+type ConnPolicy int
+
+const (
+	// RejectNewest closes the new connection
+	RejectNewest = ConnPolicy(iota)
+	// EvictOldestIdle closes the connection that has been idle for the longest
+	// time, the new one is rejected when all of them are handling a request
+	EvictOldestIdle
+)
+
+var (
+	errConnLimit = errors.New("connection limit reached")
+	errIdle      = errors.New("idle timeout")
+)
+
+// tcpConn - a served connection with its activity for EvictOldestIdle
+type tcpConn struct {
+	net.Conn
+	client *tcpClient
+	// last - unix nanoseconds of the last request
+	last atomic.Int64
+	busy atomic.Bool
+}
+
+// active - a request is handled, the connection cannot be evicted
+func (c *tcpConn) active(busy bool) {
+	c.last.Store(time.Now().UnixNano())
+	c.busy.Store(busy)
+}
+
+// tcpClient - the connections and the request budget of one address, kept after
+// the last connection closes until the budget is refilled
+type tcpClient struct {
+	addr  netip.Addr
+	conns int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// allow - token bucket of rate requests per second holding up to burst requests
+func (c *tcpClient) allow(rate float64, burst int, now time.Time) bool {
+	if rate <= 0 {
+		return true
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last.IsZero() {
+		c.tokens = float64(burst)
+	} else {
+		c.tokens = min(float64(burst), c.tokens+now.Sub(c.last).Seconds()*rate)
+	}
+	c.last = now
+	if c.tokens < 1 {
+		return false
+	}
+	c.tokens--
+	return true
+}
+
+// idle - the client has no connection and its budget is full again since rate
+// requests per second refilled burst requests, a new client is the same
+func (c *tcpClient) idle(rate float64, burst int, now time.Time) bool {
+	if c.conns > 0 {
+		return false
+	}
+	if rate <= 0 {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return now.Sub(c.last).Seconds()*rate >= float64(max(burst, 1))
+}
+
+// expireClients - drops the idle clients, called with tt.mu held
+func (tt *TcpTransport) expireClients(now time.Time) {
+	for addr, client := range tt.clients {
+		if client.idle(tt.RateLimit, tt.RateBurst, now) {
+			delete(tt.clients, addr)
+		}
+	}
+}
+
+// remoteAddr - the IP address of the peer, invalid for other than TCP connections
+func remoteAddr(conn net.Conn) netip.Addr {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.AddrPort().Addr().Unmap()
+	}
+	return netip.Addr{}
+}
+
+// allowed - Deny is checked before Allow, an empty Allow allows all addresses
+func (tt *TcpTransport) allowed(addr netip.Addr) bool {
+	for _, prefix := range tt.Deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(tt.Allow) == 0 {
+		return true
+	}
+	for _, prefix := range tt.Allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// oldestIdle - the connection to evict, nil when all are busy. Needs tt.mu.
+func (tt *TcpTransport) oldestIdle() *tcpConn {
+	var oldest *tcpConn
+	for c := range tt.conns {
+		if c.busy.Load() {
+			continue
+		}
+		if oldest == nil || c.last.Load() < oldest.last.Load() {
+			oldest = c
+		}
+	}
+	return oldest
+}
@@ -0,0 +1,234 @@
+package mbslave
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/schnack/gotest"
+)
+
+// newLimitedServer - a served unit 0x11 with register 1 = 0x2a, configure sets the limits
+func newLimitedServer(t *testing.T, configure func(tt *TcpTransport)) (*TcpTransport, *Stats) {
+	config := &Config{Address: "127.0.0.1:0", SlaveId: 0x11, SizeHoldingRegisters: 10}
+	transport := NewTcpTransport(config)
+	transport.Log = NopLogger{}
+	configure(transport)
+	dm := NewDefaultDataModel(config)
+	_ = dm.SetHoldingRegisters(1, 0x2a)
+	stats := NewStats(nil)
+	server := NewServer(transport, dm)
+	server.SetMetrics(stats)
+	done := make(chan error)
+	go func() { done <- server.Listen() }()
+	t.Cleanup(func() {
+		_ = server.Close()
+		<-done
+	})
+	return transport, stats
+}
+
+func dial(t *testing.T, addr net.Addr) net.Conn {
+	conn, err := net.DialTimeout("tcp", addr.String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	return conn
+}
+
+// roundTrip - the PDU of the response to a read of register 1
+func roundTrip(conn net.Conn) ([]byte, error) {
+	if _, err := conn.Write(MbapFrame(1, 0x11, []byte{0x03, 0x00, 0x01, 0x00, 0x01})); err != nil {
+		return nil, err
+	}
+	header := make([]byte, MbapHeaderSize)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	mbap, _ := ParseMbapHeader(header)
+	pdu := make([]byte, int(mbap.Length)-1)
+	_, err := io.ReadFull(conn, pdu)
+	return pdu, err
+}
+
+// closedByServer - the server closed the connection without an answer
+func closedByServer(conn net.Conn) bool {
+	_, err := conn.Read(make([]byte, 1))
+	return err == io.EOF
+}
+
+// events - waits until the counter reaches want, the connections are closed asynchronously
+func events(stats *Stats, event ConnEvent, want uint64) uint64 {
+	deadline := time.Now().Add(time.Second)
+	for {
+		got := stats.Snapshot().Connections[event]
+		if got >= want || time.Now().After(deadline) {
+			return got
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTcpTransport_Allow(t *testing.T) {
+	transport, stats := newLimitedServer(t, func(tt *TcpTransport) {
+		tt.Allow = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+		tt.Deny = []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}
+	})
+	if err := gotest.Expect(closedByServer(dial(t, transport.Addr()))).True(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(events(stats, ConnDenied, 1)).Eq(uint64(1)); err != nil {
+		t.Error(err)
+	}
+
+	transport, _ = newLimitedServer(t, func(tt *TcpTransport) {
+		tt.Allow = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	})
+	if _, err := roundTrip(dial(t, transport.Addr())); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTcpTransport_MaxConns(t *testing.T) {
+	transport, stats := newLimitedServer(t, func(tt *TcpTransport) {
+		tt.MaxConns = 1
+	})
+	first := dial(t, transport.Addr())
+	if _, err := roundTrip(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(closedByServer(dial(t, transport.Addr()))).True(); err != nil {
+		t.Error(err)
+	}
+	if _, err := roundTrip(first); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(events(stats, ConnRejected, 1)).Eq(uint64(1)); err != nil {
+		t.Error(err)
+	}
+
+	transport, stats = newLimitedServer(t, func(tt *TcpTransport) {
+		tt.MaxConns = 2
+		tt.ConnPolicy = EvictOldestIdle
+	})
+	oldest, newer := dial(t, transport.Addr()), dial(t, transport.Addr())
+	for _, conn := range []net.Conn{oldest, newer} {
+		if _, err := roundTrip(conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := roundTrip(dial(t, transport.Addr())); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(closedByServer(oldest)).True(); err != nil {
+		t.Error(err)
+	}
+	if _, err := roundTrip(newer); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(events(stats, ConnEvicted, 1)).Eq(uint64(1)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTcpTransport_IdleTimeout(t *testing.T) {
+	transport, stats := newLimitedServer(t, func(tt *TcpTransport) {
+		tt.IdleTimeout = 50 * time.Millisecond
+	})
+	conn := dial(t, transport.Addr())
+	if _, err := roundTrip(conn); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := gotest.Expect(closedByServer(conn)).True(); err != nil {
+		t.Error(err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("closed after %s", elapsed)
+	}
+	if err := gotest.Expect(events(stats, ConnIdle, 1)).Eq(uint64(1)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(events(stats, ConnClosed, 1)).Eq(uint64(1)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTcpTransport_RateLimit(t *testing.T) {
+	transport, stats := newLimitedServer(t, func(tt *TcpTransport) {
+		tt.RateLimit = 0.1
+		tt.RateBurst = 2
+	})
+	// the budget is shared by the connections of an address
+	conns := []net.Conn{dial(t, transport.Addr()), dial(t, transport.Addr()), dial(t, transport.Addr())}
+	var responses [][]byte
+	for _, conn := range conns {
+		pdu, err := roundTrip(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		responses = append(responses, pdu)
+	}
+	if err := gotest.Expect(responses).Eq([][]byte{{0x03, 0x02, 0x00, 0x2a}, {0x03, 0x02, 0x00, 0x2a}, {0x83, ErrorWait}}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(events(stats, ConnRateLimited, 1)).Eq(uint64(1)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTcpTransport_RateLimitReconnect(t *testing.T) {
+	transport, stats := newLimitedServer(t, func(tt *TcpTransport) {
+		tt.RateLimit = 0.1
+		tt.RateBurst = 2
+	})
+	// the budget is kept when the client reconnects between the requests
+	var responses [][]byte
+	for i := 0; i < 3; i++ {
+		conn := dial(t, transport.Addr())
+		pdu, err := roundTrip(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		responses = append(responses, pdu)
+		_ = conn.Close()
+		events(stats, ConnClosed, uint64(i+1))
+	}
+	if err := gotest.Expect(responses).Eq([][]byte{{0x03, 0x02, 0x00, 0x2a}, {0x03, 0x02, 0x00, 0x2a}, {0x83, ErrorWait}}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTcpClient_idle(t *testing.T) {
+	c := &tcpClient{conns: 1}
+	now := time.Unix(0, 0)
+	c.allow(2, 4, now)
+	if err := gotest.Expect(c.idle(2, 4, now.Add(time.Hour))).False(); err != nil {
+		t.Error("a connected client expired")
+	}
+	c.conns = 0
+	// 4 requests are refilled in 2 seconds
+	if err := gotest.Expect(c.idle(2, 4, now.Add(1900*time.Millisecond))).False(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(c.idle(2, 4, now.Add(2*time.Second))).True(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTcpClient_Allow(t *testing.T) {
+	c := &tcpClient{}
+	now := time.Unix(0, 0)
+	var allowed []bool
+	for _, d := range []time.Duration{0, 0, 0, 500 * time.Millisecond, 500 * time.Millisecond} {
+		now = now.Add(d)
+		allowed = append(allowed, c.allow(2, 2, now))
+	}
+	if err := gotest.Expect(allowed).Eq([]bool{true, true, false, true, true}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,58 @@
+package mbslave
+
+// TcpRequest - Modbus/TCP request. The PDU is parsed by the embedded RtuRequest
+// with a computed checksum, so both transports share the same validation.
+type TcpRequest struct {
+	RtuRequest
+	Header MbapHeader
+	tcpRaw []byte
+}
+
+func NewTcpRequest(b []byte) Request {
+	request := &TcpRequest{tcpRaw: b}
+	if len(b) > MbapHeaderSize {
+		request.raw = AppendCrc(append([]byte(nil), b[MbapHeaderSize-1:]...))
+	} else {
+		request.raw = b
+	}
+	return request
+}
+
+func (tr *TcpRequest) Parse() error {
+	header, err := ParseMbapHeader(tr.tcpRaw)
+	if err != nil {
+		return err
+	}
+	if int(header.Length)+MbapHeaderSize-1 != len(tr.tcpRaw) {
+		return ErrFrameDamaged
+	}
+	tr.Header = header
+	return tr.RtuRequest.Parse()
+}
+
+// GetSlaveId - the unit id of the MBAP header even if the ADU is not parsed
+func (tr *TcpRequest) GetSlaveId() uint8 {
+	if len(tr.tcpRaw) >= MbapHeaderSize {
+		return tr.tcpRaw[MbapHeaderSize-1]
+	}
+	return 0
+}
+
+// GetTransactionId - the transaction id of the MBAP header
+func (tr *TcpRequest) GetTransactionId() uint16 {
+	if len(tr.tcpRaw) >= 2 {
+		return uint16(tr.tcpRaw[0])<<8 | uint16(tr.tcpRaw[1])
+	}
+	return 0
+}
+
+func (tr *TcpRequest) GetADU() []byte {
+	return tr.tcpRaw
+}
+
+func (tr *TcpRequest) GetPDU() []byte {
+	if len(tr.tcpRaw) <= MbapHeaderSize {
+		return nil
+	}
+	return tr.tcpRaw[MbapHeaderSize:]
+}
@@ -0,0 +1,54 @@
+package mbslave
+
+import (
+	"github.com/schnack/gotest"
+	"testing"
+)
+
+func TestNewTcpRequest(t *testing.T) {
+	tcp := NewTcpRequest([]byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x06, 0x11, 0x03, 0x00, 0x6b, 0x00, 0x03})
+	if err := gotest.Expect(tcp.Parse()).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(tcp.GetSlaveId()).Eq(uint8(0x11)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(tcp.GetFunction()).Eq(FuncReadHoldingRegisters); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(tcp.GetAddress()).Eq(uint16(0x006b)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(tcp.GetQuantity()).Eq(uint16(0x0003)); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(tcp.GetPDU()).Eq([]byte{0x03, 0x00, 0x6b, 0x00, 0x03}); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(tcp.(*TcpRequest).GetTransactionId()).Eq(uint16(7)); err != nil {
+		t.Error(err)
+	}
+
+	damaged := NewTcpRequest([]byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x07, 0x11, 0x03, 0x00, 0x6b, 0x00, 0x03})
+	if err := gotest.Expect(damaged.Parse()).Error("frame damaged"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTcpResponse_GetADU(t *testing.T) {
+	response := NewTcpResponse(NewTcpRequest([]byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x06, 0x11, 0x03, 0x00, 0x6b, 0x00, 0x01}))
+	response.SetRead([]byte{0x12, 0x34})
+	adu, err := response.GetADU()
+	if err := gotest.Expect(err).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(adu).Eq([]byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x05, 0x11, 0x03, 0x02, 0x12, 0x34}); err != nil {
+		t.Error(err)
+	}
+
+	response.SetError(ErrorAddress)
+	adu, _ = response.GetADU()
+	if err := gotest.Expect(adu).Eq([]byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x03, 0x11, 0x83, 0x02}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,28 @@
+package mbslave
+
+// TcpResponse - Modbus/TCP response echoing the transaction id of the request
+type TcpResponse struct {
+	RtuResponse
+	transactionId uint16
+}
+
+func NewTcpResponse(request Request) Response {
+	response := &TcpResponse{
+		RtuResponse: RtuResponse{
+			slaveId:  request.GetSlaveId(),
+			function: request.GetFunction(),
+		},
+	}
+	if tr, ok := request.(*TcpRequest); ok {
+		response.transactionId = tr.GetTransactionId()
+	}
+	return response
+}
+
+func (tr *TcpResponse) GetADU() ([]byte, error) {
+	pdu, err := tr.GetPDU()
+	if err != nil {
+		return nil, err
+	}
+	return MbapFrame(tr.transactionId, tr.slaveId, pdu), nil
+}
@@ -0,0 +1,486 @@
+package mbslave
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type TcpTransport struct {
+	*Config
+	handler Handler
+	reject  Handler
+	Log     Logger
+	Metrics Metrics
+	Tracer  Tracer
+	// Capture receives every received and transmitted ADU
+	Capture Capturer
+	// Rtu - the connections carry RTU frames instead of MBAP (RTU over TCP)
+	Rtu bool
+	// TLS - the connections are wrapped in TLS, see NewTlsTransport
+	TLS *tls.Config
+	// Allow - networks of the clients allowed to connect, empty allows all.
+	// Deny is checked first.
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+	// MaxConns - concurrent connections, zero is unlimited
+	MaxConns int
+	// ConnPolicy - what happens to a new connection over MaxConns
+	ConnPolicy ConnPolicy
+	// IdleTimeout - connections without a request are closed, zero keeps them open
+	IdleTimeout time.Duration
+	// RateLimit - requests per second of a client address, more are answered
+	// with ErrorWait (0x06). Zero is unlimited.
+	RateLimit float64
+	// RateBurst - requests a client may send at once, at least 1
+	RateBurst int
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*tcpConn]struct{}
+	clients  map[netip.Addr]*tcpClient
+	ready    chan struct{}
+	closed   bool
+}
+
+func NewTcpTransport(config *Config) *TcpTransport {
+	return &TcpTransport{
+		Config: config,
+		Log:    NewLogrusLogger(logrus.StandardLogger()),
+	}
+}
+
+// NewRtuOverTcpTransport - serves RTU frames with CRC over TCP connections
+func NewRtuOverTcpTransport(config *Config) *TcpTransport {
+	tt := NewTcpTransport(config)
+	tt.Rtu = true
+	return tt
+}
+
+func (tt *TcpTransport) SetHandler(f func(request Request, response Response)) {
+	tt.handler = HandlerFunc(f)
+}
+
+// SetContextHandler - the handler receives the RequestInfo of every frame, the
+// context is cancelled when the connection closes
+func (tt *TcpTransport) SetContextHandler(h Handler) {
+	tt.handler = h
+}
+
+// SetRejectHandler - h sees the requests answered with ErrorWait over RateLimit
+func (tt *TcpTransport) SetRejectHandler(h Handler) {
+	tt.reject = h
+}
+
+func (tt *TcpTransport) SetMetrics(m Metrics) {
+	tt.Metrics = m
+}
+
+func (tt *TcpTransport) SetTracer(t Tracer) {
+	tt.Tracer = t
+}
+
+// Addr - the listening address, blocks until Listen has bound the socket
+func (tt *TcpTransport) Addr() net.Addr {
+	<-tt.readyChan()
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if tt.listener == nil {
+		return nil
+	}
+	return tt.listener.Addr()
+}
+
+// Listen - accepts connections until Close, every connection is served by its own goroutine
+func (tt *TcpTransport) Listen() error {
+	listener, err := net.Listen("tcp", tt.Config.Address)
+	if err != nil {
+		tt.signalReady()
+		return err
+	}
+	return tt.Serve(listener)
+}
+
+// Serve - accepts connections from the listener until Close, they are
+// wrapped in TLS when TLS is set
+func (tt *TcpTransport) Serve(listener net.Listener) error {
+	if tt.TLS != nil {
+		listener = tls.NewListener(listener, tt.TLS)
+	}
+	tt.mu.Lock()
+	if tt.closed {
+		tt.mu.Unlock()
+		_ = listener.Close()
+		tt.signalReady()
+		return ErrTransportClosed
+	}
+	tt.listener = listener
+	tt.conns = make(map[*tcpConn]struct{})
+	tt.clients = make(map[netip.Addr]*tcpClient)
+	tt.mu.Unlock()
+	tt.signalReady()
+
+	if tt.Log.Enabled(LevelDebug) {
+		tt.Log.Log(LevelDebug, "start listening", Field{Key: "address", Value: listener.Addr().String()})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			tt.mu.Lock()
+			closed := tt.closed
+			tt.mu.Unlock()
+			if closed {
+				return ErrTransportClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		addr := remoteAddr(conn)
+		if !tt.allowed(addr) {
+			tt.metrics().Connection(ConnDenied)
+			tt.refused(conn, "connection denied")
+			continue
+		}
+		c, err := tt.track(conn, addr)
+		if errors.Is(err, ErrTransportClosed) {
+			_ = conn.Close()
+			return err
+		}
+		if err != nil {
+			tt.metrics().Connection(ConnRejected)
+			tt.refused(conn, "connection rejected")
+			continue
+		}
+		tt.metrics().Connection(ConnAccepted)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer tt.untrack(c)
+			connCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			tt.serveConn(connCtx, c)
+		}()
+	}
+}
+
+// Close - stops accepting and closes all connections, Listen returns ErrTransportClosed
+func (tt *TcpTransport) Close() error {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.closed = true
+	for conn := range tt.conns {
+		_ = conn.Close()
+	}
+	if tt.listener == nil {
+		return nil
+	}
+	return tt.listener.Close()
+}
+
+func (tt *TcpTransport) signalReady() {
+	ready := tt.readyChan()
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	select {
+	case <-ready:
+	default:
+		close(ready)
+	}
+}
+
+// readyChan - closed by signalReady, made here for a TcpTransport without NewTcpTransport
+func (tt *TcpTransport) readyChan() chan struct{} {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if tt.ready == nil {
+		tt.ready = make(chan struct{})
+	}
+	return tt.ready
+}
+
+// track - adds the connection within MaxConns, an idle one may be evicted for it
+func (tt *TcpTransport) track(conn net.Conn, addr netip.Addr) (*tcpConn, error) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if tt.closed {
+		return nil, ErrTransportClosed
+	}
+	if tt.MaxConns > 0 && len(tt.conns) >= tt.MaxConns {
+		var victim *tcpConn
+		if tt.ConnPolicy == EvictOldestIdle {
+			victim = tt.oldestIdle()
+		}
+		if victim == nil {
+			return nil, errConnLimit
+		}
+		delete(tt.conns, victim)
+		_ = victim.Close()
+		tt.metrics().Connection(ConnEvicted)
+		if tt.Log.Enabled(LevelInfo) {
+			tt.Log.Log(LevelInfo, "connection evicted", Field{Key: "remote", Value: victim.RemoteAddr().String()})
+		}
+	}
+	tt.expireClients(time.Now())
+	client := tt.clients[addr]
+	if client == nil {
+		client = &tcpClient{addr: addr}
+		tt.clients[addr] = client
+	}
+	client.conns++
+	c := &tcpConn{Conn: conn, client: client}
+	c.active(false)
+	tt.conns[c] = struct{}{}
+	return c, nil
+}
+
+func (tt *TcpTransport) untrack(c *tcpConn) {
+	tt.mu.Lock()
+	delete(tt.conns, c)
+	// the budget outlives the connection, a reconnect does not refill it
+	c.client.conns--
+	tt.mu.Unlock()
+	_ = c.Close()
+	tt.metrics().Connection(ConnClosed)
+}
+
+// refused - closes a connection that is not served
+func (tt *TcpTransport) refused(conn net.Conn, msg string) {
+	if tt.Log.Enabled(LevelWarn) {
+		tt.Log.Log(LevelWarn, msg, Field{Key: "remote", Value: conn.RemoteAddr().String()})
+	}
+	_ = conn.Close()
+}
+
+func (tt *TcpTransport) serveConn(ctx context.Context, conn *tcpConn) {
+	if tt.Log.Enabled(LevelDebug) {
+		tt.Log.Log(LevelDebug, "connection opened", Field{Key: "remote", Value: conn.RemoteAddr().String()})
+	}
+	peer := RequestInfo{
+		Transport: "tcp",
+		Local:     conn.LocalAddr().String(),
+		Remote:    conn.RemoteAddr().String(),
+	}
+	if tc, ok := conn.Conn.(*tls.Conn); ok {
+		cert, role, err := tt.handshake(ctx, tc)
+		if err != nil {
+			if tt.Log.Enabled(LevelWarn) {
+				tt.Log.Log(LevelWarn, "tls handshake failed", Field{Key: "remote", Value: peer.Remote}, FieldError(err))
+			}
+			return
+		}
+		peer.Transport, peer.Certificate, peer.Role = "tls", cert, role
+	}
+	if tt.Rtu {
+		peer.Transport = "rtu-over-tcp"
+		tt.serveRtu(ctx, conn, peer)
+		return
+	}
+	header := make([]byte, MbapHeaderSize)
+	for {
+		if tt.IdleTimeout > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(tt.IdleTimeout))
+		}
+		if _, err := io.ReadFull(conn, header); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				tt.metrics().Connection(ConnIdle)
+				err = errIdle
+			}
+			tt.connClosed(conn, err)
+			return
+		}
+		first := time.Now()
+		mbap, err := ParseMbapHeader(header)
+		if err != nil {
+			tt.metrics().ParseError()
+			tt.connClosed(conn, err)
+			return
+		}
+		adu := make([]byte, MbapHeaderSize+int(mbap.Length)-1)
+		copy(adu, header)
+		if _, err := io.ReadFull(conn, adu[MbapHeaderSize:]); err != nil {
+			tt.connClosed(conn, err)
+			return
+		}
+		if err := tt.newFrame(ctx, conn, peer, adu, first); err != nil {
+			tt.connClosed(conn, err)
+			return
+		}
+	}
+}
+
+// serveRtu - splits the stream by the expected frame length, a pause of the silent interval
+// ends frames of unknown length
+func (tt *TcpTransport) serveRtu(ctx context.Context, conn *tcpConn, peer RequestInfo) {
+	framer := NewRtuFramer(0)
+	framer.OnDiscard = func([]byte) {
+		tt.metrics().CrcError()
+	}
+	gap := tt.Config.SilentInterval
+	if gap <= 0 {
+		gap = 50 * time.Millisecond
+	}
+	buf := make([]byte, MaxRtuAduSize)
+	// first - the time of the first read of the buffered frame
+	var first time.Time
+	for {
+		idle := framer.Buffered() == 0
+		deadline := time.Time{}
+		if !idle {
+			deadline = time.Now().Add(gap)
+		} else if tt.IdleTimeout > 0 {
+			deadline = time.Now().Add(tt.IdleTimeout)
+		}
+		_ = conn.SetReadDeadline(deadline)
+		n, err := conn.Read(buf)
+		if idle {
+			first = time.Now()
+		}
+		var frames [][]byte
+		for _, b := range buf[:n] {
+			frames = append(frames, framer.Push(b, time.Now())...)
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			if idle && n == 0 {
+				tt.metrics().Connection(ConnIdle)
+				tt.connClosed(conn, errIdle)
+				return
+			}
+			frames, err = framer.Gap(), nil
+		}
+		for _, adu := range frames {
+			if err := tt.newFrame(ctx, conn, peer, adu, first); err != nil {
+				tt.connClosed(conn, err)
+				return
+			}
+		}
+		if err != nil {
+			tt.connClosed(conn, err)
+			return
+		}
+	}
+}
+
+func (tt *TcpTransport) connClosed(conn net.Conn, err error) {
+	if !tt.Log.Enabled(LevelDebug) {
+		return
+	}
+	fields := []Field{{Key: "remote", Value: conn.RemoteAddr().String()}}
+	if err != nil && !errors.Is(err, io.EOF) {
+		fields = append(fields, FieldError(err))
+	}
+	tt.Log.Log(LevelDebug, "connection closed", fields...)
+}
+
+// newFrame - peer holds the fields of the connection for the RequestInfo,
+// first is the time of the first part of the frame
+func (tt *TcpTransport) newFrame(ctx context.Context, conn *tcpConn, peer RequestInfo, adu []byte, first time.Time) error {
+	metrics := tt.metrics()
+	metrics.FrameReceived(len(adu))
+	tt.capture(DirectionIn, adu)
+
+	newRequest, newResponse := NewTcpRequest, NewTcpResponse
+	if tt.Rtu {
+		newRequest, newResponse = NewRtuRequest, NewRtuResponse
+	}
+	request := newRequest(adu)
+	if tt.Log.Enabled(LevelDebug) {
+		tt.Log.Log(LevelDebug, "<- in", FieldRaw(adu), Field{Key: "remote", Value: conn.RemoteAddr().String()})
+	}
+	response := newResponse(request)
+
+	start := time.Now()
+	conn.active(true)
+	defer conn.active(false)
+	info := peer
+	info.Unit = request.GetSlaveId()
+	info.Function = request.GetFunction()
+	info.Received = start
+	info.Frame = adu
+	info.Broadcast = rtuBroadcast(request)
+	if !tt.Rtu {
+		info.TransactionId = binary.BigEndian.Uint16(adu[0:2])
+	}
+	if first.IsZero() || first.After(start) {
+		first = start
+	}
+	ctx, span := startRequestSpan(WithRequestInfo(ctx, info), tt.tracer(), info.Transport, len(adu), request, first)
+	defer span.End()
+	if err := request.Parse(); err != nil {
+		// counted whatever unit the frame is for
+		countFrameError(metrics, err)
+		span.RecordError(err)
+		response.Unanswered(true)
+	} else if !conn.client.allow(tt.RateLimit, tt.RateBurst, start) {
+		metrics.Connection(ConnRateLimited)
+		metrics.Exception(request.GetFunction(), ErrorWait)
+		response.SetError(ErrorWait)
+		if tt.reject != nil {
+			tt.reject(ctx, request, response)
+		}
+	} else if tt.handler != nil {
+		ctx, handleSpan := tt.tracer().Start(ctx, "modbus.handle", time.Time{})
+		tt.handler(ctx, request, response)
+		handleSpan.End()
+	}
+	duration := time.Since(start)
+
+	out, err := response.GetADU()
+	if err != nil {
+		metrics.Unanswered(request.GetSlaveId())
+		span.SetAttributes(Field{Key: "unanswered", Value: true})
+		return nil
+	}
+	span.SetAttributes(FieldException(response.GetError()), FieldBytes("response_bytes", len(out)))
+	_, writeSpan := tt.tracer().Start(ctx, "modbus.write", time.Time{})
+	_, err = conn.Write(out)
+	writeSpan.End()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	metrics.FrameSent(len(out))
+	tt.capture(DirectionOut, out)
+	if tt.Log.Enabled(LevelDebug) {
+		tt.Log.Log(LevelDebug, "-> out", FieldRaw(out), FieldDuration(duration))
+	}
+	return nil
+}
+
+func (tt *TcpTransport) capture(direction Direction, adu []byte) {
+	if tt.Capture == nil {
+		return
+	}
+	if err := tt.Capture.Capture(direction, time.Now(), adu); err != nil && tt.Log.Enabled(LevelError) {
+		tt.Log.Log(LevelError, "capture failed", FieldError(err))
+	}
+}
+
+func (tt *TcpTransport) tracer() Tracer {
+	if tt.Tracer == nil {
+		return NopTracer{}
+	}
+	return tt.Tracer
+}
+
+func (tt *TcpTransport) metrics() Metrics {
+	if tt.Metrics == nil {
+		return NopMetrics{}
+	}
+	return tt.Metrics
+}
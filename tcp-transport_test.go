@@ -0,0 +1,140 @@
+package mbslave
+
+import (
+	"github.com/schnack/gotest"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTcpTransport_Listen(t *testing.T) {
+	config := &Config{Address: "127.0.0.1:0", SlaveId: 0x11, SizeHoldingRegisters: 10}
+	server := NewTcpServer(config)
+	server.Transport.(*TcpTransport).Log = NopLogger{}
+	_ = server.DataModel.(*DefaultDataModel).SetHoldingRegisters(1, 0xabcd)
+
+	done := make(chan error)
+	go func() {
+		done <- server.Listen()
+	}()
+
+	conn, err := net.DialTimeout("tcp", server.Transport.(*TcpTransport).Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+
+	_, _ = conn.Write(MbapFrame(0x0102, 0x11, []byte{0x03, 0x00, 0x01, 0x00, 0x01}))
+	resp := make([]byte, 11)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(resp).Eq(MbapFrame(0x0102, 0x11, []byte{0x03, 0x02, 0xab, 0xcd})); err != nil {
+		t.Error(err)
+	}
+
+	if err := gotest.Expect(server.Close()).NotError(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(<-done).NotError(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTcpTransport_Unit(t *testing.T) {
+	config := &Config{Address: "127.0.0.1:0", SlaveId: 0x11, SizeHoldingRegisters: 10}
+	// a struct literal works like NewTcpTransport
+	transport := &TcpTransport{Config: config, Log: NopLogger{}}
+	server := NewServer(transport, NewDefaultDataModel(config))
+	done := make(chan error)
+	go func() {
+		done <- server.Listen()
+	}()
+
+	conn, err := net.DialTimeout("tcp", transport.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+
+	// 0 and 255 address the server itself, they are no broadcasts
+	for _, unit := range []uint8{0x00, 0xff} {
+		_, _ = conn.Write(MbapFrame(uint16(unit), unit, []byte{0x06, 0x00, 0x01, 0x00, unit}))
+		resp := make([]byte, 12)
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			t.Fatalf("unit %d: %s", unit, err)
+		}
+		if err := gotest.Expect(resp).Eq(MbapFrame(uint16(unit), unit, []byte{0x06, 0x00, 0x01, 0x00, unit})); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if err := gotest.Expect(server.Close()).NotError(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(<-done).NotError(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTcpTransport_Rtu(t *testing.T) {
+	config := &Config{Address: "127.0.0.1:0", SlaveId: 0x11, SizeHoldingRegisters: 10, SilentInterval: 10 * time.Millisecond}
+	transport := NewRtuOverTcpTransport(config)
+	transport.Log = NopLogger{}
+	dm := NewDefaultDataModel(config)
+	_ = dm.SetHoldingRegisters(1, 0xabcd)
+	server := NewServer(transport, dm)
+
+	done := make(chan error)
+	go func() {
+		done <- server.Listen()
+	}()
+
+	conn, err := net.DialTimeout("tcp", transport.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+
+	// two requests in one segment and one of unknown length
+	request := AppendCrc([]byte{0x11, 0x03, 0x00, 0x01, 0x00, 0x01})
+	unknown := AppendCrc([]byte{0x11, 0x2b, 0x0e, 0x01, 0x00})
+	_, _ = conn.Write(append(append(append([]byte(nil), request...), request...), unknown...))
+	expected := AppendCrc([]byte{0x11, 0x03, 0x02, 0xab, 0xcd})
+	expected = append(expected, expected...)
+	expected = append(expected, AppendCrc([]byte{0x11, 0xab, ErrorFunction})...)
+	resp := make([]byte, len(expected))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(resp).Eq(expected); err != nil {
+		t.Error(err)
+	}
+
+	if err := gotest.Expect(server.Close()).NotError(); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(<-done).NotError(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTcpTransport_Closed(t *testing.T) {
+	// while listening and after Close, like the RtuTransport
+	tt := NewTcpTransport(&Config{Address: "127.0.0.1:0"})
+	tt.Log = NopLogger{}
+	done := make(chan error)
+	go func() { done <- tt.Listen() }()
+	tt.Addr()
+	_ = tt.Close()
+	if err := gotest.Expect(<-done).Eq(ErrTransportClosed); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(tt.Listen()).Eq(ErrTransportClosed); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,64 @@
+package mbslave
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"time"
+)
+
+// OidModbusRole - the certificate extension carrying the role of the client
+// in the Modbus/TCP Security profile, an ASN.1 UTF8String
+var OidModbusRole = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 50316, 802, 1}
+
+// tlsHandshakeTimeout - a client that does not finish the handshake in time is disconnected
+const tlsHandshakeTimeout = 10 * time.Second
+
+// NewTlsTransport - Modbus/TCP Security, MBAP frames over TLS (port 802). A
+// tls.Config without ClientAuth requires and verifies a client certificate
+// against ClientCAs, the role of the certificate is passed in RequestInfo.
+func NewTlsTransport(config *Config, tlsConfig *tls.Config) *TcpTransport {
+	if tlsConfig.ClientAuth == tls.NoClientCert {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	tt := NewTcpTransport(config)
+	tt.TLS = tlsConfig
+	return tt
+}
+
+// ModbusRole - the role of the certificate, empty without the extension
+func ModbusRole(cert *x509.Certificate) (string, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(OidModbusRole) {
+			continue
+		}
+		var role string
+		rest, err := asn1.UnmarshalWithParams(ext.Value, &role, "utf8")
+		if err != nil {
+			return "", fmt.Errorf("modbus role: %w", err)
+		}
+		if len(rest) > 0 {
+			return "", fmt.Errorf("modbus role: trailing data")
+		}
+		return role, nil
+	}
+	return "", nil
+}
+
+// handshake - the client certificate and its role
+func (tt *TcpTransport) handshake(ctx context.Context, conn *tls.Conn) (*x509.Certificate, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+	defer cancel()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return nil, "", err
+	}
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, "", nil
+	}
+	role, err := ModbusRole(certs[0])
+	return certs[0], role, err
+}
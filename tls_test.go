@@ -0,0 +1,180 @@
+package mbslave
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/schnack/gotest"
+)
+
+// testCA - issues certificates for the tests, valid for an hour
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue - a server certificate for 127.0.0.1 or a client certificate with the role
+func (ca *testCA) issue(t *testing.T, server bool, role string) tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	if role != "" {
+		value, _ := asn1.MarshalWithParams(role, "utf8")
+		template.ExtraExtensions = []pkix.Extension{{Id: OidModbusRole, Value: value}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// tlsRequest - sends one MBAP request, the PDU of the response
+func tlsRequest(addr net.Addr, config *tls.Config, pdu []byte) ([]byte, error) {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", addr.String(), config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write(MbapFrame(1, 0x11, pdu)); err != nil {
+		return nil, err
+	}
+	header := make([]byte, MbapHeaderSize)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	mbap, _ := ParseMbapHeader(header)
+	resp := make([]byte, int(mbap.Length)-1)
+	_, err = io.ReadFull(conn, resp)
+	return resp, err
+}
+
+func TestTlsTransport(t *testing.T) {
+	ca := newTestCA(t)
+	config := &Config{Address: "127.0.0.1:0", SlaveId: 0x11, SizeHoldingRegisters: 10}
+	transport := NewTlsTransport(config, &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, true, "")},
+		ClientCAs:    ca.pool,
+	})
+	transport.Log = NopLogger{}
+	dm := NewDefaultDataModel(config)
+	_ = dm.SetHoldingRegisters(1, 0x2a)
+	server := NewServer(transport, dm)
+	roles := Roles{"operator": {{Functions: []uint8{FuncReadHoldingRegisters}, Start: 0, Count: 4}}}
+	server.Use(AuthorizeMiddleware(roles.Authorize, NopLogger{}))
+	infos := make(chan RequestInfo, 4)
+	dm.SetContextFunction(FuncReadHoldingRegisters, func(ctx context.Context, req Request, resp Response) {
+		info, _ := RequestInfoFrom(ctx)
+		infos <- info
+		dm.ReadHoldingRegisters(req, resp)
+	})
+	done := make(chan error)
+	go func() { done <- server.Listen() }()
+	defer func() {
+		_ = server.Close()
+		<-done
+	}()
+
+	client := &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{ca.issue(t, false, "operator")}}
+	resp, err := tlsRequest(transport.Addr(), client, []byte{0x03, 0x00, 0x01, 0x00, 0x01})
+	if err := gotest.Expect(err).NotError(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gotest.Expect(resp).Eq([]byte{0x03, 0x02, 0x00, 0x2a}); err != nil {
+		t.Error(err)
+	}
+	info := <-infos
+	if err := gotest.Expect(info.Transport).Eq("tls"); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(info.Role).Eq("operator"); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(info.Certificate).NotNil(); err != nil {
+		t.Error(err)
+	}
+
+	// writes and addresses outside the permission are denied
+	for _, pdu := range [][]byte{{0x06, 0x00, 0x01, 0x00, 0x01}, {0x03, 0x00, 0x03, 0x00, 0x02}} {
+		resp, err = tlsRequest(transport.Addr(), client, pdu)
+		if err := gotest.Expect(err).NotError(); err != nil {
+			t.Fatal(err)
+		}
+		if err := gotest.Expect(resp).Eq([]byte{pdu[0] | 0x80, ErrorFunction}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// a client without a certificate does not get through the handshake
+	if _, err := tlsRequest(transport.Addr(), &tls.Config{RootCAs: ca.pool}, []byte{0x03, 0x00, 0x01, 0x00, 0x01}); err == nil {
+		t.Error("a client without a certificate was served")
+	}
+	// neither does one issued by another CA
+	other := newTestCA(t)
+	if _, err := tlsRequest(transport.Addr(), &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{other.issue(t, false, "operator")}}, []byte{0x03, 0x00, 0x01, 0x00, 0x01}); err == nil {
+		t.Error("a client of another CA was served")
+	}
+}
+
+func TestModbusRole(t *testing.T) {
+	ca := newTestCA(t)
+	for role, want := range map[string]string{"operator": "operator", "": ""} {
+		cert, _ := x509.ParseCertificate(ca.issue(t, false, role).Certificate[0])
+		got, err := ModbusRole(cert)
+		if err := gotest.Expect(err).NotError(); err != nil {
+			t.Error(err)
+		}
+		if err := gotest.Expect(got).Eq(want); err != nil {
+			t.Error(err)
+		}
+	}
+
+	cert := &x509.Certificate{Extensions: []pkix.Extension{{Id: OidModbusRole, Value: []byte{0x02, 0x01, 0x05}}}}
+	if _, err := ModbusRole(cert); err == nil {
+		t.Error("an integer was accepted as role")
+	}
+}
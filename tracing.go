@@ -0,0 +1,56 @@
+package mbslave
+
+import (
+	"context"
+	"time"
+)
+
+// Tracer - starts the spans of the request handling, the otel package adapts
+// OpenTelemetry. The spans of a request:
+//
+//	modbus.request         the first byte of the frame to the end of the response
+//	  modbus.frame         waiting for the rest of the frame
+//	  modbus.handle        the handler of the transport
+//	    modbus.parse       the parsing by BaseDataModel
+//	    modbus.function    the function handler with its middlewares
+//	      modbus.watch     the watchers of a written value of DefaultDataModel
+//	  modbus.write         sending the response
+type Tracer interface {
+	// Start - a child of the span in ctx beginning at start, a zero start is now
+	Start(ctx context.Context, name string, start time.Time, attrs ...Field) (context.Context, Span)
+}
+
+// Span - attributes may be added until End
+type Span interface {
+	SetAttributes(attrs ...Field)
+	// RecordError - marks the span as failed
+	RecordError(err error)
+	End()
+}
+
+// NopTracer - starts no spans
+type NopTracer struct{}
+
+func (NopTracer) Start(ctx context.Context, _ string, _ time.Time, _ ...Field) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttributes(...Field) {}
+func (nopSpan) RecordError(error)      {}
+func (nopSpan) End()                   {}
+
+// startRequestSpan - the request span of a transport with its frame span from
+// the first byte to now
+func startRequestSpan(ctx context.Context, tracer Tracer, transport string, size int, request Request, first time.Time) (context.Context, Span) {
+	ctx, span := tracer.Start(ctx, "modbus.request", first,
+		Field{Key: "transport", Value: transport},
+		FieldUnit(request.GetSlaveId()),
+		FieldFunction(request.GetFunction()),
+		FieldBytes("request_bytes", size),
+	)
+	_, frame := tracer.Start(ctx, "modbus.frame", first)
+	frame.End()
+	return ctx, span
+}
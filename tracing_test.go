@@ -0,0 +1,97 @@
+package mbslave
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/schnack/gotest"
+)
+
+type recordedSpan struct {
+	name   string
+	parent string
+	fields map[string]interface{}
+}
+
+// recordTracer - records the ended spans with the name of their parent
+type recordTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordTracerKey struct{}
+
+func (r *recordTracer) Start(ctx context.Context, name string, _ time.Time, attrs ...Field) (context.Context, Span) {
+	span := &recordTracerSpan{tracer: r, span: &recordedSpan{name: name, fields: make(map[string]interface{})}}
+	if parent, ok := ctx.Value(recordTracerKey{}).(*recordedSpan); ok {
+		span.span.parent = parent.name
+	}
+	span.SetAttributes(attrs...)
+	return context.WithValue(ctx, recordTracerKey{}, span.span), span
+}
+
+type recordTracerSpan struct {
+	tracer *recordTracer
+	span   *recordedSpan
+}
+
+func (s *recordTracerSpan) SetAttributes(attrs ...Field) {
+	for _, f := range attrs {
+		s.span.fields[f.Key] = f.Value
+	}
+}
+
+func (s *recordTracerSpan) RecordError(err error) {
+	s.span.fields["error"] = err
+}
+
+func (s *recordTracerSpan) End() {
+	s.tracer.mu.Lock()
+	s.tracer.spans = append(s.tracer.spans, s.span)
+	s.tracer.mu.Unlock()
+}
+
+func TestServer_SetTracer(t *testing.T) {
+	config := &Config{Address: "127.0.0.1:0", SlaveId: 0x11, SizeHoldingRegisters: 10}
+	transport := NewTcpTransport(config)
+	transport.Log = NopLogger{}
+	dm := NewDefaultDataModel(config)
+	server := NewServer(transport, dm)
+	tracer := &recordTracer{}
+	server.SetTracer(tracer)
+	done := make(chan error)
+	go func() { done <- server.Listen() }()
+
+	tcpRequest(t, transport.Addr(), []byte{0x03, 0x00, 0x01, 0x00, 0x01})
+	_ = server.Close()
+	<-done
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	var names []string
+	for _, span := range tracer.spans {
+		names = append(names, span.parent+">"+span.name)
+	}
+	if err := gotest.Expect(names).Eq([]string{
+		"modbus.request>modbus.frame",
+		"modbus.handle>modbus.parse",
+		"modbus.handle>modbus.function",
+		"modbus.request>modbus.handle",
+		"modbus.request>modbus.write",
+		">modbus.request",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	request := tracer.spans[len(tracer.spans)-1].fields
+	if err := gotest.Expect(request["transport"]).Eq("tcp"); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(request["response_bytes"]).Eq(11); err != nil {
+		t.Error(err)
+	}
+	if err := gotest.Expect(tracer.spans[2].fields["quantity"]).Eq(uint16(1)); err != nil {
+		t.Error(err)
+	}
+}
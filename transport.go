@@ -1,6 +1,22 @@
 package mbslave
 
+import "errors"
+
+// ErrTransportClosed - Listen of a transport that was closed, before or while listening
+var ErrTransportClosed = errors.New("transport closed")
+
 type Transport interface {
 	Listen() error
 	SetHandler(func(Request, Response))
 }
+
+// ContextTransport - a transport passing the RequestInfo and its cancellation to the handler
+type ContextTransport interface {
+	SetContextHandler(h Handler)
+}
+
+// RejectingTransport - a transport answering some requests by itself, e.g. over a rate limit.
+// h receives them with the exception already set, the server passes them through its middlewares.
+type RejectingTransport interface {
+	SetRejectHandler(h Handler)
+}